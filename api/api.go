@@ -4,37 +4,310 @@ import "time"
 
 type (
 	// RetrieveTokenRequest is the request struct for the RetrieveToken endpoint handler.
-	// It contains the UserID for the token that needs to be retrieved.
+	// It contains the UserID for the token that needs to be retrieved, and optionally the
+	// Provider that issued it when the user has tokens from several providers. VersionID
+	// optionally selects a historical version of the secret instead of the current one.
 	RetrieveTokenRequest struct {
-		UserID string `json:"user_id" binding:"required"`
+		UserID    string `json:"user_id" binding:"required"`
+		Provider  string `json:"provider"`
+		VersionID string `json:"version_id"`
 	}
 
 	// SaveTokenRequest is the request struct for the SaveToken endpoint handler. It contains
-	// the UserID, AccessToken, RefreshToken, and Expiry of the token that needs to be saved.
+	// the UserID, AccessToken, RefreshToken, and Expiry of the token that needs to be saved,
+	// and optionally the Provider that issued it, its TokenType and the space-delimited
+	// Scope it was granted. Expiry may only be left out when the server has a default
+	// token lifetime configured.
 	SaveTokenRequest struct {
 		UserID       string    `json:"user_id" binding:"required"`
+		Provider     string    `json:"provider"`
+		TokenType    string    `json:"token_type"`
+		Scope        string    `json:"scope"`
 		AccessToken  string    `json:"access_token" binding:"required"`
 		RefreshToken string    `json:"refresh_token" binding:"required"`
-		Expiry       time.Time `json:"expiry" binding:"required"`
+		Expiry       time.Time `json:"expiry"`
 	}
 
+	// PatchTokenRequest is the request struct for the PatchToken endpoint handler. It
+	// contains the AccessToken and Expiry that replace those of the stored token of the
+	// UserID, and optionally the Provider, leaving its refresh token as it is.
+	PatchTokenRequest struct {
+		UserID      string
+		Provider    string    `json:"provider"`
+		AccessToken string    `json:"access_token" binding:"required"`
+		Expiry      time.Time `json:"expiry" binding:"required"`
+	}
+
+	// RollbackTokenRequest is the request struct for the Rollback endpoint handler. It
+	// contains the UserID, and optionally the Provider, of the token that is rolled back
+	// to its previous version.
+	RollbackTokenRequest struct {
+		UserID   string `json:"user_id" binding:"required"`
+		Provider string `json:"provider"`
+	}
+
+	// DeviceAuthRequest is the request struct for the DeviceStart endpoint handler. It
+	// contains the UserID the token is stored under once the device is authorized, and
+	// optionally the Provider that issues it.
+	DeviceAuthRequest struct {
+		UserID   string
+		Provider string `json:"provider"`
+	}
+
+	// DeviceAuthResponse is the response struct of the DeviceStart endpoint handler. The
+	// user authorizes the device by entering the UserCode at the VerificationURI. The device
+	// code stays with the service, which polls for the token.
+	DeviceAuthResponse struct {
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+		ExpiresIn               int64  `json:"expires_in"`
+	}
+
+	// ExportTokenRequest is the request struct for the ExportToken endpoint handler. It
+	// contains the UserID, and optionally the Provider, of the token to export, and the
+	// base64url-encoded DER (PKIX) RSA PublicKey of the service it is exported to.
+	ExportTokenRequest struct {
+		UserID    string
+		Provider  string
+		PublicKey string
+	}
+
+	// SealedToken is a token encrypted for a single recipient, so it can be moved between
+	// services. The token is encrypted with a random content key using AES-256-GCM with the
+	// Nonce, the content key is encrypted for the RSA public key of the recipient using
+	// RSA-OAEP with SHA-256. Algorithm names this scheme.
+	SealedToken struct {
+		Algorithm    string `json:"alg" binding:"required"`
+		EncryptedKey []byte `json:"encrypted_key" binding:"required"`
+		Nonce        []byte `json:"nonce" binding:"required"`
+		Ciphertext   []byte `json:"ciphertext" binding:"required"`
+	}
+
+	// ImportTokenRequest is the request struct for the ImportToken endpoint handler. It
+	// contains the SealedToken to store for the UserID, optionally under the Provider.
+	ImportTokenRequest struct {
+		UserID   string
+		Provider string      `json:"provider"`
+		Token    SealedToken `json:"token" binding:"required"`
+	}
+
+	// BulkImportItem is a single token of the BulkImport endpoint handler's request array.
+	// Items are validated one by one, so an invalid item fails on its own instead of
+	// rejecting the whole request.
+	BulkImportItem struct {
+		UserID       string    `json:"user_id"`
+		Provider     string    `json:"provider"`
+		AccessToken  string    `json:"access_token"`
+		RefreshToken string    `json:"refresh_token"`
+		Expiry       time.Time `json:"expiry"`
+	}
+
+	// ValidateTokenRequest is the request struct for the ValidateToken endpoint handler. It
+	// contains the JWT to validate.
+	ValidateTokenRequest struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	// ValidateTokenResponse is the response struct of the ValidateToken endpoint handler. A
+	// Valid token comes with its subject Sub and its expiry Exp in Unix seconds, when it has
+	// one, an invalid token with the Reason it was rejected.
+	ValidateTokenResponse struct {
+		Valid  bool   `json:"valid"`
+		Sub    string `json:"sub,omitempty"`
+		Exp    int64  `json:"exp,omitempty"`
+		Reason string `json:"reason,omitempty"`
+	}
+
+	// TokenDescription is the response struct of the DescribeToken endpoint handler. It
+	// describes a stored token without revealing the access or refresh token.
+	TokenDescription struct {
+		UserID          string    `json:"user_id"`
+		Provider        string    `json:"provider,omitempty"`
+		TokenType       string    `json:"token_type"`
+		Expiry          time.Time `json:"expiry"`
+		HasRefreshToken bool      `json:"has_refresh_token"`
+		Scopes          []string  `json:"scopes"`
+	}
+
+	// TokenResponse is the response struct of the RetrieveToken endpoint handler, with the
+	// snake_case field names of RFC 6749. Expiry is formatted as RFC 3339, and ExpiresAtUnix
+	// is the same instant in Unix seconds, both omitted for tokens that do not expire.
+	TokenResponse struct {
+		AccessToken   string `json:"access_token"`
+		TokenType     string `json:"token_type"`
+		RefreshToken  string `json:"refresh_token"`
+		Expiry        string `json:"expiry,omitempty"`
+		ExpiresAtUnix int64  `json:"expires_at_unix,omitempty"`
+	}
+
+	// OAuth2TokenResponse is the response struct of the RetrieveToken endpoint handler in the
+	// shape of the access token response of RFC 6749, section 5.1, for standard OAuth
+	// clients. ExpiresIn is the remaining lifetime of the token in seconds, zero once it
+	// expired, and is omitted with the other optional fields for tokens that do not expire.
+	OAuth2TokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    *int64 `json:"expires_in,omitempty"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		Scope        string `json:"scope,omitempty"`
+	}
+
+	// CamelCaseTokenResponse is the response struct of the RetrieveToken endpoint handler
+	// for clients that expect camelCase field names.
+	CamelCaseTokenResponse struct {
+		AccessToken   string `json:"accessToken"`
+		TokenType     string `json:"tokenType"`
+		RefreshToken  string `json:"refreshToken"`
+		Expiry        string `json:"expiry,omitempty"`
+		ExpiresAtUnix int64  `json:"expiresAtUnix,omitempty"`
+	}
+
+	// GetSecretRequest is the request struct for the secret.Getter. When VersionID is set,
+	// that version of the secret is read instead of the current one.
 	GetSecretRequest struct {
-		SecretID string
+		SecretID  string
+		VersionID string
 	}
 
+	// PutSecretRequest is the request struct for the secret.Putter. When VersionID is set,
+	// the put only goes ahead if it still matches the current version of the secret.
 	PutSecretRequest struct {
-		SecretID string
-		Token    string
+		SecretID  string
+		Token     string
+		VersionID string
 	}
 
+	// CreateSecretRequest is the request struct for the secret.Creator. The Token is the
+	// only field that becomes the value of the secret, the optional Description, Tags and
+	// KmsKeyID are metadata of the secret and complement the configuration of the creator.
+	// With a ClientRequestToken, retrying a create that may have succeeded is idempotent.
 	CreateSecretRequest struct {
+		SecretID           string
+		Token              string
+		Description        string
+		Tags               map[string]string
+		KmsKeyID           string
+		ClientRequestToken string
+	}
+
+	DeleteSecretRequest struct {
 		SecretID string
-		Token    string
 	}
 
+	// RollbackSecretRequest is the request struct for the secret.Rollbacker.
+	RollbackSecretRequest struct {
+		SecretID string
+	}
+
+	// MigrateSecretRequest is the request struct for the secret.Migrator. The secret
+	// OldSecretID is moved to NewSecretID.
+	MigrateSecretRequest struct {
+		OldSecretID string
+		NewSecretID string
+	}
+
+	// ListSecretsRequest is the request struct for the secret.Lister. It lists every
+	// secret whose name starts with Prefix.
+	ListSecretsRequest struct {
+		Prefix string
+	}
+
+	// SecretValue is a secret value returned by the secret.MetaGetter together with the
+	// VersionID and CreatedDate of the version it was read from.
+	SecretValue struct {
+		Value       string
+		VersionID   string
+		CreatedDate time.Time
+	}
+
+	// SecretSummary describes a secret returned by the secret.Lister without its value.
+	SecretSummary struct {
+		SecretID        string
+		LastChangedDate time.Time
+	}
+
+	// CleanupRequest is the request struct for the Cleanup endpoint handler. Token secrets
+	// whose token expired before ExpiredBefore, or that were last changed before
+	// ChangedBefore, are deleted. With DryRun set, they are only reported.
+	CleanupRequest struct {
+		ExpiredBefore time.Time `json:"expired_before"`
+		ChangedBefore time.Time `json:"changed_before"`
+		DryRun        bool      `json:"dry_run"`
+	}
+
+	// CleanupResponse lists the secrets a cleanup found to be stale and how many of them
+	// were deleted.
+	CleanupResponse struct {
+		Candidates []string `json:"candidates"`
+		Deleted    int      `json:"deleted"`
+		DryRun     bool     `json:"dry_run"`
+	}
+
+	// StatsResponse is the response struct of the Stats endpoint handler. It counts the
+	// save and retrieve requests served since the start of the process and those of them
+	// that failed with a server error, the last of which happened at LastErrorAt. JWTCache
+	// describes the cache of verified JWTs, when the parser has one.
+	StatsResponse struct {
+		Saves          int64       `json:"saves"`
+		SaveErrors     int64       `json:"save_errors"`
+		Retrieves      int64       `json:"retrieves"`
+		RetrieveErrors int64       `json:"retrieve_errors"`
+		UptimeSeconds  int64       `json:"uptime_seconds"`
+		LastErrorAt    *time.Time  `json:"last_error_at"`
+		JWTCache       *CacheStats `json:"jwt_cache,omitempty"`
+	}
+
+	// CacheStats describes a cache: the number of Entries it holds, and the number of
+	// lookups since the start of the process that it answered, Hits, or not, Misses.
+	CacheStats struct {
+		Entries int   `json:"entries"`
+		Hits    int64 `json:"hits"`
+		Misses  int64 `json:"misses"`
+	}
+
+	// ConfigResponse is the response struct of the Config endpoint handler. It describes the
+	// effective configuration of the service, leaving out KMS keys and AWS credentials.
+	// Features lists the optional behaviours that are enabled.
+	ConfigResponse struct {
+		Version         string   `json:"version"`
+		Region          string   `json:"region"`
+		SecondaryRegion string   `json:"secondary_region,omitempty"`
+		RootDomain      string   `json:"root_domain"`
+		Environment     string   `json:"environment,omitempty"`
+		Domain          string   `json:"domain"`
+		Domains         []string `json:"domains"`
+		Middlewares     []string `json:"middlewares"`
+		Backend         string   `json:"backend"`
+		ResponseStyle   string   `json:"response_style"`
+		TLS             bool     `json:"tls"`
+		MutualTLS       bool     `json:"mutual_tls"`
+		Features        []string `json:"features"`
+	}
+
+	// DomainDescription describes a secret domain served by the service, for service
+	// discovery. Path is the prefix of its endpoints. RecoveryWindowDays is omitted when the
+	// domain keeps the Secrets Manager default. The KMS key of the domain is never included.
+	DomainDescription struct {
+		Name               string            `json:"name"`
+		Path               string            `json:"path"`
+		RecoveryWindowDays int64             `json:"recovery_window_days,omitempty"`
+		Tags               map[string]string `json:"tags,omitempty"`
+	}
+
+	// DomainsResponse is the response struct of the Domains endpoint handler, with the
+	// domains sorted by name.
+	DomainsResponse struct {
+		Domains []DomainDescription `json:"domains"`
+	}
+
+	// ResolveSecretRequest is the request struct for the secret.IDResolver. The secret ID
+	// is formed from its fields as described by SecretID.
 	ResolveSecretRequest struct {
-		RootDomain string
-		Domain     string
-		UserID     string
+		RootDomain  string
+		Environment string
+		Domain      string
+		UserID      string
+		Provider    string
 	}
 )
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// SecretID is the parsed form of the ID of a secret managed by the service. Its string form
+// is RootDomain/Domain/UserID, or RootDomain/Environment/Domain/UserID when Environment is
+// set, followed by /Provider when Provider is set. Segments are used verbatim, so a segment
+// that contains a slash cannot be parsed back.
+type SecretID struct {
+	RootDomain  string
+	Environment string
+	Domain      string
+	UserID      string
+	Provider    string
+}
+
+// String returns the secret ID in the format described by SecretID.
+func (id SecretID) String() string {
+	s := id.DomainPrefix() + id.UserID
+	if id.Provider != "" {
+		s += "/" + id.Provider
+	}
+
+	return s
+}
+
+// DomainPrefix returns the prefix shared by the IDs of every secret in the domain of id,
+// including the trailing slash.
+func (id SecretID) DomainPrefix() string {
+	if id.Environment == "" {
+		return fmt.Sprintf("%v/%v/", id.RootDomain, id.Domain)
+	}
+
+	return fmt.Sprintf("%v/%v/%v/", id.RootDomain, id.Environment, id.Domain)
+}
+
+// UserPrefix returns the prefix shared by the IDs of the provider-specific secrets of the
+// user of id, including the trailing slash.
+func (id SecretID) UserPrefix() string {
+	return id.DomainPrefix() + id.UserID + "/"
+}
+
+// SecretID returns the SecretID that r resolves.
+func (r *ResolveSecretRequest) SecretID() SecretID {
+	return SecretID{
+		RootDomain:  r.RootDomain,
+		Environment: r.Environment,
+		Domain:      r.Domain,
+		UserID:      r.UserID,
+		Provider:    r.Provider,
+	}
+}
+
+// ParseSecretID parses s as the ID of a secret under rootDomain and environment, which may
+// be empty. The remaining segments are Domain/UserID with an optional /Provider.
+func ParseSecretID(s, rootDomain, environment string) (SecretID, error) {
+	prefix := rootDomain + "/"
+	if environment != "" {
+		prefix += environment + "/"
+	}
+
+	rest, ok := strings.CutPrefix(s, prefix)
+	if !ok {
+		return SecretID{}, fmt.Errorf("secret ID %q does not start with %q", s, prefix)
+	}
+
+	segments := strings.Split(rest, "/")
+	if len(segments) < 2 || len(segments) > 3 || slices.Contains(segments, "") {
+		return SecretID{}, fmt.Errorf("secret ID %q is not of the form %vdomain/user[/provider]", s, prefix)
+	}
+
+	id := SecretID{RootDomain: rootDomain, Environment: environment, Domain: segments[0], UserID: segments[1]}
+	if len(segments) == 3 {
+		id.Provider = segments[2]
+	}
+
+	return id, nil
+}
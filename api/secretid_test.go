@@ -0,0 +1,86 @@
+package api
+
+import "testing"
+
+func TestSecretID_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		id   SecretID
+		want string
+	}{
+		{
+			name: "WithoutEnvironment",
+			id:   SecretID{RootDomain: "root", Domain: "token", UserID: "1"},
+			want: "root/token/1",
+		},
+		{
+			name: "WithoutEnvironmentWithProvider",
+			id:   SecretID{RootDomain: "root", Domain: "token", UserID: "1", Provider: "google"},
+			want: "root/token/1/google",
+		},
+		{
+			name: "WithEnvironment",
+			id:   SecretID{RootDomain: "root", Environment: "prod", Domain: "token", UserID: "1"},
+			want: "root/prod/token/1",
+		},
+		{
+			name: "WithEnvironmentAndProvider",
+			id:   SecretID{RootDomain: "root", Environment: "prod", Domain: "token", UserID: "1", Provider: "google"},
+			want: "root/prod/token/1/google",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.id.String()
+			if got != tt.want {
+				t.Errorf("String() = %v, want %v", got, tt.want)
+			}
+
+			parsed, err := ParseSecretID(got, tt.id.RootDomain, tt.id.Environment)
+			if err != nil {
+				t.Fatalf("ParseSecretID() error = %v", err)
+			}
+			if parsed != tt.id {
+				t.Errorf("ParseSecretID() = %+v, want %+v", parsed, tt.id)
+			}
+		})
+	}
+}
+
+func TestParseSecretID_Malformed(t *testing.T) {
+	tests := []struct {
+		name        string
+		secretID    string
+		environment string
+	}{
+		{name: "OtherRootDomain", secretID: "other/token/1"},
+		{name: "OtherEnvironment", secretID: "root/dev/token/1", environment: "prod"},
+		{name: "MissingUser", secretID: "root/token"},
+		{name: "EmptyUser", secretID: "root/token//google"},
+		{name: "TooManySegments", secretID: "root/token/1/google/extra"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := ParseSecretID(tt.secretID, "root", tt.environment); err == nil {
+				t.Errorf("ParseSecretID() = %+v, want error", got)
+			}
+		})
+	}
+}
+
+func TestSecretID_Prefixes(t *testing.T) {
+	id := SecretID{RootDomain: "root", Environment: "dev", Domain: "token", UserID: "1"}
+	if got := id.DomainPrefix(); got != "root/dev/token/" {
+		t.Errorf("DomainPrefix() = %v, want root/dev/token/", got)
+	}
+	if got := id.UserPrefix(); got != "root/dev/token/1/" {
+		t.Errorf("UserPrefix() = %v, want root/dev/token/1/", got)
+	}
+
+	id.Environment = ""
+	if got := id.DomainPrefix(); got != "root/token/" {
+		t.Errorf("DomainPrefix() = %v, want root/token/", got)
+	}
+}
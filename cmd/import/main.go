@@ -0,0 +1,85 @@
+package main
+
+import (
+	"app/env"
+	"app/internal/awsconfig"
+	"app/internal/secret"
+	"app/internal/token"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// The import command seeds Secrets Manager with tokens from a JSON file containing an
+// array of {"user_id", "provider", "token"} records, e.g. when migrating from another
+// system. It uses the same environment variables as the server.
+//
+//	go run ./cmd/import --concurrency 8 --dry-run tokens.json
+func main() {
+	concurrency := flag.Int("concurrency", token.DefaultImportConcurrency, "number of tokens saved in parallel")
+	dryRun := flag.Bool("dry-run", false, "report what would be created or updated without saving")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: import [--concurrency n] [--dry-run] <file.json>")
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *concurrency, *dryRun); err != nil {
+		slog.Error("Import failed", "error", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(path string, concurrency int, dryRun bool) error {
+	vars, err := env.GetAwsVars()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records, err := token.ReadImportRecords(f)
+	if err != nil {
+		return err
+	}
+
+	scl, err := secret.NewClient(awsconfig.Options(vars)...)
+	if err != nil {
+		return err
+	}
+	svc := token.NewService(vars, secret.NewLimitedClient(scl, vars.MaxConcurrentCalls, vars.CallWait), env.DomainVars{Name: token.DefaultDomain})
+
+	im := token.Importer{
+		Env:         vars,
+		Res:         &svc.Manager.AWSResolver,
+		Svr:         svc.Saver,
+		Concurrency: concurrency,
+		DryRun:      dryRun,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report := im.Import(ctx, records)
+	for _, res := range report.Results {
+		if res.Outcome == "failed" {
+			fmt.Printf("failed\t%v\t%v\t%v\n", res.UserID, res.Provider, res.Error)
+		}
+	}
+	fmt.Printf("created: %d, updated: %d, failed: %d (dry run: %v)\n",
+		report.Created, report.Updated, report.Failed, dryRun)
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d records failed", report.Failed, len(records))
+	}
+	return nil
+}
@@ -2,89 +2,289 @@ package main
 
 import (
 	"app/env"
+	"app/internal/awsconfig"
 	"app/internal/key"
+	"app/internal/logging"
 	"app/internal/rest"
 	"app/internal/secret"
 	"app/internal/token"
-	"fmt"
-	"github.com/gin-gonic/gin"
+	"context"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"golang.org/x/oauth2"
 	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// version is the version of the build, set with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
 func main() {
+	lvars, err := env.GetLogVars()
+	if err != nil {
+		slog.Error("Server not started, could not get log env vars", "error", err.Error())
+		return
+	}
+	slog.SetDefault(slog.New(logging.NewHandler(os.Stderr, lvars)))
+
 	vars, err := env.GetAwsVars()
 	if err != nil {
 		slog.Error("Server not started, could not get env vars", "error", err.Error())
 		return
 	}
 
-	scl, err := secret.NewClient()
+	domains, err := env.GetDomainVars()
+	if err != nil {
+		slog.Error("Server not started, could not get domain env vars", "error", err.Error())
+		return
+	}
+
+	svars, err := env.GetServerVars()
+	if err != nil {
+		slog.Error("Server not started, could not get server env vars", "error", err.Error())
+		return
+	}
+
+	avars, err := env.GetAuthVars()
+	if err != nil {
+		slog.Error("Server not started, could not get auth env vars", "error", err.Error())
+		return
+	}
+
+	tvars, err := env.GetTokenVars()
+	if err != nil {
+		slog.Error("Server not started, could not get token env vars", "error", err.Error())
+		return
+	}
+
+	jvars, err := env.GetJanitorVars()
+	if err != nil {
+		slog.Error("Server not started, could not get janitor env vars", "error", err.Error())
+		return
+	}
+
+	rvars, err := env.GetRefreshVars()
+	if err != nil {
+		slog.Error("Server not started, could not get refresh env vars", "error", err.Error())
+		return
+	}
+
+	nvars, err := env.GetTenantVars()
+	if err != nil {
+		slog.Error("Server not started, could not get tenant env vars", "error", err.Error())
+		return
+	}
+	if len(nvars.Roles) > 0 && vars.SecondaryRegion != "" {
+		slog.Error("Server not started, SMS_TENANT_ROLES cannot be combined with SMS_SECONDARY_REGION")
+		return
+	}
+
+	scl, err := secret.NewClient(awsconfig.Options(vars)...)
 	if err != nil {
 		slog.Error("Server not started, could not get secret client", "error", err.Error())
 		return
 	}
 
-	kcl, err := key.NewClient()
+	// With tenant roles, the calls of a request go to the account of its tenant.
+	var cl secret.Client = scl
+	if len(nvars.Roles) > 0 {
+		rcs, err := secret.NewRoleClients(awsconfig.Options(vars)...)
+		if err != nil {
+			slog.Error("Server not started, could not get role clients", "error", err.Error())
+			return
+		}
+		cl = &secret.RoleClient{Default: scl, Roles: rcs.Client}
+	}
+	// The breaker wraps the limit, so calls fail fast instead of waiting for a slot.
+	cl = secret.NewBreakerClient(secret.NewLimitedClient(cl, vars.MaxConcurrentCalls, vars.CallWait),
+		vars.BreakerThreshold, vars.BreakerCooldown)
+
+	kcl, err := key.NewClient(awsconfig.Options(vars)...)
 	if err != nil {
 		slog.Error("Server not started, could not get key client", "error", err.Error())
 		return
 	}
 
-	psr, err := rest.NewJWTParser(&key.AwsGetter{Client: kcl, KeyID: vars.KmsKeyID})
+	var kget key.Getter = &key.RetryingGetter{
+		Getter:   &key.AwsGetter{Client: kcl, KeyID: vars.KmsKeyID},
+		Attempts: key.DefaultAttempts,
+		Backoff:  key.DefaultBackoff,
+	}
+	// /readyz/kms probes the current KMS key, the one new JWTs are signed with.
+	keyChecks := []rest.Check{rest.KeyCheck(kget, rest.DefaultKeyCheckTTL)}
+	if len(vars.PreviousKmsKeyIDs) > 0 {
+		set := &key.KeySet{Getters: map[string]key.Getter{vars.KmsKeyID: kget}}
+		for _, keyID := range vars.PreviousKmsKeyIDs {
+			set.Getters[keyID] = &key.RetryingGetter{
+				Getter:   &key.AwsGetter{Client: kcl, KeyID: keyID},
+				Attempts: key.DefaultAttempts,
+				Backoff:  key.DefaultBackoff,
+			}
+		}
+		kget = set
+	}
+	if avars.JWKSURL != "" {
+		kget = &key.JWKSGetter{URL: avars.JWKSURL, Client: &http.Client{Timeout: 10 * time.Second}}
+		keyChecks = nil
+	}
+
+	jp, err := rest.NewJWTParser(kget)
 	if err != nil {
 		slog.Error("Server not started, could not create JWT Parser", "error", err.Error())
+		return
 	}
+	jp.ValidMethods = avars.ValidMethods
+	// The router gets the Parser only once it was created, never a nil *JWTParser.
+	var psr rest.Parser = jp
 
-	mgr := secret.AWSManager{
-		AWSGetter:   secret.AWSGetter{Client: scl},
-		AWSPutter:   secret.AWSPutter{Client: scl},
-		AWSCreator:  secret.AWSCreator{Client: scl},
-		AWSResolver: secret.AWSResolver{Client: scl},
+	opts := token.Options{
+		TokenType:       tvars.DefaultTokenType,
+		RequireExisting: !tvars.CreateIfMissing,
+		MaxProviders:    tvars.MaxProviders,
+		ProviderTTLs:    tvars.ProviderTTLs,
+		MigrateOnRead:   tvars.MigrateOnRead,
+		// Readers always accept base64 payloads and schema envelopes, so disabling
+		// SMS_TOKEN_BASE64 or SMS_TOKEN_SCHEMA again does not make the tokens stored in
+		// the meantime unreadable.
+		Dec:                 token.Base64Serializer{Serializer: token.SchemaSerializer{}},
+		RefreshOnRetrieve:   rvars.OnRetrieve,
+		WriteBack:           rvars.WriteBack,
+		ScheduleWindow:      rvars.ScheduleWindow,
+		ScheduleConcurrency: rvars.ScheduleConcurrency,
+	}
+	if tvars.Schema {
+		opts.Ser = token.SchemaSerializer{}
+	}
+	if tvars.Base64 {
+		opts.Ser = token.Base64Serializer{Serializer: opts.Ser}
+	}
+	if tvars.RefreshTokenKeyID != "" {
+		enc := &key.AwsCipher{
+			Client:  kcl,
+			KeyID:   tvars.RefreshTokenKeyID,
+			Context: map[string]string{"field": "refresh_token"},
+		}
+		opts.Ser = token.RefreshTokenSerializer{Serializer: opts.Ser, Enc: enc}
+		opts.Dec = token.RefreshTokenSerializer{Serializer: opts.Dec, Enc: enc}
 	}
 
-	svr := token.ApiSaver{
-		Res: &mgr.AWSResolver,
-		Put: &mgr.AWSPutter,
-		Ctr: &mgr.AWSCreator,
+	oauthConfig := &oauth2.Config{
+		ClientID:     rvars.ClientID,
+		ClientSecret: rvars.ClientSecret,
+		Endpoint:     oauth2.Endpoint{TokenURL: rvars.TokenURL},
 	}
+	var ref token.Refresher = &token.OAuthRefresher{Config: oauthConfig}
+	if len(rvars.Providers) > 0 {
+		pr := &token.ProviderRefresher{Configs: map[string]*oauth2.Config{}}
+		for name, client := range rvars.Providers {
+			pr.Configs[name] = &oauth2.Config{
+				ClientID:     client.ClientID,
+				ClientSecret: client.ClientSecret,
+				Endpoint:     oauth2.Endpoint{TokenURL: client.TokenURL}}
+		}
+		if rvars.TokenURL != "" {
+			pr.Default = oauthConfig
+		}
+		ref = pr
+	}
+	opts.Ref = ref
+
+	svc := token.NewService(vars, cl, env.DomainVars{Name: token.DefaultDomain})
+	svc.Configure(opts)
+	svc.Janitor.Ser = token.Base64Serializer{Serializer: token.SchemaSerializer{}}
 
-	rtr := token.ApiRetriever{
-		Env: vars,
-		Res: &mgr.AWSResolver,
-		Get: &mgr,
+	if vars.SecondaryRegion != "" {
+		scl2, err := secret.NewClient(append(awsconfig.Options(vars), config.WithRegion(vars.SecondaryRegion))...)
+		if err != nil {
+			slog.Error("Server not started, could not get secondary secret client", "error", err.Error())
+			return
+		}
+		// Tenant roles cannot be combined with a secondary region, so cl wraps scl.
+		svc.Retriever.Get = &secret.MultiRegionGetter{
+			Primary: cl,
+			Secondary: secret.NewBreakerClient(secret.NewLimitedClient(scl2, vars.MaxConcurrentCalls, vars.CallWait),
+				vars.BreakerThreshold, vars.BreakerCooldown),
+		}
 	}
 
-	// Create router
-	r := GinRouter{Saver: &svr, Retriever: &rtr, Parser: psr}
+	reg := token.NewRegistry(vars, cl, domains, opts)
 
-	// Run the server
-	r.StartServer()
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-type GinRouter struct {
-	Saver     token.Saver
-	Retriever token.Retriever
-	Parser    rest.Parser
-}
+	// Device polls stop with the server.
+	var device token.DeviceAuthorizer
+	if rvars.DeviceAuthURL != "" {
+		device = &token.DeviceFlow{
+			Config: &oauth2.Config{
+				ClientID:     rvars.ClientID,
+				ClientSecret: rvars.ClientSecret,
+				Endpoint:     oauth2.Endpoint{DeviceAuthURL: rvars.DeviceAuthURL, TokenURL: rvars.TokenURL}},
+			Svr: svc.Saver,
+			Ctx: ctx}
+	}
+
+	var jobs sync.WaitGroup
+	if jvars.Interval > 0 {
+		jobs.Add(1)
+		go func() { defer jobs.Done(); svc.Janitor.Run(ctx, jvars.Interval) }()
+	}
+	if rvars.ScheduleInterval > 0 {
+		jobs.Add(1)
+		go func() { defer jobs.Done(); svc.Scheduler.Run(ctx, rvars.ScheduleInterval) }()
+	}
 
-// StartServer defines a Gin router with /token/save and /token/get endpoints. It also
-// contains the gin.Recovery and Authenticate middleware that recover the server from
-// panic calls and authenticate userID's in requests, respectively.
-func (g GinRouter) StartServer() *gin.Engine {
 	// Create router
-	r := gin.New()
-	r.Use(gin.Recovery())
-	r.Use(rest.Authenticate(g.Parser))
+	imp := &token.Importer{
+		Env:         vars,
+		Res:         &svc.Manager.AWSResolver,
+		Svr:         svc.Saver,
+		Concurrency: token.DefaultImportConcurrency}
+
+	sealer := &token.ApiSealer{Ret: svc.Retriever, Svr: svc.Saver}
+	var exporter token.Exporter
+	if tvars.Export {
+		exporter = sealer
+	}
+	var sealedImporter token.SealedImporter
+	if tvars.ImportKeyID != "" {
+		sealer.Dec = &key.AwsRSADecrypter{Client: kcl, KeyID: tvars.ImportKeyID}
+		sealedImporter = sealer
+	}
 
-	// Define routes
-	r.PUT("/token/save", rest.SaveTokenHandler(g.Saver))
-	r.GET("/token/get", rest.RetrieveTokenHandler(g.Retriever))
+	r := rest.GinRouter{
+		Saver:          svc.Saver,
+		Retriever:      svc.Retriever,
+		Describer:      svc.Retriever,
+		Patcher:        svc.Patcher,
+		Exporter:       exporter,
+		SealedImporter: sealedImporter,
+		Cleaner:        svc.Janitor,
+		Rollbacker:     svc.Rollbacker,
+		Device:         device,
+		Importer:       imp,
+		Parser:         psr,
+		Auth:           avars,
+		Tenants:        nvars,
+		Registry:       reg,
+		Config:         svars,
+		Runtime: rest.RuntimeConfig{
+			Version: version, Region: scl.Options().Region, Backend: rest.BackendAWS, Aws: vars},
+		Checks: []rest.Check{{
+			Name:  "secretsmanager",
+			Probe: func(ctx context.Context) error { return secret.Ping(ctx, scl) }}},
+		KeyChecks: keyChecks}
 
-	// Run the server
-	slog.Info("Starting Server!")
-	if err := r.Run(":8080"); err != nil {
-		slog.Error(fmt.Sprintf("Server has died! %v", err))
+	// Run the server until interrupted
+	if _, err = r.StartServer(ctx); err != nil {
+		slog.Error("Server stopped", "error", err.Error())
 	}
 
-	return r
+	// Background jobs finish their current pass before the process exits.
+	stop()
+	jobs.Wait()
 }
@@ -5,28 +5,814 @@ import (
 	"github.com/joho/godotenv"
 	"log/slog"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// AwsVars holds the AWS configuration of the service, read by GetAwsVars. Only
+// SmsRootDomain and KmsKeyID are required, zero optional fields keep the defaults.
 type AwsVars struct {
-	SmsRootDomain string
-	KmsKeyID      string
+	SmsRootDomain        string
+	KmsKeyID             string
+	PreviousKmsKeyIDs    []string
+	SecondaryRegion      string
+	Profile              string
+	Environment          string
+	MaxSecretVersions    int
+	MaxSecretSize        int
+	AllowedProviders     []string
+	MaxConcurrentCalls   int
+	CallWait             time.Duration
+	BreakerThreshold     int
+	BreakerCooldown      time.Duration
+	AssumeRoleARN        string
+	AssumeRoleExternalID string
+}
+
+// DomainVars is the configuration of a single secret domain (namespace) served by this
+// deployment. Each domain can encrypt its secrets with its own KMS key, keep deleted
+// secrets recoverable for its own number of days and label them with its own tags.
+type DomainVars struct {
+	Name               string
+	KmsKeyID           string
+	RecoveryWindowDays int64
+	Tags               map[string]string
+}
+
+// JanitorVars configures the background janitor that deletes dead tokens. A zero
+// Interval disables the janitor.
+type JanitorVars struct {
+	Interval time.Duration
+}
+
+// ServerVars configures the HTTP server and its handlers, read by GetServerVars. A zero
+// timeout or limit means none.
+type ServerVars struct {
+	Recovery          bool
+	RequestLogging    bool
+	ResponseStyle     string
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSClientCAFile   string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxRequestTimeout time.Duration
+	GinMode           string
+	RejectExpired     bool
+	Preflight         bool
+	DefaultTokenTTL   time.Duration
+	Pprof             bool
+	MaxConnections    int
+	SnakeCaseOnly     bool
+}
+
+// Default timeouts of the http.Server and default cap of request deadlines, used when the
+// corresponding variable is not set. The write timeout leaves a request that uses the whole
+// cap time to write its response.
+const (
+	DefaultReadHeaderTimeout = 5 * time.Second
+	DefaultReadTimeout       = 15 * time.Second
+	DefaultWriteTimeout      = 45 * time.Second
+	DefaultIdleTimeout       = 60 * time.Second
+	DefaultMaxRequestTimeout = 30 * time.Second
+)
+
+const (
+	ResponseStyleSnakeCase = "snake_case"
+	ResponseStyleCamelCase = "camelCase"
+	ResponseStyleOAuth2    = "oauth2"
+)
+
+// RefreshVars configures refreshing tokens on retrieval and in the background, read by
+// GetRefreshVars. Providers optionally gives providers their own OAuth client, the
+// top-level one then serves the tokens saved without a provider.
+type RefreshVars struct {
+	OnRetrieve          bool
+	WriteBack           bool
+	ClientID            string
+	ClientSecret        string
+	TokenURL            string
+	DeviceAuthURL       string
+	ScheduleInterval    time.Duration
+	ScheduleWindow      time.Duration
+	ScheduleConcurrency int
+	Providers           map[string]OAuthClientVars
+}
+
+// OAuthClientVars identify the OAuth client and the token endpoint of a single provider.
+type OAuthClientVars struct {
+	ClientID     string
+	ClientSecret string
+	TokenURL     string
+}
+
+// Defaults of the background refresh, used when the corresponding variable is not set.
+const (
+	DefaultScheduleWindow      = 10 * time.Minute
+	DefaultScheduleConcurrency = 4
+)
+
+// AuthVars configures how requests are authenticated. SubjectClaim names the JWT claim
+// holding the user ID. JWKSURL optionally names a JSON Web Key Set to verify JWTs with,
+// instead of the KMS public key. ValidMethods optionally lists the JWT alg values accepted,
+// empty accepts the signing method of the key. Tokens longer than MaxSize bytes are
+// rejected without being parsed. With QueryToken, requests without an Authorization header
+// may pass the JWT in a query parameter, for clients that cannot set headers.
+type AuthVars struct {
+	SubjectClaim string
+	JWKSURL      string
+	ValidMethods []string
+	MaxSize      int
+	QueryToken   bool
+}
+
+// signingMethods are the JWT alg values that can be verified with the RSA and P-256 keys
+// the service supports.
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256"}
+
+// DefaultSubjectClaim is the JWT claim holding the user ID when none is configured.
+const DefaultSubjectClaim = "sub"
+
+// DefaultJWTMaxSize is the length in bytes of the longest JWT accepted when none is
+// configured.
+const DefaultJWTMaxSize = 8 << 10
+
+// DefaultTokenType is the token type stored for tokens saved without one when none is
+// configured.
+const DefaultTokenType = "Bearer"
+
+// TokenVars configures how tokens are stored, read by GetTokenVars. Base64 and Schema only
+// select the format tokens are written in, tokens are read in either format.
+type TokenVars struct {
+	DefaultTokenType  string
+	Base64            bool
+	CreateIfMissing   bool
+	MaxProviders      int
+	RefreshTokenKeyID string
+	Schema            bool
+	MigrateOnRead     bool
+	ProviderTTLs      map[string]time.Duration
+	Export            bool
+	ImportKeyID       string
+}
+
+// LogVars configures the logger. Format is LogFormatText or LogFormatJSON, and records
+// below Level are dropped.
+type LogVars struct {
+	Format string
+	Level  slog.Level
+}
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// TenantVars configures multi-tenant deployments that keep the secrets of each tenant in
+// its own AWS account. Claim names the JWT claim holding the tenant, and Roles maps each
+// tenant to the ARN of the IAM role its secrets are accessed with. Without Roles, every
+// request uses the credentials of the service itself.
+type TenantVars struct {
+	Claim string
+	Roles map[string]string
+}
+
+// DefaultTenantClaim is the JWT claim holding the tenant when none is configured.
+const DefaultTenantClaim = "tenant"
+
+var envFileOnce sync.Once
+
+// loadEnvFile loads the .env file into the process environment the first time any of the
+// Get functions is called, so the fallback message is only logged once.
+func loadEnvFile() {
+	envFileOnce.Do(func() {
+		if err := godotenv.Load(); err != nil {
+			slog.Info("No env file found, using os environment variables")
+		}
+	})
 }
 
 func GetAwsVars() (AwsVars, error) {
-	err := godotenv.Load()
-	if err != nil {
-		slog.Info("No env file found, using os environment variables")
-	}
+	loadEnvFile()
 
 	rootDomain := os.Getenv("SMS_ROOT_DOMAIN")
 	if rootDomain == "" {
 		return AwsVars{}, fmt.Errorf("SMS_ROOT_DOMAIN environment variable not set")
 	}
+	// The root domain is the first segment of every secret ID, a slash would shift the
+	// segments and whitespace would make IDs that look alike differ.
+	if strings.Contains(rootDomain, "/") || strings.TrimSpace(rootDomain) != rootDomain {
+		return AwsVars{}, fmt.Errorf("SMS_ROOT_DOMAIN environment variable %q must not contain slashes "+
+			"or surrounding whitespace", rootDomain)
+	}
 
 	keyID := os.Getenv("KMS_KEY_ID")
 	if keyID == "" {
 		return AwsVars{}, fmt.Errorf("KMS_KEY_ID environment variable not set")
 	}
 
-	return AwsVars{SmsRootDomain: rootDomain, KmsKeyID: keyID}, nil
+	roleARN, externalID := os.Getenv("AWS_ASSUME_ROLE_ARN"), os.Getenv("AWS_ASSUME_ROLE_EXTERNAL_ID")
+	if roleARN != "" && !strings.HasPrefix(roleARN, "arn:") {
+		return AwsVars{}, fmt.Errorf("AWS_ASSUME_ROLE_ARN environment variable must be an IAM role ARN")
+	}
+	if externalID != "" && roleARN == "" {
+		return AwsVars{}, fmt.Errorf("AWS_ASSUME_ROLE_EXTERNAL_ID requires AWS_ASSUME_ROLE_ARN")
+	}
+
+	var previousKeyIDs []string
+	if value := os.Getenv("KMS_PREVIOUS_KEY_IDS"); value != "" {
+		for _, id := range strings.Split(value, ",") {
+			id = strings.TrimSpace(id)
+			if id == "" || id == keyID || slices.Contains(previousKeyIDs, id) {
+				return AwsVars{}, fmt.Errorf("KMS_PREVIOUS_KEY_IDS environment variable must be a comma-separated " +
+					"list of distinct key IDs other than KMS_KEY_ID")
+			}
+			previousKeyIDs = append(previousKeyIDs, id)
+		}
+	}
+
+	environment := os.Getenv("SMS_ENV")
+	if strings.Contains(environment, "/") {
+		return AwsVars{}, fmt.Errorf("SMS_ENV environment variable must not contain '/'")
+	}
+
+	var maxVersions int
+	if value := os.Getenv("SMS_MAX_SECRET_VERSIONS"); value != "" {
+		var err error
+		maxVersions, err = strconv.Atoi(value)
+		if err != nil || maxVersions < 1 {
+			return AwsVars{}, fmt.Errorf("SMS_MAX_SECRET_VERSIONS environment variable must be a positive number")
+		}
+	}
+
+	var maxSize int
+	if value := os.Getenv("SMS_MAX_SECRET_SIZE"); value != "" {
+		var err error
+		maxSize, err = strconv.Atoi(value)
+		if err != nil || maxSize < 1 {
+			return AwsVars{}, fmt.Errorf("SMS_MAX_SECRET_SIZE environment variable must be a positive number")
+		}
+	}
+
+	var maxCalls int
+	if value := os.Getenv("SMS_MAX_CONCURRENT_AWS_CALLS"); value != "" {
+		var err error
+		maxCalls, err = strconv.Atoi(value)
+		if err != nil || maxCalls < 1 {
+			return AwsVars{}, fmt.Errorf("SMS_MAX_CONCURRENT_AWS_CALLS environment variable must be a positive number")
+		}
+	}
+
+	var callWait time.Duration
+	if value := os.Getenv("SMS_AWS_CALL_WAIT"); value != "" {
+		var err error
+		callWait, err = time.ParseDuration(value)
+		if err != nil || callWait <= 0 {
+			return AwsVars{}, fmt.Errorf("SMS_AWS_CALL_WAIT environment variable must be a positive duration")
+		}
+	}
+
+	var breakerThreshold int
+	if value := os.Getenv("SMS_BREAKER_THRESHOLD"); value != "" {
+		var err error
+		breakerThreshold, err = strconv.Atoi(value)
+		if err != nil || breakerThreshold < 1 {
+			return AwsVars{}, fmt.Errorf("SMS_BREAKER_THRESHOLD environment variable must be a positive number")
+		}
+	}
+
+	var breakerCooldown time.Duration
+	if value := os.Getenv("SMS_BREAKER_COOLDOWN"); value != "" {
+		var err error
+		breakerCooldown, err = time.ParseDuration(value)
+		if err != nil || breakerCooldown <= 0 {
+			return AwsVars{}, fmt.Errorf("SMS_BREAKER_COOLDOWN environment variable must be a positive duration")
+		}
+	}
+
+	var providers []string
+	if value := os.Getenv("SMS_ALLOWED_PROVIDERS"); value != "" {
+		for _, provider := range strings.Split(value, ",") {
+			provider = strings.TrimSpace(provider)
+			if !providerPattern.MatchString(provider) {
+				return AwsVars{}, fmt.Errorf("SMS_ALLOWED_PROVIDERS environment variable must be a comma-separated "+
+					"list of provider names, got %q", provider)
+			}
+			providers = append(providers, provider)
+		}
+	}
+
+	return AwsVars{
+		SmsRootDomain:        rootDomain,
+		KmsKeyID:             keyID,
+		PreviousKmsKeyIDs:    previousKeyIDs,
+		SecondaryRegion:      os.Getenv("SMS_SECONDARY_REGION"),
+		Profile:              os.Getenv("SMS_AWS_PROFILE"),
+		Environment:          environment,
+		MaxSecretVersions:    maxVersions,
+		MaxSecretSize:        maxSize,
+		AllowedProviders:     providers,
+		MaxConcurrentCalls:   maxCalls,
+		CallWait:             callWait,
+		BreakerThreshold:     breakerThreshold,
+		BreakerCooldown:      breakerCooldown,
+		AssumeRoleARN:        roleARN,
+		AssumeRoleExternalID: externalID}, nil
+}
+
+// providerPattern matches the characters Secrets Manager allows in a secret name, except the
+// slash separating the segments of secret IDs.
+var providerPattern = regexp.MustCompile(`^[A-Za-z0-9_+=.@-]+$`)
+
+// GetDomainVars reads the comma-separated SMS_DOMAINS list and the configuration of each
+// listed domain from SMS_DOMAIN_<NAME>_KMS_KEY_ID, SMS_DOMAIN_<NAME>_RECOVERY_WINDOW_DAYS
+// and SMS_DOMAIN_<NAME>_TAGS (comma-separated key=value pairs). When SMS_DOMAINS is not
+// set, only the "token" domain is served, with the Secrets Manager defaults.
+func GetDomainVars() ([]DomainVars, error) {
+	loadEnvFile()
+
+	names := os.Getenv("SMS_DOMAINS")
+	if names == "" {
+		names = "token"
+	}
+
+	var domains []DomainVars
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("SMS_DOMAINS environment variable contains an empty domain")
+		}
+		prefix := "SMS_DOMAIN_" + strings.ToUpper(name) + "_"
+
+		d := DomainVars{Name: name, KmsKeyID: os.Getenv(prefix + "KMS_KEY_ID")}
+
+		if days := os.Getenv(prefix + "RECOVERY_WINDOW_DAYS"); days != "" {
+			var err error
+			d.RecoveryWindowDays, err = strconv.ParseInt(days, 10, 64)
+			if err != nil || d.RecoveryWindowDays < 7 || d.RecoveryWindowDays > 30 {
+				return nil, fmt.Errorf("%sRECOVERY_WINDOW_DAYS must be between 7 and 30", prefix)
+			}
+		}
+
+		if tags := os.Getenv(prefix + "TAGS"); tags != "" {
+			d.Tags = map[string]string{}
+			for _, tag := range strings.Split(tags, ",") {
+				k, v, ok := strings.Cut(tag, "=")
+				if !ok || strings.TrimSpace(k) == "" {
+					return nil, fmt.Errorf("%sTAGS must be comma-separated key=value pairs", prefix)
+				}
+				d.Tags[strings.TrimSpace(k)] = strings.TrimSpace(v)
+			}
+		}
+
+		domains = append(domains, d)
+	}
+
+	return domains, nil
+}
+
+// GetJanitorVars reads the SMS_JANITOR_INTERVAL duration (e.g. "24h"). The janitor is
+// disabled when the variable is not set.
+func GetJanitorVars() (JanitorVars, error) {
+	loadEnvFile()
+
+	interval := os.Getenv("SMS_JANITOR_INTERVAL")
+	if interval == "" {
+		return JanitorVars{}, nil
+	}
+
+	d, err := time.ParseDuration(interval)
+	if err != nil || d <= 0 {
+		return JanitorVars{}, fmt.Errorf("SMS_JANITOR_INTERVAL must be a positive duration")
+	}
+
+	return JanitorVars{Interval: d}, nil
+}
+
+// GetServerVars reads the HTTP server configuration, see the README for the variables and
+// their defaults. A write timeout must be longer than SMS_MAX_REQUEST_TIMEOUT, or a request
+// running out its deadline could not write its response.
+func GetServerVars() (ServerVars, error) {
+	loadEnvFile()
+
+	recovery, err := getBool("SMS_RECOVERY", true)
+	if err != nil {
+		return ServerVars{}, err
+	}
+
+	logging, err := getBool("SMS_REQUEST_LOGGING", false)
+	if err != nil {
+		return ServerVars{}, err
+	}
+
+	style := os.Getenv("SMS_RESPONSE_STYLE")
+	switch style {
+	case "":
+		style = ResponseStyleSnakeCase
+	case ResponseStyleSnakeCase, ResponseStyleCamelCase, ResponseStyleOAuth2:
+	default:
+		return ServerVars{}, fmt.Errorf("SMS_RESPONSE_STYLE must be %s, %s or %s",
+			ResponseStyleSnakeCase, ResponseStyleCamelCase, ResponseStyleOAuth2)
+	}
+
+	certFile, keyFile := os.Getenv("SMS_TLS_CERT_FILE"), os.Getenv("SMS_TLS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		return ServerVars{}, fmt.Errorf("SMS_TLS_CERT_FILE and SMS_TLS_KEY_FILE must be set together")
+	}
+	caFile := os.Getenv("SMS_TLS_CLIENT_CA_FILE")
+	if caFile != "" && certFile == "" {
+		return ServerVars{}, fmt.Errorf("SMS_TLS_CLIENT_CA_FILE requires SMS_TLS_CERT_FILE and SMS_TLS_KEY_FILE")
+	}
+
+	rejectExpired, err := getBool("RETRIEVE_REJECT_EXPIRED", false)
+	if err != nil {
+		return ServerVars{}, err
+	}
+
+	preflight, err := getBool("SMS_PREFLIGHT", true)
+	if err != nil {
+		return ServerVars{}, err
+	}
+
+	ttl, err := getDuration("SMS_DEFAULT_TOKEN_TTL", 0)
+	if err != nil {
+		return ServerVars{}, err
+	}
+
+	pprof, err := getBool("ENABLE_PPROF", false)
+	if err != nil {
+		return ServerVars{}, err
+	}
+
+	snakeCaseOnly, err := getBool("SMS_SNAKE_CASE_ONLY", false)
+	if err != nil {
+		return ServerVars{}, err
+	}
+
+	var maxConns int
+	if value := os.Getenv("SMS_MAX_CONNECTIONS"); value != "" {
+		maxConns, err = strconv.Atoi(value)
+		if err != nil || maxConns < 1 {
+			return ServerVars{}, fmt.Errorf("SMS_MAX_CONNECTIONS environment variable must be a positive number")
+		}
+	}
+
+	ginMode := os.Getenv("GIN_MODE")
+	switch ginMode {
+	case "":
+		ginMode = "release"
+		if strings.EqualFold(os.Getenv("SMS_LOG_LEVEL"), "debug") {
+			ginMode = "debug"
+		}
+	case "debug", "release", "test":
+	default:
+		return ServerVars{}, fmt.Errorf("GIN_MODE must be debug, release or test")
+	}
+
+	vars := ServerVars{
+		Recovery:        recovery,
+		RequestLogging:  logging,
+		ResponseStyle:   style,
+		TLSCertFile:     certFile,
+		TLSKeyFile:      keyFile,
+		TLSClientCAFile: caFile,
+		GinMode:         ginMode,
+		RejectExpired:   rejectExpired,
+		Preflight:       preflight,
+		DefaultTokenTTL: ttl,
+		Pprof:           pprof,
+		MaxConnections:  maxConns,
+		SnakeCaseOnly:   snakeCaseOnly}
+
+	timeouts := []struct {
+		name  string
+		field *time.Duration
+		def   time.Duration
+	}{
+		{"SMS_READ_HEADER_TIMEOUT", &vars.ReadHeaderTimeout, DefaultReadHeaderTimeout},
+		{"SMS_READ_TIMEOUT", &vars.ReadTimeout, DefaultReadTimeout},
+		{"SMS_WRITE_TIMEOUT", &vars.WriteTimeout, DefaultWriteTimeout},
+		{"SMS_IDLE_TIMEOUT", &vars.IdleTimeout, DefaultIdleTimeout},
+		{"SMS_MAX_REQUEST_TIMEOUT", &vars.MaxRequestTimeout, DefaultMaxRequestTimeout},
+	}
+	for _, t := range timeouts {
+		if *t.field, err = getDuration(t.name, t.def); err != nil {
+			return ServerVars{}, err
+		}
+	}
+	if vars.WriteTimeout > 0 && vars.MaxRequestTimeout > 0 && vars.WriteTimeout <= vars.MaxRequestTimeout {
+		return ServerVars{}, fmt.Errorf("SMS_WRITE_TIMEOUT must be longer than SMS_MAX_REQUEST_TIMEOUT")
+	}
+
+	return vars, nil
+}
+
+// GetRefreshVars reads SMS_REFRESH_ON_RETRIEVE (default false) and SMS_REFRESH_WRITE_BACK
+// (default true). When refreshing is enabled, SMS_OAUTH_CLIENT_ID, SMS_OAUTH_CLIENT_SECRET
+// and SMS_OAUTH_TOKEN_URL must be set as well. SMS_OAUTH_DEVICE_AUTH_URL enables the device
+// flow, which needs the same OAuth client, and so does the background refresh enabled by
+// SMS_REFRESH_SCHEDULE_INTERVAL, configured by SMS_REFRESH_SCHEDULE_WINDOW and
+// SMS_REFRESH_SCHEDULE_CONCURRENCY. SMS_OAUTH_PROVIDERS lists the providers with their own
+// OAuth client, configured by SMS_OAUTH_<PROVIDER>_CLIENT_ID, SMS_OAUTH_<PROVIDER>_CLIENT_SECRET
+// and SMS_OAUTH_<PROVIDER>_TOKEN_URL, which can refresh tokens in place of the former.
+func GetRefreshVars() (RefreshVars, error) {
+	loadEnvFile()
+
+	onRetrieve, err := getBool("SMS_REFRESH_ON_RETRIEVE", false)
+	if err != nil {
+		return RefreshVars{}, err
+	}
+
+	writeBack, err := getBool("SMS_REFRESH_WRITE_BACK", true)
+	if err != nil {
+		return RefreshVars{}, err
+	}
+
+	vars := RefreshVars{
+		OnRetrieve:    onRetrieve,
+		WriteBack:     writeBack,
+		ClientID:      os.Getenv("SMS_OAUTH_CLIENT_ID"),
+		ClientSecret:  os.Getenv("SMS_OAUTH_CLIENT_SECRET"),
+		TokenURL:      os.Getenv("SMS_OAUTH_TOKEN_URL"),
+		DeviceAuthURL: os.Getenv("SMS_OAUTH_DEVICE_AUTH_URL")}
+	if vars.Providers, err = getOAuthProviders(); err != nil {
+		return RefreshVars{}, err
+	}
+	canRefresh := (vars.ClientID != "" && vars.TokenURL != "") || len(vars.Providers) > 0
+	if onRetrieve && !canRefresh {
+		return RefreshVars{}, fmt.Errorf("SMS_OAUTH_CLIENT_ID and SMS_OAUTH_TOKEN_URL environment variables must be set to refresh tokens")
+	}
+	if vars.DeviceAuthURL != "" && (vars.ClientID == "" || vars.TokenURL == "") {
+		return RefreshVars{}, fmt.Errorf("SMS_OAUTH_CLIENT_ID and SMS_OAUTH_TOKEN_URL environment variables must be set for the device flow")
+	}
+
+	if vars.ScheduleInterval, err = getDuration("SMS_REFRESH_SCHEDULE_INTERVAL", 0); err != nil {
+		return RefreshVars{}, err
+	}
+	if vars.ScheduleWindow, err = getDuration("SMS_REFRESH_SCHEDULE_WINDOW", DefaultScheduleWindow); err != nil {
+		return RefreshVars{}, err
+	}
+	vars.ScheduleConcurrency = DefaultScheduleConcurrency
+	if value := os.Getenv("SMS_REFRESH_SCHEDULE_CONCURRENCY"); value != "" {
+		vars.ScheduleConcurrency, err = strconv.Atoi(value)
+		if err != nil || vars.ScheduleConcurrency < 1 {
+			return RefreshVars{}, fmt.Errorf("SMS_REFRESH_SCHEDULE_CONCURRENCY environment variable must be a positive number")
+		}
+	}
+	if vars.ScheduleInterval > 0 && !canRefresh {
+		return RefreshVars{}, fmt.Errorf("SMS_OAUTH_CLIENT_ID and SMS_OAUTH_TOKEN_URL environment variables must be set to refresh tokens")
+	}
+	if vars.ScheduleInterval > 0 && !writeBack {
+		return RefreshVars{}, fmt.Errorf("SMS_REFRESH_SCHEDULE_INTERVAL cannot be combined with SMS_REFRESH_WRITE_BACK=false")
+	}
+
+	return vars, nil
+}
+
+// getOAuthProviders reads the OAuth clients of the providers listed in SMS_OAUTH_PROVIDERS,
+// each of which needs a client ID and token URL.
+func getOAuthProviders() (map[string]OAuthClientVars, error) {
+	value := os.Getenv("SMS_OAUTH_PROVIDERS")
+	if value == "" {
+		return nil, nil
+	}
+
+	providers := map[string]OAuthClientVars{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if !providerPattern.MatchString(name) {
+			return nil, fmt.Errorf("SMS_OAUTH_PROVIDERS environment variable must be a comma-separated "+
+				"list of provider names, got %q", name)
+		}
+		prefix := "SMS_OAUTH_" + strings.ToUpper(name) + "_"
+
+		client := OAuthClientVars{
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			TokenURL:     os.Getenv(prefix + "TOKEN_URL")}
+		if client.ClientID == "" || client.TokenURL == "" {
+			return nil, fmt.Errorf("%sCLIENT_ID and %sTOKEN_URL environment variables must be set", prefix, prefix)
+		}
+		providers[name] = client
+	}
+
+	return providers, nil
+}
+
+// GetAuthVars reads JWT_SUBJECT_CLAIM, the JWT claim holding the user ID, which defaults
+// to DefaultSubjectClaim, JWT_JWKS_URL, which must be an https URL when set, and
+// JWT_VALID_METHODS, the comma-separated JWT alg values accepted, and JWT_MAX_SIZE, the
+// length in bytes of the longest JWT accepted, which defaults to DefaultJWTMaxSize.
+// JWT_QUERY_TOKEN (default false) also accepts the JWT in the access_token query parameter.
+func GetAuthVars() (AuthVars, error) {
+	loadEnvFile()
+
+	claim := os.Getenv("JWT_SUBJECT_CLAIM")
+	if claim == "" {
+		claim = DefaultSubjectClaim
+	}
+
+	jwksURL := os.Getenv("JWT_JWKS_URL")
+	if jwksURL != "" && !strings.HasPrefix(jwksURL, "https://") {
+		return AuthVars{}, fmt.Errorf("JWT_JWKS_URL environment variable must be an https URL")
+	}
+
+	var methods []string
+	if value := os.Getenv("JWT_VALID_METHODS"); value != "" {
+		for _, method := range strings.Split(value, ",") {
+			method = strings.TrimSpace(method)
+			if !slices.Contains(signingMethods, method) {
+				return AuthVars{}, fmt.Errorf("JWT_VALID_METHODS environment variable must be a comma-separated "+
+					"list of %v, got %q", strings.Join(signingMethods, ", "), method)
+			}
+			methods = append(methods, method)
+		}
+	}
+
+	maxSize := DefaultJWTMaxSize
+	if value := os.Getenv("JWT_MAX_SIZE"); value != "" {
+		var err error
+		maxSize, err = strconv.Atoi(value)
+		if err != nil || maxSize < 1 {
+			return AuthVars{}, fmt.Errorf("JWT_MAX_SIZE environment variable must be a positive number")
+		}
+	}
+
+	queryToken, err := getBool("JWT_QUERY_TOKEN", false)
+	if err != nil {
+		return AuthVars{}, err
+	}
+
+	return AuthVars{SubjectClaim: claim, JWKSURL: jwksURL, ValidMethods: methods, MaxSize: maxSize,
+		QueryToken: queryToken}, nil
+}
+
+// GetTokenVars reads SMS_DEFAULT_TOKEN_TYPE, the token type stored for tokens saved without
+// one, which defaults to DefaultTokenType, SMS_TOKEN_BASE64 (default false) and
+// SMS_CREATE_IF_MISSING (default true), SMS_MAX_PROVIDERS (default unlimited) and
+// SMS_REFRESH_TOKEN_KMS_KEY_ID, the symmetric KMS key refresh tokens are encrypted with.
+// SMS_TOKEN_SCHEMA (default true) enables the schema envelope and
+// SMS_TOKEN_MIGRATE_ON_READ (default false) the migration of older tokens on read, which
+// requires the envelope.
+// SMS_TOKEN_PROVIDER_TTLS holds comma-separated provider=duration pairs, e.g.
+// "google=720h,github=2160h", giving the tokens of those providers a fixed lifetime.
+// SMS_TOKEN_EXPORT (default false) enables the export of sealed tokens, and
+// SMS_TOKEN_IMPORT_KMS_KEY_ID their import with that RSA ENCRYPT_DECRYPT KMS key.
+func GetTokenVars() (TokenVars, error) {
+	loadEnvFile()
+
+	tokenType := os.Getenv("SMS_DEFAULT_TOKEN_TYPE")
+	if tokenType == "" {
+		tokenType = DefaultTokenType
+	}
+
+	b64, err := getBool("SMS_TOKEN_BASE64", false)
+	if err != nil {
+		return TokenVars{}, err
+	}
+
+	create, err := getBool("SMS_CREATE_IF_MISSING", true)
+	if err != nil {
+		return TokenVars{}, err
+	}
+
+	var maxProviders int
+	if value := os.Getenv("SMS_MAX_PROVIDERS"); value != "" {
+		maxProviders, err = strconv.Atoi(value)
+		if err != nil || maxProviders < 1 {
+			return TokenVars{}, fmt.Errorf("SMS_MAX_PROVIDERS environment variable must be a positive number")
+		}
+	}
+
+	schema, err := getBool("SMS_TOKEN_SCHEMA", true)
+	if err != nil {
+		return TokenVars{}, err
+	}
+
+	migrate, err := getBool("SMS_TOKEN_MIGRATE_ON_READ", false)
+	if err != nil {
+		return TokenVars{}, err
+	}
+	if migrate && !schema {
+		return TokenVars{}, fmt.Errorf("SMS_TOKEN_MIGRATE_ON_READ requires SMS_TOKEN_SCHEMA")
+	}
+
+	export, err := getBool("SMS_TOKEN_EXPORT", false)
+	if err != nil {
+		return TokenVars{}, err
+	}
+
+	var ttls map[string]time.Duration
+	if value := os.Getenv("SMS_TOKEN_PROVIDER_TTLS"); value != "" {
+		ttls = map[string]time.Duration{}
+		for _, pair := range strings.Split(value, ",") {
+			provider, ttl, ok := strings.Cut(pair, "=")
+			provider = strings.TrimSpace(provider)
+			d, err := time.ParseDuration(strings.TrimSpace(ttl))
+			if !ok || provider == "" || err != nil || d <= 0 {
+				return TokenVars{}, fmt.Errorf("SMS_TOKEN_PROVIDER_TTLS must be comma-separated provider=duration pairs with positive durations")
+			}
+			ttls[provider] = d
+		}
+	}
+
+	return TokenVars{
+		DefaultTokenType:  tokenType,
+		Base64:            b64,
+		CreateIfMissing:   create,
+		MaxProviders:      maxProviders,
+		RefreshTokenKeyID: os.Getenv("SMS_REFRESH_TOKEN_KMS_KEY_ID"),
+		Schema:            schema,
+		MigrateOnRead:     migrate,
+		ProviderTTLs:      ttls,
+		Export:            export,
+		ImportKeyID:       os.Getenv("SMS_TOKEN_IMPORT_KMS_KEY_ID"),
+	}, nil
+}
+
+// GetLogVars reads SMS_LOG_FORMAT, text (default) or json, and SMS_LOG_LEVEL, one of
+// debug, info (default), warn or error.
+func GetLogVars() (LogVars, error) {
+	loadEnvFile()
+
+	format := os.Getenv("SMS_LOG_FORMAT")
+	switch format {
+	case "":
+		format = LogFormatText
+	case LogFormatText, LogFormatJSON:
+	default:
+		return LogVars{}, fmt.Errorf("SMS_LOG_FORMAT must be %s or %s", LogFormatText, LogFormatJSON)
+	}
+
+	var level slog.Level
+	if value := os.Getenv("SMS_LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return LogVars{}, fmt.Errorf("SMS_LOG_LEVEL must be debug, info, warn or error")
+		}
+	}
+
+	return LogVars{Format: format, Level: level}, nil
+}
+
+// GetTenantVars reads SMS_TENANT_CLAIM, the JWT claim holding the tenant, which defaults to
+// DefaultTenantClaim, and SMS_TENANT_ROLES, comma-separated tenant=role ARN pairs.
+func GetTenantVars() (TenantVars, error) {
+	loadEnvFile()
+
+	claim := os.Getenv("SMS_TENANT_CLAIM")
+	if claim == "" {
+		claim = DefaultTenantClaim
+	}
+
+	vars := TenantVars{Claim: claim}
+	if roles := os.Getenv("SMS_TENANT_ROLES"); roles != "" {
+		vars.Roles = map[string]string{}
+		for _, role := range strings.Split(roles, ",") {
+			tenant, arn, ok := strings.Cut(role, "=")
+			tenant, arn = strings.TrimSpace(tenant), strings.TrimSpace(arn)
+			if !ok || tenant == "" || !strings.HasPrefix(arn, "arn:") {
+				return TenantVars{}, fmt.Errorf("SMS_TENANT_ROLES must be comma-separated tenant=role ARN pairs")
+			}
+			vars.Roles[tenant] = arn
+		}
+	}
+
+	return vars, nil
+}
+
+// getBool reads a boolean environment variable, returning def when it is not set.
+func getBool(name string, def bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s environment variable must be true or false", name)
+	}
+
+	return b, nil
+}
+
+// getDuration reads a non-negative duration environment variable, returning def when it is
+// not set.
+func getDuration(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s environment variable must be a non-negative duration", name)
+	}
+
+	return d, nil
 }
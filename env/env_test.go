@@ -0,0 +1,393 @@
+package env
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGetAwsVars_RootDomain(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{
+			name:  "RootDomainValid",
+			value: "sms-root.example",
+		},
+		{
+			name:    "RootDomainTrailingSlash",
+			value:   "sms-root/",
+			wantErr: true,
+		},
+		{
+			name:    "RootDomainLeadingSlash",
+			value:   "/sms-root",
+			wantErr: true,
+		},
+		{
+			name:    "RootDomainEmbeddedSlash",
+			value:   "sms/root",
+			wantErr: true,
+		},
+		{
+			name:    "RootDomainSurroundingWhitespace",
+			value:   " sms-root\n",
+			wantErr: true,
+		},
+		{
+			name:    "RootDomainUnset",
+			value:   "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SMS_ROOT_DOMAIN", tt.value)
+			t.Setenv("KMS_KEY_ID", "key-id")
+
+			vars, err := GetAwsVars()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetAwsVars() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && vars.SmsRootDomain != tt.value {
+				t.Errorf("GetAwsVars() SmsRootDomain = %v, want %v", vars.SmsRootDomain, tt.value)
+			}
+		})
+	}
+}
+
+func TestGetAwsVars_AllowedProviders(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "AllowedProvidersUnset",
+			value: "",
+			want:  nil,
+		},
+		{
+			name:  "AllowedProvidersList",
+			value: "google, github",
+			want:  []string{"google", "github"},
+		},
+		{
+			name:    "AllowedProvidersEmptyEntry",
+			value:   "google,,github",
+			wantErr: true,
+		},
+		{
+			name:    "AllowedProvidersWithSlash",
+			value:   "google/drive",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SMS_ROOT_DOMAIN", "root-domain")
+			t.Setenv("KMS_KEY_ID", "key-id")
+			t.Setenv("SMS_ALLOWED_PROVIDERS", tt.value)
+
+			vars, err := GetAwsVars()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetAwsVars() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(vars.AllowedProviders, tt.want) {
+				t.Errorf("GetAwsVars() AllowedProviders = %v, want %v", vars.AllowedProviders, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetAwsVars_PreviousKmsKeyIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "PreviousKeyIDsUnset",
+			value: "",
+			want:  nil,
+		},
+		{
+			name:  "PreviousKeyIDsList",
+			value: "old-key, alias/older",
+			want:  []string{"old-key", "alias/older"},
+		},
+		{
+			name:    "PreviousKeyIDsCurrentKey",
+			value:   "old-key,key-id",
+			wantErr: true,
+		},
+		{
+			name:    "PreviousKeyIDsDuplicate",
+			value:   "old-key,old-key",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SMS_ROOT_DOMAIN", "root-domain")
+			t.Setenv("KMS_KEY_ID", "key-id")
+			t.Setenv("KMS_PREVIOUS_KEY_IDS", tt.value)
+
+			vars, err := GetAwsVars()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetAwsVars() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(vars.PreviousKmsKeyIDs, tt.want) {
+				t.Errorf("GetAwsVars() PreviousKmsKeyIDs = %v, want %v", vars.PreviousKmsKeyIDs, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetAuthVars_ValidMethods(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "ValidMethodsUnset",
+			value: "",
+			want:  nil,
+		},
+		{
+			name:  "ValidMethodsList",
+			value: "RS256, PS256",
+			want:  []string{"RS256", "PS256"},
+		},
+		{
+			name:    "ValidMethodsSymmetric",
+			value:   "RS256,HS256",
+			wantErr: true,
+		},
+		{
+			name:    "ValidMethodsNone",
+			value:   "none",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_VALID_METHODS", tt.value)
+
+			vars, err := GetAuthVars()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetAuthVars() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(vars.ValidMethods, tt.want) {
+				t.Errorf("GetAuthVars() ValidMethods = %v, want %v", vars.ValidMethods, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTokenVars_ProviderTTLs(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{
+			name:  "ProviderTTLsUnset",
+			value: "",
+			want:  nil,
+		},
+		{
+			name:  "ProviderTTLsList",
+			value: "google=720h, github = 2160h",
+			want:  map[string]time.Duration{"google": 720 * time.Hour, "github": 2160 * time.Hour},
+		},
+		{
+			name:    "ProviderTTLsMissingDuration",
+			value:   "google",
+			wantErr: true,
+		},
+		{
+			name:    "ProviderTTLsEmptyProvider",
+			value:   "=720h",
+			wantErr: true,
+		},
+		{
+			name:    "ProviderTTLsNotPositive",
+			value:   "google=0s",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SMS_TOKEN_PROVIDER_TTLS", tt.value)
+
+			vars, err := GetTokenVars()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetTokenVars() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !maps.Equal(vars.ProviderTTLs, tt.want) {
+				t.Errorf("GetTokenVars() ProviderTTLs = %v, want %v", vars.ProviderTTLs, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTokenVars_Schema(t *testing.T) {
+	tests := []struct {
+		name        string
+		schema      string
+		migrate     string
+		wantSchema  bool
+		wantMigrate bool
+		wantErr     bool
+	}{
+		{
+			name:       "SchemaDefault",
+			wantSchema: true,
+		},
+		{
+			name:   "SchemaDisabled",
+			schema: "false",
+		},
+		{
+			name:        "MigrateOnReadWithDefaultSchema",
+			migrate:     "true",
+			wantSchema:  true,
+			wantMigrate: true,
+		},
+		{
+			name:    "MigrateOnReadWithoutSchema",
+			schema:  "false",
+			migrate: "true",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SMS_TOKEN_SCHEMA", tt.schema)
+			t.Setenv("SMS_TOKEN_MIGRATE_ON_READ", tt.migrate)
+
+			vars, err := GetTokenVars()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetTokenVars() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if vars.Schema != tt.wantSchema || vars.MigrateOnRead != tt.wantMigrate {
+				t.Errorf("GetTokenVars() Schema, MigrateOnRead = %v, %v, want %v, %v",
+					vars.Schema, vars.MigrateOnRead, tt.wantSchema, tt.wantMigrate)
+			}
+		})
+	}
+}
+
+func TestGetRefreshVars_Providers(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers string
+		env       map[string]string
+		want      map[string]OAuthClientVars
+		wantErr   bool
+	}{
+		{
+			name: "ProvidersUnset",
+			want: nil,
+		},
+		{
+			name:      "ProvidersRefreshWithoutDefaultClient",
+			providers: "google",
+			env: map[string]string{
+				"SMS_OAUTH_GOOGLE_CLIENT_ID":     "google-id",
+				"SMS_OAUTH_GOOGLE_CLIENT_SECRET": "google-secret",
+				"SMS_OAUTH_GOOGLE_TOKEN_URL":     "https://oauth2.googleapis.com/token",
+				"SMS_REFRESH_ON_RETRIEVE":        "true"},
+			want: map[string]OAuthClientVars{"google": {
+				ClientID: "google-id", ClientSecret: "google-secret", TokenURL: "https://oauth2.googleapis.com/token"}},
+		},
+		{
+			name:      "ProvidersMissingTokenURL",
+			providers: "google",
+			env:       map[string]string{"SMS_OAUTH_GOOGLE_CLIENT_ID": "google-id"},
+			wantErr:   true,
+		},
+		{
+			name:      "ProvidersEmptyEntry",
+			providers: "google,,github",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SMS_OAUTH_PROVIDERS", tt.providers)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			vars, err := GetRefreshVars()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetRefreshVars() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(vars.Providers, tt.want) {
+				t.Errorf("GetRefreshVars() Providers = %v, want %v", vars.Providers, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetServerVars_Timeouts(t *testing.T) {
+	tests := []struct {
+		name              string
+		writeTimeout      string
+		maxRequestTimeout string
+		wantErr           bool
+	}{
+		{
+			name: "TimeoutsDefault",
+		},
+		{
+			name:              "WriteTimeoutLongerThanMaxRequestTimeout",
+			writeTimeout:      "20s",
+			maxRequestTimeout: "10s",
+		},
+		{
+			name:         "WriteTimeoutNotLongerThanMaxRequestTimeout",
+			writeTimeout: "30s",
+			wantErr:      true,
+		},
+		{
+			name:         "WriteTimeoutDisabled",
+			writeTimeout: "0",
+		},
+		{
+			name:              "MaxRequestTimeoutDisabled",
+			writeTimeout:      "5s",
+			maxRequestTimeout: "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SMS_WRITE_TIMEOUT", tt.writeTimeout)
+			t.Setenv("SMS_MAX_REQUEST_TIMEOUT", tt.maxRequestTimeout)
+
+			_, err := GetServerVars()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetServerVars() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
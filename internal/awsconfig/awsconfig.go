@@ -0,0 +1,55 @@
+package awsconfig
+
+import (
+	"app/env"
+	"context"
+	aw "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// RoleSessionName is the session name the role of AssumeRoleARN is assumed with, which
+// shows up in the CloudTrail logs of the account holding the secrets.
+const RoleSessionName = "oauth-secret-manager-service"
+
+// Options returns the config.LoadOptions functions shared by every AWS client factory of
+// the service, such as secret.NewClient and key.NewClient, derived from env.AwsVars. With
+// a Profile, credentials and settings come from that shared config profile. A profile set
+// this way takes precedence over credentials in the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables. With an AssumeRoleARN, the clients use the
+// temporary credentials of that role, assumed with the credentials found otherwise.
+func Options(vars env.AwsVars) []func(*config.LoadOptions) error {
+	var opts []func(*config.LoadOptions) error
+	if vars.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(vars.Profile))
+	}
+	if vars.AssumeRoleARN != "" {
+		opts = append(opts, assumeRole(vars, opts))
+	}
+
+	return opts
+}
+
+// assumeRole returns a config.LoadOptions function replacing the credentials with those of
+// the role of AssumeRoleARN. The role is assumed through STS with the config loaded from
+// base, lazily by the first call, and renewed before the credentials expire.
+func assumeRole(vars env.AwsVars, base []func(*config.LoadOptions) error) func(*config.LoadOptions) error {
+	base = append([]func(*config.LoadOptions) error(nil), base...)
+
+	return func(o *config.LoadOptions) error {
+		conf, err := config.LoadDefaultConfig(context.TODO(), base...)
+		if err != nil {
+			return err
+		}
+
+		o.Credentials = aw.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(conf), vars.AssumeRoleARN,
+			func(ao *stscreds.AssumeRoleOptions) {
+				ao.RoleSessionName = RoleSessionName
+				if vars.AssumeRoleExternalID != "" {
+					ao.ExternalID = aw.String(vars.AssumeRoleExternalID)
+				}
+			}))
+		return nil
+	}
+}
@@ -0,0 +1,57 @@
+package awsconfig
+
+import (
+	"app/env"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	tests := []struct {
+		name           string
+		vars           env.AwsVars
+		wantProfile    string
+		wantAssumeRole bool
+	}{
+		{
+			name:        "OptionsWithProfile",
+			vars:        env.AwsVars{Profile: "staging"},
+			wantProfile: "staging",
+		},
+		{
+			name:        "OptionsWithoutProfile",
+			vars:        env.AwsVars{},
+			wantProfile: "",
+		},
+		{
+			name: "OptionsWithAssumeRole",
+			vars: env.AwsVars{
+				AssumeRoleARN:        "arn:aws:iam::111111111111:role/secrets",
+				AssumeRoleExternalID: "external-id"},
+			wantAssumeRole: true,
+		},
+	}
+
+	t.Setenv("AWS_REGION", "eu-west-1")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts config.LoadOptions
+			for _, fn := range Options(tt.vars) {
+				if err := fn(&opts); err != nil {
+					t.Fatalf("Options() error = %v", err)
+				}
+			}
+			if opts.SharedConfigProfile != tt.wantProfile {
+				t.Errorf("Options() profile = %v, want %v", opts.SharedConfigProfile, tt.wantProfile)
+			}
+
+			cache, ok := opts.Credentials.(*aws.CredentialsCache)
+			assumeRole := ok && cache.IsCredentialsProvider(&stscreds.AssumeRoleProvider{})
+			if assumeRole != tt.wantAssumeRole {
+				t.Errorf("Options() credentials = %T, want assume role %v", opts.Credentials, tt.wantAssumeRole)
+			}
+		})
+	}
+}
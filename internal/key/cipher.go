@@ -0,0 +1,129 @@
+package key
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	aw "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"maps"
+)
+
+type (
+	// CipherClient defines the part of kms.Client used to encrypt and decrypt data, so the
+	// AwsCipher and AwsRSADecrypter can be stubbed out for testing.
+	CipherClient interface {
+		Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (
+			*kms.EncryptOutput, error)
+		Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (
+			*kms.DecryptOutput, error)
+	}
+
+	// AwsCipher encrypts and decrypts small values, such as refresh tokens, with the
+	// symmetric KMS key KeyID. Context is passed as the encryption context of every call,
+	// so a ciphertext can only be decrypted for the purpose it was encrypted for. A
+	// non-empty secret ID is added to it under SecretIDContextKey, which binds the
+	// ciphertext to the secret it is stored in. Ciphertexts encrypted without a secret ID
+	// can still be decrypted for any secret.
+	AwsCipher struct {
+		Client  CipherClient
+		KeyID   string
+		Context map[string]string
+	}
+
+	// AwsRSADecrypter decrypts small values, such as content keys, encrypted with RSA-OAEP
+	// and SHA-256 for the public key of the asymmetric ENCRYPT_DECRYPT KMS key KeyID. The
+	// private key never leaves KMS.
+	AwsRSADecrypter struct {
+		Client CipherClient
+		KeyID  string
+	}
+
+	// RSADecrypter decrypts values encrypted with RSA-OAEP and SHA-256 with a local private
+	// key. It stands in for an AwsRSADecrypter in tests.
+	RSADecrypter struct {
+		Key *rsa.PrivateKey
+	}
+)
+
+// SecretIDContextKey is the encryption context key AwsCipher binds a ciphertext to its
+// secret with.
+const SecretIDContextKey = "secret_id"
+
+// ErrUndecryptable is returned by AwsRSADecrypter and RSADecrypter for a ciphertext that
+// was not encrypted for their key, or was corrupted.
+var ErrUndecryptable = errors.New("ciphertext cannot be decrypted with the key")
+
+func (c *AwsCipher) Encrypt(ctx context.Context, plaintext []byte, secretID string) ([]byte, error) {
+	result, err := c.Client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:             aw.String(c.KeyID),
+		Plaintext:         plaintext,
+		EncryptionContext: c.encryptionContext(secretID)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to encrypt with KMS key %v: %w", c.KeyID, err)
+	}
+
+	return result.CiphertextBlob, nil
+}
+
+func (c *AwsCipher) Decrypt(ctx context.Context, ciphertext []byte, secretID string) ([]byte, error) {
+	result, err := c.Client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             aw.String(c.KeyID),
+		CiphertextBlob:    ciphertext,
+		EncryptionContext: c.encryptionContext(secretID)})
+	var invalid *types.InvalidCiphertextException
+	if errors.As(err, &invalid) && secretID != "" {
+		// The ciphertext may have been encrypted before it was bound to its secret.
+		result, err = c.Client.Decrypt(ctx, &kms.DecryptInput{
+			KeyId:             aw.String(c.KeyID),
+			CiphertextBlob:    ciphertext,
+			EncryptionContext: c.Context})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt with KMS key %v: %w", c.KeyID, err)
+	}
+
+	return result.Plaintext, nil
+}
+
+// encryptionContext returns Context with secretID added under SecretIDContextKey, or
+// Context itself for an empty secretID.
+func (c *AwsCipher) encryptionContext(secretID string) map[string]string {
+	if secretID == "" {
+		return c.Context
+	}
+
+	encCtx := make(map[string]string, len(c.Context)+1)
+	maps.Copy(encCtx, c.Context)
+	encCtx[SecretIDContextKey] = secretID
+
+	return encCtx
+}
+
+func (d *AwsRSADecrypter) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	result, err := d.Client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:               aw.String(d.KeyID),
+		CiphertextBlob:      ciphertext,
+		EncryptionAlgorithm: types.EncryptionAlgorithmSpecRsaesOaepSha256})
+	var invalid *types.InvalidCiphertextException
+	if errors.As(err, &invalid) {
+		return nil, fmt.Errorf("%w: %w", ErrUndecryptable, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt with KMS key %v: %w", d.KeyID, err)
+	}
+
+	return result.Plaintext, nil
+}
+
+func (d *RSADecrypter) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), nil, d.Key, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUndecryptable, err)
+	}
+
+	return plaintext, nil
+}
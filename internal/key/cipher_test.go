@@ -0,0 +1,204 @@
+package key
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"maps"
+	"slices"
+	"testing"
+)
+
+type CipherClientStub struct {
+	EncryptFunc func(*kms.EncryptInput) (*kms.EncryptOutput, error)
+	DecryptFunc func(*kms.DecryptInput) (*kms.DecryptOutput, error)
+}
+
+func (s *CipherClientStub) Encrypt(ctx context.Context, input *kms.EncryptInput,
+	opts ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	return s.EncryptFunc(input)
+}
+
+func (s *CipherClientStub) Decrypt(ctx context.Context, input *kms.DecryptInput,
+	opts ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	return s.DecryptFunc(input)
+}
+
+// reversingCipherStub "encrypts" by reversing the plaintext and checks that the key and
+// encryption context are passed on every call.
+func reversingCipherStub(t *testing.T, keyID string, encCtx map[string]string) *CipherClientStub {
+	check := func(gotKey *string, gotCtx map[string]string) {
+		if gotKey == nil || *gotKey != keyID {
+			t.Errorf("key = %v, want %v", gotKey, keyID)
+		}
+		if !maps.Equal(gotCtx, encCtx) {
+			t.Errorf("encryption context = %v, want %v", gotCtx, encCtx)
+		}
+	}
+	reverse := func(b []byte) []byte {
+		r := slices.Clone(b)
+		slices.Reverse(r)
+		return r
+	}
+
+	return &CipherClientStub{
+		EncryptFunc: func(input *kms.EncryptInput) (*kms.EncryptOutput, error) {
+			check(input.KeyId, input.EncryptionContext)
+			return &kms.EncryptOutput{CiphertextBlob: reverse(input.Plaintext)}, nil
+		},
+		DecryptFunc: func(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+			check(input.KeyId, input.EncryptionContext)
+			return &kms.DecryptOutput{Plaintext: reverse(input.CiphertextBlob)}, nil
+		},
+	}
+}
+
+func TestAwsCipher_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	encCtx := map[string]string{"field": "refresh_token"}
+	bound := map[string]string{"field": "refresh_token", SecretIDContextKey: "root/1"}
+	c := &AwsCipher{Client: reversingCipherStub(t, "alias/refresh", bound), KeyID: "alias/refresh", Context: encCtx}
+
+	ciphertext, err := c.Encrypt(ctx, []byte("secret"), "root/1")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if string(ciphertext) == "secret" {
+		t.Errorf("Encrypt() returned the plaintext")
+	}
+
+	plaintext, err := c.Decrypt(ctx, ciphertext, "root/1")
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("Decrypt() = %q, want %q", plaintext, "secret")
+	}
+	if len(encCtx) != 1 {
+		t.Errorf("Encrypt() modified Context: %v", encCtx)
+	}
+}
+
+func TestAwsCipher_DecryptUnbound(t *testing.T) {
+	encCtx := map[string]string{"field": "refresh_token"}
+	var got []map[string]string
+	stub := &CipherClientStub{DecryptFunc: func(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+		got = append(got, input.EncryptionContext)
+		if _, ok := input.EncryptionContext[SecretIDContextKey]; ok {
+			return nil, &types.InvalidCiphertextException{}
+		}
+		return &kms.DecryptOutput{Plaintext: []byte("secret")}, nil
+	}}
+	c := &AwsCipher{Client: stub, KeyID: "alias/refresh", Context: encCtx}
+
+	plaintext, err := c.Decrypt(context.Background(), []byte("terces"), "root/1")
+	if err != nil || string(plaintext) != "secret" {
+		t.Fatalf("Decrypt() = %q, %v, want %q", plaintext, err, "secret")
+	}
+	if len(got) != 2 || got[0][SecretIDContextKey] != "root/1" || !maps.Equal(got[1], encCtx) {
+		t.Errorf("Decrypt() encryption contexts = %v, want the bound one, then %v", got, encCtx)
+	}
+}
+
+func TestAwsCipher_Errors(t *testing.T) {
+	stub := &CipherClientStub{
+		EncryptFunc: func(*kms.EncryptInput) (*kms.EncryptOutput, error) {
+			return nil, errors.New("access denied")
+		},
+		DecryptFunc: func(*kms.DecryptInput) (*kms.DecryptOutput, error) {
+			return nil, errors.New("invalid ciphertext")
+		},
+	}
+	c := &AwsCipher{Client: stub, KeyID: "key"}
+
+	if _, err := c.Encrypt(context.Background(), []byte("secret"), "root/1"); err == nil {
+		t.Errorf("Encrypt() error = nil, want an error")
+	}
+	if _, err := c.Decrypt(context.Background(), []byte("terces"), "root/1"); err == nil {
+		t.Errorf("Decrypt() error = nil, want an error")
+	}
+}
+
+func TestAwsRSADecrypter_Decrypt(t *testing.T) {
+	tests := []struct {
+		name          string
+		output        *kms.DecryptOutput
+		err           error
+		want          string
+		wantErr       bool
+		wantUndecrypt bool
+	}{
+		{
+			name:   "DecryptSuccess",
+			output: &kms.DecryptOutput{Plaintext: []byte("content key")},
+			want:   "content key",
+		},
+		{
+			name:          "DecryptInvalidCiphertext",
+			err:           &types.InvalidCiphertextException{},
+			wantErr:       true,
+			wantUndecrypt: true,
+		},
+		{
+			name:    "DecryptAccessDenied",
+			err:     errors.New("access denied"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &AwsRSADecrypter{KeyID: "alias/import", Client: &CipherClientStub{
+				DecryptFunc: func(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+					if input.KeyId == nil || *input.KeyId != "alias/import" {
+						t.Errorf("key = %v, want %v", input.KeyId, "alias/import")
+					}
+					if input.EncryptionAlgorithm != types.EncryptionAlgorithmSpecRsaesOaepSha256 {
+						t.Errorf("algorithm = %v, want %v", input.EncryptionAlgorithm,
+							types.EncryptionAlgorithmSpecRsaesOaepSha256)
+					}
+					return tt.output, tt.err
+				}}}
+
+			got, err := d.Decrypt(context.Background(), []byte("ciphertext"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrUndecryptable) != tt.wantUndecrypt {
+				t.Errorf("Decrypt() error = %v, want undecryptable %v", err, tt.wantUndecrypt)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Decrypt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRSADecrypter_Decrypt(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &priv.PublicKey, []byte("content key"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &RSADecrypter{Key: priv}
+
+	got, err := d.Decrypt(context.Background(), ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(got) != "content key" {
+		t.Errorf("Decrypt() = %q, want %q", got, "content key")
+	}
+
+	ciphertext[0] ^= 0xff
+	if _, err = d.Decrypt(context.Background(), ciphertext); !errors.Is(err, ErrUndecryptable) {
+		t.Errorf("Decrypt() error = %v, want %v", err, ErrUndecryptable)
+	}
+}
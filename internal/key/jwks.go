@@ -0,0 +1,262 @@
+package key
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultJWKSRefreshInterval is the minimum time between two fetches of a JWKS, so tokens
+// with unknown key IDs cannot cause a storm of requests to the identity provider.
+const DefaultJWKSRefreshInterval = time.Minute
+
+// MaxJWKSSize is the size in bytes of the largest JWKS a JWKSGetter reads, far above the
+// few kilobytes of a real key set.
+const MaxJWKSSize = 1 << 20
+
+// ErrUnknownKeyID is returned by a JWKSGetter when the key set has no key with the
+// requested key ID, even after fetching it again.
+var ErrUnknownKeyID = errors.New("unknown key ID")
+
+type (
+	// JWKSGetter is a Getter and IDGetter for the public keys published by an identity
+	// provider as a JSON Web Key Set at URL. The key set is fetched on first use and
+	// cached; an unknown key ID fetches it again, at most once every RefreshInterval
+	// (DefaultJWKSRefreshInterval when zero), which picks up rotated keys. A failed fetch
+	// is not retried within RefreshInterval either, lookups return its error. At most one
+	// fetch is in flight, concurrent lookups wait for it without holding up lookups of
+	// cached keys, and key sets larger than MaxJWKSSize are rejected. RSA keys and EC keys
+	// on the P-256 curve are supported, other keys in the set are skipped. Client defaults
+	// to http.DefaultClient. It is safe for concurrent use.
+	JWKSGetter struct {
+		URL             string
+		Client          *http.Client
+		RefreshInterval time.Duration
+		Now             func() time.Time
+
+		mu        sync.Mutex
+		keys      map[string][]byte
+		lastFetch time.Time
+		fetching  chan struct{}
+		fetchErr  error
+	}
+
+	// jwk is a single JSON Web Key, with the members of RSA and EC public keys.
+	jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+)
+
+// GetPublicKey returns the public key of a key set holding exactly one key, so a
+// JWKSGetter can stand in for any Getter. Key sets with several keys need GetPublicKeyByID.
+func (jg *JWKSGetter) GetPublicKey() ([]byte, error) {
+	keys, err := jg.cachedKeys()
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != 1 {
+		return nil, fmt.Errorf("JWKS at %v has %d keys, a key ID is required", jg.URL, len(keys))
+	}
+	for _, pubKey := range keys {
+		return pubKey, nil
+	}
+
+	return nil, nil
+}
+
+func (jg *JWKSGetter) GetPublicKeyByID(kid string) ([]byte, error) {
+	keys, err := jg.cachedKeys()
+	if err != nil {
+		return nil, err
+	}
+	if pubKey, ok := keys[kid]; ok {
+		return pubKey, nil
+	}
+
+	if keys, err = jg.refresh(); err != nil {
+		return nil, err
+	}
+	if pubKey, ok := keys[kid]; ok {
+		return pubKey, nil
+	}
+
+	return nil, fmt.Errorf("%w %q", ErrUnknownKeyID, kid)
+}
+
+// cachedKeys returns the cached keys, fetching the key set when none are cached.
+func (jg *JWKSGetter) cachedKeys() (map[string][]byte, error) {
+	jg.mu.Lock()
+	keys := jg.keys
+	jg.mu.Unlock()
+	if keys != nil {
+		return keys, nil
+	}
+
+	return jg.refresh()
+}
+
+// refresh fetches the key set again, unless the last attempt was less than
+// RefreshInterval ago, and returns the cached keys together with the error of the last
+// attempt. When a fetch is already in flight, it waits for that one.
+func (jg *JWKSGetter) refresh() (map[string][]byte, error) {
+	jg.mu.Lock()
+	if fetching := jg.fetching; fetching != nil {
+		jg.mu.Unlock()
+		<-fetching
+
+		jg.mu.Lock()
+		defer jg.mu.Unlock()
+		return jg.keys, jg.fetchErr
+	}
+	if !jg.lastFetch.IsZero() && jg.now().Sub(jg.lastFetch) < jg.refreshInterval() {
+		defer jg.mu.Unlock()
+		return jg.keys, jg.fetchErr
+	}
+
+	// The attempt counts against the refresh interval even when it fails, so an
+	// unreachable identity provider is not hammered either.
+	jg.lastFetch = jg.now()
+	fetching := make(chan struct{})
+	jg.fetching = fetching
+	jg.mu.Unlock()
+
+	keys, err := jg.fetch()
+
+	jg.mu.Lock()
+	if err == nil {
+		jg.keys = keys
+	}
+	jg.fetchErr, jg.fetching = err, nil
+	keys = jg.keys
+	jg.mu.Unlock()
+	close(fetching)
+
+	return keys, err
+}
+
+// refreshInterval returns RefreshInterval, or DefaultJWKSRefreshInterval when it is zero.
+func (jg *JWKSGetter) refreshInterval() time.Duration {
+	if jg.RefreshInterval == 0 {
+		return DefaultJWKSRefreshInterval
+	}
+	return jg.RefreshInterval
+}
+
+// fetch reads the current key set.
+func (jg *JWKSGetter) fetch() (map[string][]byte, error) {
+	client := jg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Get(jg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch JWKS: status %v", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxJWKSSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch JWKS: %w", err)
+	}
+	if len(body) > MaxJWKSSize {
+		return nil, fmt.Errorf("unable to fetch JWKS: larger than %d bytes", MaxJWKSSize)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("unable to decode JWKS: %w", err)
+	}
+
+	keys := map[string][]byte{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pubKey, err := k.marshalPKIX()
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Skipping key %q of JWKS: %v", k.Kid, err))
+			continue
+		}
+		keys[k.Kid] = pubKey
+	}
+
+	return keys, nil
+}
+
+func (jg *JWKSGetter) now() time.Time {
+	if jg.Now != nil {
+		return jg.Now()
+	}
+
+	return time.Now()
+}
+
+// marshalPKIX returns the DER encoded PKIX form of the key, the format every Getter returns.
+func (k jwk) marshalPKIX() ([]byte, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return x509.MarshalPKIXPublicKey(&rsa.PublicKey{N: n, E: int(e.Int64())})
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the P-256 curve")
+		}
+		return x509.MarshalPKIXPublicKey(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y})
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid base64url integer %q", s)
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
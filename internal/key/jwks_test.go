@@ -0,0 +1,303 @@
+package key
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWKSGetter_GetPublicKeyByID(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches atomic.Int32
+	var rotated atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		keys := []map[string]string{testJWK("rsa", &rsaKey.PublicKey), testJWK("ec", &ecKey.PublicKey)}
+		if rotated.Load() {
+			keys = append(keys, testJWK("rotated", &rotatedKey.PublicKey))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	defer srv.Close()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	jg := &JWKSGetter{URL: srv.URL, Client: srv.Client(), Now: func() time.Time { return now }}
+
+	for kid, pub := range map[string]any{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey} {
+		got, err := jg.GetPublicKeyByID(kid)
+		if err != nil {
+			t.Fatalf("GetPublicKeyByID(%v) error = %v", kid, err)
+		}
+		want, _ := x509.MarshalPKIXPublicKey(pub)
+		if !bytes.Equal(got, want) {
+			t.Errorf("GetPublicKeyByID(%v) returned a different key", kid)
+		}
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("GetPublicKeyByID() fetches = %v, want 1", fetches.Load())
+	}
+
+	// An unknown key ID right after a fetch is rate limited.
+	rotated.Store(true)
+	if _, err := jg.GetPublicKeyByID("rotated"); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("GetPublicKeyByID() error = %v, want ErrUnknownKeyID", err)
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("GetPublicKeyByID() fetches = %v, want 1", fetches.Load())
+	}
+
+	// Once the refresh interval has passed, the key set is fetched again.
+	now = now.Add(DefaultJWKSRefreshInterval)
+	if _, err := jg.GetPublicKeyByID("rotated"); err != nil {
+		t.Errorf("GetPublicKeyByID() error = %v", err)
+	}
+	if _, err := jg.GetPublicKeyByID("unknown"); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("GetPublicKeyByID() error = %v, want ErrUnknownKeyID", err)
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("GetPublicKeyByID() fetches = %v, want 2", fetches.Load())
+	}
+}
+
+func TestJWKSGetter_GetPublicKey(t *testing.T) {
+	privateKey, getter, err := GenerateTestKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keys    []map[string]string
+		status  int
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name:   "GetPublicKeySingleKey",
+			keys:   []map[string]string{testJWK("1", &privateKey.PublicKey)},
+			status: http.StatusOK,
+			want:   getter.PublicKey,
+		},
+		{
+			name:    "GetPublicKeySeveralKeys",
+			keys:    []map[string]string{testJWK("1", &privateKey.PublicKey), testJWK("2", &privateKey.PublicKey)},
+			status:  http.StatusOK,
+			wantErr: true,
+		},
+		{
+			name:    "GetPublicKeyUnsupportedKeysSkipped",
+			keys:    []map[string]string{{"kty": "oct", "kid": "1", "k": "c2VjcmV0"}},
+			status:  http.StatusOK,
+			wantErr: true,
+		},
+		{
+			name:    "GetPublicKeyServerError",
+			status:  http.StatusInternalServerError,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				json.NewEncoder(w).Encode(map[string]any{"keys": tt.keys})
+			}))
+			defer srv.Close()
+
+			got, err := (&JWKSGetter{URL: srv.URL, Client: srv.Client()}).GetPublicKey()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("GetPublicKey() returned a different key")
+			}
+		})
+	}
+}
+
+func TestJWKSGetter_SingleFetch(t *testing.T) {
+	privateKey, _, err := GenerateTestKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches atomic.Int32
+	release := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{testJWK("1", &privateKey.PublicKey)}})
+	}))
+	defer srv.Close()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	jg := &JWKSGetter{URL: srv.URL, Client: srv.Client(), Now: func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}}
+	waitForFetches := func(n int32) {
+		for fetches.Load() < n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := jg.GetPublicKeyByID("1"); err != nil {
+				t.Errorf("GetPublicKeyByID() error = %v", err)
+			}
+		}()
+	}
+	waitForFetches(1)
+	release <- struct{}{}
+	wg.Wait()
+	if fetches.Load() != 1 {
+		t.Errorf("GetPublicKeyByID() fetches = %v, want a single fetch", fetches.Load())
+	}
+
+	// A fetch in flight does not hold up lookups of cached keys.
+	mu.Lock()
+	now = now.Add(DefaultJWKSRefreshInterval)
+	mu.Unlock()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := jg.GetPublicKeyByID("unknown"); !errors.Is(err, ErrUnknownKeyID) {
+			t.Errorf("GetPublicKeyByID() error = %v, want ErrUnknownKeyID", err)
+		}
+	}()
+	waitForFetches(2)
+	if _, err := jg.GetPublicKeyByID("1"); err != nil {
+		t.Errorf("GetPublicKeyByID() during a fetch error = %v", err)
+	}
+	release <- struct{}{}
+	wg.Wait()
+}
+
+func TestJWKSGetter_FailingServer(t *testing.T) {
+	privateKey, _, err := GenerateTestKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches atomic.Int32
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{testJWK("1", &privateKey.PublicKey)}})
+	}))
+	defer srv.Close()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	interval := 5 * time.Minute
+	jg := &JWKSGetter{URL: srv.URL, Client: srv.Client(), RefreshInterval: interval, Now: func() time.Time { return now }}
+
+	// Before the first successful fetch, lookups within the interval return the error of
+	// the failed fetch instead of fetching again.
+	for range 10 {
+		if _, err := jg.GetPublicKeyByID("1"); err == nil {
+			t.Errorf("GetPublicKeyByID() error = nil, want the fetch error")
+		}
+		if _, err := jg.GetPublicKey(); err == nil {
+			t.Errorf("GetPublicKey() error = nil, want the fetch error")
+		}
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("GetPublicKeyByID() fetches = %v, want 1", fetches.Load())
+	}
+
+	healthy.Store(true)
+	now = now.Add(interval)
+	if _, err := jg.GetPublicKeyByID("1"); err != nil {
+		t.Errorf("GetPublicKeyByID() error = %v", err)
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("GetPublicKeyByID() fetches = %v, want 2", fetches.Load())
+	}
+}
+
+func TestJWKSGetter_TooLarge(t *testing.T) {
+	privateKey, getter, err := GenerateTestKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := json.Marshal(map[string]any{"keys": []map[string]string{testJWK("1", &privateKey.PublicKey)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		padding int
+		wantErr bool
+	}{
+		{name: "JWKSWithinLimit", padding: MaxJWKSSize - len(set)},
+		{name: "JWKSTooLarge", padding: MaxJWKSSize - len(set) + 1, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(set)
+				w.Write(bytes.Repeat([]byte(" "), tt.padding))
+			}))
+			defer srv.Close()
+
+			got, err := (&JWKSGetter{URL: srv.URL, Client: srv.Client()}).GetPublicKey()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, getter.PublicKey) {
+				t.Errorf("GetPublicKey() returned a different key")
+			}
+		})
+	}
+}
+
+// testJWK encodes an RSA or P-256 public key as a JSON Web Key.
+func testJWK(kid string, pub any) map[string]string {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig",
+			"n": enc(k.N.Bytes()), "e": enc(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256",
+			"x": enc(k.X.FillBytes(make([]byte, 32))), "y": enc(k.Y.FillBytes(make([]byte, 32)))}
+	}
+
+	return nil
+}
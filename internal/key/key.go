@@ -2,11 +2,15 @@ package key
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	aw "github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
 	"log/slog"
+	"strings"
+	"sync"
 )
 
 type (
@@ -18,26 +22,47 @@ type (
 		GetPublicKey() ([]byte, error)
 	}
 
+	// IDGetter is implemented by key sources holding several public keys, such as a
+	// JWKSGetter. GetPublicKeyByID returns the DER encoded public key with the given key
+	// ID, the kid header of the JWTs signed with it.
+	IDGetter interface {
+		GetPublicKeyByID(kid string) ([]byte, error)
+	}
+
+	// SetGetter is implemented by key sources holding a fixed set of public keys, such as a
+	// KeySet. GetPublicKeys returns the DER encoded public keys by key ID, the kid header of
+	// the JWTs signed with them.
+	SetGetter interface {
+		GetPublicKeys() (map[string][]byte, error)
+	}
+
 	// Client interface defines an abstraction/wrapper around kms.Client. This is
 	// useful so that our key.AWSManager can depend on an abstraction such that the
 	// behaviour can be easily stubbed out for testing.
 	Client interface {
 		GetPublicKey(ctx context.Context, params *kms.GetPublicKeyInput, optFns ...func(*kms.Options)) (
 			*kms.GetPublicKeyOutput, error)
+		DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (
+			*kms.DescribeKeyOutput, error)
 	}
 
 	// AwsGetter struct is an implementation of the Getter interface. It contains the
 	// Client wrapper for testing purposes. It's constructor will set the implementation
 	// of the wrapper to the real kms.Client from the AWS SDK, it wil also set the keyID
-	// field to the KMS_KEY_ID environment variable.
+	// field to the KMS_KEY_ID environment variable. The KeyID may be a key ID, key ARN,
+	// alias name (alias/my-key) or alias ARN; aliases are resolved to the key they point
+	// to once, and the resolved key ID is cached.
 	AwsGetter struct {
 		Client Client
 		KeyID  string
+
+		mu         sync.Mutex
+		resolvedID string
 	}
 )
 
-func NewClient() (*kms.Client, error) {
-	conf, err := config.LoadDefaultConfig(context.TODO())
+func NewClient(optFns ...func(*config.LoadOptions) error) (*kms.Client, error) {
+	conf, err := config.LoadDefaultConfig(context.TODO(), optFns...)
 	if err != nil {
 		slog.Error(fmt.Sprintf("Unable to load SDK config: %v", err))
 		return nil, err
@@ -46,12 +71,62 @@ func NewClient() (*kms.Client, error) {
 	return kms.NewFromConfig(conf), nil
 }
 
+// GetPublicKey returns the DER encoded public key of the KMS key. Keys that are not for
+// signing and verification, such as encryption keys, are rejected.
 func (get *AwsGetter) GetPublicKey() ([]byte, error) {
+	keyID, err := get.ResolveKeyID()
+	if err != nil {
+		return nil, err
+	}
+
 	result, err := get.Client.GetPublicKey(context.TODO(), &kms.GetPublicKeyInput{
-		KeyId: aw.String(get.KeyID)})
+		KeyId: aw.String(keyID)})
 	if err != nil {
 		return nil, fmt.Errorf("unable to get public key from KMS: %w", err)
 	}
+	if result.KeyUsage != "" && result.KeyUsage != types.KeyUsageTypeSignVerify {
+		return nil, fmt.Errorf("KMS key %v has key usage %v, want %v to verify tokens",
+			keyID, result.KeyUsage, types.KeyUsageTypeSignVerify)
+	}
 
 	return result.PublicKey, nil
 }
+
+// ResolveKeyID returns the ID of the key the KeyID refers to. Key IDs and key ARNs are
+// returned as they are, aliases are resolved with DescribeKey, so the service knows which
+// key it actually uses even after the alias is moved to a new key.
+func (get *AwsGetter) ResolveKeyID() (string, error) {
+	if !isAlias(get.KeyID) {
+		return get.KeyID, nil
+	}
+
+	get.mu.Lock()
+	defer get.mu.Unlock()
+
+	if get.resolvedID != "" {
+		return get.resolvedID, nil
+	}
+
+	result, err := get.Client.DescribeKey(context.TODO(), &kms.DescribeKeyInput{KeyId: aw.String(get.KeyID)})
+	var notFound *types.NotFoundException
+	if errors.As(err, &notFound) {
+		return "", fmt.Errorf("KMS alias %v does not exist: %w", get.KeyID, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve KMS alias %v: %w", get.KeyID, err)
+	}
+	if result.KeyMetadata == nil || aw.ToString(result.KeyMetadata.KeyId) == "" {
+		return "", fmt.Errorf("KMS alias %v does not point to a key", get.KeyID)
+	}
+
+	get.resolvedID = aw.ToString(result.KeyMetadata.KeyId)
+	slog.Info(fmt.Sprintf("Resolved KMS alias %v to key %v", get.KeyID, get.resolvedID))
+
+	return get.resolvedID, nil
+}
+
+// isAlias reports whether keyID names a KMS alias, either by name or by ARN.
+func isAlias(keyID string) bool {
+	return strings.HasPrefix(keyID, "alias/") ||
+		(strings.HasPrefix(keyID, "arn:") && strings.Contains(keyID, ":alias/"))
+}
@@ -10,6 +10,8 @@ import (
 type AWSKeyClientStub struct {
 	GetPublicKeyFunc func(context.Context, *kms.GetPublicKeyInput, ...func(*kms.Options)) (
 		*kms.GetPublicKeyOutput, error)
+	DescribeKeyFunc func(context.Context, *kms.DescribeKeyInput, ...func(*kms.Options)) (
+		*kms.DescribeKeyOutput, error)
 }
 
 func (s *AWSKeyClientStub) GetPublicKey(ctx context.Context, input *kms.GetPublicKeyInput,
@@ -17,6 +19,11 @@ func (s *AWSKeyClientStub) GetPublicKey(ctx context.Context, input *kms.GetPubli
 	return s.GetPublicKeyFunc(ctx, input, opts...)
 }
 
+func (s *AWSKeyClientStub) DescribeKey(ctx context.Context, input *kms.DescribeKeyInput,
+	opts ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+	return s.DescribeKeyFunc(ctx, input, opts...)
+}
+
 func TestAWSManager_GetPublicKey(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -39,6 +46,38 @@ func TestAWSManager_GetPublicKey(t *testing.T) {
 			want:    []byte("PublicKey"),
 			wantErr: false,
 		},
+		{
+			name: "GetSigningPublicKey",
+			stub: func() *AWSKeyClientStub {
+				return &AWSKeyClientStub{
+					GetPublicKeyFunc: func(ctx context.Context, input *kms.GetPublicKeyInput,
+						opts ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error) {
+						return &kms.GetPublicKeyOutput{
+							PublicKey: []byte("PublicKey"),
+							KeyUsage:  types.KeyUsageTypeSignVerify,
+						}, nil
+					},
+				}
+			},
+			want:    []byte("PublicKey"),
+			wantErr: false,
+		},
+		{
+			name: "GetEncryptionPublicKey",
+			stub: func() *AWSKeyClientStub {
+				return &AWSKeyClientStub{
+					GetPublicKeyFunc: func(ctx context.Context, input *kms.GetPublicKeyInput,
+						opts ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error) {
+						return &kms.GetPublicKeyOutput{
+							PublicKey: []byte("PublicKey"),
+							KeyUsage:  types.KeyUsageTypeEncryptDecrypt,
+						}, nil
+					},
+				}
+			},
+			want:    nil,
+			wantErr: true,
+		},
 		{
 			name: "GetNonExistingPublicKey",
 			stub: func() *AWSKeyClientStub {
@@ -68,3 +107,91 @@ func TestAWSManager_GetPublicKey(t *testing.T) {
 		})
 	}
 }
+
+func TestAwsGetter_ResolveKeyID(t *testing.T) {
+	const keyID = "1234abcd-12ab-34cd-56ef-1234567890ab"
+	aliases := map[string]string{
+		"alias/signing": keyID,
+		"arn:aws:kms:eu-west-1:123456789012:alias/signing": keyID,
+	}
+
+	tests := []struct {
+		name      string
+		keyID     string
+		want      string
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "ResolveAliasName",
+			keyID:     "alias/signing",
+			want:      keyID,
+			wantCalls: 1,
+		},
+		{
+			name:      "ResolveAliasARN",
+			keyID:     "arn:aws:kms:eu-west-1:123456789012:alias/signing",
+			want:      keyID,
+			wantCalls: 1,
+		},
+		{
+			name:      "ResolveKeyIDUnchanged",
+			keyID:     keyID,
+			want:      keyID,
+			wantCalls: 0,
+		},
+		{
+			name:      "ResolveKeyARNUnchanged",
+			keyID:     "arn:aws:kms:eu-west-1:123456789012:key/" + keyID,
+			want:      "arn:aws:kms:eu-west-1:123456789012:key/" + keyID,
+			wantCalls: 0,
+		},
+		{
+			name:      "ResolveMissingAlias",
+			keyID:     "alias/missing",
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			var gotKeyID string
+			stub := &AWSKeyClientStub{
+				DescribeKeyFunc: func(ctx context.Context, input *kms.DescribeKeyInput,
+					opts ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+					calls++
+					id, ok := aliases[*input.KeyId]
+					if !ok {
+						return nil, &types.NotFoundException{}
+					}
+					return &kms.DescribeKeyOutput{KeyMetadata: &types.KeyMetadata{KeyId: &id}}, nil
+				},
+				GetPublicKeyFunc: func(ctx context.Context, input *kms.GetPublicKeyInput,
+					opts ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error) {
+					gotKeyID = *input.KeyId
+					return &kms.GetPublicKeyOutput{PublicKey: []byte("PublicKey")}, nil
+				},
+			}
+			getter := &AwsGetter{Client: stub, KeyID: tt.keyID}
+
+			// The second call is served from the cache.
+			for range 2 {
+				_, err := getter.GetPublicKey()
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err != nil {
+					break
+				}
+			}
+			if gotKeyID != tt.want {
+				t.Errorf("GetPublicKey() key ID = %v, want %v", gotKeyID, tt.want)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("GetPublicKey() DescribeKey calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package key
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// KeySet is a SetGetter holding the public keys of several Getters by key ID, e.g. the
+// current and the previous KMS key during a key rotation, when tokens signed with either
+// key are still valid.
+type KeySet struct {
+	Getters map[string]Getter
+}
+
+// GetPublicKey returns the public key of the first key ID in sorted order, so a KeySet can
+// stand in for a single Getter.
+func (ks *KeySet) GetPublicKey() ([]byte, error) {
+	if len(ks.Getters) == 0 {
+		return nil, fmt.Errorf("key set is empty")
+	}
+	id := slices.Min(slices.Collect(maps.Keys(ks.Getters)))
+
+	return ks.Getters[id].GetPublicKey()
+}
+
+// GetPublicKeys gets the public key of every Getter. It fails when any of them fails, so
+// a key that cannot be read is noticed at startup rather than when a token needs it.
+func (ks *KeySet) GetPublicKeys() (map[string][]byte, error) {
+	if len(ks.Getters) == 0 {
+		return nil, fmt.Errorf("key set is empty")
+	}
+
+	keys := make(map[string][]byte, len(ks.Getters))
+	for id, getter := range ks.Getters {
+		pubKey, err := getter.GetPublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get public key %v: %w", id, err)
+		}
+		keys[id] = pubKey
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,67 @@
+package key
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestKeySet_GetPublicKeys(t *testing.T) {
+	_, current, _ := GenerateTestKeyPair()
+	_, previous, _ := GenerateTestKeyPair()
+
+	tests := []struct {
+		name    string
+		set     *KeySet
+		wantIDs []string
+		wantErr bool
+	}{
+		{
+			name:    "KeySetReturnsEveryKey",
+			set:     &KeySet{Getters: map[string]Getter{"current": current, "previous": previous}},
+			wantIDs: []string{"current", "previous"},
+		},
+		{
+			name: "KeySetFailsWithAnyKey",
+			set: &KeySet{Getters: map[string]Getter{
+				"current": current, "previous": &StaticGetter{Err: errors.New("NotFoundException")}}},
+			wantErr: true,
+		},
+		{
+			name:    "KeySetEmpty",
+			set:     &KeySet{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := tt.set.GetPublicKeys()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetPublicKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != len(tt.wantIDs) {
+				t.Errorf("GetPublicKeys() returned %v keys, want %v", len(keys), len(tt.wantIDs))
+			}
+			for _, id := range tt.wantIDs {
+				want, _ := tt.set.Getters[id].GetPublicKey()
+				if !bytes.Equal(keys[id], want) {
+					t.Errorf("GetPublicKeys() key %v is not the key of its Getter", id)
+				}
+			}
+		})
+	}
+}
+
+func TestKeySet_GetPublicKey(t *testing.T) {
+	_, first, _ := GenerateTestKeyPair()
+	_, second, _ := GenerateTestKeyPair()
+
+	got, err := (&KeySet{Getters: map[string]Getter{"b": second, "a": first}}).GetPublicKey()
+	if err != nil {
+		t.Fatalf("GetPublicKey() error = %v", err)
+	}
+	if !bytes.Equal(got, first.PublicKey) {
+		t.Errorf("GetPublicKey() did not return the key of the first key ID")
+	}
+}
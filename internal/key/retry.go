@@ -0,0 +1,55 @@
+package key
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// DefaultAttempts is the number of times a RetryingGetter tries to get the public key.
+	DefaultAttempts = 5
+
+	// DefaultBackoff is the wait before the first retry of a RetryingGetter, doubled for
+	// every further retry.
+	DefaultBackoff = 500 * time.Millisecond
+)
+
+// RetryingGetter is a Getter that retries the wrapped Getter up to Attempts times, waiting
+// Backoff before the first retry and doubling the wait for every further one. It lets the
+// service start through transient failures, such as an IAM role that has not propagated
+// yet. Sleep defaults to time.Sleep and can be replaced in tests.
+type RetryingGetter struct {
+	Getter   Getter
+	Attempts int
+	Backoff  time.Duration
+	Sleep    func(time.Duration)
+}
+
+func (rg *RetryingGetter) GetPublicKey() ([]byte, error) {
+	sleep := time.Sleep
+	if rg.Sleep != nil {
+		sleep = rg.Sleep
+	}
+	attempts := max(rg.Attempts, 1)
+
+	var err error
+	backoff := rg.Backoff
+	for attempt := 1; ; attempt++ {
+		var pubKey []byte
+		pubKey, err = rg.Getter.GetPublicKey()
+		if err == nil {
+			return pubKey, nil
+		}
+		if attempt == attempts {
+			break
+		}
+
+		slog.Warn(fmt.Sprintf("Could not get public key, attempt %d of %d, retrying in %v: %v",
+			attempt, attempts, backoff, err))
+		sleep(backoff)
+		backoff *= 2
+	}
+
+	return nil, fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+}
@@ -0,0 +1,93 @@
+package key
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+type GetterStub struct {
+	GetPublicKeyFunc func() ([]byte, error)
+}
+
+func (s *GetterStub) GetPublicKey() ([]byte, error) {
+	return s.GetPublicKeyFunc()
+}
+
+func TestRetryingGetter_GetPublicKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		attempts  int
+		wantCalls int
+		wantWaits []time.Duration
+		wantErr   bool
+	}{
+		{
+			name:      "RetryFirstAttemptSucceeds",
+			failures:  0,
+			attempts:  3,
+			wantCalls: 1,
+			wantWaits: nil,
+			wantErr:   false,
+		},
+		{
+			name:      "RetrySucceedsAfterFailures",
+			failures:  2,
+			attempts:  3,
+			wantCalls: 3,
+			wantWaits: []time.Duration{time.Second, 2 * time.Second},
+			wantErr:   false,
+		},
+		{
+			name:      "RetryGivesUpAfterLimit",
+			failures:  5,
+			attempts:  3,
+			wantCalls: 3,
+			wantWaits: []time.Duration{time.Second, 2 * time.Second},
+			wantErr:   true,
+		},
+		{
+			name:      "RetryWithoutAttemptsTriesOnce",
+			failures:  5,
+			attempts:  0,
+			wantCalls: 1,
+			wantWaits: nil,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			var waits []time.Duration
+			rg := RetryingGetter{
+				Getter: &GetterStub{GetPublicKeyFunc: func() ([]byte, error) {
+					calls++
+					if calls <= tt.failures {
+						return nil, errors.New("AccessDeniedException")
+					}
+					return []byte("PublicKey"), nil
+				}},
+				Attempts: tt.attempts,
+				Backoff:  time.Second,
+				Sleep:    func(d time.Duration) { waits = append(waits, d) },
+			}
+
+			res, err := rg.GetPublicKey()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(res) != "PublicKey" {
+				t.Errorf("GetPublicKey() = %v, want PublicKey", string(res))
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("GetPublicKey() calls = %v, wantCalls %v", calls, tt.wantCalls)
+			}
+			if !slices.Equal(waits, tt.wantWaits) {
+				t.Errorf("GetPublicKey() waits = %v, wantWaits %v", waits, tt.wantWaits)
+			}
+		})
+	}
+}
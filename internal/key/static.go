@@ -0,0 +1,40 @@
+package key
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"slices"
+)
+
+// StaticGetter is a Getter that always returns the same DER encoded public key, or Err when
+// set. It never changes after construction, so it is safe for concurrent use, and it stands
+// in for an AwsGetter in tests.
+type StaticGetter struct {
+	PublicKey []byte
+	Err       error
+}
+
+func (sg *StaticGetter) GetPublicKey() ([]byte, error) {
+	if sg.Err != nil {
+		return nil, sg.Err
+	}
+
+	return slices.Clone(sg.PublicKey), nil
+}
+
+// GenerateTestKeyPair generates a 2048 bit RSA key pair for tests, returning the private
+// key to sign tokens with and a StaticGetter for its public key.
+func GenerateTestKeyPair() (*rsa.PrivateKey, *StaticGetter, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pubKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return privateKey, &StaticGetter{PublicKey: pubKey}, nil
+}
@@ -0,0 +1,56 @@
+package key
+
+import (
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+func TestStaticGetter_GetPublicKey(t *testing.T) {
+	privateKey, getter, err := GenerateTestKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateTestKeyPair() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		getter  *StaticGetter
+		wantErr bool
+	}{
+		{
+			name:    "StaticGetterReturnsKey",
+			getter:  getter,
+			wantErr: false,
+		},
+		{
+			name:    "StaticGetterReturnsError",
+			getter:  &StaticGetter{PublicKey: getter.PublicKey, Err: errors.New("KMSInvalidStateException")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := tt.getter.GetPublicKey()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			pubKey, err := x509.ParsePKIXPublicKey(res)
+			if err != nil {
+				t.Fatalf("GetPublicKey() returned an invalid key: %v", err)
+			}
+			if !privateKey.PublicKey.Equal(pubKey) {
+				t.Errorf("GetPublicKey() did not return the public key of the pair")
+			}
+
+			res[0] ^= 0xff
+			if again, _ := tt.getter.GetPublicKey(); again[0] == res[0] {
+				t.Errorf("GetPublicKey() returned the stored key instead of a copy")
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package logging
+
+import (
+	"app/env"
+	"io"
+	"log/slog"
+)
+
+// NewHandler returns the slog.Handler writing to w in the format and from the level of
+// vars: a slog.JSONHandler for env.LogFormatJSON, for log aggregation systems that parse
+// JSON, and a slog.TextHandler otherwise.
+func NewHandler(w io.Writer, vars env.LogVars) slog.Handler {
+	opts := &slog.HandlerOptions{Level: vars.Level}
+	if vars.Format == env.LogFormatJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+
+	return slog.NewTextHandler(w, opts)
+}
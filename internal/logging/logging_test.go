@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"app/env"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewHandler(t *testing.T) {
+	tests := []struct {
+		name  string
+		vars  env.LogVars
+		check func(t *testing.T, out string)
+	}{
+		{
+			name: "NewHandlerJSON",
+			vars: env.LogVars{Format: env.LogFormatJSON},
+			check: func(t *testing.T, out string) {
+				var record map[string]any
+				if err := json.Unmarshal([]byte(out), &record); err != nil {
+					t.Fatalf("NewHandler() output %q is not JSON: %v", out, err)
+				}
+				if record["level"] != "INFO" || record["msg"] != "Token saved" || record["user_id"] != "1" {
+					t.Errorf("NewHandler() record = %v", record)
+				}
+			},
+		},
+		{
+			name: "NewHandlerText",
+			vars: env.LogVars{Format: env.LogFormatText},
+			check: func(t *testing.T, out string) {
+				if !strings.Contains(out, `level=INFO msg="Token saved" user_id=1`) {
+					t.Errorf("NewHandler() output = %q, want text format", out)
+				}
+			},
+		},
+		{
+			name: "NewHandlerLevel",
+			vars: env.LogVars{Format: env.LogFormatText, Level: slog.LevelWarn},
+			check: func(t *testing.T, out string) {
+				if out != "" {
+					t.Errorf("NewHandler() output = %q, want info record dropped", out)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			slog.New(NewHandler(&buf, tt.vars)).Info("Token saved", "user_id", "1")
+
+			tt.check(t, buf.String())
+		})
+	}
+}
@@ -1,38 +1,68 @@
 package rest
 
 import (
+	"app/api"
+	"app/env"
 	"app/internal/key"
+	"cmp"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rsa"
-	"encoding/pem"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"log/slog"
+	"maps"
 	"net/http"
-	"reflect"
+	"slices"
 	"strings"
+	"time"
 )
 
 // Authenticate is a middleware that will authenticate a userID before every request.
 // If authentication fails, then the pending handlers are not executed, and the request
 // is scrapped with status code http.StatusUnauthorized. The function checks if the
 // headers are set correctly, with the right signing method for the JWT and that the
-// UserID from the decrypted JWT matches the UserID in the request body.
-func Authenticate(p Parser) gin.HandlerFunc {
+// UserID from the decrypted JWT matches the UserID in the request body. The UserID is
+// read from the claim named by env.AuthVars SubjectClaim, "sub" when empty, and must be
+// a non-empty string. A token longer than MaxSize bytes, env.DefaultJWTMaxSize when zero,
+// is rejected with status code http.StatusBadRequest before it is parsed. With QueryToken,
+// a request without an Authorization header is authenticated with the token taken out of
+// the QueryTokenParam query parameter by ExtractQueryToken.
+func Authenticate(p Parser, cfg env.AuthVars) gin.HandlerFunc {
 	errorBody := gin.H{"Error": "Could not authenticate user"}
+	subjectClaim := cfg.SubjectClaim
+	if subjectClaim == "" {
+		subjectClaim = env.DefaultSubjectClaim
+	}
+	maxSize := cmp.Or(cfg.MaxSize, env.DefaultJWTMaxSize)
 
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		queryToken := ""
+		if cfg.QueryToken {
+			queryToken = c.GetString(queryTokenKey)
+		}
+		if authHeader == "" && queryToken == "" {
 			slog.Error("Authorization header is empty")
 			c.AbortWithStatusJSON(http.StatusBadRequest, errorBody)
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if !strings.Contains(authHeader, "Bearer ") || tokenString == "" {
-			slog.Error("Invalid authorization header format")
+		tokenString := queryToken
+		if authHeader != "" {
+			tokenString = strings.TrimPrefix(authHeader, "Bearer ")
+			if !strings.Contains(authHeader, "Bearer ") || tokenString == "" {
+				slog.Error("Invalid authorization header format")
+				c.AbortWithStatusJSON(http.StatusBadRequest, errorBody)
+				return
+			}
+		}
+		if len(tokenString) > maxSize {
+			slog.Error(fmt.Sprintf("Token of %d bytes exceeds the maximum of %d bytes", len(tokenString), maxSize))
 			c.AbortWithStatusJSON(http.StatusBadRequest, errorBody)
 			return
 		}
@@ -51,17 +81,152 @@ func Authenticate(p Parser) gin.HandlerFunc {
 			return
 		}
 
-		userID, ok := claims["sub"]
+		userID, ok := claims[subjectClaim].(string)
 		if !ok || userID == "" {
+			slog.Error(fmt.Sprintf("Token has no %v claim with a user ID", subjectClaim))
 			c.AbortWithStatusJSON(http.StatusUnauthorized, errorBody)
 			return
 		}
 
-		c.Set("user_id", claims["sub"])
+		c.Set("user_id", userID)
+		c.Set("claims", claims)
 		c.Next()
 	}
 }
 
+// QueryTokenParam is the query parameter read by ExtractQueryToken.
+const QueryTokenParam = "access_token"
+
+// queryTokenKey is the context key ExtractQueryToken stores the token under.
+const queryTokenKey = "query_token"
+
+// ExtractQueryToken is a middleware that moves the JWT in the QueryTokenParam query
+// parameter from the request URL to the context, for Authenticate, and replaces it with
+// "REDACTED". Since it comes first in the middleware chain, the token is never written to
+// the request log or to the request dump of a recovered panic.
+func ExtractQueryToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		if tokenString := query.Get(QueryTokenParam); tokenString != "" {
+			c.Set(queryTokenKey, tokenString)
+			query.Set(QueryTokenParam, "REDACTED")
+			c.Request.URL.RawQuery = query.Encode()
+			c.Request.RequestURI = c.Request.URL.RequestURI()
+		}
+		c.Next()
+	}
+}
+
+// Reasons reported by ValidateTokenHandler for an invalid token.
+const (
+	ReasonExpired          = "expired"
+	ReasonNotYetValid      = "not_yet_valid"
+	ReasonInvalidSignature = "invalid_signature"
+	ReasonMalformed        = "malformed"
+	ReasonNoSubject        = "no_subject"
+	ReasonInvalid          = "invalid"
+)
+
+// ValidateTokenHandler is the handler for endpoint /auth/validate. It verifies the JWT in
+// the request body with the same Parser and subject claim as Authenticate, and responds
+// with an api.ValidateTokenResponse, so clients can check a token without making a
+// protected request. An invalid token is a http.StatusOK response with valid set to false,
+// only a request without a token, or with one longer than the MaxSize Authenticate
+// accepts, is a http.StatusBadRequest. Secrets Manager is not used.
+func ValidateTokenHandler(p Parser, cfg env.AuthVars) gin.HandlerFunc {
+	subjectClaim := cfg.SubjectClaim
+	if subjectClaim == "" {
+		subjectClaim = env.DefaultSubjectClaim
+	}
+	maxSize := cmp.Or(cfg.MaxSize, env.DefaultJWTMaxSize)
+
+	return func(c *gin.Context) {
+		var req api.ValidateTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			slog.Error(err.Error())
+			c.JSON(http.StatusBadRequest, gin.H{"Error": "Could not validate token"})
+			return
+		}
+		if len(req.Token) > maxSize {
+			slog.Error(fmt.Sprintf("Token of %d bytes exceeds the maximum of %d bytes", len(req.Token), maxSize))
+			c.JSON(http.StatusBadRequest, gin.H{"Error": "Could not validate token"})
+			return
+		}
+
+		token, err := p.ParseJWT(req.Token)
+		if err != nil || !token.Valid {
+			slog.Info(fmt.Sprintf("Validated an invalid token: %v", err))
+			c.JSON(http.StatusOK, api.ValidateTokenResponse{Reason: invalidReason(err)})
+			return
+		}
+
+		claims, _ := token.Claims.(jwt.MapClaims)
+		userID, _ := claims[subjectClaim].(string)
+		if userID == "" {
+			c.JSON(http.StatusOK, api.ValidateTokenResponse{Reason: ReasonNoSubject})
+			return
+		}
+
+		res := api.ValidateTokenResponse{Valid: true, Sub: userID}
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			res.Exp = exp.Unix()
+		}
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// invalidReason tells why the Parser rejected a token.
+func invalidReason(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ReasonExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return ReasonNotYetValid
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return ReasonInvalidSignature
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return ReasonMalformed
+	default:
+		return ReasonInvalid
+	}
+}
+
+// AdminScope is the scope a token needs to use the administrative endpoints.
+const AdminScope = "admin"
+
+// RequireScope is a middleware that only lets requests through whose token, as stored by
+// Authenticate, grants the given scope in its "scope" claim. The claim is either a
+// space-separated string, as in OAuth 2.0, or an array of strings. Other requests are
+// scrapped with status code http.StatusForbidden.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, _ := c.Value("claims").(jwt.MapClaims)
+		if !hasScope(claims, scope) {
+			slog.Error(fmt.Sprintf("Token does not grant the %v scope", scope))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "Insufficient scope"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func hasScope(claims jwt.MapClaims, scope string) bool {
+	var scopes []string
+	switch v := claims["scope"].(type) {
+	case string:
+		scopes = strings.Fields(v)
+	case []any:
+		for _, s := range v {
+			if str, ok := s.(string); ok {
+				scopes = append(scopes, str)
+			}
+		}
+	}
+
+	return slices.Contains(scopes, scope)
+}
+
 // Parser is an interface that defines the Parse method, which will parse a token
 // string and return a jwt.Token or an error. It is used as a wrapper around the
 // jwt.Parse method to allow for easier testing and stubbing.
@@ -69,45 +234,216 @@ type Parser interface {
 	ParseJWT(tokenString string) (*jwt.Token, error)
 }
 
-// JWTParser is an implementation of the Parser interface. It contains the public key
-// and signing method for the JWT token. It is used to parse and validate the token
-// before authenticating the user.
+// JWTParser is an implementation of the Parser interface. It verifies tokens with the keys
+// given to NewJWTParser and caches them until their exp claim. ValidMethods optionally
+// replaces the accepted alg values.
 type JWTParser struct {
+	ValidMethods []string
+
 	signingMethod jwt.SigningMethod
-	pubKey        *rsa.PublicKey
+	pubKey        crypto.PublicKey
+	keys          key.IDGetter
+	keySet        map[string]crypto.PublicKey
+	setMethods    []string
+	cache         *tokenCache
+	now           func() time.Time
 }
 
+// DefaultKeySetMethods are the alg values accepted for tokens verified with a key set when
+// no ValidMethods are configured.
+var DefaultKeySetMethods = []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}
+
+// NewJWTParser creates a JWTParser for the keys of km. With a key.IDGetter, such as a
+// key.JWKSGetter, tokens are verified with the key named by their kid header, RS256 and
+// ES256 by default. With a key.SetGetter, such as a key.KeySet, the key named by the kid
+// header or each key in turn is used. Otherwise the single key is used, with RS256 for an
+// RSA key and ES256 for a P-256 key.
 func NewJWTParser(km key.Getter) (*JWTParser, error) {
+	if keys, ok := km.(key.IDGetter); ok {
+		return &JWTParser{
+			keys:  keys,
+			cache: newTokenCache(DefaultCacheSize, time.Now),
+			now:   time.Now,
+		}, nil
+	}
+
+	if set, ok := km.(key.SetGetter); ok {
+		return newKeySetParser(set)
+	}
+
 	pubKeyBytes, err := km.GetPublicKey()
 	if err != nil {
 		return nil, err
 	}
 
-	pemBytes := pem.EncodeToMemory(&pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: pubKeyBytes,
-	})
-
-	pubKey, err := jwt.ParseRSAPublicKeyFromPEM(pemBytes)
+	pubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse public key: %w", err)
 	}
 
+	signingMethod, err := signingMethodForKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+
 	return &JWTParser{
-		signingMethod: &jwt.SigningMethodRSA{Name: "RS256", Hash: crypto.SHA256},
+		signingMethod: signingMethod,
 		pubKey:        pubKey,
+		cache:         newTokenCache(DefaultCacheSize, time.Now),
+		now:           time.Now,
 	}, nil
 }
 
+// newKeySetParser creates a JWTParser verifying tokens with the keys of set, which are
+// read once.
+func newKeySetParser(set key.SetGetter) (*JWTParser, error) {
+	keys, err := set.GetPublicKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	j := &JWTParser{
+		keySet: make(map[string]crypto.PublicKey, len(keys)),
+		cache:  newTokenCache(DefaultCacheSize, time.Now),
+		now:    time.Now,
+	}
+	for id, pubKeyBytes := range keys {
+		pubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key %v: %w", id, err)
+		}
+		signingMethod, err := signingMethodForKey(pubKey)
+		if err != nil {
+			return nil, err
+		}
+
+		j.keySet[id] = pubKey
+		if !slices.Contains(j.setMethods, signingMethod.Alg()) {
+			j.setMethods = append(j.setMethods, signingMethod.Alg())
+		}
+	}
+
+	return j, nil
+}
+
+// MinRSAKeyBits is the size of the smallest RSA key tokens are verified with.
+const MinRSAKeyBits = 2048
+
+// signingMethodForKey returns the only signing method accepted for tokens verified with
+// pubKey, so an RSA key can never verify an ECDSA signed token and vice versa. RSA keys
+// smaller than MinRSAKeyBits are rejected.
+func signingMethodForKey(pubKey crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch k := pubKey.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < MinRSAKeyBits {
+			return nil, fmt.Errorf("RSA public key of %d bits is too small, want at least %d", k.N.BitLen(), MinRSAKeyBits)
+		}
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported ECDSA curve: %v", k.Curve.Params().Name)
+		}
+		return jwt.SigningMethodES256, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", pubKey)
+	}
+}
+
+// CacheStats describes the cache of verified tokens of the parser.
+func (j *JWTParser) CacheStats() api.CacheStats {
+	return j.cache.stats()
+}
+
+// ParseJWT verifies tokenString, only ever with a key of the type its alg requires, so
+// ValidMethods cannot enable algorithm confusion.
 func (j *JWTParser) ParseJWT(tokenString string) (*jwt.Token, error) {
-	validateSigningMethod := func(token *jwt.Token) (interface{}, error) {
-		if !reflect.DeepEqual(token.Method, j.signingMethod) {
-			err := fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	if token, ok := j.cache.get(tokenString); ok {
+		return token, nil
+	}
+
+	token, err := j.verify(tokenString)
+	if err != nil || !token.Valid {
+		j.cache.remove(tokenString)
+		return token, err
+	}
+	j.cache.put(tokenString, token)
+
+	return token, nil
+}
+
+func (j *JWTParser) verify(tokenString string) (*jwt.Token, error) {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		pubKey, err := j.keyFor(token)
+		if err != nil {
 			slog.Error(err.Error())
 			return nil, err
 		}
 
+		return pubKey, nil
+	}
+	return jwt.Parse(tokenString, keyFunc, jwt.WithTimeFunc(j.now), jwt.WithValidMethods(j.validMethods()))
+}
+
+// validMethods returns the alg values a token may be signed with.
+func (j *JWTParser) validMethods() []string {
+	switch {
+	case len(j.ValidMethods) > 0:
+		return j.ValidMethods
+	case j.keys != nil:
+		return DefaultKeySetMethods
+	case j.keySet != nil:
+		return j.setMethods
+	default:
+		return []string{j.signingMethod.Alg()}
+	}
+}
+
+// keyFor returns the public key to verify token with, either the parser's only key or the
+// key named by the kid header of the token. For a key set, a token without a kid header
+// gets a jwt.VerificationKeySet of every key, which jwt.Parse tries in turn.
+func (j *JWTParser) keyFor(token *jwt.Token) (any, error) {
+	if j.keySet != nil {
+		return j.keySetKeyFor(token)
+	}
+	if j.keys == nil {
 		return j.pubKey, nil
 	}
-	return jwt.Parse(tokenString, validateSigningMethod)
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid header")
+	}
+
+	pubKeyBytes, err := j.keys.GetPublicKeyByID(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %v: %w", kid, err)
+	}
+
+	if _, err := signingMethodForKey(pubKey); err != nil {
+		return nil, err
+	}
+
+	return pubKey, nil
+}
+
+func (j *JWTParser) keySetKeyFor(token *jwt.Token) (any, error) {
+	if kid, _ := token.Header["kid"].(string); kid != "" {
+		pubKey, ok := j.keySet[kid]
+		if !ok {
+			return nil, fmt.Errorf("no key with ID %v", kid)
+		}
+		return pubKey, nil
+	}
+
+	var set jwt.VerificationKeySet
+	for _, id := range slices.Sorted(maps.Keys(j.keySet)) {
+		set.Keys = append(set.Keys, j.keySet[id])
+	}
+
+	return set, nil
 }
@@ -1,15 +1,28 @@
 package rest
 
 import (
+	"app/api"
+	"app/env"
+	"app/internal/key"
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 type ParserStub struct {
@@ -24,9 +37,12 @@ func TestAuthenticate(t *testing.T) {
 	tests := []struct {
 		name       string
 		stub       *ParserStub
+		config     env.AuthVars
 		authHeader string
+		query      string
 		wantStatus int
 		wantBody   gin.H
+		wantUserID string
 	}{
 		{
 			name: "AuthenticateSuccess",
@@ -56,6 +72,31 @@ func TestAuthenticate(t *testing.T) {
 			wantStatus: http.StatusBadRequest,
 			wantBody:   gin.H{"Error": "Could not authenticate user"},
 		},
+		{
+			name:       "AuthenticateOversizedToken",
+			config:     env.AuthVars{MaxSize: 16},
+			authHeader: "Bearer " + strings.Repeat("a", 17),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   gin.H{"Error": "Could not authenticate user"},
+		},
+		{
+			name:       "AuthenticateOversizedTokenDefaultLimit",
+			authHeader: "Bearer " + strings.Repeat("a", env.DefaultJWTMaxSize+1),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   gin.H{"Error": "Could not authenticate user"},
+		},
+		{
+			name: "AuthenticateTokenAtMaxSize",
+			stub: &ParserStub{
+				ParserFunc: func(tokenString string) (*jwt.Token, error) {
+					return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"sub": "userID"}}, nil
+				},
+			},
+			config:     env.AuthVars{MaxSize: 16},
+			authHeader: "Bearer " + strings.Repeat("a", 16),
+			wantStatus: http.StatusOK,
+			wantUserID: "userID",
+		},
 		{
 			name: "AuthenticateInvalidToken",
 			stub: &ParserStub{
@@ -89,19 +130,111 @@ func TestAuthenticate(t *testing.T) {
 			wantStatus: http.StatusUnauthorized,
 			wantBody:   gin.H{"Error": "Could not authenticate user"},
 		},
+		{
+			name: "AuthenticateUserIDNotString",
+			stub: &ParserStub{
+				ParserFunc: func(tokenString string) (*jwt.Token, error) {
+					return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"sub": float64(1)}}, nil
+				},
+			},
+			authHeader: "Bearer valid-token",
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   gin.H{"Error": "Could not authenticate user"},
+		},
+		{
+			name: "AuthenticateCustomSubjectClaim",
+			stub: &ParserStub{
+				ParserFunc: func(tokenString string) (*jwt.Token, error) {
+					return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"sub": "other", "uid": "userID"}}, nil
+				},
+			},
+			config:     env.AuthVars{SubjectClaim: "uid"},
+			authHeader: "Bearer valid-token",
+			wantStatus: http.StatusOK,
+			wantUserID: "userID",
+		},
+		{
+			name: "AuthenticateNamespacedSubjectClaim",
+			stub: &ParserStub{
+				ParserFunc: func(tokenString string) (*jwt.Token, error) {
+					return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"https://example.com/user_id": "userID"}}, nil
+				},
+			},
+			config:     env.AuthVars{SubjectClaim: "https://example.com/user_id"},
+			authHeader: "Bearer valid-token",
+			wantStatus: http.StatusOK,
+			wantUserID: "userID",
+		},
+		{
+			name: "AuthenticateCustomSubjectClaimMissing",
+			stub: &ParserStub{
+				ParserFunc: func(tokenString string) (*jwt.Token, error) {
+					return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"sub": "userID"}}, nil
+				},
+			},
+			config:     env.AuthVars{SubjectClaim: "uid"},
+			authHeader: "Bearer valid-token",
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   gin.H{"Error": "Could not authenticate user"},
+		},
+		{
+			name: "AuthenticateQueryToken",
+			stub: &ParserStub{
+				ParserFunc: func(tokenString string) (*jwt.Token, error) {
+					if tokenString != "query-token" {
+						return nil, errors.New("unexpected token " + tokenString)
+					}
+					return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"sub": "userID"}}, nil
+				},
+			},
+			config:     env.AuthVars{QueryToken: true},
+			query:      "?access_token=query-token",
+			wantStatus: http.StatusOK,
+			wantUserID: "userID",
+		},
+		{
+			name:       "AuthenticateQueryTokenDisabled",
+			query:      "?access_token=query-token",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   gin.H{"Error": "Could not authenticate user"},
+		},
+		{
+			name: "AuthenticateHeaderBeforeQueryToken",
+			stub: &ParserStub{
+				ParserFunc: func(tokenString string) (*jwt.Token, error) {
+					if tokenString != "valid-token" {
+						return nil, errors.New("unexpected token " + tokenString)
+					}
+					return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"sub": "userID"}}, nil
+				},
+			},
+			config:     env.AuthVars{QueryToken: true},
+			authHeader: "Bearer valid-token",
+			query:      "?access_token=query-token",
+			wantStatus: http.StatusOK,
+			wantUserID: "userID",
+		},
+		{
+			name:       "AuthenticateOversizedQueryToken",
+			config:     env.AuthVars{QueryToken: true, MaxSize: 16},
+			query:      "?access_token=" + strings.Repeat("a", 17),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   gin.H{"Error": "Could not authenticate user"},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			handler := Authenticate(tt.stub)
+			handler := Authenticate(tt.stub, tt.config)
 
 			resp := httptest.NewRecorder()
 			c, _ := gin.CreateTestContext(resp)
 			c.Set("user_id", "1")
-			c.Request = httptest.NewRequest("POST", "/test", bytes.NewBufferString(""))
+			c.Request = httptest.NewRequest("POST", "/test"+tt.query, bytes.NewBufferString(""))
 			c.Request.Header.Set("Content-Type", "application/json")
 			c.Request.Header.Set("Authorization", tt.authHeader)
 
+			ExtractQueryToken()(c)
 			handler(c)
 			if resp.Code != tt.wantStatus {
 				t.Errorf("RetrieveToken() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
@@ -112,57 +245,166 @@ func TestAuthenticate(t *testing.T) {
 					break
 				}
 			}
+			if tt.wantUserID != "" && c.GetString("user_id") != tt.wantUserID {
+				t.Errorf("Authenticate() user_id = %v, want = %v", c.GetString("user_id"), tt.wantUserID)
+			}
 		})
 	}
 }
 
-type KeyManagerStub struct {
-	KeyFunc func() ([]byte, error)
+func TestExtractQueryToken(t *testing.T) {
+	resp := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(resp)
+	c.Request = httptest.NewRequest("GET", "/token/get?stream=1&access_token=query-token", nil)
+
+	ExtractQueryToken()(c)
+	if got := c.GetString(queryTokenKey); got != "query-token" {
+		t.Errorf("ExtractQueryToken() token = %v, want query-token", got)
+	}
+	if strings.Contains(c.Request.URL.String(), "query-token") || strings.Contains(c.Request.RequestURI, "query-token") {
+		t.Errorf("ExtractQueryToken() left the token in %v", c.Request.RequestURI)
+	}
+	if got := c.Request.URL.Query().Get("stream"); got != "1" {
+		t.Errorf("ExtractQueryToken() stream = %v, want 1", got)
+	}
 }
 
-func (k *KeyManagerStub) GetPublicKey() ([]byte, error) {
-	return k.KeyFunc()
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		claims     jwt.MapClaims
+		wantStatus int
+	}{
+		{
+			name:       "RequireScopeString",
+			claims:     jwt.MapClaims{"sub": "1", "scope": "read admin"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "RequireScopeArray",
+			claims:     jwt.MapClaims{"sub": "1", "scope": []any{"read", "admin"}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "RequireScopeMissing",
+			claims:     jwt.MapClaims{"sub": "1", "scope": "read"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "RequireScopeNoScopeClaim",
+			claims:     jwt.MapClaims{"sub": "1"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "RequireScopePrefixDoesNotMatch",
+			claims:     jwt.MapClaims{"sub": "1", "scope": "administrator"},
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Set("claims", tt.claims)
+			c.Request = httptest.NewRequest("POST", "/token/cleanup", bytes.NewBufferString(""))
+
+			RequireScope(AdminScope)(c)
+			if c.IsAborted() {
+				if resp.Code != tt.wantStatus {
+					t.Errorf("RequireScope() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+				}
+			} else if tt.wantStatus != http.StatusOK {
+				t.Errorf("RequireScope() passed, wantStatus = %v", tt.wantStatus)
+			}
+		})
+	}
 }
 
 func TestJWTParser_Parse(t *testing.T) {
-	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
-	otherPrivateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	privateKey, getter, _ := key.GenerateTestKeyPair()
+	otherPrivateKey, otherGetter, _ := key.GenerateTestKeyPair()
+	ecPrivateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecPublicKey, _ := x509.MarshalPKIXPublicKey(&ecPrivateKey.PublicKey)
+	ecP384PrivateKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	ecP384PublicKey, _ := x509.MarshalPKIXPublicKey(&ecP384PrivateKey.PublicKey)
+	smallPrivateKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	smallPublicKey, _ := x509.MarshalPKIXPublicKey(&smallPrivateKey.PublicKey)
 
 	tests := []struct {
 		name        string
-		stub        *KeyManagerStub
+		getter      key.Getter
 		tokenString string
+		wantNewErr  bool
 		wantErr     bool
 	}{
 		{
-			name: "ParseSuccess",
-			stub: &KeyManagerStub{KeyFunc: func() ([]byte, error) {
-				return x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
-			}},
+			name:        "ParseSuccess",
+			getter:      getter,
 			tokenString: generateTestToken(privateKey),
 			wantErr:     false,
 		},
 		{
-			name: "ParseWrongPublicKey",
-			stub: &KeyManagerStub{KeyFunc: func() ([]byte, error) {
-				return x509.MarshalPKIXPublicKey(&otherPrivateKey.PublicKey)
-			}},
+			name:        "ParseWrongPublicKey",
+			getter:      otherGetter,
 			tokenString: generateTestToken(privateKey),
 			wantErr:     true,
 		},
 		{
-			name: "ParseWrongPrivateKey",
-			stub: &KeyManagerStub{KeyFunc: func() ([]byte, error) {
-				return x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
-			}},
+			name:        "ParseWrongPrivateKey",
+			getter:      getter,
 			tokenString: generateTestToken(otherPrivateKey),
 			wantErr:     true,
 		},
+		{
+			name:        "ParseECDSASuccess",
+			getter:      &key.StaticGetter{PublicKey: ecPublicKey},
+			tokenString: generateTestTokenWithMethod(jwt.SigningMethodES256, ecPrivateKey),
+			wantErr:     false,
+		},
+		{
+			name:        "ParseECDSAKeyRejectsRSAToken",
+			getter:      &key.StaticGetter{PublicKey: ecPublicKey},
+			tokenString: generateTestToken(privateKey),
+			wantErr:     true,
+		},
+		{
+			name:        "ParseRSAKeyRejectsECDSAToken",
+			getter:      getter,
+			tokenString: generateTestTokenWithMethod(jwt.SigningMethodES256, ecPrivateKey),
+			wantErr:     true,
+		},
+		{
+			name:       "ParseUnsupportedCurve",
+			getter:     &key.StaticGetter{PublicKey: ecP384PublicKey},
+			wantNewErr: true,
+		},
+		{
+			name:       "ParseRSAKeyTooSmall",
+			getter:     &key.StaticGetter{PublicKey: smallPublicKey},
+			wantNewErr: true,
+		},
+		{
+			name:       "ParseMalformedKey",
+			getter:     &key.StaticGetter{PublicKey: []byte("not a key")},
+			wantNewErr: true,
+		},
+		{
+			name:       "ParseKeyUnavailable",
+			getter:     &key.StaticGetter{Err: errors.New("KMSInvalidStateException")},
+			wantNewErr: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			parser, err := NewJWTParser(tt.stub)
+			parser, err := NewJWTParser(tt.getter)
+			if (err != nil) != tt.wantNewErr {
+				t.Fatalf("NewJWTParser() error = %v, wantNewErr = %v", err, tt.wantNewErr)
+			}
+			if err != nil {
+				return
+			}
 
 			_, err = parser.ParseJWT(tt.tokenString)
 			if (err != nil) != tt.wantErr {
@@ -172,9 +414,340 @@ func TestJWTParser_Parse(t *testing.T) {
 	}
 }
 
+func TestJWTParser_KeySet(t *testing.T) {
+	currentKey, current, _ := key.GenerateTestKeyPair()
+	previousKey, previous, _ := key.GenerateTestKeyPair()
+	otherKey, _, _ := key.GenerateTestKeyPair()
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecPubKey, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+
+	sign := func(method jwt.SigningMethod, pk any, kid string) string {
+		token := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "1"})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		tokenString, err := token.SignedString(pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+
+	set := &key.KeySet{Getters: map[string]key.Getter{
+		"current":  current,
+		"previous": previous,
+		"ec":       &key.StaticGetter{PublicKey: ecPubKey},
+	}}
+
+	tests := []struct {
+		name        string
+		tokenString string
+		wantErr     bool
+	}{
+		{
+			name:        "KeySetFirstKey",
+			tokenString: sign(jwt.SigningMethodRS256, currentKey, ""),
+		},
+		{
+			name:        "KeySetSecondKey",
+			tokenString: sign(jwt.SigningMethodRS256, previousKey, ""),
+		},
+		{
+			name:        "KeySetSecondKeyByKid",
+			tokenString: sign(jwt.SigningMethodRS256, previousKey, "previous"),
+		},
+		{
+			name:        "KeySetECKey",
+			tokenString: sign(jwt.SigningMethodES256, ecKey, ""),
+		},
+		{
+			name:        "KeySetKidOfOtherKey",
+			tokenString: sign(jwt.SigningMethodRS256, previousKey, "current"),
+			wantErr:     true,
+		},
+		{
+			name:        "KeySetUnknownKid",
+			tokenString: sign(jwt.SigningMethodRS256, previousKey, "unknown"),
+			wantErr:     true,
+		},
+		{
+			name:        "KeySetUnknownKey",
+			tokenString: sign(jwt.SigningMethodRS256, otherKey, ""),
+			wantErr:     true,
+		},
+	}
+
+	parser, err := NewJWTParser(set)
+	if err != nil {
+		t.Fatalf("NewJWTParser() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := parser.ParseJWT(tt.tokenString)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseJWT() error = %v, wantErr = %v", err, tt.wantErr)
+			}
+			if err == nil && !token.Valid {
+				t.Errorf("ParseJWT() token is not valid")
+			}
+		})
+	}
+
+	t.Run("KeySetUnreadableKey", func(t *testing.T) {
+		_, err := NewJWTParser(&key.KeySet{Getters: map[string]key.Getter{
+			"current": current, "previous": &key.StaticGetter{Err: errors.New("NotFoundException")}}})
+		if err == nil {
+			t.Errorf("NewJWTParser() with an unreadable key succeeded")
+		}
+	})
+}
+
+func TestJWTParser_ValidMethods(t *testing.T) {
+	privateKey, getter, _ := key.GenerateTestKeyPair()
+	sign := func(method jwt.SigningMethod) string {
+		tokenString, err := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "1"}).SignedString(privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+
+	tests := []struct {
+		name         string
+		validMethods []string
+		method       jwt.SigningMethod
+		wantErr      bool
+	}{
+		{
+			name:   "ValidMethodsDefaultAllowsRS256",
+			method: jwt.SigningMethodRS256,
+		},
+		{
+			name:    "ValidMethodsDefaultRejectsRS512",
+			method:  jwt.SigningMethodRS512,
+			wantErr: true,
+		},
+		{
+			name:    "ValidMethodsDefaultRejectsPS256",
+			method:  jwt.SigningMethodPS256,
+			wantErr: true,
+		},
+		{
+			name:         "ValidMethodsConfiguredAllowsPS256",
+			validMethods: []string{"RS256", "PS256"},
+			method:       jwt.SigningMethodPS256,
+		},
+		{
+			name:         "ValidMethodsConfiguredRejectsRS256",
+			validMethods: []string{"PS256"},
+			method:       jwt.SigningMethodRS256,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, err := NewJWTParser(getter)
+			if err != nil {
+				t.Fatalf("NewJWTParser() error = %v", err)
+			}
+			parser.ValidMethods = tt.validMethods
+
+			_, err = parser.ParseJWT(sign(tt.method))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseJWT() error = %v, wantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("ValidMethodsRejectsSymmetricAlg", func(t *testing.T) {
+		// A token signed with the public key as HMAC secret, the classic algorithm confusion.
+		pubKey, _ := getter.GetPublicKey()
+		tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1"}).SignedString(pubKey)
+		parser, _ := NewJWTParser(getter)
+		parser.ValidMethods = []string{"RS256", "HS256"}
+
+		if _, err := parser.ParseJWT(tokenString); err == nil {
+			t.Errorf("ParseJWT() of an HS256 token signed with the public key succeeded")
+		}
+	})
+}
+
+func TestJWTParser_JWKS(t *testing.T) {
+	rsaPrivateKey, _, _ := key.GenerateTestKeyPair()
+	ecPrivateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	enc := base64.RawURLEncoding.EncodeToString
+	jwks := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-1", "use": "sig",
+			"n": enc(rsaPrivateKey.N.Bytes()), "e": enc(big.NewInt(int64(rsaPrivateKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256",
+			"x": enc(ecPrivateKey.X.FillBytes(make([]byte, 32))), "y": enc(ecPrivateKey.Y.FillBytes(make([]byte, 32)))},
+	}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	defer srv.Close()
+
+	parser, err := NewJWTParser(&key.JWKSGetter{URL: srv.URL, Client: srv.Client()})
+	if err != nil {
+		t.Fatalf("NewJWTParser() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     jwt.SigningMethod
+		privateKey crypto.PrivateKey
+		kid        string
+		wantErr    bool
+	}{
+		{
+			name:       "JWKSRSAKey",
+			method:     jwt.SigningMethodRS256,
+			privateKey: rsaPrivateKey,
+			kid:        "rsa-1",
+		},
+		{
+			name:       "JWKSECDSAKey",
+			method:     jwt.SigningMethodES256,
+			privateKey: ecPrivateKey,
+			kid:        "ec-1",
+		},
+		{
+			name:       "JWKSUnknownKid",
+			method:     jwt.SigningMethodRS256,
+			privateKey: rsaPrivateKey,
+			kid:        "rsa-2",
+			wantErr:    true,
+		},
+		{
+			name:       "JWKSNoKid",
+			method:     jwt.SigningMethodRS256,
+			privateKey: rsaPrivateKey,
+			wantErr:    true,
+		},
+		{
+			name:       "JWKSKidOfOtherKey",
+			method:     jwt.SigningMethodES256,
+			privateKey: ecPrivateKey,
+			kid:        "rsa-1",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := jwt.NewWithClaims(tt.method, jwt.MapClaims{"sub": "1"})
+			if tt.kid != "" {
+				token.Header["kid"] = tt.kid
+			}
+			tokenString, err := token.SignedString(tt.privateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = parser.ParseJWT(tokenString)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseJWT() error = %v, wantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTokenHandler(t *testing.T) {
+	privateKey, getter, _ := key.GenerateTestKeyPair()
+	otherPrivateKey, _, _ := key.GenerateTestKeyPair()
+	parser, err := NewJWTParser(getter)
+	if err != nil {
+		t.Fatalf("NewJWTParser() error = %v", err)
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	sign := func(privateKey *rsa.PrivateKey, claims jwt.MapClaims) string {
+		tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+		return tokenString
+	}
+
+	tests := []struct {
+		name        string
+		requestBody string
+		wantStatus  int
+		wantBody    api.ValidateTokenResponse
+	}{
+		{
+			name:        "ValidateValidToken",
+			requestBody: fmt.Sprintf(`{"token": %q}`, sign(privateKey, jwt.MapClaims{"sub": "1", "exp": exp})),
+			wantStatus:  http.StatusOK,
+			wantBody:    api.ValidateTokenResponse{Valid: true, Sub: "1", Exp: exp},
+		},
+		{
+			name: "ValidateExpiredToken",
+			requestBody: fmt.Sprintf(`{"token": %q}`,
+				sign(privateKey, jwt.MapClaims{"sub": "1", "exp": time.Now().Add(-time.Hour).Unix()})),
+			wantStatus: http.StatusOK,
+			wantBody:   api.ValidateTokenResponse{Reason: ReasonExpired},
+		},
+		{
+			name:        "ValidateWrongSignature",
+			requestBody: fmt.Sprintf(`{"token": %q}`, sign(otherPrivateKey, jwt.MapClaims{"sub": "1", "exp": exp})),
+			wantStatus:  http.StatusOK,
+			wantBody:    api.ValidateTokenResponse{Reason: ReasonInvalidSignature},
+		},
+		{
+			name:        "ValidateMalformedToken",
+			requestBody: `{"token": "not-a-jwt"}`,
+			wantStatus:  http.StatusOK,
+			wantBody:    api.ValidateTokenResponse{Reason: ReasonMalformed},
+		},
+		{
+			name:        "ValidateTokenWithoutSubject",
+			requestBody: fmt.Sprintf(`{"token": %q}`, sign(privateKey, jwt.MapClaims{"exp": exp})),
+			wantStatus:  http.StatusOK,
+			wantBody:    api.ValidateTokenResponse{Reason: ReasonNoSubject},
+		},
+		{
+			name:        "ValidateMissingToken",
+			requestBody: `{}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "ValidateTokenTooLarge",
+			requestBody: fmt.Sprintf(`{"token": %q}`, strings.Repeat("a", env.DefaultJWTMaxSize+1)),
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Request = httptest.NewRequest("POST", "/auth/validate", strings.NewReader(tt.requestBody))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			ValidateTokenHandler(parser, env.AuthVars{})(c)
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("ValidateToken() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got api.ValidateTokenResponse
+			if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+				t.Fatalf("ValidateToken() body = %v, error = %v", resp.Body.String(), err)
+			}
+			if got != tt.wantBody {
+				t.Errorf("ValidateToken() = %+v, want %+v", got, tt.wantBody)
+			}
+		})
+	}
+}
+
 func generateTestToken(privateKey *rsa.PrivateKey) string {
+	return generateTestTokenWithMethod(jwt.SigningMethodRS256, privateKey)
+}
+
+func generateTestTokenWithMethod(method jwt.SigningMethod, privateKey crypto.PrivateKey) string {
 	claims := jwt.MapClaims{"sub": "1"}
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token := jwt.NewWithClaims(method, claims)
 	tokenString, _ := token.SignedString(privateKey)
 
 	return tokenString
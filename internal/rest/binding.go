@@ -0,0 +1,81 @@
+package rest
+
+import (
+	"app/env"
+	"encoding/json"
+	"github.com/gin-gonic/gin/binding"
+	"io"
+	"net/http"
+)
+
+// aliasBinding is a binding.BindingBody for JSON bodies that also accepts alternative
+// names for fields. Aliases maps an alternative name to the name of the json tag, keys
+// that use the alias are renamed before decoding. When a body holds both names, the
+// tag name wins. The decoded struct is validated like with binding.JSON.
+type aliasBinding struct {
+	Aliases map[string]string
+}
+
+// saveTokenBinding accepts the camelCase field names of api.SaveTokenRequest next to the
+// snake_case ones, for clients that cannot send snake_case.
+var saveTokenBinding = aliasBinding{Aliases: map[string]string{
+	"userId":       "user_id",
+	"tokenType":    "token_type",
+	"accessToken":  "access_token",
+	"refreshToken": "refresh_token",
+}}
+
+// patchTokenBinding accepts the camelCase field names of api.PatchTokenRequest next to the
+// snake_case ones, like saveTokenBinding.
+var patchTokenBinding = aliasBinding{Aliases: map[string]string{
+	"accessToken": "access_token",
+}}
+
+// bodyBinding returns b, or the plain binding.JSON when the env.ServerVars only accept
+// snake_case field names.
+func bodyBinding(b aliasBinding, cfg env.ServerVars) binding.BindingBody {
+	if cfg.SnakeCaseOnly {
+		return binding.JSON
+	}
+
+	return b
+}
+
+func (aliasBinding) Name() string {
+	return "json-aliases"
+}
+
+func (b aliasBinding) Bind(req *http.Request, obj any) error {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+
+	return b.BindBody(body, obj)
+}
+
+func (b aliasBinding) BindBody(body []byte, obj any) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return err
+	}
+
+	for alias, name := range b.Aliases {
+		value, ok := fields[alias]
+		if !ok {
+			continue
+		}
+		delete(fields, alias)
+		if _, ok := fields[name]; !ok {
+			fields[name] = value
+		}
+	}
+
+	renamed, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	// Let binding.JSON decode and validate, so the result matches a plain JSON binding.
+	return binding.JSON.BindBody(renamed, obj)
+}
@@ -0,0 +1,144 @@
+package rest
+
+import (
+	"app/api"
+	"app/env"
+	"testing"
+	"time"
+)
+
+func TestSaveTokenBinding(t *testing.T) {
+	want := api.SaveTokenRequest{
+		UserID:       "userID",
+		Provider:     "google",
+		TokenType:    "Bearer",
+		AccessToken:  "access_token",
+		RefreshToken: "refresh_token",
+		Expiry:       time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		want    api.SaveTokenRequest
+		wantErr bool
+	}{
+		{
+			name: "SnakeCase",
+			body: `{"user_id": "userID", "provider": "google", "token_type": "Bearer",
+				"access_token": "access_token", "refresh_token": "refresh_token", "expiry": "2026-01-02T15:04:05Z"}`,
+			want: want,
+		},
+		{
+			name: "CamelCase",
+			body: `{"userId": "userID", "provider": "google", "tokenType": "Bearer",
+				"accessToken": "access_token", "refreshToken": "refresh_token", "expiry": "2026-01-02T15:04:05Z"}`,
+			want: want,
+		},
+		{
+			name: "SnakeCaseWinsOverCamelCase",
+			body: `{"user_id": "userID", "userId": "other", "provider": "google", "token_type": "Bearer",
+				"access_token": "access_token", "refresh_token": "refresh_token", "expiry": "2026-01-02T15:04:05Z"}`,
+			want: want,
+		},
+		{
+			name:    "CamelCaseMissingRequiredField",
+			body:    `{"userId": "userID", "refreshToken": "refresh_token", "expiry": "2026-01-02T15:04:05Z"}`,
+			wantErr: true,
+		},
+		{
+			name:    "NotAnObject",
+			body:    `["userID"]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got api.SaveTokenRequest
+			err := saveTokenBinding.BindBody([]byte(tt.body), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BindBody() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("BindBody() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchTokenBinding(t *testing.T) {
+	want := api.PatchTokenRequest{
+		Provider:    "google",
+		AccessToken: "access_token",
+		Expiry:      time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{
+			name: "SnakeCase",
+			body: `{"provider": "google", "access_token": "access_token", "expiry": "2026-01-02T15:04:05Z"}`,
+		},
+		{
+			name: "CamelCase",
+			body: `{"provider": "google", "accessToken": "access_token", "expiry": "2026-01-02T15:04:05Z"}`,
+		},
+		{
+			name:    "MissingAccessToken",
+			body:    `{"provider": "google", "expiry": "2026-01-02T15:04:05Z"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got api.PatchTokenRequest
+			err := patchTokenBinding.BindBody([]byte(tt.body), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BindBody() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != want {
+				t.Errorf("BindBody() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestBodyBinding_SnakeCaseOnly(t *testing.T) {
+	tests := []struct {
+		name          string
+		snakeCaseOnly bool
+		body          string
+		wantErr       bool
+	}{
+		{
+			name: "CamelCaseAccepted",
+			body: `{"userId": "userID", "accessToken": "access_token", "refreshToken": "refresh_token"}`,
+		},
+		{
+			name:          "CamelCaseRejected",
+			snakeCaseOnly: true,
+			body:          `{"userId": "userID", "accessToken": "access_token", "refreshToken": "refresh_token"}`,
+			wantErr:       true,
+		},
+		{
+			name:          "SnakeCaseAccepted",
+			snakeCaseOnly: true,
+			body:          `{"user_id": "userID", "access_token": "access_token", "refresh_token": "refresh_token"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got api.SaveTokenRequest
+			b := bodyBinding(saveTokenBinding, env.ServerVars{SnakeCaseOnly: tt.snakeCaseOnly})
+			if err := b.BindBody([]byte(tt.body), &got); (err != nil) != tt.wantErr {
+				t.Fatalf("BindBody() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"app/api"
+	"crypto/sha256"
+	"github.com/golang-jwt/jwt/v5"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize is the number of verified tokens a JWTParser remembers.
+const DefaultCacheSize = 1024
+
+// tokenCache maps the SHA-256 hash of a token string to the token it was verified as, so a
+// token that is presented again does not need its signature verified again. An entry lives
+// until the exp claim of its token, tokens without exp are never cached. The cache holds at
+// most size entries; when full, expired entries are dropped first, then arbitrary ones.
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]cacheEntry
+	size    int
+	now     func() time.Time
+	hits    int
+	misses  int
+}
+
+type cacheEntry struct {
+	token *jwt.Token
+	exp   time.Time
+}
+
+func newTokenCache(size int, now func() time.Time) *tokenCache {
+	return &tokenCache{entries: map[[sha256.Size]byte]cacheEntry{}, size: size, now: now}
+}
+
+// get returns the cached token for tokenString if it has not expired yet.
+func (tc *tokenCache) get(tokenString string) (*jwt.Token, bool) {
+	key := sha256.Sum256([]byte(tokenString))
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	entry, ok := tc.entries[key]
+	if ok && tc.now().Before(entry.exp) {
+		tc.hits++
+		return entry.token, true
+	}
+	if ok {
+		delete(tc.entries, key)
+	}
+	tc.misses++
+
+	return nil, false
+}
+
+// put caches a verified token until its exp claim.
+func (tc *tokenCache) put(tokenString string, token *jwt.Token) {
+	exp, err := token.Claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return
+	}
+	key := sha256.Sum256([]byte(tokenString))
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if len(tc.entries) >= tc.size {
+		tc.evict()
+	}
+	tc.entries[key] = cacheEntry{token: token, exp: exp.Time}
+}
+
+// remove drops tokenString from the cache, e.g. after it failed verification.
+func (tc *tokenCache) remove(tokenString string) {
+	key := sha256.Sum256([]byte(tokenString))
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	delete(tc.entries, key)
+}
+
+// stats returns the number of entries and the hits and misses of the cache so far.
+func (tc *tokenCache) stats() api.CacheStats {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	return api.CacheStats{Entries: len(tc.entries), Hits: int64(tc.hits), Misses: int64(tc.misses)}
+}
+
+// evict makes room for a new entry. The caller must hold tc.mu.
+func (tc *tokenCache) evict() {
+	now := tc.now()
+	for key, entry := range tc.entries {
+		if !now.Before(entry.exp) {
+			delete(tc.entries, key)
+		}
+	}
+
+	for key := range tc.entries {
+		if len(tc.entries) < tc.size {
+			return
+		}
+		delete(tc.entries, key)
+	}
+}
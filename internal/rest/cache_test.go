@@ -0,0 +1,103 @@
+package rest
+
+import (
+	"app/internal/key"
+	"crypto/rsa"
+	"github.com/golang-jwt/jwt/v5"
+	"testing"
+	"time"
+)
+
+func TestJWTParser_Cache(t *testing.T) {
+	privateKey, getter, _ := key.GenerateTestKeyPair()
+	otherPrivateKey, _, _ := key.GenerateTestKeyPair()
+	start := time.Now()
+
+	sign := func(pk *rsa.PrivateKey, claims jwt.MapClaims) string {
+		tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(pk)
+		return tokenString
+	}
+	valid := sign(privateKey, jwt.MapClaims{"sub": "1", "exp": start.Add(time.Minute).Unix()})
+
+	tests := []struct {
+		name        string
+		tokenString string
+		advance     time.Duration
+		wantErr     []bool
+		wantHits    int
+		wantEntries int
+	}{
+		{
+			name:        "CacheHitWithinExp",
+			tokenString: valid,
+			wantErr:     []bool{false, false},
+			wantHits:    1,
+			wantEntries: 1,
+		},
+		{
+			name:        "CacheExpiredEntryReverified",
+			tokenString: valid,
+			advance:     2 * time.Minute,
+			wantErr:     []bool{false, true},
+			wantHits:    0,
+			wantEntries: 0,
+		},
+		{
+			name:        "CacheSkipsTokenWithoutExp",
+			tokenString: sign(privateKey, jwt.MapClaims{"sub": "1"}),
+			wantErr:     []bool{false, false},
+			wantHits:    0,
+			wantEntries: 0,
+		},
+		{
+			name:        "CacheSkipsInvalidToken",
+			tokenString: sign(otherPrivateKey, jwt.MapClaims{"sub": "1", "exp": start.Add(time.Minute).Unix()}),
+			wantErr:     []bool{true, true},
+			wantHits:    0,
+			wantEntries: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, err := NewJWTParser(getter)
+			if err != nil {
+				t.Fatalf("NewJWTParser() error = %v", err)
+			}
+			now := start
+			parser.now = func() time.Time { return now }
+			parser.cache = newTokenCache(DefaultCacheSize, parser.now)
+
+			for i, wantErr := range tt.wantErr {
+				if i > 0 {
+					now = now.Add(tt.advance)
+				}
+				_, err = parser.ParseJWT(tt.tokenString)
+				if (err != nil) != wantErr {
+					t.Errorf("ParseJWT() #%d error = %v, wantErr = %v", i, err, wantErr)
+				}
+			}
+			if parser.cache.hits != tt.wantHits {
+				t.Errorf("cache hits = %v, want = %v", parser.cache.hits, tt.wantHits)
+			}
+			if len(parser.cache.entries) != tt.wantEntries {
+				t.Errorf("cache entries = %v, want = %v", len(parser.cache.entries), tt.wantEntries)
+			}
+		})
+	}
+}
+
+func TestTokenCache_Bounded(t *testing.T) {
+	now := time.Now()
+	tc := newTokenCache(2, func() time.Time { return now })
+
+	for _, s := range []string{"a", "b", "c"} {
+		tc.put(s, &jwt.Token{Claims: jwt.MapClaims{"exp": float64(now.Add(time.Minute).Unix())}})
+	}
+	if len(tc.entries) != 2 {
+		t.Errorf("cache entries = %v, want = 2", len(tc.entries))
+	}
+	if _, ok := tc.get("c"); !ok {
+		t.Errorf("get() of the newest entry missed")
+	}
+}
@@ -0,0 +1,115 @@
+package rest
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/token"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+const (
+	// BackendAWS names Secrets Manager as the backend of the token secrets.
+	BackendAWS = "aws-secretsmanager"
+	// BackendMemory names a secret.MemoryStore as the backend of the token secrets.
+	BackendMemory = "memory"
+)
+
+// RuntimeConfig is the part of the configuration the GinRouter does not otherwise know
+// about, reported by the /config endpoint. Version is the version of the build, Region is
+// the region of the secret client and Backend is BackendAWS or BackendMemory. Of the
+// env.AwsVars, only the non-secret fields are ever reported.
+type RuntimeConfig struct {
+	Version string
+	Region  string
+	Backend string
+	Aws     env.AwsVars
+}
+
+// ConfigResponse describes the effective configuration of the GinRouter. It is built from
+// an allowlist of fields, so KMS key IDs, the AWS profile and credentials never appear in
+// it, even when they are added to the configuration structs later.
+func (g GinRouter) ConfigResponse() api.ConfigResponse {
+	middlewares := []string{}
+	for _, m := range g.Middlewares() {
+		middlewares = append(middlewares, m.Name)
+	}
+
+	return api.ConfigResponse{
+		Version:         g.Runtime.Version,
+		Region:          g.Runtime.Region,
+		SecondaryRegion: g.Runtime.Aws.SecondaryRegion,
+		RootDomain:      g.Runtime.Aws.SmsRootDomain,
+		Environment:     g.Runtime.Aws.Environment,
+		Domain:          token.DefaultDomain,
+		Domains:         slices.Sorted(maps.Keys(g.Registry)),
+		Middlewares:     middlewares,
+		Backend:         g.Runtime.Backend,
+		ResponseStyle:   g.Config.ResponseStyle,
+		TLS:             g.Config.TLSCertFile != "",
+		MutualTLS:       g.Config.TLSClientCAFile != "",
+		Features:        g.Features()}
+}
+
+// Features lists the optional behaviours enabled on the GinRouter: the optional endpoints,
+// by the dependency enabling them, and the authentication and server options.
+func (g GinRouter) Features() []string {
+	features := []string{}
+	for _, f := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"cleanup", g.Cleaner != nil},
+		{"bulk_import", g.Importer != nil},
+		{"rollback", g.Rollbacker != nil},
+		{"device_flow", g.Device != nil},
+		{"describe", g.Describer != nil},
+		{"patch", g.Patcher != nil},
+		{"export", g.Exporter != nil},
+		{"import", g.SealedImporter != nil},
+		{"tenant_roles", len(g.Tenants.Roles) > 0},
+		{"jwks", g.Auth.JWKSURL != ""},
+		{"query_token", g.Auth.QueryToken},
+		{"preflight", g.Config.Preflight},
+		{"reject_expired", g.Config.RejectExpired},
+		{"pprof", g.Config.Pprof},
+	} {
+		if f.enabled {
+			features = append(features, f.name)
+		}
+	}
+
+	return features
+}
+
+// LogStartup logs a single structured line describing the ConfigResponse of the
+// GinRouter and the address it serves on, so operators can confirm the deployed
+// configuration from the first log line. Like /config, it never contains secrets.
+func (g GinRouter) LogStartup(addr string) {
+	cfg := g.ConfigResponse()
+	slog.Info("Starting server",
+		"addr", addr,
+		"version", cfg.Version,
+		"region", cfg.Region,
+		"secondary_region", cfg.SecondaryRegion,
+		"root_domain", cfg.RootDomain,
+		"environment", cfg.Environment,
+		"domains", cfg.Domains,
+		"backend", cfg.Backend,
+		"middlewares", cfg.Middlewares,
+		"features", cfg.Features,
+		"tls", cfg.TLS,
+		"mutual_tls", cfg.MutualTLS)
+}
+
+// ConfigHandler is the handler for the administrative endpoint /config. It returns the
+// api.ConfigResponse it was created with, so operators can confirm the configuration a
+// running server has loaded.
+func ConfigHandler(cfg api.ConfigResponse) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, cfg)
+	}
+}
@@ -0,0 +1,185 @@
+package rest
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/token"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGinRouter_Config(t *testing.T) {
+	g := GinRouter{
+		Parser: &ParserStub{ParserFunc: func(tokenString string) (*jwt.Token, error) {
+			scope := "read"
+			if tokenString == "admin" {
+				scope = "admin"
+			}
+			return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"sub": "1", "scope": scope}}, nil
+		}},
+		Auth:     env.AuthVars{SubjectClaim: env.DefaultSubjectClaim},
+		Registry: token.Registry{"token": token.Domain{}, "calendar": token.Domain{}},
+		Config: env.ServerVars{
+			Recovery:        true,
+			ResponseStyle:   env.ResponseStyleSnakeCase,
+			TLSCertFile:     "/etc/tls/cert.pem",
+			TLSKeyFile:      "/etc/tls/key.pem",
+			TLSClientCAFile: "/etc/tls/ca.pem"},
+		Runtime: RuntimeConfig{
+			Version: "v1.2.3",
+			Region:  "eu-west-1",
+			Backend: BackendAWS,
+			Aws: env.AwsVars{
+				SmsRootDomain:   "root",
+				KmsKeyID:        "arn:aws:kms:eu-west-1:123456789012:key/secret-key-id",
+				SecondaryRegion: "eu-central-1",
+				Profile:         "secret-profile",
+				Environment:     "staging"}},
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+		want       *api.ConfigResponse
+	}{
+		{
+			name:       "ConfigAdmin",
+			token:      "admin",
+			wantStatus: http.StatusOK,
+			want: &api.ConfigResponse{
+				Version:         "v1.2.3",
+				Region:          "eu-west-1",
+				SecondaryRegion: "eu-central-1",
+				RootDomain:      "root",
+				Environment:     "staging",
+				Domain:          "token",
+				Domains:         []string{"calendar", "token"},
+				Middlewares:     []string{"recovery", "authenticate"},
+				Backend:         BackendAWS,
+				ResponseStyle:   env.ResponseStyleSnakeCase,
+				TLS:             true,
+				MutualTLS:       true,
+				Features:        []string{}},
+		},
+		{
+			name:       "ConfigWithoutAdminScope",
+			token:      "user",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/config", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			g.Engine().ServeHTTP(resp, req)
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("Config() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			if tt.want == nil {
+				return
+			}
+
+			body := resp.Body.String()
+			for _, secret := range []string{"secret-key-id", "secret-profile", "/etc/tls"} {
+				if strings.Contains(body, secret) {
+					t.Errorf("Config() body = %v, must not contain %v", body, secret)
+				}
+			}
+
+			var got api.ConfigResponse
+			if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Failed to decode response body: %v", err)
+			}
+			if !reflect.DeepEqual(&got, tt.want) {
+				t.Errorf("Config() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGinRouter_Features(t *testing.T) {
+	g := GinRouter{
+		Cleaner: &CleanerStub{},
+		Auth:    env.AuthVars{JWKSURL: "https://idp.example.com/jwks.json"},
+		Config:  env.ServerVars{Preflight: true}}
+
+	want := []string{"cleanup", "jwks", "preflight"}
+	if got := g.Features(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Features() = %v, want %v", got, want)
+	}
+}
+
+func TestGinRouter_LogStartup(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	g := GinRouter{
+		Parser:   &ParserStub{},
+		Registry: token.Registry{"token": token.Domain{}},
+		Config:   env.ServerVars{Recovery: true, Preflight: true},
+		Runtime: RuntimeConfig{
+			Version: "v1.2.3",
+			Region:  "eu-west-1",
+			Backend: BackendAWS,
+			Aws: env.AwsVars{
+				SmsRootDomain: "root",
+				KmsKeyID:      "arn:aws:kms:eu-west-1:123456789012:key/secret-key-id",
+				Profile:       "secret-profile"}},
+	}
+	if _, err = g.Serve(ctx, ln); err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+
+	var banner map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]any
+		if json.Unmarshal([]byte(line), &record) == nil && record["msg"] == "Starting server" {
+			banner = record
+		}
+	}
+	if banner == nil {
+		t.Fatalf("Serve() logged %q, want a startup line", logs.String())
+	}
+
+	for field, want := range map[string]any{
+		"version":     "v1.2.3",
+		"region":      "eu-west-1",
+		"root_domain": "root",
+		"backend":     BackendAWS,
+		"addr":        ln.Addr().String(),
+		"tls":         false,
+	} {
+		if banner[field] != want {
+			t.Errorf("startup log %v = %v, want %v", field, banner[field], want)
+		}
+	}
+	if features, _ := banner["features"].([]any); len(features) != 1 || features[0] != "preflight" {
+		t.Errorf("startup log features = %v, want [preflight]", banner["features"])
+	}
+	for _, secret := range []string{"secret-key-id", "secret-profile"} {
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("startup log = %v, must not contain %v", logs.String(), secret)
+		}
+	}
+}
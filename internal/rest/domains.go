@@ -0,0 +1,34 @@
+package rest
+
+import (
+	"app/api"
+	"github.com/gin-gonic/gin"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// DomainsResponse describes the domains of the token.Registry of the GinRouter. Like the
+// ConfigResponse, it is built from an allowlist of fields of their env.DomainVars, so the
+// KMS keys of the domains never appear in it.
+func (g GinRouter) DomainsResponse() api.DomainsResponse {
+	domains := []api.DomainDescription{}
+	for _, name := range slices.Sorted(maps.Keys(g.Registry)) {
+		cfg := g.Registry[name].Config
+		domains = append(domains, api.DomainDescription{
+			Name:               name,
+			Path:               "/secret/" + name,
+			RecoveryWindowDays: cfg.RecoveryWindowDays,
+			Tags:               cfg.Tags})
+	}
+
+	return api.DomainsResponse{Domains: domains}
+}
+
+// DomainsHandler is the handler for endpoint /domains. It returns the api.DomainsResponse
+// it was created with, so clients can discover the secret domains a deployment serves.
+func DomainsHandler(res api.DomainsResponse) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, res)
+	}
+}
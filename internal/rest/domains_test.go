@@ -0,0 +1,62 @@
+package rest
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/token"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGinRouter_Domains(t *testing.T) {
+	g := GinRouter{
+		Parser: &ParserStub{ParserFunc: func(tokenString string) (*jwt.Token, error) {
+			return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"sub": "1"}}, nil
+		}},
+		Auth: env.AuthVars{SubjectClaim: env.DefaultSubjectClaim},
+		Registry: token.Registry{
+			"token": token.Domain{Config: env.DomainVars{Name: "token"}},
+			"apikey": token.Domain{Config: env.DomainVars{
+				Name:               "apikey",
+				KmsKeyID:           "arn:aws:kms:eu-west-1:123456789012:key/secret-key-id",
+				RecoveryWindowDays: 7,
+				Tags:               map[string]string{"team": "payments"}}},
+		},
+	}
+
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/domains", nil)
+	req.Header.Set("Authorization", "Bearer user")
+
+	g.Engine().ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Domains() status = %v, wantStatus = %v", resp.Code, http.StatusOK)
+	}
+	if body := resp.Body.String(); strings.Contains(body, "secret-key-id") {
+		t.Errorf("Domains() body = %v, must not contain the KMS key", body)
+	}
+
+	var got api.DomainsResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	want := api.DomainsResponse{Domains: []api.DomainDescription{
+		{Name: "apikey", Path: "/secret/apikey", RecoveryWindowDays: 7, Tags: map[string]string{"team": "payments"}},
+		{Name: "token", Path: "/secret/token"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Domains() = %+v, want %+v", got, want)
+	}
+}
+
+func TestGinRouter_DomainsEmpty(t *testing.T) {
+	got := GinRouter{}.DomainsResponse()
+	if got.Domains == nil || len(got.Domains) != 0 {
+		t.Errorf("DomainsResponse() = %#v, want an empty list", got)
+	}
+}
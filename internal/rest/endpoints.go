@@ -2,20 +2,45 @@ package rest
 
 import (
 	"app/api"
+	"app/env"
+	"app/internal/secret"
 	"app/internal/token"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"time"
+)
+
+// VersionIDHeader is the response header in which RetrieveTokenHandler and SaveTokenHandler
+// report the VersionId of the secret version served or written, when it is known.
+const VersionIDHeader = "X-Version-Id"
+
+// TokenExpiryHeader is the response header in which RetrieveTokenHandler reports the
+// Expiry of the token served, in RFC 3339, so clients can cache it without parsing the body.
+const TokenExpiryHeader = "X-Token-Expiry"
+
+// Values of the on_missing query parameter of RetrieveTokenHandler.
+const (
+	OnMissingError = "error"
+	OnMissingEmpty = "empty"
 )
 
 // RetrieveTokenHandler is the handler for endpoint /token/get. It has the token.Retriever
 // interface as a dependency, which it will call to invoke the correct business logic
 // to retrieve a token for a given user. It uses the token.Retriever interface to fetch
 // the token based on the UserID provided in the request body. If the retrieval is
-// successful, it returns the access token, refresh token, and expiry date. In case
-// of an error or invalid token, the handler responds with a http.StatusInternalServerError
-// status. Note that it will still return the token if it is expired
-func RetrieveTokenHandler(r token.Retriever) gin.HandlerFunc {
+// successful, it returns the token in the ResponseStyle of the env.ServerVars, with its
+// VersionIDHeader, TokenExpiryHeader and Cache-Control headers; the query parameters are
+// described in the README. In case of an error the status is chosen by StatusForError.
+// Note that it will still return the token if it is expired, unless RejectExpired is set.
+func RetrieveTokenHandler(r token.Retriever, cfg env.ServerVars) gin.HandlerFunc {
 	errorBody := gin.H{"Error": "Could not retrieve token"}
 
 	return func(c *gin.Context) {
@@ -26,44 +51,534 @@ func RetrieveTokenHandler(r token.Retriever) gin.HandlerFunc {
 			return
 		}
 
-		tk, err := r.RetrieveToken(&api.RetrieveTokenRequest{UserID: userID.(string)})
-		if err != nil || tk == nil || tk.AccessToken == "" {
+		versionID := c.Query("version_id")
+		if versionID != "" && !secret.IsValidVersionID(versionID) {
+			c.JSON(http.StatusBadRequest, errorBody)
+			return
+		}
+
+		onMissing := c.DefaultQuery("on_missing", OnMissingError)
+		if onMissing != OnMissingError && onMissing != OnMissingEmpty {
+			c.JSON(http.StatusBadRequest, errorBody)
+			return
+		}
+
+		style := c.DefaultQuery("response_style", cfg.ResponseStyle)
+		switch style {
+		case "", env.ResponseStyleSnakeCase, env.ResponseStyleCamelCase, env.ResponseStyleOAuth2:
+		default:
+			c.JSON(http.StatusBadRequest, errorBody)
+			return
+		}
+
+		tk, servedVersionID, err := retrieveToken(c.Request.Context(), r, &api.RetrieveTokenRequest{
+			UserID:    userID.(string),
+			Provider:  c.Query("provider"),
+			VersionID: versionID})
+		if err != nil && onMissing == OnMissingEmpty && secret.IsErrorResourceNotFound(err) {
+			c.JSON(http.StatusOK, gin.H{"token": nil})
+			return
+		}
+		var deleted *secret.ErrSecretDeleted
+		if errors.As(err, &deleted) {
+			c.JSON(http.StatusGone, gin.H{
+				"Error":             "Token was deleted",
+				"recoverable_until": deleted.DeletionDate.Format(time.RFC3339)})
+			return
+		}
+		if err != nil {
+			respondError(c, err, errorBody)
+			return
+		}
+		if tk == nil || tk.AccessToken == "" {
+			c.JSON(http.StatusInternalServerError, errorBody)
+			return
+		}
+		if cfg.RejectExpired && versionID == "" && !tk.Expiry.IsZero() && tk.Expiry.Before(time.Now()) {
+			slog.Info(fmt.Sprintf("Refused token of user %v, it expired at %v", userID, tk.Expiry))
+			c.JSON(http.StatusUnauthorized, gin.H{"Error": "Token has expired"})
+			return
+		}
+
+		if servedVersionID != "" {
+			c.Header(VersionIDHeader, servedVersionID)
+		}
+		now := time.Now()
+		setExpiryHeaders(c, tk, now)
+		res := tokenResponse(tk, style, now)
+		if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEPOSTForm) != gin.MIMEPOSTForm {
+			c.JSON(http.StatusOK, res)
+			return
+		}
+
+		form, err := formEncode(res)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Unable to form encode token: %v", err))
 			c.JSON(http.StatusInternalServerError, errorBody)
 			return
 		}
+		c.Data(http.StatusOK, gin.MIMEPOSTForm, []byte(form.Encode()))
+	}
+}
+
+// setExpiryHeaders sets the TokenExpiryHeader and Cache-Control header of a response serving
+// tk at now. Tokens without an expiry get neither. The response carries a token of a single
+// user, so only private caches may keep it.
+func setExpiryHeaders(c *gin.Context, tk *oauth2.Token, now time.Time) {
+	if tk.Expiry.IsZero() {
+		return
+	}
+
+	maxAge := int64(max(tk.Expiry.Sub(now), 0) / time.Second)
+	c.Header(TokenExpiryHeader, tk.Expiry.UTC().Format(time.RFC3339))
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+}
+
+// retrieveToken retrieves the token through r, with the VersionId served when r is a
+// token.VersionRetriever.
+func retrieveToken(ctx context.Context, r token.Retriever, req *api.RetrieveTokenRequest) (*oauth2.Token, string, error) {
+	if vr, ok := r.(token.VersionRetriever); ok {
+		return vr.RetrieveTokenWithVersion(ctx, req)
+	}
+
+	tk, err := r.RetrieveToken(ctx, req)
+	return tk, "", err
+}
+
+// DescribeTokenHandler is the handler for endpoint /token/describe. It has the
+// token.Describer interface as a dependency and responds with the api.TokenDescription of
+// the token of the user, including the scopes it was granted, but never the token itself.
+// The optional provider query parameter selects the token of that provider.
+func DescribeTokenHandler(d token.Describer) gin.HandlerFunc {
+	errorBody := gin.H{"Error": "Could not describe token"}
+
+	return func(c *gin.Context) {
+		userID, ok := c.Get("user_id")
+		if !ok || userID == "" {
+			c.JSON(http.StatusUnauthorized, errorBody)
+			return
+		}
+
+		desc, err := d.DescribeToken(c.Request.Context(), &api.RetrieveTokenRequest{
+			UserID:   userID.(string),
+			Provider: c.Query("provider")})
+		if err != nil {
+			respondError(c, err, errorBody)
+			return
+		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"access_token":  tk.AccessToken,
-			"refresh_token": tk.RefreshToken,
-			"expiry":        tk.Expiry.String()})
+		c.JSON(http.StatusOK, desc)
 	}
 }
 
+// formEncode converts a token response to form values, named like its JSON fields.
+func formEncode(res any) (url.Values, error) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	for k, v := range fields {
+		form.Set(k, fmt.Sprint(v))
+	}
+	return form, nil
+}
+
+// tokenResponse builds the response struct matching the response style, snake_case
+// unless camelCase or oauth2 is asked for, whose expires_in counts from now. A token
+// without an Expiry is served without one, rather than with the zero time.
+func tokenResponse(tk *oauth2.Token, style string, now time.Time) any {
+	if style == env.ResponseStyleOAuth2 {
+		res := api.OAuth2TokenResponse{
+			AccessToken:  tk.AccessToken,
+			TokenType:    tk.Type(),
+			RefreshToken: tk.RefreshToken,
+			Scope:        token.Scope(tk)}
+		if !tk.Expiry.IsZero() {
+			expiresIn := int64(max(tk.Expiry.Sub(now), 0) / time.Second)
+			res.ExpiresIn = &expiresIn
+		}
+		return res
+	}
+
+	var expiry string
+	var expiresAt int64
+	if !tk.Expiry.IsZero() {
+		expiry = tk.Expiry.Format(time.RFC3339)
+		expiresAt = tk.Expiry.Unix()
+	}
+
+	if style == env.ResponseStyleCamelCase {
+		return api.CamelCaseTokenResponse{
+			AccessToken:   tk.AccessToken,
+			TokenType:     tk.TokenType,
+			RefreshToken:  tk.RefreshToken,
+			Expiry:        expiry,
+			ExpiresAtUnix: expiresAt}
+	}
+
+	return api.TokenResponse{
+		AccessToken:   tk.AccessToken,
+		TokenType:     tk.TokenType,
+		RefreshToken:  tk.RefreshToken,
+		Expiry:        expiry,
+		ExpiresAtUnix: expiresAt}
+}
+
 // SaveTokenHandler is the handler for endpoint /token/save. It has the token.Saver
 // interface as a dependency, which it will call to invoke the correct business
 // logic to save a token given the request is correctly structured. On success,
-// the handler will return a basic success message with status code http.StatusOK
-func SaveTokenHandler(s token.Saver) gin.HandlerFunc {
+// the handler will return a basic success message with status code http.StatusOK and
+// the token.SaveResult, "created" or "updated", as result,
+// otherwise the status for the error is chosen by StatusForError. When the secret quota
+// of the account is exhausted, the response says so, since no retry will help. The request
+// body may use the camelCase field names (userId, accessToken, ...) instead of snake_case,
+// unless SnakeCaseOnly is set in the env.ServerVars.
+// A request without an expiry is rejected with http.StatusBadRequest, unless the
+// env.ServerVars have a DefaultTokenTTL, which then sets the expiry from now. When the
+// token.Saver is a token.VersionSaver, the VersionId of the secret version written is
+// returned as version_id and in the VersionIDHeader.
+func SaveTokenHandler(s token.Saver, cfg env.ServerVars) gin.HandlerFunc {
 	errorBody := gin.H{"Error": "Could not save token"}
+	limitBody := gin.H{"Error": "Could not save token, secret quota exceeded"}
 
 	return func(c *gin.Context) {
 		var req api.SaveTokenRequest
-		if err := c.ShouldBindBodyWithJSON(&req); err != nil {
+		if err := c.ShouldBindBodyWith(&req, bodyBinding(saveTokenBinding, cfg)); err != nil {
 			slog.Error(err.Error())
 			c.JSON(http.StatusBadRequest, errorBody)
 			return
 		}
+		if req.Expiry.IsZero() {
+			if cfg.DefaultTokenTTL <= 0 {
+				slog.Error("Token has no expiry and no default token TTL is configured")
+				c.JSON(http.StatusBadRequest, errorBody)
+				return
+			}
+			req.Expiry = time.Now().Add(cfg.DefaultTokenTTL)
+		}
 
-		err := s.SaveToken(&api.SaveTokenRequest{
+		result, versionID, err := saveToken(c.Request.Context(), s, &api.SaveTokenRequest{
 			UserID:       req.UserID,
+			Provider:     req.Provider,
+			TokenType:    req.TokenType,
+			Scope:        req.Scope,
 			AccessToken:  req.AccessToken,
 			RefreshToken: req.RefreshToken,
 			Expiry:       req.Expiry})
+		if secret.IsErrorLimitExceeded(err) {
+			slog.Warn(fmt.Sprintf("Secrets Manager quota exceeded, delete unused secrets (see /token/cleanup) "+
+				"or request a quota increase: %v", err))
+			c.JSON(StatusForError(err), limitBody)
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, errorBody)
+			respondError(c, err, errorBody)
+			return
+		}
+
+		body := gin.H{"Message": "Token saved successfully", "result": result}
+		if versionID != "" {
+			c.Header(VersionIDHeader, versionID)
+			body["version_id"] = versionID
+		}
+		c.JSON(http.StatusOK, body)
+	}
+}
+
+// saveToken saves the token through s, with the VersionId written when s is a
+// token.VersionSaver.
+func saveToken(ctx context.Context, s token.Saver, req *api.SaveTokenRequest) (token.SaveResult, string, error) {
+	if vs, ok := s.(token.VersionSaver); ok {
+		return vs.SaveTokenWithVersion(ctx, req)
+	}
+
+	result, err := s.SaveToken(ctx, req)
+	return result, "", err
+}
+
+// PatchTokenHandler is the handler for endpoint PATCH /token. It replaces the access_token
+// and expiry of the stored token of the authenticated user, and optionally the provider in
+// the request body, through the token.Patcher and keeps the stored refresh token, for
+// callers that refreshed the access token themselves. A token is never created, a user
+// without one gets a http.StatusNotFound, other errors are mapped by StatusForError. Like
+// SaveTokenHandler, it accepts accessToken for access_token unless SnakeCaseOnly is set.
+func PatchTokenHandler(p token.Patcher, cfg env.ServerVars) gin.HandlerFunc {
+	errorBody := gin.H{"Error": "Could not update token"}
+
+	return func(c *gin.Context) {
+		userID, ok := c.Get("user_id")
+		if !ok || userID == "" {
+			c.JSON(http.StatusUnauthorized, errorBody)
+			return
+		}
+
+		var req api.PatchTokenRequest
+		if err := c.ShouldBindBodyWith(&req, bodyBinding(patchTokenBinding, cfg)); err != nil {
+			slog.Error(err.Error())
+			c.JSON(http.StatusBadRequest, errorBody)
+			return
+		}
+		req.UserID = userID.(string)
+
+		if err := p.PatchToken(c.Request.Context(), &req); err != nil {
+			respondError(c, err, errorBody)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"Message": "Token updated successfully"})
+	}
+}
+
+// SaveDomainTokenHandler is the handler for endpoint /secret/:domain/save. It looks up the
+// domain from the request path in the token.Registry and hands the request to the
+// SaveTokenHandler of that domain's token.Saver. Unknown domains get a http.StatusNotFound.
+func SaveDomainTokenHandler(reg token.Registry, cfg env.ServerVars) gin.HandlerFunc {
+	handlers := map[string]gin.HandlerFunc{}
+	for name, d := range reg {
+		handlers[name] = SaveTokenHandler(d.Saver, cfg)
+	}
+
+	return domainHandler(handlers)
+}
+
+// RetrieveDomainTokenHandler is the handler for endpoint /secret/:domain/get. It looks up
+// the domain from the request path in the token.Registry and hands the request to the
+// RetrieveTokenHandler of that domain's token.Retriever. Unknown domains get a
+// http.StatusNotFound.
+func RetrieveDomainTokenHandler(reg token.Registry, cfg env.ServerVars) gin.HandlerFunc {
+	handlers := map[string]gin.HandlerFunc{}
+	for name, d := range reg {
+		handlers[name] = RetrieveTokenHandler(d.Retriever, cfg)
+	}
+
+	return domainHandler(handlers)
+}
+
+// domainHandler hands a request to the handler of the domain in its path, built once when
+// the router is set up.
+func domainHandler(handlers map[string]gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h, ok := handlers[c.Param("domain")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"Error": "Unknown domain"})
+			return
+		}
+
+		h(c)
+	}
+}
+
+// CleanupHandler is the handler for the administrative endpoint /token/cleanup. It has the
+// token.Cleaner interface as a dependency, which deletes the stale token secrets selected by
+// the cutoffs in the request body, or only reports them when dry_run is set. At least one
+// cutoff is required, otherwise the request is rejected with http.StatusBadRequest.
+func CleanupHandler(cl token.Cleaner) gin.HandlerFunc {
+	errorBody := gin.H{"Error": "Could not clean up tokens"}
+
+	return func(c *gin.Context) {
+		var req api.CleanupRequest
+		if err := c.ShouldBindBodyWithJSON(&req); err != nil {
+			slog.Error(err.Error())
+			c.JSON(http.StatusBadRequest, errorBody)
+			return
+		}
+
+		res, err := cl.Cleanup(c.Request.Context(), &req)
+		if errors.Is(err, token.ErrNoCleanupCutoff) {
+			c.JSON(http.StatusBadRequest, errorBody)
+			return
+		}
+		if err != nil {
+			respondError(c, err, errorBody)
+			return
+		}
+
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// BulkImportHandler is the handler for the administrative endpoint /token/bulk-import. It
+// hands the JSON array of api.BulkImportItem in the request body to the token.BulkImporter,
+// which saves the tokens with bounded concurrency. A failing item does not stop the import,
+// the response is the token.ImportReport with the outcome of every item, in request order.
+// A request body that is not a non-empty array is rejected with http.StatusBadRequest.
+func BulkImportHandler(im token.BulkImporter) gin.HandlerFunc {
+	errorBody := gin.H{"Error": "Could not import tokens"}
+
+	return func(c *gin.Context) {
+		var items []api.BulkImportItem
+		if err := c.ShouldBindBodyWithJSON(&items); err != nil {
+			slog.Error(err.Error())
+			c.JSON(http.StatusBadRequest, errorBody)
+			return
+		}
+		if len(items) == 0 {
+			c.JSON(http.StatusBadRequest, errorBody)
+			return
+		}
+
+		records := make([]token.ImportRecord, len(items))
+		for i, item := range items {
+			records[i] = token.ImportRecord{
+				UserID:   item.UserID,
+				Provider: item.Provider,
+				Token: oauth2.Token{
+					AccessToken:  item.AccessToken,
+					RefreshToken: item.RefreshToken,
+					Expiry:       item.Expiry}}
+		}
+
+		c.JSON(http.StatusOK, im.Import(c.Request.Context(), records))
+	}
+}
+
+// RollbackHandler is the handler for the administrative endpoint /token/rollback. It rolls
+// the token of the user_id, and optionally provider, in the request body back to its
+// previous version through the token.Rollbacker, for when a bad token was saved. The
+// response is the token that is current again, with the field names of the ResponseStyle
+// of the env.ServerVars. A token without a previous version results in a
+// http.StatusNotFound status, other errors are mapped by StatusForError.
+func RollbackHandler(rb token.Rollbacker, cfg env.ServerVars) gin.HandlerFunc {
+	errorBody := gin.H{"Error": "Could not roll back token"}
+
+	return func(c *gin.Context) {
+		var req api.RollbackTokenRequest
+		if err := c.ShouldBindBodyWithJSON(&req); err != nil {
+			slog.Error(err.Error())
+			c.JSON(http.StatusBadRequest, errorBody)
+			return
+		}
+
+		tk, err := rb.RollbackToken(c.Request.Context(), &req)
+		if err != nil {
+			respondError(c, err, errorBody)
+			return
+		}
+
+		c.JSON(http.StatusOK, tokenResponse(tk, cfg.ResponseStyle, time.Now()))
+	}
+}
+
+// DeviceStartHandler is the handler for endpoint /oauth/device/start. It starts the device
+// authorization grant through the token.DeviceAuthorizer for the authenticated user, and
+// optionally the provider in the request body, and responds with the user code and the
+// verification URI the user authorizes the device at. Once authorized, the token is saved
+// under the user in the background, the device then retrieves it from /token/get. A user
+// already waiting for too many devices gets 429.
+func DeviceStartHandler(d token.DeviceAuthorizer) gin.HandlerFunc {
+	errorBody := gin.H{"Error": "Could not start device authorization"}
+
+	return func(c *gin.Context) {
+		userID, ok := c.Get("user_id")
+		if !ok || userID == "" {
+			c.JSON(http.StatusUnauthorized, errorBody)
+			return
+		}
+
+		var req api.DeviceAuthRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindBodyWithJSON(&req); err != nil {
+				slog.Error(err.Error())
+				c.JSON(http.StatusBadRequest, errorBody)
+				return
+			}
+		}
+		req.UserID = userID.(string)
+
+		res, err := d.StartDeviceAuth(c.Request.Context(), &req)
+		if errors.Is(err, token.ErrTooManyDevicePolls) {
+			c.JSON(http.StatusTooManyRequests, errorBody)
+			return
+		}
+		if err != nil {
+			slog.Error(fmt.Sprintf("unable to start device authorization: %v", err))
+			c.JSON(http.StatusBadGateway, errorBody)
+			return
+		}
+
+		var expiresIn int64
+		if !res.Expiry.IsZero() {
+			expiresIn = int64(time.Until(res.Expiry).Seconds())
+		}
+		c.JSON(http.StatusOK, api.DeviceAuthResponse{
+			UserCode:                res.UserCode,
+			VerificationURI:         res.VerificationURI,
+			VerificationURIComplete: res.VerificationURIComplete,
+			ExpiresIn:               expiresIn})
+	}
+}
+
+// ExportTokenHandler is the handler for endpoint /token/export. It exports the token of the
+// authenticated user, and optionally the provider query parameter, through the
+// token.Exporter as an api.SealedToken, sealed for the RSA public key in the required
+// public_key query parameter. The token never leaves in the clear. A missing or invalid
+// public key results in a http.StatusBadRequest status.
+func ExportTokenHandler(e token.Exporter) gin.HandlerFunc {
+	errorBody := gin.H{"Error": "Could not export token"}
+
+	return func(c *gin.Context) {
+		userID, ok := c.Get("user_id")
+		if !ok || userID == "" {
+			c.JSON(http.StatusUnauthorized, errorBody)
+			return
+		}
+
+		publicKey := c.Query("public_key")
+		if publicKey == "" {
+			c.JSON(http.StatusBadRequest, errorBody)
+			return
+		}
+
+		sealed, err := e.ExportToken(c.Request.Context(), &api.ExportTokenRequest{
+			UserID:    userID.(string),
+			Provider:  c.Query("provider"),
+			PublicKey: publicKey})
+		if err != nil {
+			respondError(c, err, errorBody)
+			return
+		}
+
+		c.JSON(http.StatusOK, sealed)
+	}
+}
+
+// ImportTokenHandler is the handler for endpoint /token/import. It stores the api.SealedToken
+// in the request body, exported by another service for this one, as the token of the
+// authenticated user through the token.SealedImporter. Like SaveTokenHandler, it responds
+// with the token.SaveResult. A token that cannot be opened results in a
+// http.StatusBadRequest status.
+func ImportTokenHandler(i token.SealedImporter) gin.HandlerFunc {
+	errorBody := gin.H{"Error": "Could not import token"}
+
+	return func(c *gin.Context) {
+		userID, ok := c.Get("user_id")
+		if !ok || userID == "" {
+			c.JSON(http.StatusUnauthorized, errorBody)
+			return
+		}
+
+		var req api.ImportTokenRequest
+		if err := c.ShouldBindBodyWithJSON(&req); err != nil {
+			slog.Error(err.Error())
+			c.JSON(http.StatusBadRequest, errorBody)
+			return
+		}
+		req.UserID = userID.(string)
+
+		result, err := i.ImportToken(c.Request.Context(), &req)
+		if err != nil {
+			respondError(c, err, errorBody)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"Message": "Token saved successfully"})
+		c.JSON(http.StatusOK, gin.H{"Message": "Token imported successfully", "result": result})
 	}
 }
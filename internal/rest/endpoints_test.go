@@ -2,31 +2,113 @@ package rest
 
 import (
 	"app/api"
+	"app/env"
+	"app/internal/key"
+	"app/internal/secret"
+	"app/internal/token"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/oauth2"
+	"maps"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
 	"testing"
 	"time"
 )
 
 type SaverRetrieverStub struct {
 	RetrieveTokenFunc func(*api.RetrieveTokenRequest) (*oauth2.Token, error)
-	SaveTokenFunc     func(*api.SaveTokenRequest) error
+	SaveTokenFunc     func(*api.SaveTokenRequest) (token.SaveResult, error)
 }
 
-func (s *SaverRetrieverStub) RetrieveToken(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+func (s *SaverRetrieverStub) RetrieveToken(ctx context.Context, req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
 	return s.RetrieveTokenFunc(req)
 }
 
-func (s *SaverRetrieverStub) SaveToken(req *api.SaveTokenRequest) error {
+func (s *SaverRetrieverStub) SaveToken(ctx context.Context, req *api.SaveTokenRequest) (token.SaveResult, error) {
 	return s.SaveTokenFunc(req)
 }
 
+// VersionStub is a token.VersionSaver and token.VersionRetriever serving the token of the
+// version it saved last.
+type VersionStub struct {
+	token     *oauth2.Token
+	versionID string
+}
+
+func (s *VersionStub) SaveToken(ctx context.Context, req *api.SaveTokenRequest) (token.SaveResult, error) {
+	result, _, err := s.SaveTokenWithVersion(ctx, req)
+	return result, err
+}
+
+func (s *VersionStub) SaveTokenWithVersion(ctx context.Context, req *api.SaveTokenRequest) (token.SaveResult, string, error) {
+	s.token = &oauth2.Token{AccessToken: req.AccessToken, RefreshToken: req.RefreshToken, Expiry: req.Expiry}
+	s.versionID = fmt.Sprintf("version-of-%v", req.AccessToken)
+	return token.SaveUpdated, s.versionID, nil
+}
+
+func (s *VersionStub) RetrieveToken(ctx context.Context, req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+	tk, _, err := s.RetrieveTokenWithVersion(ctx, req)
+	return tk, err
+}
+
+func (s *VersionStub) RetrieveTokenWithVersion(ctx context.Context, req *api.RetrieveTokenRequest) (*oauth2.Token, string, error) {
+	return s.token, s.versionID, nil
+}
+
+type CleanerStub struct {
+	CleanupFunc func(context.Context, *api.CleanupRequest) (*api.CleanupResponse, error)
+}
+
+func (s *CleanerStub) Cleanup(ctx context.Context, req *api.CleanupRequest) (*api.CleanupResponse, error) {
+	return s.CleanupFunc(ctx, req)
+}
+
+type RollbackerStub struct {
+	RollbackTokenFunc func(*api.RollbackTokenRequest) (*oauth2.Token, error)
+}
+
+func (s *RollbackerStub) RollbackToken(ctx context.Context, req *api.RollbackTokenRequest) (*oauth2.Token, error) {
+	return s.RollbackTokenFunc(req)
+}
+
+type DescriberStub struct {
+	DescribeTokenFunc func(*api.RetrieveTokenRequest) (*api.TokenDescription, error)
+}
+
+func (s *DescriberStub) DescribeToken(ctx context.Context, req *api.RetrieveTokenRequest) (*api.TokenDescription, error) {
+	return s.DescribeTokenFunc(req)
+}
+
+type PatcherStub struct {
+	PatchTokenFunc func(*api.PatchTokenRequest) error
+}
+
+func (s *PatcherStub) PatchToken(ctx context.Context, req *api.PatchTokenRequest) error {
+	return s.PatchTokenFunc(req)
+}
+
+type DeviceAuthorizerStub struct {
+	StartDeviceAuthFunc func(*api.DeviceAuthRequest) (*oauth2.DeviceAuthResponse, error)
+}
+
+func (s *DeviceAuthorizerStub) StartDeviceAuth(ctx context.Context, req *api.DeviceAuthRequest) (*oauth2.DeviceAuthResponse, error) {
+	return s.StartDeviceAuthFunc(req)
+}
+
 func TestRetrieveTokenHandler(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -41,13 +123,29 @@ func TestRetrieveTokenHandler(t *testing.T) {
 				return &oauth2.Token{
 					AccessToken:  "access_token",
 					RefreshToken: "refresh_token",
+					Expiry:       time.Date(2025, 1, 2, 15, 4, 5, 0, time.FixedZone("CET", 3600)),
 				}, nil
 			},
 			userID:     "1",
 			wantStatus: http.StatusOK,
 			wantBody: gin.H{
-				"access_token":  "access_token",
-				"refresh_token": "refresh_token",
+				"access_token":    "access_token",
+				"refresh_token":   "refresh_token",
+				"expiry":          "2025-01-02T15:04:05+01:00",
+				"expires_at_unix": float64(1735826645),
+			},
+		},
+		{
+			name: "RetrieveTokenWithoutExpiry",
+			retrieverStub: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+				return &oauth2.Token{AccessToken: "access_token", RefreshToken: "refresh_token"}, nil
+			},
+			userID:     "1",
+			wantStatus: http.StatusOK,
+			wantBody: gin.H{
+				"access_token":    "access_token",
+				"expiry":          nil,
+				"expires_at_unix": nil,
 			},
 		},
 		{
@@ -65,11 +163,40 @@ func TestRetrieveTokenHandler(t *testing.T) {
 			wantStatus: http.StatusInternalServerError,
 			wantBody:   gin.H{"Error": "Could not retrieve token"},
 		},
+		{
+			name: "RetrieveTokenNotFound",
+			retrieverStub: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+				return nil, &types.ResourceNotFoundException{}
+			},
+			userID:     "1",
+			wantStatus: http.StatusNotFound,
+			wantBody:   gin.H{"Error": "Could not retrieve token"},
+		},
+		{
+			name: "RetrieveTokenDeletedRecoverable",
+			retrieverStub: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+				return nil, &secret.ErrSecretDeleted{
+					SecretID:     "root/token/1",
+					DeletionDate: time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)}
+			},
+			userID:     "1",
+			wantStatus: http.StatusGone,
+			wantBody:   gin.H{"Error": "Token was deleted", "recoverable_until": "2025-02-01T12:00:00Z"},
+		},
+		{
+			name: "RetrieveTokenAWSRequestID",
+			retrieverStub: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+				return nil, awsError("req-1", http.StatusInternalServerError, &smithy.GenericAPIError{Code: "InternalServiceError"})
+			},
+			userID:     "1",
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   gin.H{"Error": "Could not retrieve token", "aws_request_id": "req-1"},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			handler := RetrieveTokenHandler(&SaverRetrieverStub{RetrieveTokenFunc: tt.retrieverStub})
+			handler := RetrieveTokenHandler(&SaverRetrieverStub{RetrieveTokenFunc: tt.retrieverStub}, env.ServerVars{})
 
 			resp := httptest.NewRecorder()
 			c, _ := gin.CreateTestContext(resp)
@@ -87,80 +214,1407 @@ func TestRetrieveTokenHandler(t *testing.T) {
 					break
 				}
 			}
+			if expiry, ok := getValueFromResponse(t, resp.Body, "expiry").(string); ok {
+				parsed, err := time.Parse(time.RFC3339, expiry)
+				if err != nil {
+					t.Fatalf("RetrieveToken() expiry = %v, not RFC 3339: %v", expiry, err)
+				}
+				if unix := getValueFromResponse(t, resp.Body, "expires_at_unix"); unix != float64(parsed.Unix()) {
+					t.Errorf("RetrieveToken() expires_at_unix = %v, want %v", unix, parsed.Unix())
+				}
+			}
 		})
 	}
 }
 
-func TestSaveTokenHandler(t *testing.T) {
+func TestRetrieveTokenHandler_RejectExpired(t *testing.T) {
 	tests := []struct {
-		name        string
-		saverStub   func(*api.SaveTokenRequest) error
-		requestBody string
-		wantStatus  int
-		wantBody    map[string]interface{}
+		name          string
+		rejectExpired bool
+		expiry        time.Time
+		wantStatus    int
 	}{
 		{
-			name: "SaveTokenSuccessful",
-			saverStub: func(req *api.SaveTokenRequest) error {
-				return nil
-			},
-			requestBody: fmt.Sprintf(`{
-				"user_id":       "userID", 
-				"access_token":  "access_token", 
-				"refresh_token": "refresh_token", 
-				"expiry":        "%s"}`, time.Now().Format(time.RFC3339)),
+			name:       "RejectExpiredDisabledReturnsExpired",
+			expiry:     time.Now().Add(-time.Hour),
 			wantStatus: http.StatusOK,
-			wantBody:   gin.H{"Message": "Token saved successfully"},
 		},
 		{
-			name:        "SaveTokenInvalidRequestBody",
-			requestBody: `{"user_id": "userID"}`,
-			wantStatus:  http.StatusBadRequest,
-			wantBody:    gin.H{"Error": "Could not save token"},
+			name:          "RejectExpiredEnabledRefusesExpired",
+			rejectExpired: true,
+			expiry:        time.Now().Add(-time.Hour),
+			wantStatus:    http.StatusUnauthorized,
 		},
 		{
-			name: "SaveTokenSaverError",
-			saverStub: func(req *api.SaveTokenRequest) error {
-				return errors.New("server error")
-			},
-			requestBody: fmt.Sprintf(`{
-				"user_id":       "userID", 
-				"access_token":  "access_token", 
-				"refresh_token": "refresh_token", 
-				"expiry":        "%s"}`, time.Now().Format(time.RFC3339)),
-			wantStatus: http.StatusInternalServerError,
-			wantBody:   gin.H{"Error": "Could not save token"},
+			name:          "RejectExpiredEnabledReturnsValid",
+			rejectExpired: true,
+			expiry:        time.Now().Add(time.Hour),
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "RejectExpiredEnabledReturnsWithoutExpiry",
+			rejectExpired: true,
+			wantStatus:    http.StatusOK,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			handler := SaveTokenHandler(&SaverRetrieverStub{SaveTokenFunc: tt.saverStub})
+			stub := &SaverRetrieverStub{RetrieveTokenFunc: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+				return &oauth2.Token{AccessToken: "access_token", Expiry: tt.expiry}, nil
+			}}
+			handler := RetrieveTokenHandler(stub, env.ServerVars{RejectExpired: tt.rejectExpired})
 
 			resp := httptest.NewRecorder()
 			c, _ := gin.CreateTestContext(resp)
-			c.Request = httptest.NewRequest("POST", "/token/save", bytes.NewBufferString(tt.requestBody))
-			c.Request.Header.Set("Content-Type", "application/json")
+			c.Set("user_id", "1")
+			c.Request = httptest.NewRequest("GET", "/token/get", nil)
 
 			handler(c)
 			if resp.Code != tt.wantStatus {
-				t.Errorf("RetrieveToken() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+				t.Fatalf("RetrieveToken() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
 			}
-			for key, value := range tt.wantBody {
-				if getValueFromResponse(t, resp.Body, key) != value {
-					t.Errorf("RetrieveToken() body = %v, wantBody = %v", resp.Body.String(), tt.wantBody)
-					break
+			hasToken := getValueFromResponse(t, resp.Body, "access_token") == "access_token"
+			if hasToken != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("RetrieveToken() body = %v, want the token only with status 200", resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestRetrieveTokenHandler_ExpiryHeaders(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name       string
+		expiry     time.Time
+		wantMaxAge int
+	}{
+		{
+			name:       "ExpiryHeadersValid",
+			expiry:     expiry,
+			wantMaxAge: 3600,
+		},
+		{
+			name:       "ExpiryHeadersExpired",
+			expiry:     expiry.Add(-2 * time.Hour),
+			wantMaxAge: 0,
+		},
+		{
+			name: "ExpiryHeadersWithoutExpiry",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &SaverRetrieverStub{RetrieveTokenFunc: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+				return &oauth2.Token{AccessToken: "access_token", Expiry: tt.expiry}, nil
+			}}
+			handler := RetrieveTokenHandler(stub, env.ServerVars{})
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Set("user_id", "1")
+			c.Request = httptest.NewRequest("GET", "/token/get", nil)
+
+			handler(c)
+			if resp.Code != http.StatusOK {
+				t.Fatalf("RetrieveToken() status = %v, wantStatus = %v", resp.Code, http.StatusOK)
+			}
+
+			header, cacheControl := resp.Header().Get(TokenExpiryHeader), resp.Header().Get("Cache-Control")
+			if tt.expiry.IsZero() {
+				if header != "" || cacheControl != "" {
+					t.Errorf("RetrieveToken() headers = %q, %q, want none without an expiry", header, cacheControl)
 				}
+				return
+			}
+
+			if got, err := time.Parse(time.RFC3339, header); err != nil || !got.Equal(tt.expiry) {
+				t.Errorf("RetrieveToken() %v = %q, want %v", TokenExpiryHeader, header, tt.expiry.UTC().Format(time.RFC3339))
+			}
+			var maxAge int
+			if _, err := fmt.Sscanf(cacheControl, "private, max-age=%d", &maxAge); err != nil ||
+				maxAge > tt.wantMaxAge || maxAge < tt.wantMaxAge-5 {
+				t.Errorf("RetrieveToken() Cache-Control = %q, want private, max-age=%d", cacheControl, tt.wantMaxAge)
 			}
 		})
 	}
 }
 
-func getValueFromResponse(t *testing.T, body *bytes.Buffer, key string) any {
-	var responseBody gin.H
-	if err := json.Unmarshal(body.Bytes(), &responseBody); err != nil {
-		t.Fatalf("Failed to decode response body: %v", err)
+func TestRetrieveTokenHandler_VersionID(t *testing.T) {
+	tokens := map[string]string{
+		"":                                     "current",
+		"EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE": "old",
 	}
+	stub := &SaverRetrieverStub{RetrieveTokenFunc: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+		access, ok := tokens[req.VersionID]
+		if !ok {
+			return nil, &types.ResourceNotFoundException{}
+		}
+		return &oauth2.Token{AccessToken: access}, nil
+	}}
 
-	return responseBody[key]
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       string
+	}{
+		{name: "VersionIDCurrent", query: "", wantStatus: http.StatusOK, want: "current"},
+		{name: "VersionIDHistorical", query: "?version_id=EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE", wantStatus: http.StatusOK, want: "old"},
+		{name: "VersionIDUnknown", query: "?version_id=EXAMPLE9-90ab-cdef-fedc-ba987EXAMPLE", wantStatus: http.StatusNotFound},
+		{name: "VersionIDMalformed", query: "?version_id=v1", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RetrieveTokenHandler(stub, env.ServerVars{})
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Set("user_id", "1")
+			c.Request = httptest.NewRequest("GET", "/token/get"+tt.query, nil)
+
+			handler(c)
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("RetrieveToken() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			if tt.want != "" && getValueFromResponse(t, resp.Body, "access_token") != tt.want {
+				t.Errorf("RetrieveToken() body = %v, want access_token %v", resp.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRetrieveTokenHandler_ResponseStyle(t *testing.T) {
+	tests := []struct {
+		name     string
+		style    string
+		wantKeys []string
+	}{
+		{
+			name:     "ResponseStyleDefault",
+			style:    "",
+			wantKeys: []string{"access_token", "refresh_token", "token_type"},
+		},
+		{
+			name:     "ResponseStyleSnakeCase",
+			style:    env.ResponseStyleSnakeCase,
+			wantKeys: []string{"access_token", "refresh_token", "token_type"},
+		},
+		{
+			name:     "ResponseStyleCamelCase",
+			style:    env.ResponseStyleCamelCase,
+			wantKeys: []string{"accessToken", "refreshToken", "tokenType"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &SaverRetrieverStub{RetrieveTokenFunc: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+				return &oauth2.Token{AccessToken: "access_token", TokenType: "Bearer", RefreshToken: "refresh_token"}, nil
+			}}
+			handler := RetrieveTokenHandler(stub, env.ServerVars{ResponseStyle: tt.style})
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Set("user_id", "1")
+			c.Request = httptest.NewRequest("GET", "/token/get", nil)
+
+			handler(c)
+			var body map[string]any
+			if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode response body: %v", err)
+			}
+			if keys := slices.Sorted(maps.Keys(body)); !slices.Equal(keys, tt.wantKeys) {
+				t.Errorf("RetrieveToken() keys = %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestRetrieveTokenHandler_OAuth2Response(t *testing.T) {
+	tests := []struct {
+		name          string
+		style         string
+		query         string
+		expiry        time.Time
+		wantStatus    int
+		wantExpiresIn float64
+		wantKeys      []string
+	}{
+		{
+			name:          "OAuth2ResponseQuery",
+			query:         "?response_style=oauth2",
+			expiry:        time.Now().Add(time.Hour),
+			wantStatus:    http.StatusOK,
+			wantExpiresIn: 3600,
+			wantKeys:      []string{"access_token", "expires_in", "refresh_token", "scope", "token_type"},
+		},
+		{
+			name:          "OAuth2ResponseConfigured",
+			style:         env.ResponseStyleOAuth2,
+			expiry:        time.Now().Add(90 * time.Second),
+			wantStatus:    http.StatusOK,
+			wantExpiresIn: 90,
+			wantKeys:      []string{"access_token", "expires_in", "refresh_token", "scope", "token_type"},
+		},
+		{
+			name:          "OAuth2ResponseExpired",
+			query:         "?response_style=oauth2",
+			expiry:        time.Now().Add(-time.Hour),
+			wantStatus:    http.StatusOK,
+			wantExpiresIn: 0,
+			wantKeys:      []string{"access_token", "expires_in", "refresh_token", "scope", "token_type"},
+		},
+		{
+			name:       "OAuth2ResponseWithoutExpiry",
+			query:      "?response_style=oauth2",
+			wantStatus: http.StatusOK,
+			wantKeys:   []string{"access_token", "refresh_token", "scope", "token_type"},
+		},
+		{
+			name:       "OAuth2ResponseOverridden",
+			style:      env.ResponseStyleOAuth2,
+			query:      "?response_style=snake_case",
+			wantStatus: http.StatusOK,
+			wantKeys:   []string{"access_token", "refresh_token", "token_type"},
+		},
+		{
+			name:       "OAuth2ResponseUnknownStyle",
+			query:      "?response_style=xml",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &SaverRetrieverStub{RetrieveTokenFunc: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+				return token.WithScope(&oauth2.Token{
+					AccessToken:  "access_token",
+					TokenType:    "Bearer",
+					RefreshToken: "refresh_token",
+					Expiry:       tt.expiry}, "read write"), nil
+			}}
+			handler := RetrieveTokenHandler(stub, env.ServerVars{ResponseStyle: tt.style})
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Set("user_id", "1")
+			c.Request = httptest.NewRequest("GET", "/token/get"+tt.query, nil)
+
+			handler(c)
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("RetrieveToken() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body map[string]any
+			if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode response body: %v", err)
+			}
+			if keys := slices.Sorted(maps.Keys(body)); !slices.Equal(keys, tt.wantKeys) {
+				t.Errorf("RetrieveToken() keys = %v, want %v", keys, tt.wantKeys)
+			}
+			if _, ok := body["scope"]; ok && body["scope"] != "read write" {
+				t.Errorf("RetrieveToken() scope = %v, want %v", body["scope"], "read write")
+			}
+			if expiresIn, ok := body["expires_in"].(float64); ok {
+				// A second may pass between the stub and the handler.
+				if expiresIn > tt.wantExpiresIn || expiresIn < tt.wantExpiresIn-1 {
+					t.Errorf("RetrieveToken() expires_in = %v, want %v", expiresIn, tt.wantExpiresIn)
+				}
+			}
+		})
+	}
+}
+
+func TestRetrieveTokenHandler_OnMissing(t *testing.T) {
+	// The MemoryStore resolves no secret, like Secrets Manager for a user without a token.
+	store := secret.NewMemoryStore()
+	retriever := &token.ApiRetriever{Env: env.AwsVars{SmsRootDomain: "root"}, Res: store, Get: store}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "OnMissingDefault",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"Error":"Could not retrieve token"}`,
+		},
+		{
+			name:       "OnMissingError",
+			query:      "?on_missing=error",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"Error":"Could not retrieve token"}`,
+		},
+		{
+			name:       "OnMissingEmpty",
+			query:      "?on_missing=empty",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"token":null}`,
+		},
+		{
+			name:       "OnMissingInvalid",
+			query:      "?on_missing=ignore",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"Error":"Could not retrieve token"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RetrieveTokenHandler(retriever, env.ServerVars{})
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Set("user_id", "1")
+			c.Request = httptest.NewRequest("GET", "/token/get"+tt.query, nil)
+
+			handler(c)
+			if resp.Code != tt.wantStatus {
+				t.Errorf("RetrieveToken() status = %v, want %v", resp.Code, tt.wantStatus)
+			}
+			if body := resp.Body.String(); body != tt.wantBody {
+				t.Errorf("RetrieveToken() body = %v, want %v", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRetrieveTokenHandler_Accept(t *testing.T) {
+	tests := []struct {
+		name            string
+		accept          string
+		wantContentType string
+	}{
+		{
+			name:            "AcceptDefault",
+			wantContentType: "application/json; charset=utf-8",
+		},
+		{
+			name:            "AcceptJSON",
+			accept:          "application/json",
+			wantContentType: "application/json; charset=utf-8",
+		},
+		{
+			name:            "AcceptForm",
+			accept:          "application/x-www-form-urlencoded",
+			wantContentType: "application/x-www-form-urlencoded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &SaverRetrieverStub{RetrieveTokenFunc: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+				return &oauth2.Token{AccessToken: "access token", TokenType: "Bearer", RefreshToken: "refresh_token"}, nil
+			}}
+			handler := RetrieveTokenHandler(stub, env.ServerVars{})
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Set("user_id", "1")
+			c.Request = httptest.NewRequest("GET", "/token/get", nil)
+			if tt.accept != "" {
+				c.Request.Header.Set("Accept", tt.accept)
+			}
+
+			handler(c)
+			if resp.Code != http.StatusOK {
+				t.Fatalf("RetrieveToken() status = %v, want %v", resp.Code, http.StatusOK)
+			}
+			if ct := resp.Header().Get("Content-Type"); ct != tt.wantContentType {
+				t.Errorf("RetrieveToken() Content-Type = %v, want %v", ct, tt.wantContentType)
+			}
+
+			var accessToken, refreshToken string
+			if tt.wantContentType == gin.MIMEPOSTForm {
+				form, err := url.ParseQuery(resp.Body.String())
+				if err != nil {
+					t.Fatalf("Failed to decode response body: %v", err)
+				}
+				accessToken, refreshToken = form.Get("access_token"), form.Get("refresh_token")
+			} else {
+				var body api.TokenResponse
+				if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+					t.Fatalf("Failed to decode response body: %v", err)
+				}
+				accessToken, refreshToken = body.AccessToken, body.RefreshToken
+			}
+			if accessToken != "access token" || refreshToken != "refresh_token" {
+				t.Errorf("RetrieveToken() body = %v, want access token and refresh_token", resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestSaveTokenHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		saverStub   func(*api.SaveTokenRequest) (token.SaveResult, error)
+		requestBody string
+		wantStatus  int
+		wantBody    map[string]interface{}
+	}{
+		{
+			name: "SaveTokenSuccessful",
+			saverStub: func(req *api.SaveTokenRequest) (token.SaveResult, error) {
+				return token.SaveCreated, nil
+			},
+			requestBody: fmt.Sprintf(`{
+				"user_id":       "userID", 
+				"access_token":  "access_token", 
+				"refresh_token": "refresh_token", 
+				"expiry":        "%s"}`, time.Now().Format(time.RFC3339)),
+			wantStatus: http.StatusOK,
+			wantBody:   gin.H{"Message": "Token saved successfully", "result": "created"},
+		},
+		{
+			name: "SaveTokenUpdated",
+			saverStub: func(req *api.SaveTokenRequest) (token.SaveResult, error) {
+				return token.SaveUpdated, nil
+			},
+			requestBody: fmt.Sprintf(`{
+				"user_id":       "userID", 
+				"access_token":  "access_token", 
+				"refresh_token": "refresh_token", 
+				"expiry":        "%s"}`, time.Now().Format(time.RFC3339)),
+			wantStatus: http.StatusOK,
+			wantBody:   gin.H{"Message": "Token saved successfully", "result": "updated"},
+		},
+		{
+			name: "SaveTokenCamelCase",
+			saverStub: func(req *api.SaveTokenRequest) (token.SaveResult, error) {
+				if req.UserID != "userID" || req.AccessToken != "access_token" || req.RefreshToken != "refresh_token" {
+					return "", errors.New("request not bound")
+				}
+				return token.SaveCreated, nil
+			},
+			requestBody: fmt.Sprintf(`{
+				"userId":       "userID", 
+				"accessToken":  "access_token", 
+				"refreshToken": "refresh_token", 
+				"expiry":       "%s"}`, time.Now().Format(time.RFC3339)),
+			wantStatus: http.StatusOK,
+			wantBody:   gin.H{"Message": "Token saved successfully", "result": "created"},
+		},
+		{
+			name:        "SaveTokenInvalidRequestBody",
+			requestBody: `{"user_id": "userID"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantBody:    gin.H{"Error": "Could not save token"},
+		},
+		{
+			name: "SaveTokenSaverError",
+			saverStub: func(req *api.SaveTokenRequest) (token.SaveResult, error) {
+				return "", errors.New("server error")
+			},
+			requestBody: fmt.Sprintf(`{
+				"user_id":       "userID", 
+				"access_token":  "access_token", 
+				"refresh_token": "refresh_token", 
+				"expiry":        "%s"}`, time.Now().Format(time.RFC3339)),
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   gin.H{"Error": "Could not save token"},
+		},
+		{
+			name: "SaveTokenMissingSecretNotCreated",
+			saverStub: func(req *api.SaveTokenRequest) (token.SaveResult, error) {
+				return "", &types.ResourceNotFoundException{}
+			},
+			requestBody: fmt.Sprintf(`{
+				"user_id":       "userID", 
+				"access_token":  "access_token", 
+				"refresh_token": "refresh_token", 
+				"expiry":        "%s"}`, time.Now().Format(time.RFC3339)),
+			wantStatus: http.StatusNotFound,
+			wantBody:   gin.H{"Error": "Could not save token"},
+		},
+		{
+			name: "SaveTokenThrottled",
+			saverStub: func(req *api.SaveTokenRequest) (token.SaveResult, error) {
+				return "", &smithy.GenericAPIError{Code: "ThrottlingException"}
+			},
+			requestBody: fmt.Sprintf(`{
+				"user_id":       "userID", 
+				"access_token":  "access_token", 
+				"refresh_token": "refresh_token", 
+				"expiry":        "%s"}`, time.Now().Format(time.RFC3339)),
+			wantStatus: http.StatusTooManyRequests,
+			wantBody:   gin.H{"Error": "Could not save token"},
+		},
+		{
+			name: "SaveTokenLimitExceeded",
+			saverStub: func(req *api.SaveTokenRequest) (token.SaveResult, error) {
+				return "", fmt.Errorf("create: %w", &types.LimitExceededException{})
+			},
+			requestBody: fmt.Sprintf(`{
+				"user_id":       "userID", 
+				"access_token":  "access_token", 
+				"refresh_token": "refresh_token", 
+				"expiry":        "%s"}`, time.Now().Format(time.RFC3339)),
+			wantStatus: http.StatusTooManyRequests,
+			wantBody:   gin.H{"Error": "Could not save token, secret quota exceeded"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := SaveTokenHandler(&SaverRetrieverStub{SaveTokenFunc: tt.saverStub}, env.ServerVars{})
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Request = httptest.NewRequest("POST", "/token/save", bytes.NewBufferString(tt.requestBody))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler(c)
+			if resp.Code != tt.wantStatus {
+				t.Errorf("RetrieveToken() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			for key, value := range tt.wantBody {
+				if getValueFromResponse(t, resp.Body, key) != value {
+					t.Errorf("RetrieveToken() body = %v, wantBody = %v", resp.Body.String(), tt.wantBody)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSaveTokenHandler_DefaultTokenTTL(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		expiry     string
+		wantStatus int
+		wantExpiry time.Duration
+	}{
+		{
+			name:       "DefaultTTLApplied",
+			ttl:        time.Hour,
+			wantStatus: http.StatusOK,
+			wantExpiry: time.Hour,
+		},
+		{
+			name:       "DefaultTTLExpiryGiven",
+			ttl:        time.Hour,
+			expiry:     time.Now().Add(2 * time.Hour).Format(time.RFC3339),
+			wantStatus: http.StatusOK,
+			wantExpiry: 2 * time.Hour,
+		},
+		{
+			name:       "NoDefaultTTLExpiryRequired",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved *api.SaveTokenRequest
+			handler := SaveTokenHandler(&SaverRetrieverStub{
+				SaveTokenFunc: func(req *api.SaveTokenRequest) (token.SaveResult, error) {
+					saved = req
+					return token.SaveCreated, nil
+				},
+			}, env.ServerVars{DefaultTokenTTL: tt.ttl})
+
+			body := map[string]string{"user_id": "1", "access_token": "access", "refresh_token": "refresh"}
+			if tt.expiry != "" {
+				body["expiry"] = tt.expiry
+			}
+			raw, _ := json.Marshal(body)
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Request = httptest.NewRequest("PUT", "/token/save", bytes.NewReader(raw))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler(c)
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("SaveToken() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if saved != nil {
+					t.Errorf("SaveToken() saved %+v, want the request rejected", saved)
+				}
+				return
+			}
+			if d := time.Until(saved.Expiry) - tt.wantExpiry; d > time.Minute || d < -time.Minute {
+				t.Errorf("SaveToken() expiry = %v, want about %v from now", saved.Expiry, tt.wantExpiry)
+			}
+		})
+	}
+}
+
+func TestTokenHandlers_VersionID(t *testing.T) {
+	stub := &VersionStub{}
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", "1") })
+	r.PUT("/token/save", SaveTokenHandler(stub, env.ServerVars{}))
+	r.GET("/token/get", RetrieveTokenHandler(stub, env.ServerVars{}))
+
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest("PUT", "/token/save", bytes.NewBufferString(fmt.Sprintf(`{
+		"user_id": "1", "access_token": "access", "refresh_token": "refresh", "expiry": "%s"}`,
+		time.Now().Add(time.Hour).Format(time.RFC3339))))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("SaveToken() status = %v, body = %v", resp.Code, resp.Body.String())
+	}
+	saved := getValueFromResponse(t, resp.Body, "version_id")
+	if saved != "version-of-access" || resp.Header().Get(VersionIDHeader) != saved {
+		t.Errorf("SaveToken() version_id = %v, %v = %v, want version-of-access",
+			saved, VersionIDHeader, resp.Header().Get(VersionIDHeader))
+	}
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest("GET", "/token/get", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("RetrieveToken() status = %v, body = %v", resp.Code, resp.Body.String())
+	}
+	if served := resp.Header().Get(VersionIDHeader); served != saved {
+		t.Errorf("RetrieveToken() %v = %v, want the saved version %v", VersionIDHeader, served, saved)
+	}
+}
+
+func TestTokenHandlers_Provider(t *testing.T) {
+	tokens := map[string]*oauth2.Token{}
+	stub := &SaverRetrieverStub{
+		SaveTokenFunc: func(req *api.SaveTokenRequest) (token.SaveResult, error) {
+			tokens[req.Provider] = &oauth2.Token{AccessToken: req.AccessToken}
+			return token.SaveCreated, nil
+		},
+		RetrieveTokenFunc: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+			tk, ok := tokens[req.Provider]
+			if !ok {
+				return nil, &types.ResourceNotFoundException{}
+			}
+			return tk, nil
+		},
+	}
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", "1") })
+	r.PUT("/token/save", SaveTokenHandler(stub, env.ServerVars{}))
+	r.GET("/token/get", RetrieveTokenHandler(stub, env.ServerVars{}))
+
+	for _, provider := range []string{"google", "github"} {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/token/save", bytes.NewBufferString(fmt.Sprintf(`{
+			"user_id": "1", "provider": "%s", "access_token": "%s_access", "refresh_token": "refresh",
+			"expiry": "%s"}`, provider, provider, time.Now().Add(time.Hour).Format(time.RFC3339))))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("SaveToken() status = %v, body = %v", resp.Code, resp.Body.String())
+		}
+	}
+
+	for _, provider := range []string{"google", "github"} {
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest("GET", "/token/get?provider="+provider, nil))
+		if resp.Code != http.StatusOK {
+			t.Fatalf("RetrieveToken() status = %v, body = %v", resp.Code, resp.Body.String())
+		}
+		if got := getValueFromResponse(t, resp.Body, "access_token"); got != provider+"_access" {
+			t.Errorf("RetrieveToken() of %v = %v, want %v_access", provider, got, provider)
+		}
+	}
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest("GET", "/token/get", nil))
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("RetrieveToken() without a provider status = %v, want %v", resp.Code, http.StatusNotFound)
+	}
+}
+
+func TestPatchTokenHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		patcherStub func(*api.PatchTokenRequest) error
+		userID      string
+		requestBody string
+		wantStatus  int
+		wantBody    map[string]interface{}
+	}{
+		{
+			name: "PatchTokenSuccess",
+			patcherStub: func(req *api.PatchTokenRequest) error {
+				if req.UserID != "1" || req.Provider != "google" || req.AccessToken != "new_token" || req.Expiry.IsZero() {
+					return errors.New("request not bound")
+				}
+				return nil
+			},
+			userID:      "1",
+			requestBody: `{"provider": "google", "access_token": "new_token", "expiry": "2030-01-01T00:00:00Z"}`,
+			wantStatus:  http.StatusOK,
+			wantBody:    gin.H{"Message": "Token updated successfully"},
+		},
+		{
+			name:        "PatchTokenMissingAccessToken",
+			userID:      "1",
+			requestBody: `{"expiry": "2030-01-01T00:00:00Z"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantBody:    gin.H{"Error": "Could not update token"},
+		},
+		{
+			name: "PatchTokenNotFound",
+			patcherStub: func(req *api.PatchTokenRequest) error {
+				return &types.ResourceNotFoundException{}
+			},
+			userID:      "1",
+			requestBody: `{"access_token": "new_token", "expiry": "2030-01-01T00:00:00Z"}`,
+			wantStatus:  http.StatusNotFound,
+			wantBody:    gin.H{"Error": "Could not update token"},
+		},
+		{
+			name:        "PatchTokenNoUser",
+			requestBody: `{"access_token": "new_token", "expiry": "2030-01-01T00:00:00Z"}`,
+			wantStatus:  http.StatusUnauthorized,
+			wantBody:    gin.H{"Error": "Could not update token"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := PatchTokenHandler(&PatcherStub{PatchTokenFunc: tt.patcherStub}, env.ServerVars{})
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			if tt.userID != "" {
+				c.Set("user_id", tt.userID)
+			}
+			c.Request = httptest.NewRequest("PATCH", "/token", bytes.NewBufferString(tt.requestBody))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler(c)
+			if resp.Code != tt.wantStatus {
+				t.Errorf("PatchToken() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			for key, value := range tt.wantBody {
+				if getValueFromResponse(t, resp.Body, key) != value {
+					t.Errorf("PatchToken() body = %v, wantBody = %v", resp.Body.String(), tt.wantBody)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestDomainTokenHandlers(t *testing.T) {
+	var called string
+	domainStub := func(name string) token.Domain {
+		stub := &SaverRetrieverStub{
+			SaveTokenFunc: func(req *api.SaveTokenRequest) (token.SaveResult, error) {
+				called = name
+				return token.SaveUpdated, nil
+			},
+			RetrieveTokenFunc: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+				called = name
+				return &oauth2.Token{AccessToken: name}, nil
+			},
+		}
+		return token.Domain{Saver: stub, Retriever: stub}
+	}
+	reg := token.Registry{"token": domainStub("token"), "apikey": domainStub("apikey")}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", "1") })
+	r.PUT("/secret/:domain/save", SaveDomainTokenHandler(reg, env.ServerVars{}))
+	r.GET("/secret/:domain/get", RetrieveDomainTokenHandler(reg, env.ServerVars{}))
+
+	body := fmt.Sprintf(`{
+		"user_id":       "1",
+		"access_token":  "access_token",
+		"refresh_token": "refresh_token",
+		"expiry":        "%s"}`, time.Now().Format(time.RFC3339))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCalled string
+	}{
+		{
+			name:       "SaveTokenDomain",
+			method:     "PUT",
+			path:       "/secret/token/save",
+			body:       body,
+			wantStatus: http.StatusOK,
+			wantCalled: "token",
+		},
+		{
+			name:       "SaveApiKeyDomain",
+			method:     "PUT",
+			path:       "/secret/apikey/save",
+			body:       body,
+			wantStatus: http.StatusOK,
+			wantCalled: "apikey",
+		},
+		{
+			name:       "RetrieveApiKeyDomain",
+			method:     "GET",
+			path:       "/secret/apikey/get",
+			wantStatus: http.StatusOK,
+			wantCalled: "apikey",
+		},
+		{
+			name:       "SaveUnknownDomain",
+			method:     "PUT",
+			path:       "/secret/session/save",
+			body:       body,
+			wantStatus: http.StatusNotFound,
+			wantCalled: "",
+		},
+		{
+			name:       "RetrieveUnknownDomain",
+			method:     "GET",
+			path:       "/secret/session/get",
+			wantStatus: http.StatusNotFound,
+			wantCalled: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = ""
+			resp := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			r.ServeHTTP(resp, req)
+			if resp.Code != tt.wantStatus {
+				t.Errorf("%v %v status = %v, wantStatus = %v", tt.method, tt.path, resp.Code, tt.wantStatus)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("%v %v routed to = %q, want %q", tt.method, tt.path, called, tt.wantCalled)
+			}
+		})
+	}
+}
+
+func TestCleanupHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		cleanerStub func(context.Context, *api.CleanupRequest) (*api.CleanupResponse, error)
+		requestBody string
+		wantStatus  int
+		wantBody    map[string]interface{}
+	}{
+		{
+			name: "CleanupSuccess",
+			cleanerStub: func(ctx context.Context, req *api.CleanupRequest) (*api.CleanupResponse, error) {
+				if !req.DryRun || req.ExpiredBefore.IsZero() {
+					return nil, errors.New("request not bound")
+				}
+				return &api.CleanupResponse{Candidates: []string{"root/token/1"}, DryRun: true}, nil
+			},
+			requestBody: `{"expired_before": "2025-01-01T00:00:00Z", "dry_run": true}`,
+			wantStatus:  http.StatusOK,
+			wantBody:    gin.H{"deleted": float64(0), "dry_run": true},
+		},
+		{
+			name:        "CleanupInvalidRequestBody",
+			requestBody: `{"expired_before": "yesterday"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantBody:    gin.H{"Error": "Could not clean up tokens"},
+		},
+		{
+			name: "CleanupNoCutoff",
+			cleanerStub: func(ctx context.Context, req *api.CleanupRequest) (*api.CleanupResponse, error) {
+				return nil, token.ErrNoCleanupCutoff
+			},
+			requestBody: `{"dry_run": true}`,
+			wantStatus:  http.StatusBadRequest,
+			wantBody:    gin.H{"Error": "Could not clean up tokens"},
+		},
+		{
+			name: "CleanupListError",
+			cleanerStub: func(ctx context.Context, req *api.CleanupRequest) (*api.CleanupResponse, error) {
+				return nil, errors.New("server error")
+			},
+			requestBody: `{"changed_before": "2025-01-01T00:00:00Z"}`,
+			wantStatus:  http.StatusInternalServerError,
+			wantBody:    gin.H{"Error": "Could not clean up tokens"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := CleanupHandler(&CleanerStub{CleanupFunc: tt.cleanerStub})
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Request = httptest.NewRequest("POST", "/token/cleanup", bytes.NewBufferString(tt.requestBody))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler(c)
+			if resp.Code != tt.wantStatus {
+				t.Errorf("Cleanup() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			for key, value := range tt.wantBody {
+				if getValueFromResponse(t, resp.Body, key) != value {
+					t.Errorf("Cleanup() body = %v, wantBody = %v", resp.Body.String(), tt.wantBody)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestBulkImportHandler(t *testing.T) {
+	saver := &SaverRetrieverStub{
+		SaveTokenFunc: func(req *api.SaveTokenRequest) (token.SaveResult, error) {
+			switch req.UserID {
+			case "failing":
+				return "", errors.New("server error")
+			case "existing":
+				return token.SaveUpdated, nil
+			}
+			return token.SaveCreated, nil
+		},
+	}
+	im := &token.Importer{Env: env.AwsVars{SmsRootDomain: "root"}, Svr: saver, Concurrency: 2}
+
+	tests := []struct {
+		name        string
+		requestBody string
+		wantStatus  int
+		want        token.ImportReport
+	}{
+		{
+			name: "BulkImportMixedItems",
+			requestBody: `[
+				{"user_id": "new", "access_token": "a", "refresh_token": "r", "expiry": "2025-01-01T00:00:00Z"},
+				{"user_id": "existing", "access_token": "a"},
+				{"access_token": "a"},
+				{"user_id": "no-access-token"},
+				{"user_id": "failing", "access_token": "a"}
+			]`,
+			wantStatus: http.StatusOK,
+			want: token.ImportReport{
+				Results: []token.ImportResult{
+					{UserID: "new", Outcome: "created"},
+					{UserID: "existing", Outcome: "updated"},
+					{Outcome: "failed", Error: "user_id and token.access_token are required"},
+					{UserID: "no-access-token", Outcome: "failed", Error: "user_id and token.access_token are required"},
+					{UserID: "failing", Outcome: "failed", Error: "server error"},
+				},
+				Created: 1,
+				Updated: 1,
+				Failed:  3,
+			},
+		},
+		{
+			name:        "BulkImportNotAnArray",
+			requestBody: `{"user_id": "new", "access_token": "a"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "BulkImportEmptyArray",
+			requestBody: `[]`,
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := BulkImportHandler(im)
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Request = httptest.NewRequest("POST", "/token/bulk-import", bytes.NewBufferString(tt.requestBody))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler(c)
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("BulkImport() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got token.ImportReport
+			if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Failed to decode response body: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("BulkImport() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func getValueFromResponse(t *testing.T, body *bytes.Buffer, key string) any {
+	var responseBody gin.H
+	if err := json.Unmarshal(body.Bytes(), &responseBody); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	return responseBody[key]
+}
+
+func TestRollbackHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		rollbackerStub func(*api.RollbackTokenRequest) (*oauth2.Token, error)
+		requestBody    string
+		wantStatus     int
+		wantBody       map[string]interface{}
+	}{
+		{
+			name: "RollbackSuccess",
+			rollbackerStub: func(req *api.RollbackTokenRequest) (*oauth2.Token, error) {
+				if req.UserID != "1" || req.Provider != "google" {
+					return nil, errors.New("request not bound")
+				}
+				return &oauth2.Token{AccessToken: "previous_token", TokenType: "Bearer"}, nil
+			},
+			requestBody: `{"user_id": "1", "provider": "google"}`,
+			wantStatus:  http.StatusOK,
+			wantBody:    gin.H{"access_token": "previous_token", "token_type": "Bearer"},
+		},
+		{
+			name:        "RollbackMissingUserID",
+			requestBody: `{"provider": "google"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantBody:    gin.H{"Error": "Could not roll back token"},
+		},
+		{
+			name: "RollbackNoPreviousVersion",
+			rollbackerStub: func(req *api.RollbackTokenRequest) (*oauth2.Token, error) {
+				return nil, secret.ErrNoPreviousVersion
+			},
+			requestBody: `{"user_id": "1"}`,
+			wantStatus:  http.StatusNotFound,
+			wantBody:    gin.H{"Error": "Could not roll back token"},
+		},
+		{
+			name: "RollbackServerError",
+			rollbackerStub: func(req *api.RollbackTokenRequest) (*oauth2.Token, error) {
+				return nil, errors.New("server error")
+			},
+			requestBody: `{"user_id": "1"}`,
+			wantStatus:  http.StatusInternalServerError,
+			wantBody:    gin.H{"Error": "Could not roll back token"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RollbackHandler(&RollbackerStub{RollbackTokenFunc: tt.rollbackerStub}, env.ServerVars{})
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Request = httptest.NewRequest("POST", "/token/rollback", bytes.NewBufferString(tt.requestBody))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler(c)
+			if resp.Code != tt.wantStatus {
+				t.Errorf("Rollback() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			for key, value := range tt.wantBody {
+				if getValueFromResponse(t, resp.Body, key) != value {
+					t.Errorf("Rollback() body = %v, wantBody = %v", resp.Body.String(), tt.wantBody)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestDeviceStartHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		deviceStub  func(*api.DeviceAuthRequest) (*oauth2.DeviceAuthResponse, error)
+		userID      string
+		requestBody string
+		wantStatus  int
+		wantBody    map[string]interface{}
+	}{
+		{
+			name: "DeviceStartSuccess",
+			deviceStub: func(req *api.DeviceAuthRequest) (*oauth2.DeviceAuthResponse, error) {
+				if req.UserID != "1" || req.Provider != "google" {
+					return nil, errors.New("request not bound")
+				}
+				return &oauth2.DeviceAuthResponse{
+					DeviceCode:      "device-code",
+					UserCode:        "ABCD-EFGH",
+					VerificationURI: "https://provider.example/device"}, nil
+			},
+			userID:      "1",
+			requestBody: `{"provider": "google"}`,
+			wantStatus:  http.StatusOK,
+			wantBody:    gin.H{"user_code": "ABCD-EFGH", "verification_uri": "https://provider.example/device", "device_code": nil},
+		},
+		{
+			name: "DeviceStartNoBody",
+			deviceStub: func(req *api.DeviceAuthRequest) (*oauth2.DeviceAuthResponse, error) {
+				return &oauth2.DeviceAuthResponse{UserCode: "ABCD-EFGH"}, nil
+			},
+			userID:     "1",
+			wantStatus: http.StatusOK,
+			wantBody:   gin.H{"user_code": "ABCD-EFGH"},
+		},
+		{
+			name:        "DeviceStartInvalidBody",
+			userID:      "1",
+			requestBody: `{"provider": 1}`,
+			wantStatus:  http.StatusBadRequest,
+			wantBody:    gin.H{"Error": "Could not start device authorization"},
+		},
+		{
+			name: "DeviceStartProviderError",
+			deviceStub: func(req *api.DeviceAuthRequest) (*oauth2.DeviceAuthResponse, error) {
+				return nil, errors.New("provider error")
+			},
+			userID:     "1",
+			wantStatus: http.StatusBadGateway,
+			wantBody:   gin.H{"Error": "Could not start device authorization"},
+		},
+		{
+			name:       "DeviceStartNoUser",
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   gin.H{"Error": "Could not start device authorization"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := DeviceStartHandler(&DeviceAuthorizerStub{StartDeviceAuthFunc: tt.deviceStub})
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			if tt.userID != "" {
+				c.Set("user_id", tt.userID)
+			}
+			c.Request = httptest.NewRequest("POST", "/oauth/device/start", bytes.NewBufferString(tt.requestBody))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler(c)
+			if resp.Code != tt.wantStatus {
+				t.Errorf("DeviceStart() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			for key, value := range tt.wantBody {
+				if getValueFromResponse(t, resp.Body, key) != value {
+					t.Errorf("DeviceStart() body = %v, wantBody = %v", resp.Body.String(), tt.wantBody)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestDescribeTokenHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		describeStub func(*api.RetrieveTokenRequest) (*api.TokenDescription, error)
+		userID       string
+		wantStatus   int
+		wantBody     map[string]interface{}
+	}{
+		{
+			name: "DescribeTokenSuccess",
+			describeStub: func(req *api.RetrieveTokenRequest) (*api.TokenDescription, error) {
+				return &api.TokenDescription{
+					UserID:          req.UserID,
+					Provider:        req.Provider,
+					TokenType:       "Bearer",
+					HasRefreshToken: true,
+					Scopes:          []string{"read", "write"}}, nil
+			},
+			userID:     "1",
+			wantStatus: http.StatusOK,
+			wantBody:   gin.H{"user_id": "1", "provider": "google", "has_refresh_token": true, "access_token": nil},
+		},
+		{
+			name: "DescribeTokenNotFound",
+			describeStub: func(req *api.RetrieveTokenRequest) (*api.TokenDescription, error) {
+				return nil, &types.ResourceNotFoundException{}
+			},
+			userID:     "1",
+			wantStatus: http.StatusNotFound,
+			wantBody:   gin.H{"Error": "Could not describe token"},
+		},
+		{
+			name:       "DescribeTokenNoUser",
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   gin.H{"Error": "Could not describe token"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := DescribeTokenHandler(&DescriberStub{DescribeTokenFunc: tt.describeStub})
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			if tt.userID != "" {
+				c.Set("user_id", tt.userID)
+			}
+			c.Request = httptest.NewRequest("GET", "/token/describe?provider=google", nil)
+
+			handler(c)
+			if resp.Code != tt.wantStatus {
+				t.Errorf("DescribeToken() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			for key, value := range tt.wantBody {
+				if getValueFromResponse(t, resp.Body, key) != value {
+					t.Errorf("DescribeToken() body = %v, wantBody = %v", resp.Body.String(), tt.wantBody)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestExportImportTokenHandlers(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := base64.URLEncoding.EncodeToString(der)
+
+	newSealer := func(dec token.KeyDecrypter) *token.ApiSealer {
+		store := secret.NewMemoryStore()
+		return &token.ApiSealer{
+			Ret: &token.ApiRetriever{Res: store, Get: store},
+			Svr: &token.ApiSaver{Res: store, Put: store, Ctr: store},
+			Dec: dec}
+	}
+	from, to := newSealer(nil), newSealer(&key.RSADecrypter{Key: priv})
+	if _, err = from.Svr.SaveToken(context.Background(), &api.SaveTokenRequest{
+		UserID: "1", Provider: "google", TokenType: "Bearer", AccessToken: "access_token"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{
+			name:       "ExportTokenMissingKey",
+			query:      "provider=google",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "ExportTokenInvalidKey",
+			query:      "provider=google&public_key=bm90IGEga2V5",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "ExportTokenNotFound",
+			query:      "provider=github&public_key=" + publicKey,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "ExportTokenSuccess",
+			query:      "provider=google&public_key=" + publicKey,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			c.Set("user_id", "1")
+			c.Request = httptest.NewRequest("GET", "/token/export?"+tt.query, nil)
+
+			ExportTokenHandler(from)(c)
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("ExportToken() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			if resp.Code != http.StatusOK {
+				return
+			}
+			if bytes.Contains(resp.Body.Bytes(), []byte("access_token")) {
+				t.Errorf("ExportToken() body = %v, contains the access token", resp.Body.String())
+			}
+
+			body := fmt.Sprintf(`{"provider":"google","token":%s}`, resp.Body.String())
+			resp = httptest.NewRecorder()
+			c, _ = gin.CreateTestContext(resp)
+			c.Set("user_id", "2")
+			c.Request = httptest.NewRequest("POST", "/token/import", bytes.NewBufferString(body))
+
+			ImportTokenHandler(to)(c)
+			if resp.Code != http.StatusOK {
+				t.Fatalf("ImportToken() status = %v, body = %v", resp.Code, resp.Body.String())
+			}
+			if got := getValueFromResponse(t, resp.Body, "result"); got != string(token.SaveCreated) {
+				t.Errorf("ImportToken() result = %v, want %v", got, token.SaveCreated)
+			}
+
+			tk, err := to.Ret.RetrieveToken(context.Background(), &api.RetrieveTokenRequest{UserID: "2", Provider: "google"})
+			if err != nil || tk.AccessToken != "access_token" {
+				t.Errorf("ImportToken() stored %v, %v", tk, err)
+			}
+		})
+	}
+}
+
+func TestImportTokenHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		userID     string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "ImportTokenNoUser",
+			body:       `{"token":{"alg":"RSA-OAEP-256+A256GCM","encrypted_key":"a2V5","nonce":"bm9uY2U=","ciphertext":"Y2lwaGVy"}}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "ImportTokenMissingToken",
+			userID:     "1",
+			body:       `{"provider":"google"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "ImportTokenUndecryptable",
+			userID:     "1",
+			body:       `{"token":{"alg":"RSA-OAEP-256+A256GCM","encrypted_key":"a2V5","nonce":"bm9uY2U=","ciphertext":"Y2lwaGVy"}}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			priv, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store := secret.NewMemoryStore()
+			handler := ImportTokenHandler(&token.ApiSealer{
+				Svr: &token.ApiSaver{Res: store, Put: store, Ctr: store},
+				Dec: &key.RSADecrypter{Key: priv}})
+
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			if tt.userID != "" {
+				c.Set("user_id", tt.userID)
+			}
+			c.Request = httptest.NewRequest("POST", "/token/import", bytes.NewBufferString(tt.body))
+
+			handler(c)
+			if resp.Code != tt.wantStatus {
+				t.Errorf("ImportToken() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+		})
+	}
 }
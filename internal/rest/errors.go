@@ -0,0 +1,84 @@
+package rest
+
+import (
+	"app/internal/key"
+	"app/internal/secret"
+	"app/internal/token"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"maps"
+	"net/http"
+)
+
+// StatusForError maps an error returned by the token and secret layers to the HTTP status
+// code the handlers should respond with. The error is unwrapped, so AWS exceptions wrapped
+// with fmt.Errorf and %w are still recognised. A secret without a previous version to roll
+// back to is a http.StatusNotFound, a user with too many providers a http.StatusConflict
+// and a provider that is not allowed, a malformed access token or a sealed token that cannot
+// be opened a http.StatusBadRequest,
+// a token too large to store a http.StatusRequestEntityTooLarge. A secret scheduled for
+// deletion, which can still be restored, is a http.StatusGone. Other AWS errors are mapped
+// by their secret.ErrorKind.
+// A request that ran out of the time given by RequestTimeout is a
+// http.StatusGatewayTimeout, one rejected by an open circuit breaker or left waiting for a
+// free slot of a secret.LimitedClient a http.StatusServiceUnavailable, anything unknown is a
+// http.StatusInternalServerError.
+func StatusForError(err error) int {
+	var deleted *secret.ErrSecretDeleted
+
+	switch {
+	case errors.Is(err, secret.ErrNoPreviousVersion):
+		return http.StatusNotFound
+	case errors.As(err, &deleted):
+		return http.StatusGone
+	case errors.Is(err, token.ErrTooManyProviders):
+		return http.StatusConflict
+	case errors.Is(err, secret.ErrProviderNotAllowed), errors.Is(err, secret.ErrInvalidCreateRequest),
+		errors.Is(err, token.ErrInvalidAccessToken), errors.Is(err, token.ErrInvalidSealKey),
+		errors.Is(err, token.ErrInvalidSealedToken), errors.Is(err, key.ErrUndecryptable):
+		return http.StatusBadRequest
+	case errors.Is(err, secret.ErrSecretTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, secret.ErrCircuitOpen), errors.Is(err, secret.ErrTooManyCalls):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+
+	switch secret.Classify(err) {
+	case secret.KindNotFound:
+		return http.StatusNotFound
+	case secret.KindExists:
+		return http.StatusConflict
+	case secret.KindLimitExceeded, secret.KindThrottled:
+		return http.StatusTooManyRequests
+	case secret.KindInvalidRequest:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// respondError responds with the status StatusForError chooses for err and the given body.
+// When err carries an AWS request ID, it is logged and, for server errors, added to the
+// body as aws_request_id so users can report it to support.
+func respondError(c *gin.Context, err error, body gin.H) {
+	status := StatusForError(err)
+
+	requestID := secret.RequestID(err)
+	if requestID == "" {
+		slog.Error(fmt.Sprintf("Request failed with status %d: %v", status, err))
+		c.JSON(status, body)
+		return
+	}
+
+	slog.Error(fmt.Sprintf("Request failed with status %d, AWS request ID %v: %v", status, requestID, err))
+	if status >= http.StatusInternalServerError {
+		body = maps.Clone(body)
+		body["aws_request_id"] = requestID
+	}
+	c.JSON(status, body)
+}
@@ -0,0 +1,232 @@
+package rest
+
+import (
+	"app/internal/key"
+	"app/internal/secret"
+	"app/internal/token"
+	"context"
+	"errors"
+	"fmt"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusForError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{
+			name: "ResourceNotFound",
+			err:  &types.ResourceNotFoundException{},
+			want: http.StatusNotFound,
+		},
+		{
+			name: "SecretDeleted",
+			err:  fmt.Errorf("resolve failed: %w", &secret.ErrSecretDeleted{SecretID: "id"}),
+			want: http.StatusGone,
+		},
+		{
+			name: "CircuitOpen",
+			err:  fmt.Errorf("get failed: %w", secret.ErrCircuitOpen),
+			want: http.StatusServiceUnavailable,
+		},
+		{
+			name: "TooManyCalls",
+			err:  fmt.Errorf("get failed: %w", fmt.Errorf("%w: %w", secret.ErrTooManyCalls, context.DeadlineExceeded)),
+			want: http.StatusServiceUnavailable,
+		},
+		{
+			name: "ResourceExists",
+			err:  &types.ResourceExistsException{},
+			want: http.StatusConflict,
+		},
+		{
+			name: "ProviderNotAllowed",
+			err:  fmt.Errorf("provider %q: %w", "gogle", secret.ErrProviderNotAllowed),
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "SecretTooLarge",
+			err:  fmt.Errorf("%w: secret id would hold 65537 bytes", secret.ErrSecretTooLarge),
+			want: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "InvalidCreateRequest",
+			err:  fmt.Errorf("%w: invalid tag key %q", secret.ErrInvalidCreateRequest, "aws:owner"),
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "InvalidAccessToken",
+			err:  token.ErrInvalidAccessToken,
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "InvalidSealKey",
+			err:  fmt.Errorf("%w: RSA public key of 1024 bits is too small", token.ErrInvalidSealKey),
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "UndecryptableSealedToken",
+			err:  fmt.Errorf("%w: decryption error", key.ErrUndecryptable),
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "TooManyProviders",
+			err:  fmt.Errorf("save failed: %w", token.ErrTooManyProviders),
+			want: http.StatusConflict,
+		},
+		{
+			name: "LimitExceeded",
+			err:  &types.LimitExceededException{},
+			want: http.StatusTooManyRequests,
+		},
+		{
+			name: "InvalidRequest",
+			err:  &types.InvalidRequestException{},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "InvalidParameter",
+			err:  &types.InvalidParameterException{},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "DeadlineExceeded",
+			err:  fmt.Errorf("get secret: %w", context.DeadlineExceeded),
+			want: http.StatusGatewayTimeout,
+		},
+		{
+			name: "ThrottlingException",
+			err:  &smithy.GenericAPIError{Code: "ThrottlingException"},
+			want: http.StatusTooManyRequests,
+		},
+		{
+			name: "TooManyRequestsException",
+			err:  &smithy.GenericAPIError{Code: "TooManyRequestsException"},
+			want: http.StatusTooManyRequests,
+		},
+		{
+			name: "UnknownAPIError",
+			err:  &smithy.GenericAPIError{Code: "InternalServiceError"},
+			want: http.StatusInternalServerError,
+		},
+		{
+			name: "PlainError",
+			err:  errors.New("server error"),
+			want: http.StatusInternalServerError,
+		},
+		{
+			name: "WrappedResourceNotFound",
+			err:  fmt.Errorf("resolve: %w", &types.ResourceNotFoundException{}),
+			want: http.StatusNotFound,
+		},
+		{
+			name: "WrappedResourceExists",
+			err:  fmt.Errorf("create: %w", &types.ResourceExistsException{}),
+			want: http.StatusConflict,
+		},
+		{
+			name: "WrappedLimitExceeded",
+			err:  fmt.Errorf("create: %w", &types.LimitExceededException{}),
+			want: http.StatusTooManyRequests,
+		},
+		{
+			name: "WrappedInvalidRequest",
+			err:  fmt.Errorf("get: %w", &types.InvalidRequestException{}),
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "WrappedInvalidParameter",
+			err:  fmt.Errorf("get: %w", &types.InvalidParameterException{}),
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "WrappedThrottling",
+			err:  fmt.Errorf("put: %w", &smithy.GenericAPIError{Code: "ThrottlingException"}),
+			want: http.StatusTooManyRequests,
+		},
+		{
+			name: "WrappedPlainError",
+			err:  fmt.Errorf("put: %w", errors.New("server error")),
+			want: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StatusForError(tt.err); got != tt.want {
+				t.Errorf("StatusForError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRespondError(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantStatus    int
+		wantRequestID any
+	}{
+		{
+			name:          "ServerErrorWithRequestID",
+			err:           awsError("req-1", http.StatusInternalServerError, &smithy.GenericAPIError{Code: "InternalServiceError"}),
+			wantStatus:    http.StatusInternalServerError,
+			wantRequestID: "req-1",
+		},
+		{
+			name:          "ClientErrorWithRequestID",
+			err:           awsError("req-2", http.StatusBadRequest, &types.ResourceNotFoundException{}),
+			wantStatus:    http.StatusNotFound,
+			wantRequestID: nil,
+		},
+		{
+			name:          "ServerErrorWithoutRequestID",
+			err:           errors.New("server error"),
+			wantStatus:    http.StatusInternalServerError,
+			wantRequestID: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := gin.H{"Error": "Could not retrieve token"}
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+
+			respondError(c, tt.err, body)
+			if resp.Code != tt.wantStatus {
+				t.Errorf("respondError() status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+			}
+			if got := getValueFromResponse(t, resp.Body, "aws_request_id"); got != tt.wantRequestID {
+				t.Errorf("respondError() aws_request_id = %v, want = %v", got, tt.wantRequestID)
+			}
+			if _, ok := body["aws_request_id"]; ok {
+				t.Errorf("respondError() modified the shared error body")
+			}
+		})
+	}
+}
+
+// awsError wraps err the way the AWS SDK returns a failed operation, carrying requestID.
+func awsError(requestID string, status int, err error) error {
+	return &smithy.OperationError{
+		ServiceID:     "Secrets Manager",
+		OperationName: "GetSecretValue",
+		Err: &awshttp.ResponseError{
+			RequestID: requestID,
+			ResponseError: &smithyhttp.ResponseError{
+				Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+				Err:      err,
+			},
+		},
+	}
+}
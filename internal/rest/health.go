@@ -0,0 +1,140 @@
+package rest
+
+import (
+	"app/internal/key"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultCheckTimeout bounds each Check of the /readyz endpoint.
+const DefaultCheckTimeout = 2 * time.Second
+
+// KeyCheckName is the name of the Check returned by KeyCheck.
+const KeyCheckName = "kms"
+
+// DefaultKeyCheckTTL is how long the Check returned by KeyCheck reuses the outcome of
+// fetching the public key, so frequent probes do not call KMS every time.
+const DefaultKeyCheckTTL = 30 * time.Second
+
+// Check is a named readiness probe of a dependency of the server, such as Secrets Manager.
+// Probe returns an error when the dependency cannot be reached.
+type Check struct {
+	Name  string
+	Probe func(ctx context.Context) error
+}
+
+// LivezHandler is the handler for the liveness probe /livez. It always responds with
+// http.StatusOK, since answering at all proves the process is alive. Dependencies are
+// deliberately not checked, so a dependency outage does not get the process restarted.
+func LivezHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// ReadyzHandler is the handler for the readiness probe /readyz. It runs every Check, each
+// bounded by DefaultCheckTimeout, and responds with http.StatusOK when all pass. Otherwise
+// it responds with http.StatusServiceUnavailable and the names of the failed checks, so
+// the instance is taken out of the load balancer until its dependencies recover. The
+// probe needs no token, so the errors themselves are only logged.
+func ReadyzHandler(checks []Check) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		failed := gin.H{}
+		for _, check := range checks {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), DefaultCheckTimeout)
+			err := check.Probe(ctx)
+			cancel()
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Readiness check %v failed: %v", check.Name, err))
+				failed[check.Name] = "failed"
+			}
+		}
+
+		if len(failed) > 0 {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": failed})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// keyCheck remembers the outcome of the last time the public key was fetched, and the
+// fetch in flight, which is closed once it has stored its outcome.
+type keyCheck struct {
+	get       key.Getter
+	ttl       time.Duration
+	now       func() time.Time
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+	fetching  chan struct{}
+}
+
+// KeyCheck returns a Check that fetches the public key JWTs are verified with through get,
+// and fails unless JWTs can be verified with it. The outcome is reused for ttl, or
+// DefaultKeyCheckTTL when zero. At most one fetch is in flight, concurrent probes share
+// its outcome. A key.Getter takes no context, so a fetch that outlasts the probe is left
+// to finish in the background, and later probes wait for it rather than fetching again.
+func KeyCheck(get key.Getter, ttl time.Duration) Check {
+	if ttl <= 0 {
+		ttl = DefaultKeyCheckTTL
+	}
+	kc := &keyCheck{get: get, ttl: ttl, now: time.Now}
+
+	return Check{Name: KeyCheckName, Probe: kc.probe}
+}
+
+func (kc *keyCheck) probe(ctx context.Context) error {
+	kc.mu.Lock()
+	if !kc.checkedAt.IsZero() && kc.now().Sub(kc.checkedAt) < kc.ttl {
+		err := kc.err
+		kc.mu.Unlock()
+		return err
+	}
+	if kc.fetching == nil {
+		kc.fetching = make(chan struct{})
+		go kc.fetch(kc.fetching)
+	}
+	fetching := kc.fetching
+	kc.mu.Unlock()
+
+	select {
+	case <-fetching:
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+		return kc.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fetch checks the public key, stores the outcome and closes done.
+func (kc *keyCheck) fetch(done chan struct{}) {
+	err := kc.verifiable()
+
+	kc.mu.Lock()
+	kc.checkedAt, kc.err, kc.fetching = kc.now(), err, nil
+	kc.mu.Unlock()
+	close(done)
+}
+
+// verifiable fetches the public key and checks that JWTs can be verified with it.
+func (kc *keyCheck) verifiable() error {
+	der, err := kc.get.GetPublicKey()
+	if err != nil {
+		return err
+	}
+	pubKey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return fmt.Errorf("unable to parse public key: %w", err)
+	}
+	_, err = signingMethodForKey(pubKey)
+
+	return err
+}
@@ -0,0 +1,248 @@
+package rest
+
+import (
+	"app/internal/key"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	passing := Check{Name: "kms", Probe: func(ctx context.Context) error { return nil }}
+	failing := Check{Name: "secretsmanager", Probe: func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}}
+
+	tests := []struct {
+		name       string
+		path       string
+		checks     []Check
+		wantStatus int
+		wantFailed map[string]any
+	}{
+		{
+			name:       "LivezWithFailingDependency",
+			path:       "/livez",
+			checks:     []Check{passing, failing},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "ReadyzAllChecksPass",
+			path:       "/readyz",
+			checks:     []Check{passing},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "ReadyzNoChecks",
+			path:       "/readyz",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "ReadyzCheckFails",
+			path:       "/readyz",
+			checks:     []Check{passing, failing},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: map[string]any{"secretsmanager": "failed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Probes need no token, so the parser must never be asked.
+			parser := &ParserStub{ParserFunc: func(tokenString string) (*jwt.Token, error) {
+				t.Errorf("%v was authenticated", tt.path)
+				return nil, errors.New("unexpected authentication")
+			}}
+			router := NewTestRouter(GinRouter{Parser: parser, Checks: tt.checks})
+
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, httptest.NewRequest("GET", tt.path, nil))
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("GET %v status = %v, wantStatus = %v", tt.path, resp.Code, tt.wantStatus)
+			}
+
+			if strings.Contains(resp.Body.String(), "connection refused") {
+				t.Errorf("GET %v body = %v, want no error details", tt.path, resp.Body.String())
+			}
+
+			var body struct {
+				Checks map[string]any `json:"checks"`
+			}
+			if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode response body: %v", err)
+			}
+			if len(body.Checks) != len(tt.wantFailed) {
+				t.Fatalf("GET %v checks = %v, want %v", tt.path, body.Checks, tt.wantFailed)
+			}
+			for name, msg := range tt.wantFailed {
+				if body.Checks[name] != msg {
+					t.Errorf("GET %v checks = %v, want %v", tt.path, body.Checks, tt.wantFailed)
+				}
+			}
+		})
+	}
+}
+
+// countingGetter is a key.Getter that counts its calls and returns the key of its
+// key.StaticGetter.
+type countingGetter struct {
+	key.StaticGetter
+	calls atomic.Int32
+}
+
+func (g *countingGetter) GetPublicKey() ([]byte, error) {
+	g.calls.Add(1)
+	return g.StaticGetter.GetPublicKey()
+}
+
+func TestReadyzKMS(t *testing.T) {
+	_, valid, err := key.GenerateTestKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallDER, err := x509.MarshalPKIXPublicKey(&small.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		getter     *key.StaticGetter
+		wantStatus int
+		wantFailed bool
+	}{
+		{
+			name:       "ReadyzKMSReachable",
+			getter:     valid,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "ReadyzKMSUnreachable",
+			getter:     &key.StaticGetter{Err: errors.New("kms unreachable")},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: true,
+		},
+		{
+			name:       "ReadyzKMSUnverifiableKey",
+			getter:     &key.StaticGetter{PublicKey: smallDER},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getter := &countingGetter{StaticGetter: *tt.getter}
+			router := NewTestRouter(GinRouter{Parser: &ParserStub{}, KeyChecks: []Check{KeyCheck(getter, time.Minute)}})
+
+			for range 2 {
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, httptest.NewRequest("GET", "/readyz/kms", nil))
+				if resp.Code != tt.wantStatus {
+					t.Fatalf("GET /readyz/kms status = %v, wantStatus = %v", resp.Code, tt.wantStatus)
+				}
+				if strings.Contains(resp.Body.String(), "kms unreachable") {
+					t.Errorf("GET /readyz/kms body = %v, want no error details", resp.Body.String())
+				}
+
+				var body struct {
+					Checks map[string]any `json:"checks"`
+				}
+				if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+					t.Fatalf("Failed to decode response body: %v", err)
+				}
+				if _, failed := body.Checks[KeyCheckName]; failed != tt.wantFailed {
+					t.Errorf("GET /readyz/kms checks = %v, want failed %v", body.Checks, tt.wantFailed)
+				}
+			}
+			if calls := getter.calls.Load(); calls != 1 {
+				t.Errorf("GetPublicKey() called %d times, want once within the TTL", calls)
+			}
+		})
+	}
+}
+
+func TestKeyCheck_Expires(t *testing.T) {
+	_, valid, err := key.GenerateTestKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	getter := &countingGetter{StaticGetter: *valid}
+	now := time.Now()
+	kc := &keyCheck{get: getter, ttl: time.Minute, now: func() time.Time { return now }}
+
+	for _, advance := range []time.Duration{0, 30 * time.Second, time.Minute} {
+		now = now.Add(advance)
+		if err := kc.probe(context.Background()); err != nil {
+			t.Fatalf("probe() error = %v", err)
+		}
+	}
+	if calls := getter.calls.Load(); calls != 2 {
+		t.Errorf("GetPublicKey() called %d times, want 2", calls)
+	}
+}
+
+func TestReadyzKMS_NotConfigured(t *testing.T) {
+	resp := httptest.NewRecorder()
+	NewTestRouter(GinRouter{Parser: &ParserStub{}}).ServeHTTP(resp, httptest.NewRequest("GET", "/readyz/kms", nil))
+	if resp.Code == http.StatusOK {
+		t.Errorf("GET /readyz/kms status = %v without KeyChecks, want it unregistered", resp.Code)
+	}
+}
+
+// blockingGetter is a key.Getter whose calls block until release is closed.
+type blockingGetter struct {
+	countingGetter
+	release chan struct{}
+}
+
+func (g *blockingGetter) GetPublicKey() ([]byte, error) {
+	<-g.release
+	return g.countingGetter.GetPublicKey()
+}
+
+func TestKeyCheck_SingleFetch(t *testing.T) {
+	_, valid, err := key.GenerateTestKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	getter := &blockingGetter{countingGetter: countingGetter{StaticGetter: *valid}, release: make(chan struct{})}
+	kc := &keyCheck{get: getter, ttl: time.Minute, now: time.Now}
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if err := kc.probe(ctx); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("probe() of a hung fetch error = %v, want %v", err, context.DeadlineExceeded)
+			}
+		}()
+	}
+	wg.Wait()
+
+	close(getter.release)
+	if err := kc.probe(context.Background()); err != nil {
+		t.Fatalf("probe() error = %v", err)
+	}
+	if calls := getter.calls.Load(); calls != 1 {
+		t.Errorf("GetPublicKey() called %d times, want a single fetch", calls)
+	}
+}
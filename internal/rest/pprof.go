@@ -0,0 +1,29 @@
+package rest
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http/pprof"
+)
+
+// PprofPrefix is the path the net/http/pprof endpoints are registered under.
+const PprofPrefix = "/debug/pprof"
+
+// pprofProfiles are the runtime/pprof profiles served by name under PprofPrefix.
+var pprofProfiles = []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"}
+
+// registerPprof registers the net/http/pprof endpoints under PprofPrefix on r. They reveal
+// the internals of the running service, so they require the AdminScope like the other
+// administrative endpoints. A CPU profile or trace longer than the WriteTimeout of the
+// server is refused by pprof, so it cannot be cut off halfway.
+func registerPprof(r *gin.Engine) {
+	g := r.Group(PprofPrefix, RequireScope(AdminScope))
+	g.GET("/", gin.WrapF(pprof.Index))
+	g.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	g.GET("/profile", gin.WrapF(pprof.Profile))
+	g.GET("/symbol", gin.WrapF(pprof.Symbol))
+	g.POST("/symbol", gin.WrapF(pprof.Symbol))
+	g.GET("/trace", gin.WrapF(pprof.Trace))
+	for _, name := range pprofProfiles {
+		g.GET("/"+name, gin.WrapH(pprof.Handler(name)))
+	}
+}
@@ -0,0 +1,86 @@
+package rest
+
+import (
+	"app/env"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGinRouter_Pprof(t *testing.T) {
+	parser := &ParserStub{ParserFunc: func(tokenString string) (*jwt.Token, error) {
+		scope := "read"
+		if tokenString == "admin" {
+			scope = "admin"
+		}
+		return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"sub": "1", "scope": scope}}, nil
+	}}
+
+	tests := []struct {
+		name       string
+		pprof      bool
+		token      string
+		path       string
+		wantStatus int
+	}{
+		{
+			name:       "PprofDisabledIndex",
+			token:      "admin",
+			path:       "/debug/pprof/",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "PprofDisabledHeap",
+			token:      "admin",
+			path:       "/debug/pprof/heap",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "PprofEnabledIndex",
+			pprof:      true,
+			token:      "admin",
+			path:       "/debug/pprof/",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "PprofEnabledHeap",
+			pprof:      true,
+			token:      "admin",
+			path:       "/debug/pprof/heap?debug=1",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "PprofEnabledCmdline",
+			pprof:      true,
+			token:      "admin",
+			path:       "/debug/pprof/cmdline",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "PprofEnabledWithoutAdminScope",
+			pprof:      true,
+			token:      "user",
+			path:       "/debug/pprof/heap",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := GinRouter{
+				Parser: parser,
+				Auth:   env.AuthVars{SubjectClaim: env.DefaultSubjectClaim},
+				Config: env.ServerVars{Pprof: tt.pprof}}
+
+			resp := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			g.Engine().ServeHTTP(resp, req)
+			if resp.Code != tt.wantStatus {
+				t.Errorf("GET %v status = %v, wantStatus = %v", tt.path, resp.Code, tt.wantStatus)
+			}
+		})
+	}
+}
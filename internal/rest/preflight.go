@@ -0,0 +1,62 @@
+package rest
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Preflight is a middleware that answers OPTIONS requests for a path served by routes with
+// http.StatusNoContent and an Allow header listing the methods of the matching routes, so
+// preflight requests get an answer without a token. Other requests, and OPTIONS requests
+// for paths no route serves, are passed on. Routes are read on every OPTIONS request, so
+// routes registered after the middleware are included.
+func Preflight(routes func() gin.RoutesInfo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodOptions {
+			c.Next()
+			return
+		}
+
+		var methods []string
+		for _, route := range routes() {
+			if route.Method != http.MethodOptions && matchRoute(route.Path, c.Request.URL.Path) &&
+				!slices.Contains(methods, route.Method) {
+				methods = append(methods, route.Method)
+			}
+		}
+		if len(methods) == 0 {
+			c.Next()
+			return
+		}
+
+		slices.Sort(methods)
+		c.Header("Allow", strings.Join(methods, ", "))
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// matchRoute reports whether path is served by a route with the given gin pattern, where a
+// :param segment matches any single segment and a *param segment matches the rest.
+func matchRoute(pattern, path string) bool {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, "*") {
+			return true
+		}
+		if i >= len(pathSegments) {
+			return false
+		}
+		if !strings.HasPrefix(segment, ":") && segment != pathSegments[i] {
+			return false
+		}
+		if pathSegments[i] == "" {
+			return false
+		}
+	}
+
+	return len(patternSegments) == len(pathSegments)
+}
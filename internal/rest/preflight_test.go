@@ -0,0 +1,87 @@
+package rest
+
+import (
+	"app/env"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPreflight(t *testing.T) {
+	tests := []struct {
+		name       string
+		preflight  bool
+		path       string
+		wantStatus int
+		wantAllow  string
+	}{
+		{
+			name:       "PreflightTokenSave",
+			preflight:  true,
+			path:       "/token/save",
+			wantStatus: http.StatusNoContent,
+			wantAllow:  "PUT",
+		},
+		{
+			name:       "PreflightTokenGet",
+			preflight:  true,
+			path:       "/token/get",
+			wantStatus: http.StatusNoContent,
+			wantAllow:  "GET",
+		},
+		{
+			name:       "PreflightDomainRoute",
+			preflight:  true,
+			path:       "/secret/calendar/save",
+			wantStatus: http.StatusNoContent,
+			wantAllow:  "PUT",
+		},
+		{
+			name:       "PreflightUnknownPath",
+			preflight:  true,
+			path:       "/token/unknown",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "PreflightDisabled",
+			path:       "/token/save",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := GinRouter{Parser: &ParserStub{}, Config: env.ServerVars{Preflight: tt.preflight}}.Engine()
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest(http.MethodOptions, tt.path, nil))
+			if resp.Code != tt.wantStatus {
+				t.Errorf("OPTIONS %v status = %v, want %v", tt.path, resp.Code, tt.wantStatus)
+			}
+			if got := resp.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("OPTIONS %v Allow = %q, want %q", tt.path, got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "/token/save", path: "/token/save", want: true},
+		{pattern: "/token/save", path: "/token/get", want: false},
+		{pattern: "/secret/:domain/save", path: "/secret/calendar/save", want: true},
+		{pattern: "/secret/:domain/save", path: "/secret//save", want: false},
+		{pattern: "/secret/:domain/save", path: "/secret/calendar", want: false},
+		{pattern: "/files/*path", path: "/files/a/b", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := matchRoute(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchRoute(%v, %v) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
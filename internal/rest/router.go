@@ -0,0 +1,267 @@
+package rest
+
+import (
+	"app/env"
+	"app/internal/token"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/netutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+type (
+	// GinRouter holds the dependencies of the HTTP server: the token.Saver and token.Retriever
+	// behind the /token endpoints, the token.Registry behind the /secret/:domain endpoints, the
+	// Parser used to authenticate requests configured by Auth, and the server configuration.
+	// With Tenants roles, the secrets of each request are accessed with the IAM role of
+	// its tenant.
+	// The optional token.Cleaner, token.BulkImporter and token.Rollbacker enable the
+	// administrative /token/cleanup, /token/bulk-import and /token/rollback endpoints, the
+	// optional token.DeviceAuthorizer enables /oauth/device/start, the optional
+	// token.Describer /token/describe and the optional token.Patcher PATCH /token. The
+	// optional token.Exporter and token.SealedImporter enable /token/export and
+	// /token/import. Runtime is reported by /config, the Checks decide
+	// the readiness reported by /readyz, the KeyChecks, such as KeyCheck, whether JWTs can
+	// be verified, reported by /readyz/kms, and Stats, when set, counts the requests
+	// reported by /stats.
+	GinRouter struct {
+		Saver          token.Saver
+		Retriever      token.Retriever
+		Cleaner        token.Cleaner
+		Importer       token.BulkImporter
+		Rollbacker     token.Rollbacker
+		Device         token.DeviceAuthorizer
+		Describer      token.Describer
+		Patcher        token.Patcher
+		Exporter       token.Exporter
+		SealedImporter token.SealedImporter
+		Parser         Parser
+		Auth           env.AuthVars
+		Tenants        env.TenantVars
+		Registry       token.Registry
+		Config         env.ServerVars
+		Runtime        RuntimeConfig
+		Checks         []Check
+		KeyChecks      []Check
+		Stats          *Stats
+	}
+
+	// Middleware is a named gin.HandlerFunc, so the assembled middleware chain can be
+	// inspected by name.
+	Middleware struct {
+		Name    string
+		Handler gin.HandlerFunc
+	}
+)
+
+// Middlewares assembles the middleware chain in the order it is applied. Optional
+// middlewares are only included when enabled in the env.ServerVars, RequestTimeout when a
+// MaxRequestTimeout is set. ExtractQueryToken, included when env.AuthVars QueryToken is
+// set, comes first so no other middleware sees the token. Authenticate comes after them
+// so they also run for rejected requests, only AssumeTenantRole, included when there are
+// tenant roles, follows it since it needs the claims of the token.
+func (g GinRouter) Middlewares() []Middleware {
+	var chain []Middleware
+	if g.Auth.QueryToken {
+		chain = append(chain, Middleware{Name: "querytoken", Handler: ExtractQueryToken()})
+	}
+	if g.Config.Recovery {
+		chain = append(chain, Middleware{Name: "recovery", Handler: gin.Recovery()})
+	}
+	if g.Config.RequestLogging {
+		chain = append(chain, Middleware{Name: "logger", Handler: gin.Logger()})
+	}
+	if g.Config.MaxRequestTimeout > 0 {
+		chain = append(chain, Middleware{Name: "timeout", Handler: RequestTimeout(g.Config.MaxRequestTimeout)})
+	}
+	chain = append(chain, Middleware{Name: "authenticate", Handler: Authenticate(g.Parser, g.Auth)})
+	if len(g.Tenants.Roles) > 0 {
+		chain = append(chain, Middleware{Name: "tenant", Handler: AssumeTenantRole(g.Tenants)})
+	}
+
+	return chain
+}
+
+// Engine defines a Gin router with the /token and /secret/:domain endpoints behind the
+// middlewares returned by Middlewares. Optional endpoints are only registered with their
+// dependency or setting, administrative ones require the AdminScope, and the probes and
+// /auth/validate are registered before Authenticate, so they need no token.
+func (g GinRouter) Engine() *gin.Engine {
+	r := gin.New()
+	for _, m := range g.Middlewares() {
+		if m.Name == "authenticate" {
+			r.GET("/livez", LivezHandler())
+			r.GET("/readyz", ReadyzHandler(g.Checks))
+			if len(g.KeyChecks) > 0 {
+				r.GET("/readyz/kms", ReadyzHandler(g.KeyChecks))
+			}
+			r.POST("/auth/validate", ValidateTokenHandler(g.Parser, g.Auth))
+			if g.Config.Preflight {
+				r.Use(Preflight(r.Routes))
+			}
+		}
+		r.Use(m.Handler)
+	}
+
+	stats := g.Stats
+	if stats == nil {
+		stats = NewStats()
+	}
+
+	// Define routes
+	r.PUT("/token/save", stats.CountSave(), SaveTokenHandler(g.Saver, g.Config))
+	r.GET("/token/get", stats.CountRetrieve(), RetrieveTokenHandler(g.Retriever, g.Config))
+	r.PUT("/secret/:domain/save", stats.CountSave(), SaveDomainTokenHandler(g.Registry, g.Config))
+	r.GET("/secret/:domain/get", stats.CountRetrieve(), RetrieveDomainTokenHandler(g.Registry, g.Config))
+	r.GET("/domains", DomainsHandler(g.DomainsResponse()))
+	r.GET("/config", RequireScope(AdminScope), ConfigHandler(g.ConfigResponse()))
+	r.GET("/stats", RequireScope(AdminScope), StatsHandler(stats, g.Parser))
+	if g.Cleaner != nil {
+		r.POST("/token/cleanup", RequireScope(AdminScope), CleanupHandler(g.Cleaner))
+	}
+	if g.Importer != nil {
+		r.POST("/token/bulk-import", RequireScope(AdminScope), BulkImportHandler(g.Importer))
+	}
+	if g.Describer != nil {
+		r.GET("/token/describe", DescribeTokenHandler(g.Describer))
+	}
+	if g.Patcher != nil {
+		r.PATCH("/token", stats.CountSave(), PatchTokenHandler(g.Patcher, g.Config))
+	}
+	if g.Device != nil {
+		r.POST("/oauth/device/start", DeviceStartHandler(g.Device))
+	}
+	if g.Exporter != nil {
+		r.GET("/token/export", stats.CountRetrieve(), ExportTokenHandler(g.Exporter))
+	}
+	if g.SealedImporter != nil {
+		r.POST("/token/import", stats.CountSave(), ImportTokenHandler(g.SealedImporter))
+	}
+	if g.Rollbacker != nil {
+		r.POST("/token/rollback", RequireScope(AdminScope), RollbackHandler(g.Rollbacker, g.Config))
+	}
+	if g.Config.Pprof {
+		registerPprof(r)
+	}
+
+	return r
+}
+
+// ErrNilParser is returned by StartServer when the GinRouter has no Parser, since every
+// request would fail to authenticate.
+var ErrNilParser = errors.New("router has no Parser to authenticate requests")
+
+// StartServer serves the Engine on port 8080 until ctx is cancelled, at which point the
+// server shuts down gracefully. A GinRouter without a Parser is rejected before the port
+// is bound.
+func (g GinRouter) StartServer(ctx context.Context) (*gin.Engine, error) {
+	if g.Parser == nil {
+		return nil, ErrNilParser
+	}
+
+	ln, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		return nil, err
+	}
+
+	return g.Serve(ctx, ln)
+}
+
+// Serve serves the Engine on ln until ctx is cancelled, like StartServer. Gin is switched
+// to the GinMode of the env.ServerVars, if any, before the Engine is created. When the
+// env.ServerVars name a TLS certificate, connections are served over TLS, and when they
+// also name a client CA, only clients presenting a certificate signed by it are accepted.
+// With MaxConnections, connections beyond it wait to be accepted until another one closes,
+// so a burst of clients cannot exhaust the memory of the service.
+func (g GinRouter) Serve(ctx context.Context, ln net.Listener) (*gin.Engine, error) {
+	if g.Parser == nil {
+		ln.Close()
+		return nil, ErrNilParser
+	}
+
+	tlsConfig, err := g.TLSConfig()
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if g.Config.MaxConnections > 0 {
+		ln = netutil.LimitListener(ln, g.Config.MaxConnections)
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+
+	if g.Config.GinMode != "" {
+		gin.SetMode(g.Config.GinMode)
+	}
+	r := g.Engine()
+
+	srv := g.HTTPServer(r, tlsConfig)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error(fmt.Sprintf("Server did not shut down cleanly: %v", err))
+		}
+	}()
+
+	// Run the server
+	g.LogStartup(ln.Addr().String())
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error(fmt.Sprintf("Server has died! %v", err))
+		return r, err
+	}
+
+	return r, nil
+}
+
+// HTTPServer builds the http.Server serving h, with the timeouts of the env.ServerVars, so
+// slow clients cannot hold connections open indefinitely.
+func (g GinRouter) HTTPServer(h http.Handler, tlsConfig *tls.Config) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: g.Config.ReadHeaderTimeout,
+		ReadTimeout:       g.Config.ReadTimeout,
+		WriteTimeout:      g.Config.WriteTimeout,
+		IdleTimeout:       g.Config.IdleTimeout}
+}
+
+// TLSConfig builds the tls.Config from the certificate files of the env.ServerVars. It
+// returns nil when no certificate is configured, meaning the server runs plain HTTP.
+// With a client CA, client certificates are required and verified against it.
+func (g GinRouter) TLSConfig() (*tls.Config, error) {
+	if g.Config.TLSCertFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(g.Config.TLSCertFile, g.Config.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load server certificate: %w", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if g.Config.TLSClientCAFile != "" {
+		caPEM, err := os.ReadFile(g.Config.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("client CA file %v contains no certificates", g.Config.TLSClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,360 @@
+package rest
+
+import (
+	"app/env"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGinRouter_Middlewares(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  env.ServerVars
+		auth    env.AuthVars
+		tenants env.TenantVars
+		want    []string
+	}{
+		{
+			name:   "MiddlewaresDefault",
+			config: env.ServerVars{Recovery: true},
+			want:   []string{"recovery", "authenticate"},
+		},
+		{
+			name:   "MiddlewaresAllEnabled",
+			config: env.ServerVars{Recovery: true, RequestLogging: true},
+			want:   []string{"recovery", "logger", "authenticate"},
+		},
+		{
+			name:   "MiddlewaresLoggingOnly",
+			config: env.ServerVars{RequestLogging: true},
+			want:   []string{"logger", "authenticate"},
+		},
+		{
+			name:   "MiddlewaresRequestTimeout",
+			config: env.ServerVars{Recovery: true, MaxRequestTimeout: time.Second},
+			want:   []string{"recovery", "timeout", "authenticate"},
+		},
+		{
+			name:    "MiddlewaresTenantRoles",
+			config:  env.ServerVars{Recovery: true},
+			tenants: env.TenantVars{Roles: map[string]string{"acme": "arn:aws:iam::111111111111:role/acme"}},
+			want:    []string{"recovery", "authenticate", "tenant"},
+		},
+		{
+			name:   "MiddlewaresQueryToken",
+			config: env.ServerVars{Recovery: true, RequestLogging: true},
+			auth:   env.AuthVars{QueryToken: true},
+			want:   []string{"querytoken", "recovery", "logger", "authenticate"},
+		},
+		{
+			name:   "MiddlewaresNoneEnabled",
+			config: env.ServerVars{},
+			want:   []string{"authenticate"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			for _, m := range (GinRouter{Config: tt.config, Auth: tt.auth, Tenants: tt.tenants}).Middlewares() {
+				if m.Handler == nil {
+					t.Errorf("Middlewares() %v has no handler", m.Name)
+				}
+				names = append(names, m.Name)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("Middlewares() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestGinRouter_QueryTokenNotLogged(t *testing.T) {
+	var logs bytes.Buffer
+	defer func(w io.Writer) { gin.DefaultWriter = w }(gin.DefaultWriter)
+	gin.DefaultWriter = &logs
+
+	r := GinRouter{
+		Parser: &ParserStub{},
+		Auth:   env.AuthVars{QueryToken: true},
+		Config: env.ServerVars{RequestLogging: true}}.Engine()
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest("GET", "/livez?access_token=secret-token", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("GET /livez status = %v, want %v", resp.Code, http.StatusOK)
+	}
+	if strings.Contains(logs.String(), "secret-token") {
+		t.Errorf("request log = %q, want the token scrubbed", logs.String())
+	}
+	if !strings.Contains(logs.String(), "access_token=REDACTED") {
+		t.Errorf("request log = %q, want access_token=REDACTED", logs.String())
+	}
+}
+
+func TestGinRouter_HTTPServer(t *testing.T) {
+	g := GinRouter{Config: env.ServerVars{
+		ReadHeaderTimeout: 2 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      20 * time.Second,
+		IdleTimeout:       time.Minute}}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	srv := g.HTTPServer(http.NotFoundHandler(), tlsConfig)
+	if srv.ReadHeaderTimeout != 2*time.Second {
+		t.Errorf("HTTPServer() ReadHeaderTimeout = %v, want 2s", srv.ReadHeaderTimeout)
+	}
+	if srv.ReadTimeout != 10*time.Second {
+		t.Errorf("HTTPServer() ReadTimeout = %v, want 10s", srv.ReadTimeout)
+	}
+	if srv.WriteTimeout != 20*time.Second {
+		t.Errorf("HTTPServer() WriteTimeout = %v, want 20s", srv.WriteTimeout)
+	}
+	if srv.IdleTimeout != time.Minute {
+		t.Errorf("HTTPServer() IdleTimeout = %v, want 1m", srv.IdleTimeout)
+	}
+	if srv.TLSConfig != tlsConfig || srv.Handler == nil {
+		t.Errorf("HTTPServer() = %+v, want handler and TLS config set", srv)
+	}
+}
+
+func TestGinRouter_StartServerNilParser(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	r, err := GinRouter{Config: env.ServerVars{Recovery: true}}.StartServer(ctx)
+	if !errors.Is(err, ErrNilParser) {
+		t.Errorf("StartServer() error = %v, want ErrNilParser", err)
+	}
+	if r != nil {
+		t.Errorf("StartServer() engine = %v, want nil", r)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	if _, err = (GinRouter{}).Serve(ctx, ln); !errors.Is(err, ErrNilParser) {
+		t.Errorf("Serve() error = %v, want ErrNilParser", err)
+	}
+}
+
+func TestGinRouter_ServeGinMode(t *testing.T) {
+	defer gin.SetMode(gin.TestMode)
+
+	for _, mode := range []string{gin.ReleaseMode, gin.DebugMode} {
+		t.Run(mode, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Listen() error = %v", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err = GinRouter{Parser: &ParserStub{}, Config: env.ServerVars{GinMode: mode}}.Serve(ctx, ln)
+			if err != nil {
+				t.Fatalf("Serve() error = %v", err)
+			}
+			if gin.Mode() != mode {
+				t.Errorf("Serve() gin mode = %v, want %v", gin.Mode(), mode)
+			}
+		})
+	}
+}
+
+func TestGinRouter_ServeMaxConnections(t *testing.T) {
+	const maxConns = 2
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go GinRouter{Parser: &ParserStub{}, Config: env.ServerVars{MaxConnections: maxConns}}.Serve(ctx, ln)
+	url := "http://" + ln.Addr().String() + "/livez"
+
+	// Slow clients that connect but never send a request hold their connection open.
+	var slow []net.Conn
+	for range maxConns {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		defer conn.Close()
+		slow = append(slow, conn)
+	}
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 200 * time.Millisecond}
+	if resp, err := client.Get(url); err == nil {
+		resp.Body.Close()
+		t.Fatalf("Get() status = %v beyond %d connections, want it to wait", resp.StatusCode, maxConns)
+	}
+
+	slow[0].Close()
+	client.Timeout = 5 * time.Second
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Get() error = %v after a connection closed", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Get() status = %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestGinRouter_ServeMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey := generateTestCert(t, nil, nil, "ca")
+	serverCert, serverKey := generateTestCert(t, caCert, caKey, "server")
+	clientCert, clientKey := generateTestCert(t, caCert, caKey, "client")
+	otherCACert, otherCAKey := generateTestCert(t, nil, nil, "other-ca")
+	otherClientCert, otherClientKey := generateTestCert(t, otherCACert, otherCAKey, "other-client")
+
+	config := env.ServerVars{
+		TLSCertFile:     writeTestPEM(t, dir, "server.crt", "CERTIFICATE", serverCert.Raw),
+		TLSKeyFile:      writeTestPEM(t, dir, "server.key", "PRIVATE KEY", marshalTestKey(t, serverKey)),
+		TLSClientCAFile: writeTestPEM(t, dir, "ca.crt", "CERTIFICATE", caCert.Raw),
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	parser := &ParserStub{ParserFunc: func(tokenString string) (*jwt.Token, error) {
+		return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"sub": "1"}}, nil
+	}}
+	go GinRouter{Parser: parser, Config: config}.Serve(ctx, ln)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+
+	tests := []struct {
+		name        string
+		clientCerts []tls.Certificate
+		wantErr     bool
+	}{
+		{
+			name:        "MutualTLSValidClientCert",
+			clientCerts: []tls.Certificate{{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}},
+			wantErr:     false,
+		},
+		{
+			name:        "MutualTLSNoClientCert",
+			clientCerts: nil,
+			wantErr:     true,
+		},
+		{
+			name:        "MutualTLSUntrustedClientCert",
+			clientCerts: []tls.Certificate{{Certificate: [][]byte{otherClientCert.Raw}, PrivateKey: otherClientKey}},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				Certificates: tt.clientCerts,
+			}}}
+
+			resp, err := client.Get(fmt.Sprintf("https://%v/token/get", ln.Addr()))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			// The request got through TLS; it is only rejected for lacking a JWT.
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Get() status = %v, want %v", resp.StatusCode, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+// generateTestCert creates a certificate for name, signed by parent, or self-signed as a
+// CA when parent is nil.
+func generateTestCert(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, name string) (
+	*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+
+	return cert, key
+}
+
+func marshalTestKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey() error = %v", err)
+	}
+
+	return der
+}
+
+func writeTestPEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	return path
+}
@@ -0,0 +1,85 @@
+package rest
+
+import (
+	"app/api"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Stats counts the save and retrieve requests served by a GinRouter, for deployments
+// without Prometheus. A request counts as failed when it is answered with a server error,
+// client errors such as a missing token do not count. It is safe for concurrent use.
+type Stats struct {
+	started        time.Time
+	now            func() time.Time
+	saves          atomic.Int64
+	saveErrors     atomic.Int64
+	retrieves      atomic.Int64
+	retrieveErrors atomic.Int64
+	lastError      atomic.Int64
+}
+
+// NewStats creates Stats whose uptime starts now.
+func NewStats() *Stats {
+	return &Stats{started: time.Now(), now: time.Now}
+}
+
+// CountSave is a middleware counting the requests of a save endpoint.
+func (s *Stats) CountSave() gin.HandlerFunc {
+	return s.count(&s.saves, &s.saveErrors)
+}
+
+// CountRetrieve is a middleware counting the requests of a retrieve endpoint.
+func (s *Stats) CountRetrieve() gin.HandlerFunc {
+	return s.count(&s.retrieves, &s.retrieveErrors)
+}
+
+func (s *Stats) count(total, errs *atomic.Int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		total.Add(1)
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			errs.Add(1)
+			s.lastError.Store(s.now().UnixNano())
+		}
+	}
+}
+
+// Snapshot returns the current counters.
+func (s *Stats) Snapshot() api.StatsResponse {
+	res := api.StatsResponse{
+		Saves:          s.saves.Load(),
+		SaveErrors:     s.saveErrors.Load(),
+		Retrieves:      s.retrieves.Load(),
+		RetrieveErrors: s.retrieveErrors.Load(),
+		UptimeSeconds:  int64(s.now().Sub(s.started).Seconds()),
+	}
+	if nanos := s.lastError.Load(); nanos != 0 {
+		lastError := time.Unix(0, nanos).UTC()
+		res.LastErrorAt = &lastError
+	}
+
+	return res
+}
+
+// CacheReporter is implemented by a Parser that caches verified tokens, such as JWTParser.
+type CacheReporter interface {
+	CacheStats() api.CacheStats
+}
+
+// StatsHandler is the handler for the administrative endpoint /stats. It responds with the
+// api.StatsResponse of the current counters, including the cache statistics of p when it
+// is a CacheReporter.
+func StatsHandler(s *Stats, p Parser) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res := s.Snapshot()
+		if cr, ok := p.(CacheReporter); ok {
+			cache := cr.CacheStats()
+			res.JWTCache = &cache
+		}
+		c.JSON(http.StatusOK, res)
+	}
+}
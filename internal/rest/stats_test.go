@@ -0,0 +1,157 @@
+package rest
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/key"
+	"app/internal/token"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGinRouter_Stats(t *testing.T) {
+	stub := &SaverRetrieverStub{
+		SaveTokenFunc: func(req *api.SaveTokenRequest) (token.SaveResult, error) {
+			return token.SaveCreated, nil
+		},
+		RetrieveTokenFunc: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+			if req.Provider == "broken" {
+				return nil, errors.New("server error")
+			}
+			return &oauth2.Token{AccessToken: "access_token"}, nil
+		},
+	}
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	stats := &Stats{started: now.Add(-time.Minute), now: func() time.Time { return now }}
+	g := GinRouter{
+		Saver:     stub,
+		Retriever: stub,
+		Parser: &ParserStub{ParserFunc: func(tokenString string) (*jwt.Token, error) {
+			scope := "read"
+			if tokenString == "admin" {
+				scope = "admin"
+			}
+			return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"sub": "1", "scope": scope}}, nil
+		}},
+		Stats: stats,
+	}
+	r := g.Engine()
+	serve := func(method, path, tokenString, body string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	saveBody := `{"user_id": "1", "access_token": "access_token", "refresh_token": "refresh_token",
+		"expiry": "2026-01-02T15:04:05Z"}`
+	for _, req := range []struct{ method, path, body string }{
+		{"PUT", "/token/save", saveBody},
+		{"PUT", "/token/save", saveBody},
+		{"PUT", "/token/save", `{}`},
+		{"GET", "/token/get", ""},
+		{"GET", "/token/get?provider=broken", ""},
+	} {
+		serve(req.method, req.path, "user", req.body)
+	}
+
+	if resp := serve("GET", "/stats", "user", ""); resp.Code != http.StatusForbidden {
+		t.Errorf("Stats() without admin scope status = %v, want %v", resp.Code, http.StatusForbidden)
+	}
+
+	resp := serve("GET", "/stats", "admin", "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Stats() status = %v, want %v", resp.Code, http.StatusOK)
+	}
+	var got api.StatsResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	want := api.StatsResponse{Saves: 3, SaveErrors: 0, Retrieves: 2, RetrieveErrors: 1, UptimeSeconds: 60}
+	if got.Saves != want.Saves || got.SaveErrors != want.SaveErrors || got.Retrieves != want.Retrieves ||
+		got.RetrieveErrors != want.RetrieveErrors || got.UptimeSeconds != want.UptimeSeconds {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+	if got.LastErrorAt == nil || !got.LastErrorAt.Equal(now) {
+		t.Errorf("Stats() last_error_at = %v, want %v", got.LastErrorAt, now)
+	}
+}
+
+func TestStats_NoErrors(t *testing.T) {
+	got := NewStats().Snapshot()
+	if got.LastErrorAt != nil || got.Saves != 0 || got.Retrieves != 0 {
+		t.Errorf("Snapshot() = %+v, want empty counters", got)
+	}
+}
+
+// A GinRouter without Stats counts its requests with Stats of its own.
+func TestGinRouter_StatsDefault(t *testing.T) {
+	g := GinRouter{
+		Parser: &ParserStub{ParserFunc: func(tokenString string) (*jwt.Token, error) {
+			return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"sub": "1", "scope": "admin"}}, nil
+		}},
+		Auth: env.AuthVars{SubjectClaim: env.DefaultSubjectClaim},
+	}
+
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/stats", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	g.Engine().ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Errorf("Stats() status = %v, want %v", resp.Code, http.StatusOK)
+	}
+}
+
+func TestStatsHandler_JWTCache(t *testing.T) {
+	privateKey, getter, _ := key.GenerateTestKeyPair()
+	parser, err := NewJWTParser(getter)
+	if err != nil {
+		t.Fatalf("NewJWTParser() error = %v", err)
+	}
+	tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodRS256,
+		jwt.MapClaims{"sub": "1", "exp": time.Now().Add(time.Minute).Unix()}).SignedString(privateKey)
+
+	stats := func() *api.CacheStats {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		StatsHandler(NewStats(), parser)(c)
+
+		var got api.StatsResponse
+		if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+			t.Fatalf("Failed to decode response body: %v", err)
+		}
+		return got.JWTCache
+	}
+
+	for _, want := range []api.CacheStats{
+		{Entries: 1, Hits: 0, Misses: 1},
+		{Entries: 1, Hits: 1, Misses: 1},
+	} {
+		if _, err = parser.ParseJWT(tokenString); err != nil {
+			t.Fatalf("ParseJWT() error = %v", err)
+		}
+		if got := stats(); got == nil || *got != want {
+			t.Errorf("StatsHandler() jwt_cache = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestStatsHandler_WithoutCache(t *testing.T) {
+	resp := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(resp)
+	StatsHandler(NewStats(), &ParserStub{})(c)
+
+	if bytes.Contains(resp.Body.Bytes(), []byte("jwt_cache")) {
+		t.Errorf("StatsHandler() = %v, want no jwt_cache", resp.Body.String())
+	}
+}
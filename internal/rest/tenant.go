@@ -0,0 +1,38 @@
+package rest
+
+import (
+	"app/env"
+	"app/internal/secret"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"log/slog"
+	"net/http"
+)
+
+// AssumeTenantRole is a middleware that selects the IAM role the secrets of a request are
+// accessed with. It reads the tenant from the claim named by env.TenantVars Claim of the
+// token stored by Authenticate, so it must run after it, and stores the role of the tenant
+// in the request context with secret.WithRoleARN, for the secret.RoleClient. Tokens
+// without a tenant, or with a tenant that has no role, are scrapped with status code
+// http.StatusForbidden, so no request falls back to the credentials of the service.
+func AssumeTenantRole(cfg env.TenantVars) gin.HandlerFunc {
+	claim := cfg.Claim
+	if claim == "" {
+		claim = env.DefaultTenantClaim
+	}
+
+	return func(c *gin.Context) {
+		claims, _ := c.Value("claims").(jwt.MapClaims)
+		tenant, _ := claims[claim].(string)
+		roleARN, ok := cfg.Roles[tenant]
+		if tenant == "" || !ok {
+			slog.Error(fmt.Sprintf("Token has no %v claim with a known tenant", claim))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "Unknown tenant"})
+			return
+		}
+
+		c.Request = c.Request.WithContext(secret.WithRoleARN(c.Request.Context(), roleARN))
+		c.Next()
+	}
+}
@@ -0,0 +1,62 @@
+package rest
+
+import (
+	"app/env"
+	"app/internal/secret"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAssumeTenantRole(t *testing.T) {
+	cfg := env.TenantVars{
+		Claim: "org",
+		Roles: map[string]string{"acme": "arn:aws:iam::111111111111:role/acme"}}
+
+	tests := []struct {
+		name       string
+		claims     jwt.MapClaims
+		wantStatus int
+		wantRole   string
+	}{
+		{
+			name:       "TenantKnown",
+			claims:     jwt.MapClaims{"sub": "1", "org": "acme"},
+			wantStatus: http.StatusOK,
+			wantRole:   "arn:aws:iam::111111111111:role/acme",
+		},
+		{
+			name:       "TenantUnknown",
+			claims:     jwt.MapClaims{"sub": "1", "org": "globex"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "TenantMissing",
+			claims:     jwt.MapClaims{"sub": "1"},
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var role string
+			r := gin.New()
+			r.Use(func(c *gin.Context) { c.Set("claims", tt.claims) }, AssumeTenantRole(cfg))
+			r.GET("/", func(c *gin.Context) {
+				role, _ = secret.RoleARN(c.Request.Context())
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("AssumeTenantRole() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if role != tt.wantRole {
+				t.Errorf("AssumeTenantRole() role = %v, want %v", role, tt.wantRole)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package rest
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// NewTestRouter returns the Engine of deps, the same routes and middlewares StartServer
+// serves, as an http.Handler for httptest.NewServer. Tests inject stub dependencies such
+// as a token.Saver, token.Retriever or Parser into deps to exercise the middlewares and
+// handlers end to end. Gin runs in test mode, so the routes are not logged.
+func NewTestRouter(deps GinRouter) http.Handler {
+	gin.SetMode(gin.TestMode)
+
+	return deps.Engine()
+}
@@ -0,0 +1,87 @@
+package rest
+
+import (
+	"app/api"
+	"app/internal/key"
+	"encoding/json"
+	"errors"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewTestRouter_RetrieveToken(t *testing.T) {
+	privateKey, getter, err := key.GenerateTestKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser, err := NewJWTParser(getter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retriever := &SaverRetrieverStub{RetrieveTokenFunc: func(req *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+		if req.UserID != "1" {
+			return nil, errors.New("unexpected user")
+		}
+		return &oauth2.Token{AccessToken: "access_token", RefreshToken: "refresh_token"}, nil
+	}}
+
+	srv := httptest.NewServer(NewTestRouter(GinRouter{Retriever: retriever, Parser: parser}))
+	defer srv.Close()
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantToken     string
+	}{
+		{
+			name:          "RetrieveTokenAuthenticated",
+			authorization: "Bearer " + generateTestToken(privateKey),
+			wantStatus:    http.StatusOK,
+			wantToken:     "access_token",
+		},
+		{
+			name:          "RetrieveTokenInvalidJWT",
+			authorization: "Bearer invalid",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:       "RetrieveTokenNoAuthorization",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", srv.URL+"/token/get", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("GET /token/get error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("GET /token/get status = %v, wantStatus = %v", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantToken == "" {
+				return
+			}
+			var body api.TokenResponse
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode response body: %v", err)
+			}
+			if body.AccessToken != tt.wantToken {
+				t.Errorf("GET /token/get access_token = %v, want %v", body.AccessToken, tt.wantToken)
+			}
+		})
+	}
+}
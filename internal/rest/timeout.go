@@ -0,0 +1,45 @@
+package rest
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RequestTimeoutHeader lets a client bound how long the service works on its request, in
+// milliseconds.
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// RequestTimeout turns the X-Request-Timeout header into a deadline on the request context,
+// which is passed down to the AWS calls, so they are abandoned once the client has given
+// up. Timeouts above limit are capped to it, so clients cannot keep calls running for
+// longer than the server allows. Requests without the header get no deadline, and a header
+// that is not a positive number of milliseconds is rejected with http.StatusBadRequest.
+func RequestTimeout(limit time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value := c.GetHeader(RequestTimeoutHeader)
+		if value == "" {
+			c.Next()
+			return
+		}
+
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || ms <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest,
+				gin.H{"error": RequestTimeoutHeader + " must be a positive number of milliseconds"})
+			return
+		}
+
+		timeout := limit
+		if ms < limit.Milliseconds() {
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,136 @@
+package rest
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/key"
+	"app/internal/secret"
+	"app/internal/token"
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       string
+		wantStatus   int
+		wantDeadline time.Duration
+	}{
+		{
+			name:       "RequestTimeoutNoHeader",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:         "RequestTimeoutHeader",
+			header:       "500",
+			wantStatus:   http.StatusOK,
+			wantDeadline: 500 * time.Millisecond,
+		},
+		{
+			name:         "RequestTimeoutCapped",
+			header:       "3600000",
+			wantStatus:   http.StatusOK,
+			wantDeadline: 2 * time.Second,
+		},
+		{
+			name:       "RequestTimeoutNotANumber",
+			header:     "1s",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "RequestTimeoutZero",
+			header:     "0",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deadline time.Time
+			r := gin.New()
+			r.Use(RequestTimeout(2 * time.Second))
+			r.GET("/", func(c *gin.Context) {
+				deadline, _ = c.Request.Context().Deadline()
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestTimeoutHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			start := time.Now()
+			r.ServeHTTP(w, req)
+			end := time.Now()
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("RequestTimeout() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantDeadline == 0 {
+				if !deadline.IsZero() {
+					t.Errorf("RequestTimeout() deadline = %v, want none", deadline)
+				}
+				return
+			}
+			if deadline.Before(start.Add(tt.wantDeadline)) || deadline.After(end.Add(tt.wantDeadline)) {
+				t.Errorf("RequestTimeout() deadline in %v, want %v", deadline.Sub(start), tt.wantDeadline)
+			}
+		})
+	}
+}
+
+// slowGetter stands in for a Secrets Manager call that takes longer than the client is
+// willing to wait.
+type slowGetter struct {
+	delay time.Duration
+}
+
+func (sg slowGetter) GetSecret(ctx context.Context, r *api.GetSecretRequest) (string, error) {
+	select {
+	case <-time.After(sg.delay):
+		return `{"access_token":"access_token"}`, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func TestRequestTimeout_DeadlineExceeded(t *testing.T) {
+	privateKey, getter, err := key.GenerateTestKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser, err := NewJWTParser(getter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := env.AwsVars{SmsRootDomain: "root"}
+	store := secret.NewMemoryStore()
+	retriever := &token.ApiRetriever{Env: vars, Res: store, Get: slowGetter{delay: 5 * time.Second}}
+	if err := store.CreateSecret(context.Background(), &api.CreateSecretRequest{SecretID: "root/token/1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewTestRouter(GinRouter{
+		Retriever: retriever,
+		Parser:    parser,
+		Config:    env.ServerVars{MaxRequestTimeout: time.Second}})
+
+	req := httptest.NewRequest("GET", "/token/get", nil)
+	req.Header.Set("Authorization", "Bearer "+generateTestToken(privateKey))
+	req.Header.Set(RequestTimeoutHeader, "20")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusGatewayTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request took %v, want it abandoned after the header timeout", elapsed)
+	}
+}
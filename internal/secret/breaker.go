@@ -0,0 +1,175 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a BreakerClient instead of calling Secrets Manager while its
+// circuit is open.
+var ErrCircuitOpen = errors.New("secrets manager circuit breaker is open")
+
+// DefaultBreakerCooldown is how long a BreakerClient stays open when no cooldown is set.
+const DefaultBreakerCooldown = 30 * time.Second
+
+// BreakerClient is a Client that stops calling Secrets Manager during an outage, so requests
+// fail fast instead of piling up retries. After Threshold consecutive calls failed with an
+// error worth retrying, see IsErrorRetryable, the circuit opens and every call fails with
+// ErrCircuitOpen for Cooldown. Then a single call is let through to probe Secrets Manager:
+// its success closes the circuit again, its failure opens it for another Cooldown. Errors
+// caused by the request itself, such as a missing secret, count as successes. Calls that
+// were cancelled, ran out of the deadline of their context or did not get a slot of a
+// LimitedClient are not counted, so clients cannot open the circuit for everyone.
+type BreakerClient struct {
+	Client    Client
+	Threshold int
+	Cooldown  time.Duration
+	Now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// NewBreakerClient wraps cl in a BreakerClient opening after threshold consecutive
+// failures. A threshold below one returns cl unchanged.
+func NewBreakerClient(cl Client, threshold int, cooldown time.Duration) Client {
+	if threshold < 1 {
+		return cl
+	}
+
+	return &BreakerClient{Client: cl, Threshold: threshold, Cooldown: cooldown}
+}
+
+// allow reports whether a call may go through. A call let through while the circuit is
+// half-open is the probe, which the caller must report with done.
+func (bc *BreakerClient) allow() bool {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.failures < bc.Threshold {
+		return true
+	}
+	if bc.probing || bc.now().Before(bc.openUntil) {
+		return false
+	}
+
+	bc.probing = true
+	return true
+}
+
+// done records the outcome of a call that was let through.
+func (bc *BreakerClient) done(err error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	wasOpen := bc.failures >= bc.Threshold
+	bc.probing = false
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrTooManyCalls) {
+		// A call cancelled, out of the time its client gave it or left waiting for a slot of
+		// a LimitedClient tells nothing about Secrets Manager.
+		return
+	}
+	if err == nil || !IsErrorRetryable(err) {
+		if wasOpen {
+			slog.Info("Secrets Manager recovered, closing circuit breaker")
+		}
+		bc.failures = 0
+		return
+	}
+
+	bc.failures++
+	if bc.failures >= bc.Threshold {
+		cooldown := bc.Cooldown
+		if cooldown <= 0 {
+			cooldown = DefaultBreakerCooldown
+		}
+		bc.openUntil = bc.now().Add(cooldown)
+		if !wasOpen {
+			slog.Warn(fmt.Sprintf("Opening circuit breaker for %v after %d failed calls to Secrets Manager: %v",
+				cooldown, bc.failures, err))
+		}
+	}
+}
+
+func (bc *BreakerClient) now() time.Time {
+	if bc.Now == nil {
+		return time.Now()
+	}
+	return bc.Now()
+}
+
+// guarded makes call through the circuit breaker of bc.
+func guarded[T any](bc *BreakerClient, call func() (T, error)) (T, error) {
+	if !bc.allow() {
+		var zero T
+		return zero, ErrCircuitOpen
+	}
+
+	out, err := call()
+	bc.done(err)
+	return out, err
+}
+
+func (bc *BreakerClient) GetSecretValue(ctx context.Context, input *sm.GetSecretValueInput, optFns ...func(*sm.Options)) (
+	*sm.GetSecretValueOutput, error) {
+	return guarded(bc, func() (*sm.GetSecretValueOutput, error) {
+		return bc.Client.GetSecretValue(ctx, input, optFns...)
+	})
+}
+
+func (bc *BreakerClient) PutSecretValue(ctx context.Context, input *sm.PutSecretValueInput, optFns ...func(*sm.Options)) (
+	*sm.PutSecretValueOutput, error) {
+	return guarded(bc, func() (*sm.PutSecretValueOutput, error) {
+		return bc.Client.PutSecretValue(ctx, input, optFns...)
+	})
+}
+
+func (bc *BreakerClient) CreateSecret(ctx context.Context, input *sm.CreateSecretInput, optFns ...func(*sm.Options)) (
+	*sm.CreateSecretOutput, error) {
+	return guarded(bc, func() (*sm.CreateSecretOutput, error) {
+		return bc.Client.CreateSecret(ctx, input, optFns...)
+	})
+}
+
+func (bc *BreakerClient) DescribeSecret(ctx context.Context, input *sm.DescribeSecretInput, optFns ...func(*sm.Options)) (
+	*sm.DescribeSecretOutput, error) {
+	return guarded(bc, func() (*sm.DescribeSecretOutput, error) {
+		return bc.Client.DescribeSecret(ctx, input, optFns...)
+	})
+}
+
+func (bc *BreakerClient) DeleteSecret(ctx context.Context, input *sm.DeleteSecretInput, optFns ...func(*sm.Options)) (
+	*sm.DeleteSecretOutput, error) {
+	return guarded(bc, func() (*sm.DeleteSecretOutput, error) {
+		return bc.Client.DeleteSecret(ctx, input, optFns...)
+	})
+}
+
+func (bc *BreakerClient) ListSecrets(ctx context.Context, input *sm.ListSecretsInput, optFns ...func(*sm.Options)) (
+	*sm.ListSecretsOutput, error) {
+	return guarded(bc, func() (*sm.ListSecretsOutput, error) {
+		return bc.Client.ListSecrets(ctx, input, optFns...)
+	})
+}
+
+func (bc *BreakerClient) UpdateSecretVersionStage(ctx context.Context, input *sm.UpdateSecretVersionStageInput,
+	optFns ...func(*sm.Options)) (*sm.UpdateSecretVersionStageOutput, error) {
+	return guarded(bc, func() (*sm.UpdateSecretVersionStageOutput, error) {
+		return bc.Client.UpdateSecretVersionStage(ctx, input, optFns...)
+	})
+}
+
+func (bc *BreakerClient) ListSecretVersionIds(ctx context.Context, input *sm.ListSecretVersionIdsInput,
+	optFns ...func(*sm.Options)) (*sm.ListSecretVersionIdsOutput, error) {
+	return guarded(bc, func() (*sm.ListSecretVersionIdsOutput, error) {
+		return bc.Client.ListSecretVersionIds(ctx, input, optFns...)
+	})
+}
@@ -0,0 +1,156 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"testing"
+	"time"
+)
+
+func TestBreakerClient(t *testing.T) {
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	calls := 0
+	var failWith error
+	stub := &AWSClientStub{
+		DescribeSecretFunc: func(ctx context.Context, input *sm.DescribeSecretInput, opts ...func(*sm.Options)) (
+			*sm.DescribeSecretOutput, error) {
+			calls++
+			if failWith != nil {
+				return nil, failWith
+			}
+			return &sm.DescribeSecretOutput{}, nil
+		},
+	}
+	bc := &BreakerClient{Client: stub, Threshold: 3, Cooldown: time.Minute, Now: func() time.Time { return now }}
+	describe := func() error {
+		_, err := bc.DescribeSecret(context.Background(), &sm.DescribeSecretInput{})
+		return err
+	}
+
+	// Errors caused by the request do not count as failures.
+	failWith = &types.ResourceNotFoundException{}
+	for range 5 {
+		describe()
+	}
+	if err := describe(); errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("DescribeSecret() error = %v, want the circuit closed after client errors", err)
+	}
+
+	failWith = &types.InternalServiceError{}
+	for range 3 {
+		if err := describe(); !errors.As(err, new(*types.InternalServiceError)) {
+			t.Fatalf("DescribeSecret() error = %v, want the error of Secrets Manager", err)
+		}
+	}
+
+	calls = 0
+	if err := describe(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("DescribeSecret() error = %v, want %v", err, ErrCircuitOpen)
+	}
+	if calls != 0 {
+		t.Errorf("DescribeSecret() called Secrets Manager %d times while open, want 0", calls)
+	}
+
+	// The probe after the cooldown still fails, which opens the circuit again.
+	now = now.Add(time.Minute)
+	if err := describe(); errors.Is(err, ErrCircuitOpen) || calls != 1 {
+		t.Fatalf("DescribeSecret() error = %v with %d calls, want a probe after the cooldown", err, calls)
+	}
+	if err := describe(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("DescribeSecret() error = %v, want %v after a failed probe", err, ErrCircuitOpen)
+	}
+
+	// A successful probe closes the circuit.
+	now = now.Add(time.Minute)
+	failWith = nil
+	if err := describe(); err != nil {
+		t.Fatalf("DescribeSecret() probe error = %v", err)
+	}
+	for range 3 {
+		if err := describe(); err != nil {
+			t.Errorf("DescribeSecret() error = %v, want the circuit closed after recovery", err)
+		}
+	}
+}
+
+func TestBreakerClient_LocalErrorsNotCounted(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{
+			name: "DeadlineExceeded",
+			err:  context.DeadlineExceeded,
+		},
+		{
+			name: "TooManyCalls",
+			err:  fmt.Errorf("%w: %w", ErrTooManyCalls, context.DeadlineExceeded),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &AWSClientStub{
+				DescribeSecretFunc: func(ctx context.Context, input *sm.DescribeSecretInput, opts ...func(*sm.Options)) (
+					*sm.DescribeSecretOutput, error) {
+					return nil, tt.err
+				},
+			}
+			bc := &BreakerClient{Client: stub, Threshold: 2, Cooldown: time.Minute}
+
+			for range 5 {
+				if _, err := bc.DescribeSecret(context.Background(), &sm.DescribeSecretInput{}); errors.Is(err, ErrCircuitOpen) {
+					t.Fatalf("DescribeSecret() error = %v, want the circuit closed after %v", err, tt.err)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerClient_LimitedClientFull(t *testing.T) {
+	running := make(chan struct{})
+	unblock := make(chan struct{})
+	defer close(unblock)
+	stub := &AWSClientStub{
+		DescribeSecretFunc: func(ctx context.Context, input *sm.DescribeSecretInput, opts ...func(*sm.Options)) (
+			*sm.DescribeSecretOutput, error) {
+			running <- struct{}{}
+			<-unblock
+			return &sm.DescribeSecretOutput{}, nil
+		},
+	}
+	bc := NewBreakerClient(NewLimitedClient(stub, 1, time.Millisecond), 2, time.Minute)
+	go bc.DescribeSecret(context.Background(), &sm.DescribeSecretInput{})
+	<-running
+
+	for range 5 {
+		_, err := bc.DescribeSecret(context.Background(), &sm.DescribeSecretInput{})
+		if !errors.Is(err, ErrTooManyCalls) {
+			t.Fatalf("DescribeSecret() error = %v, want %v while the limit is reached", err, ErrTooManyCalls)
+		}
+	}
+}
+
+func TestBreakerClient_SingleProbe(t *testing.T) {
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	bc := &BreakerClient{Threshold: 1, Cooldown: time.Minute, Now: func() time.Time { return now }}
+	bc.done(&types.InternalServiceError{})
+
+	now = now.Add(time.Minute)
+	if !bc.allow() {
+		t.Fatalf("allow() = false, want a probe after the cooldown")
+	}
+	if bc.allow() {
+		t.Errorf("allow() = true while the probe is in flight, want false")
+	}
+}
+
+func TestNewBreakerClient_Disabled(t *testing.T) {
+	stub := &AWSClientStub{}
+	if cl := NewBreakerClient(stub, 0, time.Minute); cl != Client(stub) {
+		t.Errorf("NewBreakerClient() = %T, want the client unchanged", cl)
+	}
+}
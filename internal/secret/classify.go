@@ -0,0 +1,95 @@
+package secret
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
+)
+
+// ErrorKind is the class of a failed AWS request, as determined by Classify, so callers can
+// switch on it instead of repeating the errors.As checks for each exception type.
+type ErrorKind int
+
+const (
+	// KindNone is the kind of a nil error.
+	KindNone ErrorKind = iota
+	// KindUnknown is the kind of any error not covered by the other kinds, including errors
+	// without an AWS response, such as connection failures.
+	KindUnknown
+	// KindNotFound is a secret that does not exist, types.ResourceNotFoundException.
+	KindNotFound
+	// KindExists is a secret that already exists, types.ResourceExistsException.
+	KindExists
+	// KindLimitExceeded is an exhausted Secrets Manager quota, types.LimitExceededException.
+	KindLimitExceeded
+	// KindThrottled is a request rejected for exceeding the request rate, see throttlingCodes.
+	KindThrottled
+	// KindAccessDenied is a request the credentials of the service are not allowed to make,
+	// see accessDeniedCodes.
+	KindAccessDenied
+	// KindInvalidRequest is a request Secrets Manager rejected as invalid,
+	// types.InvalidRequestException or types.InvalidParameterException.
+	KindInvalidRequest
+)
+
+// accessDeniedCodes are the error codes AWS uses when the caller lacks the IAM permission
+// for a request. Like the throttlingCodes, they are not modelled as typed exceptions.
+var accessDeniedCodes = map[string]bool{
+	"AccessDeniedException": true,
+	"AccessDenied":          true,
+}
+
+// Classify unwraps err and returns its ErrorKind. Typed exceptions of the SDK are matched
+// first, then the error codes of other AWS API errors.
+func Classify(err error) ErrorKind {
+	if err == nil {
+		return KindNone
+	}
+
+	var (
+		notFound      *types.ResourceNotFoundException
+		exists        *types.ResourceExistsException
+		limitExceeded *types.LimitExceededException
+		invalid       *types.InvalidRequestException
+		invalidParam  *types.InvalidParameterException
+		apiErr        smithy.APIError
+	)
+
+	switch {
+	case errors.As(err, &notFound):
+		return KindNotFound
+	case errors.As(err, &exists):
+		return KindExists
+	case errors.As(err, &limitExceeded):
+		return KindLimitExceeded
+	case errors.As(err, &invalid), errors.As(err, &invalidParam):
+		return KindInvalidRequest
+	case errors.As(err, &apiErr) && throttlingCodes[apiErr.ErrorCode()]:
+		return KindThrottled
+	case errors.As(err, &apiErr) && accessDeniedCodes[apiErr.ErrorCode()]:
+		return KindAccessDenied
+	default:
+		return KindUnknown
+	}
+}
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindNone:
+		return "none"
+	case KindNotFound:
+		return "not_found"
+	case KindExists:
+		return "exists"
+	case KindLimitExceeded:
+		return "limit_exceeded"
+	case KindThrottled:
+		return "throttled"
+	case KindAccessDenied:
+		return "access_denied"
+	case KindInvalidRequest:
+		return "invalid_request"
+	default:
+		return "unknown"
+	}
+}
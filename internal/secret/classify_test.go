@@ -0,0 +1,86 @@
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorKind
+	}{
+		{
+			name: "ClassifyNil",
+			err:  nil,
+			want: KindNone,
+		},
+		{
+			name: "ClassifyResourceNotFound",
+			err:  &types.ResourceNotFoundException{},
+			want: KindNotFound,
+		},
+		{
+			name: "ClassifyWrappedResourceNotFound",
+			err:  fmt.Errorf("resolving secret: %w", &types.ResourceNotFoundException{}),
+			want: KindNotFound,
+		},
+		{
+			name: "ClassifyResourceExists",
+			err:  &types.ResourceExistsException{},
+			want: KindExists,
+		},
+		{
+			name: "ClassifyLimitExceeded",
+			err:  &types.LimitExceededException{},
+			want: KindLimitExceeded,
+		},
+		{
+			name: "ClassifyInvalidRequest",
+			err:  &types.InvalidRequestException{},
+			want: KindInvalidRequest,
+		},
+		{
+			name: "ClassifyInvalidParameter",
+			err:  &types.InvalidParameterException{},
+			want: KindInvalidRequest,
+		},
+		{
+			name: "ClassifyThrottling",
+			err:  &smithy.GenericAPIError{Code: "ThrottlingException", Fault: smithy.FaultClient},
+			want: KindThrottled,
+		},
+		{
+			name: "ClassifyRequestLimitExceeded",
+			err:  &smithy.GenericAPIError{Code: "RequestLimitExceeded", Fault: smithy.FaultClient},
+			want: KindThrottled,
+		},
+		{
+			name: "ClassifyAccessDenied",
+			err:  &smithy.GenericAPIError{Code: "AccessDeniedException", Fault: smithy.FaultClient},
+			want: KindAccessDenied,
+		},
+		{
+			name: "ClassifyOtherAPIError",
+			err:  &types.InternalServiceError{},
+			want: KindUnknown,
+		},
+		{
+			name: "ClassifyNonAPIError",
+			err:  errors.New("connection reset"),
+			want: KindUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"time"
+)
+
+// LimitedClient is a Client that allows at most a fixed number of calls to the wrapped
+// Client to be in flight at once, so a traffic spike does not exhaust the connections to
+// Secrets Manager. Calls beyond the limit wait for a slot, for at most Wait when it is set,
+// and fail with ErrTooManyCalls, wrapping the context error, when they get none in time.
+type LimitedClient struct {
+	Client Client
+	Wait   time.Duration
+	slots  chan struct{}
+}
+
+// ErrTooManyCalls is returned by a LimitedClient for a call that did not get a slot before
+// its context was done or its Wait passed.
+var ErrTooManyCalls = errors.New("too many concurrent calls to Secrets Manager")
+
+// NewLimitedClient wraps cl in a LimitedClient allowing limit calls in flight, where calls
+// wait for a slot for at most wait, zero until their context is done. A limit below one
+// returns cl unchanged.
+func NewLimitedClient(cl Client, limit int, wait time.Duration) Client {
+	if limit < 1 {
+		return cl
+	}
+
+	return &LimitedClient{Client: cl, Wait: wait, slots: make(chan struct{}, limit)}
+}
+
+// acquire waits for a free slot, which the caller must give back with release.
+func (lc *LimitedClient) acquire(ctx context.Context) error {
+	select {
+	case lc.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if lc.Wait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, lc.Wait)
+		defer cancel()
+	}
+
+	select {
+	case lc.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrTooManyCalls, ctx.Err())
+	}
+}
+
+func (lc *LimitedClient) release() {
+	<-lc.slots
+}
+
+func (lc *LimitedClient) GetSecretValue(ctx context.Context, input *sm.GetSecretValueInput, optFns ...func(*sm.Options)) (
+	*sm.GetSecretValueOutput, error) {
+	if err := lc.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer lc.release()
+
+	return lc.Client.GetSecretValue(ctx, input, optFns...)
+}
+
+func (lc *LimitedClient) PutSecretValue(ctx context.Context, input *sm.PutSecretValueInput, optFns ...func(*sm.Options)) (
+	*sm.PutSecretValueOutput, error) {
+	if err := lc.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer lc.release()
+
+	return lc.Client.PutSecretValue(ctx, input, optFns...)
+}
+
+func (lc *LimitedClient) CreateSecret(ctx context.Context, input *sm.CreateSecretInput, optFns ...func(*sm.Options)) (
+	*sm.CreateSecretOutput, error) {
+	if err := lc.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer lc.release()
+
+	return lc.Client.CreateSecret(ctx, input, optFns...)
+}
+
+func (lc *LimitedClient) DescribeSecret(ctx context.Context, input *sm.DescribeSecretInput, optFns ...func(*sm.Options)) (
+	*sm.DescribeSecretOutput, error) {
+	if err := lc.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer lc.release()
+
+	return lc.Client.DescribeSecret(ctx, input, optFns...)
+}
+
+func (lc *LimitedClient) DeleteSecret(ctx context.Context, input *sm.DeleteSecretInput, optFns ...func(*sm.Options)) (
+	*sm.DeleteSecretOutput, error) {
+	if err := lc.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer lc.release()
+
+	return lc.Client.DeleteSecret(ctx, input, optFns...)
+}
+
+func (lc *LimitedClient) ListSecrets(ctx context.Context, input *sm.ListSecretsInput, optFns ...func(*sm.Options)) (
+	*sm.ListSecretsOutput, error) {
+	if err := lc.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer lc.release()
+
+	return lc.Client.ListSecrets(ctx, input, optFns...)
+}
+
+func (lc *LimitedClient) UpdateSecretVersionStage(ctx context.Context, input *sm.UpdateSecretVersionStageInput,
+	optFns ...func(*sm.Options)) (*sm.UpdateSecretVersionStageOutput, error) {
+	if err := lc.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer lc.release()
+
+	return lc.Client.UpdateSecretVersionStage(ctx, input, optFns...)
+}
+
+func (lc *LimitedClient) ListSecretVersionIds(ctx context.Context, input *sm.ListSecretVersionIdsInput,
+	optFns ...func(*sm.Options)) (*sm.ListSecretVersionIdsOutput, error) {
+	if err := lc.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer lc.release()
+
+	return lc.Client.ListSecretVersionIds(ctx, input, optFns...)
+}
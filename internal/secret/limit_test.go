@@ -0,0 +1,147 @@
+package secret
+
+import (
+	"app/api"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"testing"
+	"time"
+)
+
+func TestLimitedClient_BlocksBeyondLimit(t *testing.T) {
+	running := make(chan string)
+	unblock := make(chan struct{})
+	stub := &AWSClientStub{
+		GetSecretValueFunc: func(ctx context.Context, input *sm.GetSecretValueInput, opts ...func(*sm.Options)) (
+			*sm.GetSecretValueOutput, error) {
+			running <- aws.ToString(input.SecretId)
+			<-unblock
+			return &sm.GetSecretValueOutput{SecretString: aws.String("token")}, nil
+		},
+	}
+	get := &AWSGetter{Client: NewLimitedClient(stub, 2, 0)}
+
+	done := make(chan error, 3)
+	for _, id := range []string{"first", "second", "third"} {
+		go func() {
+			_, err := get.GetSecret(context.Background(), &api.GetSecretRequest{SecretID: id})
+			done <- err
+		}()
+	}
+
+	for range 2 {
+		select {
+		case <-running:
+		case <-time.After(time.Second):
+			t.Fatalf("calls within the limit did not run")
+		}
+	}
+	select {
+	case id := <-running:
+		t.Fatalf("call for %v ran beyond the limit", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unblock <- struct{}{}
+	select {
+	case <-running:
+	case <-time.After(time.Second):
+		t.Fatalf("waiting call did not run after a slot was freed")
+	}
+	close(unblock)
+
+	for range 3 {
+		if err := <-done; err != nil {
+			t.Errorf("GetSecret() error = %v", err)
+		}
+	}
+}
+
+func TestLimitedClient_ContextCancelled(t *testing.T) {
+	running := make(chan struct{})
+	unblock := make(chan struct{})
+	defer close(unblock)
+	stub := &AWSClientStub{
+		DescribeSecretFunc: func(ctx context.Context, input *sm.DescribeSecretInput, opts ...func(*sm.Options)) (
+			*sm.DescribeSecretOutput, error) {
+			running <- struct{}{}
+			<-unblock
+			return &sm.DescribeSecretOutput{}, nil
+		},
+	}
+	cl := NewLimitedClient(stub, 1, 0)
+	go cl.DescribeSecret(context.Background(), &sm.DescribeSecretInput{})
+	<-running
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := cl.DescribeSecret(ctx, &sm.DescribeSecretInput{})
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrTooManyCalls) {
+		t.Errorf("DescribeSecret() error = %v, want %v and %v", err, ErrTooManyCalls, context.DeadlineExceeded)
+	}
+}
+
+func TestLimitedClient_Wait(t *testing.T) {
+	tests := []struct {
+		name    string
+		wait    time.Duration
+		slow    time.Duration
+		wantErr bool
+	}{
+		{
+			name: "WaitUntilSlotFree",
+			slow: 50 * time.Millisecond,
+		},
+		{
+			name: "WaitLongerThanCall",
+			wait: time.Second,
+			slow: 50 * time.Millisecond,
+		},
+		{
+			name:    "WaitShorterThanCall",
+			wait:    20 * time.Millisecond,
+			slow:    time.Second,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			running := make(chan struct{}, 2)
+			stub := &AWSClientStub{
+				GetSecretValueFunc: func(ctx context.Context, input *sm.GetSecretValueInput, opts ...func(*sm.Options)) (
+					*sm.GetSecretValueOutput, error) {
+					running <- struct{}{}
+					time.Sleep(tt.slow)
+					return &sm.GetSecretValueOutput{SecretString: aws.String("token")}, nil
+				},
+			}
+			get := &AWSGetter{Client: NewLimitedClient(stub, 1, tt.wait)}
+			go get.GetSecret(context.Background(), &api.GetSecretRequest{SecretID: "first"})
+			<-running
+
+			start := time.Now()
+			_, err := get.GetSecret(context.Background(), &api.GetSecretRequest{SecretID: "second"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrTooManyCalls) {
+					t.Errorf("GetSecret() error = %v, want %v", err, ErrTooManyCalls)
+				}
+				if elapsed := time.Since(start); elapsed >= tt.slow {
+					t.Errorf("GetSecret() failed after %v, want after about %v", elapsed, tt.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestNewLimitedClient_Unlimited(t *testing.T) {
+	stub := &AWSClientStub{}
+	if cl := NewLimitedClient(stub, 0, 0); cl != Client(stub) {
+		t.Errorf("NewLimitedClient() = %T, want the client unchanged", cl)
+	}
+}
@@ -0,0 +1,168 @@
+package secret
+
+import (
+	"app/api"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory implementation of the Getter, MetaGetter, Putter,
+// VersionPutter, Creator, VersionCreator, Deleter, Lister, Versioner and IDResolver
+// interfaces. It is safe for concurrent use and mirrors the errors
+// of Secrets Manager (types.ResourceNotFoundException, types.ResourceExistsException), so it
+// can stand in for an AWSManager in tests and dry runs. Like Secrets Manager, it rejects
+// values longer than MaxSecretSize, with ErrSecretTooLarge.
+type MemoryStore struct {
+	mu      sync.Mutex
+	secrets map[string]memorySecret
+}
+
+type memorySecret struct {
+	value       string
+	version     int
+	lastChanged time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{secrets: map[string]memorySecret{}}
+}
+
+func (ms *MemoryStore) GetSecret(ctx context.Context, r *api.GetSecretRequest) (string, error) {
+	value, err := ms.GetSecretWithMeta(ctx, r)
+	if err != nil {
+		return "", err
+	}
+
+	return value.Value, nil
+}
+
+func (ms *MemoryStore) GetSecretWithMeta(ctx context.Context, r *api.GetSecretRequest) (*api.SecretValue, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	s, ok := ms.secrets[r.SecretID]
+	if !ok {
+		return nil, notFound(r.SecretID)
+	}
+	if r.VersionID != "" && r.VersionID != versionID(s.version) {
+		// Only the current version is kept.
+		return nil, notFound(r.SecretID)
+	}
+
+	return &api.SecretValue{Value: s.value, VersionID: versionID(s.version), CreatedDate: s.lastChanged}, nil
+}
+
+func (ms *MemoryStore) GetSecretVersion(ctx context.Context, r *api.GetSecretRequest) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	s, ok := ms.secrets[r.SecretID]
+	if !ok {
+		return "", notFound(r.SecretID)
+	}
+
+	return versionID(s.version), nil
+}
+
+func (ms *MemoryStore) PutSecret(ctx context.Context, r *api.PutSecretRequest) error {
+	_, err := ms.PutSecretWithVersion(ctx, r)
+	return err
+}
+
+func (ms *MemoryStore) PutSecretWithVersion(ctx context.Context, r *api.PutSecretRequest) (string, error) {
+	if err := checkSize(r.SecretID, r.Token, 0); err != nil {
+		return "", err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	s, ok := ms.secrets[r.SecretID]
+	if !ok {
+		return "", notFound(r.SecretID)
+	}
+	if r.VersionID != "" && r.VersionID != versionID(s.version) {
+		return "", ErrVersionConflict
+	}
+
+	ms.secrets[r.SecretID] = memorySecret{value: r.Token, version: s.version + 1, lastChanged: time.Now()}
+	return versionID(s.version + 1), nil
+}
+
+func (ms *MemoryStore) CreateSecret(ctx context.Context, r *api.CreateSecretRequest) error {
+	_, err := ms.CreateSecretWithVersion(ctx, r)
+	return err
+}
+
+func (ms *MemoryStore) CreateSecretWithVersion(ctx context.Context, r *api.CreateSecretRequest) (string, error) {
+	if err := validateCreateRequest(r); err != nil {
+		return "", err
+	}
+	if err := checkSize(r.SecretID, r.Token, 0); err != nil {
+		return "", err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.secrets[r.SecretID]; ok {
+		return "", &types.ResourceExistsException{Message: &r.SecretID}
+	}
+
+	ms.secrets[r.SecretID] = memorySecret{value: r.Token, version: 1, lastChanged: time.Now()}
+	return versionID(1), nil
+}
+
+func (ms *MemoryStore) DeleteSecret(ctx context.Context, r *api.DeleteSecretRequest) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.secrets[r.SecretID]; !ok {
+		return notFound(r.SecretID)
+	}
+
+	delete(ms.secrets, r.SecretID)
+	return nil
+}
+
+func (ms *MemoryStore) ListSecrets(ctx context.Context, r *api.ListSecretsRequest) ([]api.SecretSummary, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var secrets []api.SecretSummary
+	for id, s := range ms.secrets {
+		if strings.HasPrefix(id, r.Prefix) {
+			secrets = append(secrets, api.SecretSummary{SecretID: id, LastChangedDate: s.lastChanged})
+		}
+	}
+	slices.SortFunc(secrets, func(a, b api.SecretSummary) int { return strings.Compare(a.SecretID, b.SecretID) })
+
+	return secrets, nil
+}
+
+func (ms *MemoryStore) ResolveSecretID(ctx context.Context, r *api.ResolveSecretRequest) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	secretID := r.SecretID().String()
+	if _, ok := ms.secrets[secretID]; !ok {
+		return secretID, notFound(secretID)
+	}
+
+	return secretID, nil
+}
+
+func versionID(version int) string {
+	return fmt.Sprintf("v%d", version)
+}
+
+func notFound(secretID string) error {
+	msg := fmt.Sprintf("secret %v not found", secretID)
+	return &types.ResourceNotFoundException{Message: &msg}
+}
@@ -0,0 +1,56 @@
+package secret
+
+import (
+	"app/api"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	resolve := &api.ResolveSecretRequest{RootDomain: "root", Domain: "token", UserID: "1"}
+
+	id, err := store.ResolveSecretID(context.Background(), resolve)
+	if !IsErrorResourceNotFound(err) || id != "root/token/1" {
+		t.Fatalf("ResolveSecretID() = %v, %v, want root/token/1 and not found", id, err)
+	}
+
+	if err = store.CreateSecret(context.Background(), &api.CreateSecretRequest{SecretID: id, Token: "v1"}); err != nil {
+		t.Fatalf("CreateSecret() error = %v", err)
+	}
+	if err = store.CreateSecret(context.Background(), &api.CreateSecretRequest{SecretID: id, Token: "v1"}); err == nil {
+		t.Errorf("CreateSecret() of existing secret error = nil, want error")
+	}
+	if _, err = store.ResolveSecretID(context.Background(), resolve); err != nil {
+		t.Errorf("ResolveSecretID() error = %v", err)
+	}
+
+	version, _ := store.GetSecretVersion(context.Background(), &api.GetSecretRequest{SecretID: id})
+	if err = store.PutSecret(context.Background(), &api.PutSecretRequest{SecretID: id, Token: "v2", VersionID: version}); err != nil {
+		t.Errorf("PutSecret() error = %v", err)
+	}
+	err = store.PutSecret(context.Background(), &api.PutSecretRequest{SecretID: id, Token: "v3", VersionID: version})
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("PutSecret() with stale version error = %v, want ErrVersionConflict", err)
+	}
+	if value, _ := store.GetSecret(context.Background(), &api.GetSecretRequest{SecretID: id}); value != "v2" {
+		t.Errorf("GetSecret() = %v, want v2", value)
+	}
+	if meta, _ := store.GetSecretWithMeta(context.Background(), &api.GetSecretRequest{SecretID: id}); meta == nil ||
+		meta.Value != "v2" || meta.VersionID == version || meta.CreatedDate.IsZero() {
+		t.Errorf("GetSecretWithMeta() = %+v, want v2 with a new version", meta)
+	}
+
+	list, _ := store.ListSecrets(context.Background(), &api.ListSecretsRequest{Prefix: "root/token/"})
+	if len(list) != 1 || list[0].SecretID != id {
+		t.Errorf("ListSecrets() = %v, want [%v]", list, id)
+	}
+
+	if err = store.DeleteSecret(context.Background(), &api.DeleteSecretRequest{SecretID: id}); err != nil {
+		t.Errorf("DeleteSecret() error = %v", err)
+	}
+	if _, err = store.GetSecret(context.Background(), &api.GetSecretRequest{SecretID: id}); !IsErrorResourceNotFound(err) {
+		t.Errorf("GetSecret() after delete error = %v, want not found", err)
+	}
+}
@@ -0,0 +1,102 @@
+package secret
+
+import (
+	"app/api"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+type (
+	// Migrator interface defines the behaviour of moving a secret to a new ID, e.g. after a
+	// change of the naming strategy. It takes a MigrateSecretRequest struct pointer as an
+	// argument and returns the MigrateResult or an error.
+	Migrator interface {
+		MigrateSecret(ctx context.Context, r *api.MigrateSecretRequest) (MigrateResult, error)
+	}
+
+	// MigrateResult tells what a migration did: MigrateMoved when the secret was copied to
+	// its new ID and the old one deleted, MigrateDone when it had been migrated before.
+	MigrateResult string
+
+	// StoreMigrator is an implementation of the Migrator interface on top of the Getter,
+	// Creator and Deleter of any store. The old secret is only deleted once the new one has
+	// been read back with the same value, so a migration that fails half-way can be run
+	// again. With DryRun set, secrets are only read, and the result tells what a migration
+	// would do.
+	StoreMigrator struct {
+		Get    Getter
+		Ctr    Creator
+		Del    Deleter
+		DryRun bool
+	}
+)
+
+const (
+	MigrateMoved MigrateResult = "moved"
+	MigrateDone  MigrateResult = "done"
+)
+
+// ErrMigrationConflict is returned by StoreMigrator when the new secret already exists
+// with a value different from the old secret.
+var ErrMigrationConflict = errors.New("new secret exists with a different value")
+
+// MigrateSecret moves the secret OldSecretID to NewSecretID. When the new secret already
+// exists with the same value, e.g. from an interrupted earlier run, only the old secret is
+// deleted. When the old secret no longer exists but the new one does, the secret has been
+// migrated before and MigrateDone is returned.
+func (mg *StoreMigrator) MigrateSecret(ctx context.Context, r *api.MigrateSecretRequest) (MigrateResult, error) {
+	oldValue, oldErr := mg.Get.GetSecret(ctx, &api.GetSecretRequest{SecretID: r.OldSecretID})
+	if oldErr != nil && !IsErrorResourceNotFound(oldErr) {
+		return "", oldErr
+	}
+
+	newValue, newErr := mg.Get.GetSecret(ctx, &api.GetSecretRequest{SecretID: r.NewSecretID})
+	if newErr != nil && !IsErrorResourceNotFound(newErr) {
+		return "", newErr
+	}
+
+	switch {
+	case oldErr != nil && newErr != nil:
+		return "", oldErr
+	case oldErr != nil:
+		return MigrateDone, nil
+	case newErr == nil && newValue != oldValue:
+		slog.Error(fmt.Sprintf("Cannot migrate secret %v, %v exists with a different value", r.OldSecretID, r.NewSecretID))
+		return "", ErrMigrationConflict
+	}
+
+	if mg.DryRun {
+		return MigrateMoved, nil
+	}
+
+	if newErr != nil {
+		if err := mg.Ctr.CreateSecret(ctx, &api.CreateSecretRequest{SecretID: r.NewSecretID, Token: oldValue}); err != nil {
+			return "", err
+		}
+		if err := mg.verify(ctx, r.NewSecretID, oldValue); err != nil {
+			return "", err
+		}
+	}
+
+	if err := mg.Del.DeleteSecret(ctx, &api.DeleteSecretRequest{SecretID: r.OldSecretID}); err != nil {
+		return "", err
+	}
+	slog.Info(fmt.Sprintf("Migrated secret %v to %v", r.OldSecretID, r.NewSecretID))
+
+	return MigrateMoved, nil
+}
+
+// verify reads the created secret back and compares it to the value it was created with.
+func (mg *StoreMigrator) verify(ctx context.Context, secretID string, want string) error {
+	got, err := mg.Get.GetSecret(ctx, &api.GetSecretRequest{SecretID: secretID})
+	if err != nil {
+		return fmt.Errorf("unable to verify migrated secret %v: %w", secretID, err)
+	}
+	if got != want {
+		return fmt.Errorf("migrated secret %v does not match: %w", secretID, ErrMigrationConflict)
+	}
+
+	return nil
+}
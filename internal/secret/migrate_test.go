@@ -0,0 +1,100 @@
+package secret
+
+import (
+	"app/api"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStoreMigrator_MigrateSecret(t *testing.T) {
+	tests := []struct {
+		name      string
+		secrets   map[string]string
+		dryRun    bool
+		want      MigrateResult
+		wantErr   error
+		wantAfter map[string]string
+	}{
+		{
+			name:      "MigrateMovesSecret",
+			secrets:   map[string]string{"old": "token"},
+			want:      MigrateMoved,
+			wantAfter: map[string]string{"new": "token"},
+		},
+		{
+			name:      "MigrateNewExistsWithSameValue",
+			secrets:   map[string]string{"old": "token", "new": "token"},
+			want:      MigrateMoved,
+			wantAfter: map[string]string{"new": "token"},
+		},
+		{
+			name:      "MigrateNewExistsWithOtherValue",
+			secrets:   map[string]string{"old": "token", "new": "other"},
+			wantErr:   ErrMigrationConflict,
+			wantAfter: map[string]string{"old": "token", "new": "other"},
+		},
+		{
+			name:      "MigrateAlreadyMigrated",
+			secrets:   map[string]string{"new": "token"},
+			want:      MigrateDone,
+			wantAfter: map[string]string{"new": "token"},
+		},
+		{
+			name:      "MigrateDryRun",
+			secrets:   map[string]string{"old": "token"},
+			dryRun:    true,
+			want:      MigrateMoved,
+			wantAfter: map[string]string{"old": "token"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := NewMemoryStore()
+			for id, value := range tt.secrets {
+				if err := store.CreateSecret(ctx, &api.CreateSecretRequest{SecretID: id, Token: value}); err != nil {
+					t.Fatalf("CreateSecret() error = %v", err)
+				}
+			}
+			mg := StoreMigrator{Get: store, Ctr: store, Del: store, DryRun: tt.dryRun}
+
+			res, err := mg.MigrateSecret(ctx, &api.MigrateSecretRequest{OldSecretID: "old", NewSecretID: "new"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("MigrateSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if res != tt.want {
+				t.Errorf("MigrateSecret() = %q, want %q", res, tt.want)
+			}
+
+			secrets, _ := store.ListSecrets(ctx, &api.ListSecretsRequest{})
+			if len(secrets) != len(tt.wantAfter) {
+				t.Errorf("MigrateSecret() left %v secrets, want %v", len(secrets), len(tt.wantAfter))
+			}
+			for id, want := range tt.wantAfter {
+				if value, _ := store.GetSecret(ctx, &api.GetSecretRequest{SecretID: id}); value != want {
+					t.Errorf("GetSecret(%v) = %q, want %q", id, value, want)
+				}
+			}
+
+			// A migration can be run again and finds nothing left to do.
+			if err == nil && !tt.dryRun {
+				res, err = mg.MigrateSecret(ctx, &api.MigrateSecretRequest{OldSecretID: "old", NewSecretID: "new"})
+				if err != nil || res != MigrateDone {
+					t.Errorf("MigrateSecret() again = %q, %v, want %q", res, err, MigrateDone)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreMigrator_MigrateSecretMissing(t *testing.T) {
+	store := NewMemoryStore()
+	mg := StoreMigrator{Get: store, Ctr: store, Del: store}
+
+	_, err := mg.MigrateSecret(context.Background(), &api.MigrateSecretRequest{OldSecretID: "old", NewSecretID: "new"})
+	if !IsErrorResourceNotFound(err) {
+		t.Errorf("MigrateSecret() error = %v, want not found", err)
+	}
+}
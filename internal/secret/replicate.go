@@ -0,0 +1,110 @@
+package secret
+
+import (
+	"app/api"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// ReplicatingPutter is a Putter that mirrors every write to a Primary Putter to one or more
+// Secondaries, such as an AWSPutter of another region or an in-memory audit copy, for
+// disaster recovery. The Primary is written synchronously and is authoritative: its result
+// is the result of PutSecret, and only a successful write is replicated. The Secondaries are
+// written in the background, without the VersionID of the request since their versions
+// differ from those of the Primary, and a failing secondary is logged but never fails the
+// write. The writes of a secret are queued per secondary and replicated one at a time in
+// the order of the Primary, so a secondary never ends up with an older token than the
+// Primary. Wait blocks until the replications in flight are done.
+type ReplicatingPutter struct {
+	Primary     Putter
+	Secondaries []Putter
+	inFlight    sync.WaitGroup
+	mu          sync.Mutex
+	queues      map[replicaKey][]replication
+}
+
+// replicaKey identifies the queue of the writes of a secret to a secondary.
+type replicaKey struct {
+	secondary int
+	secretID  string
+}
+
+// replication is a write queued for a secondary, with the context it is written with.
+type replication struct {
+	ctx context.Context
+	r   *api.PutSecretRequest
+}
+
+func (rp *ReplicatingPutter) PutSecret(ctx context.Context, r *api.PutSecretRequest) error {
+	_, err := rp.PutSecretWithVersion(ctx, r)
+	return err
+}
+
+// PutSecretWithVersion is PutSecret reporting the VersionId of the Primary, which is empty
+// when the Primary is no VersionPutter.
+func (rp *ReplicatingPutter) PutSecretWithVersion(ctx context.Context, r *api.PutSecretRequest) (string, error) {
+	versionID, err := PutSecretWithVersion(ctx, rp.Primary, r)
+	if err != nil {
+		return "", err
+	}
+
+	// The replication outlives the request, so it must not be cancelled with it.
+	ctx = context.WithoutCancel(ctx)
+	replica := &api.PutSecretRequest{SecretID: r.SecretID, Token: r.Token}
+	for i := range rp.Secondaries {
+		rp.enqueue(replicaKey{secondary: i, secretID: r.SecretID}, replication{ctx: ctx, r: replica})
+	}
+
+	return versionID, nil
+}
+
+// enqueue queues rep behind the writes of key still in flight, and starts replicating them
+// unless that is running already.
+func (rp *ReplicatingPutter) enqueue(key replicaKey, rep replication) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if rp.queues == nil {
+		rp.queues = make(map[replicaKey][]replication)
+	}
+	queue, running := rp.queues[key]
+	rp.queues[key] = append(queue, rep)
+	if running {
+		return
+	}
+
+	rp.inFlight.Add(1)
+	go rp.replicate(key)
+}
+
+// replicate writes the queued writes of key to its secondary in order, until the queue is
+// empty.
+func (rp *ReplicatingPutter) replicate(key replicaKey) {
+	defer rp.inFlight.Done()
+
+	for {
+		rp.mu.Lock()
+		queue := rp.queues[key]
+		if len(queue) == 0 {
+			delete(rp.queues, key)
+			rp.mu.Unlock()
+			return
+		}
+		rep := queue[0]
+		rp.queues[key] = queue[1:]
+		rp.mu.Unlock()
+
+		if err := rp.Secondaries[key.secondary].PutSecret(rep.ctx, rep.r); err != nil {
+			slog.Error(fmt.Sprintf("Unable to replicate secret %v to secondary store %d: %v", key.secretID,
+				key.secondary, err))
+		}
+	}
+}
+
+// Wait blocks until every replication started by PutSecret is done, e.g. before the
+// process exits.
+func (rp *ReplicatingPutter) Wait() {
+	rp.inFlight.Wait()
+}
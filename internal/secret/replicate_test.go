@@ -0,0 +1,154 @@
+package secret
+
+import (
+	"app/api"
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// putterFunc adapts a function to the Putter interface.
+type putterFunc func(r *api.PutSecretRequest) error
+
+func (f putterFunc) PutSecret(_ context.Context, r *api.PutSecretRequest) error {
+	return f(r)
+}
+
+func TestReplicatingPutter_PutSecret(t *testing.T) {
+	errPrimary := errors.New("primary unavailable")
+	errSecondary := errors.New("secondary unavailable")
+
+	tests := []struct {
+		name          string
+		primaryErr    error
+		secondaryErr  error
+		wantErr       error
+		wantPrimary   string
+		wantReplicas  int
+		wantLoggedErr bool
+	}{
+		{
+			name:         "ReplicateSuccess",
+			wantPrimary:  "new",
+			wantReplicas: 2,
+		},
+		{
+			name:        "ReplicatePrimaryFails",
+			primaryErr:  errPrimary,
+			wantErr:     errPrimary,
+			wantPrimary: "old",
+		},
+		{
+			name:          "ReplicateSecondaryFails",
+			secondaryErr:  errSecondary,
+			wantPrimary:   "new",
+			wantReplicas:  1,
+			wantLoggedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			defer slog.SetDefault(slog.Default())
+			slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+			store := NewMemoryStore()
+			ctx := context.Background()
+			if err := store.CreateSecret(ctx, &api.CreateSecretRequest{SecretID: "id", Token: "old"}); err != nil {
+				t.Fatal(err)
+			}
+			replicas := make(chan *api.PutSecretRequest, 2)
+			rp := &ReplicatingPutter{
+				Primary: putterFunc(func(r *api.PutSecretRequest) error {
+					if tt.primaryErr != nil {
+						return tt.primaryErr
+					}
+					return store.PutSecret(ctx, r)
+				}),
+				Secondaries: []Putter{
+					putterFunc(func(r *api.PutSecretRequest) error {
+						replicas <- r
+						return nil
+					}),
+					putterFunc(func(r *api.PutSecretRequest) error {
+						if tt.secondaryErr != nil {
+							return tt.secondaryErr
+						}
+						replicas <- r
+						return nil
+					}),
+				},
+			}
+
+			err := rp.PutSecret(ctx, &api.PutSecretRequest{SecretID: "id", Token: "new", VersionID: "v1"})
+			rp.Wait()
+			close(replicas)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PutSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got, _ := store.GetSecret(ctx, &api.GetSecretRequest{SecretID: "id"})
+			if got != tt.wantPrimary {
+				t.Errorf("PutSecret() primary = %v, want %v", got, tt.wantPrimary)
+			}
+
+			n := 0
+			for r := range replicas {
+				n++
+				if r.SecretID != "id" || r.Token != "new" || r.VersionID != "" {
+					t.Errorf("PutSecret() replicated %+v, want the token without a version", r)
+				}
+			}
+			if n != tt.wantReplicas {
+				t.Errorf("PutSecret() replicated %d times, want %d", n, tt.wantReplicas)
+			}
+			if logged := strings.Contains(logs.String(), errSecondary.Error()); logged != tt.wantLoggedErr {
+				t.Errorf("PutSecret() logged secondary error = %v, want %v: %v", logged, tt.wantLoggedErr, logs.String())
+			}
+		})
+	}
+}
+
+func TestReplicatingPutter_Order(t *testing.T) {
+	ctx := context.Background()
+	primary, store := NewMemoryStore(), NewMemoryStore()
+	for _, s := range []*MemoryStore{primary, store} {
+		if err := s.CreateSecret(ctx, &api.CreateSecretRequest{SecretID: "id", Token: "old"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first replica is held back until the second write is done, so a replication
+	// that is not queued would let it overwrite the second one.
+	release := make(chan struct{})
+	var replicated []string
+	rp := &ReplicatingPutter{
+		Primary: primary,
+		Secondaries: []Putter{putterFunc(func(r *api.PutSecretRequest) error {
+			if r.Token == "first" {
+				<-release
+			}
+			replicated = append(replicated, r.Token)
+			return store.PutSecret(ctx, r)
+		})},
+	}
+	for _, token := range []string{"first", "second"} {
+		if err := rp.PutSecret(ctx, &api.PutSecretRequest{SecretID: "id", Token: token}); err != nil {
+			t.Fatalf("PutSecret() error = %v", err)
+		}
+	}
+	close(release)
+	rp.Wait()
+
+	if !slices.Equal(replicated, []string{"first", "second"}) {
+		t.Errorf("PutSecret() replicated %v, want [first second]", replicated)
+	}
+	if got, _ := store.GetSecret(ctx, &api.GetSecretRequest{SecretID: "id"}); got != "second" {
+		t.Errorf("PutSecret() secondary = %v, want second", got)
+	}
+}
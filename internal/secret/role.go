@@ -0,0 +1,134 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	aw "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"log/slog"
+	"sync"
+)
+
+type (
+	// RoleClients creates Secrets Manager clients that use the temporary credentials of an
+	// assumed IAM role, e.g. of a tenant whose secrets live in another AWS account. There is
+	// one client per role ARN, which renews its credentials through STS before they expire.
+	RoleClients struct {
+		Config      aw.Config
+		STS         stscreds.AssumeRoleAPIClient
+		SessionName string
+
+		mu      sync.Mutex
+		clients map[string]Client
+	}
+
+	// RoleClient is a Client that sends the calls made for a request to the client of the
+	// IAM role stored in the request context by WithRoleARN, and all other calls to Default.
+	RoleClient struct {
+		Default Client
+		Roles   func(roleARN string) Client
+	}
+
+	roleARNKey struct{}
+)
+
+// DefaultRoleSessionName is the session name roles are assumed with, which shows up in the
+// CloudTrail logs of the tenant accounts.
+const DefaultRoleSessionName = "oauth-secret-manager-service"
+
+// NewRoleClients creates RoleClients from the default AWS config, whose credentials are
+// used to assume the roles. The optFns are applied on top of the defaults, like for
+// NewClient.
+func NewRoleClients(optFns ...func(*config.LoadOptions) error) (*RoleClients, error) {
+	conf, err := config.LoadDefaultConfig(context.TODO(), optFns...)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Unable to load SDK config: %v", err))
+		return nil, err
+	}
+
+	return &RoleClients{Config: conf, STS: sts.NewFromConfig(conf), SessionName: DefaultRoleSessionName}, nil
+}
+
+// Client returns the client for roleARN, creating it on first use. The role is assumed
+// lazily, by the first call made through the client.
+func (rc *RoleClients) Client(roleARN string) Client {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if cl, ok := rc.clients[roleARN]; ok {
+		return cl
+	}
+	if rc.clients == nil {
+		rc.clients = map[string]Client{}
+	}
+
+	conf := rc.Config.Copy()
+	conf.Credentials = aw.NewCredentialsCache(stscreds.NewAssumeRoleProvider(rc.STS, roleARN,
+		func(o *stscreds.AssumeRoleOptions) { o.RoleSessionName = rc.SessionName }))
+	cl := sm.NewFromConfig(conf)
+	rc.clients[roleARN] = cl
+
+	return cl
+}
+
+// WithRoleARN returns a copy of ctx in which the calls of a RoleClient use the IAM role
+// roleARN.
+func WithRoleARN(ctx context.Context, roleARN string) context.Context {
+	return context.WithValue(ctx, roleARNKey{}, roleARN)
+}
+
+// RoleARN returns the IAM role stored in ctx by WithRoleARN, if any.
+func RoleARN(ctx context.Context) (string, bool) {
+	roleARN, ok := ctx.Value(roleARNKey{}).(string)
+	return roleARN, ok && roleARN != ""
+}
+
+func (rc *RoleClient) client(ctx context.Context) Client {
+	if roleARN, ok := RoleARN(ctx); ok {
+		return rc.Roles(roleARN)
+	}
+	return rc.Default
+}
+
+func (rc *RoleClient) GetSecretValue(ctx context.Context, input *sm.GetSecretValueInput, optFns ...func(*sm.Options)) (
+	*sm.GetSecretValueOutput, error) {
+	return rc.client(ctx).GetSecretValue(ctx, input, optFns...)
+}
+
+func (rc *RoleClient) PutSecretValue(ctx context.Context, input *sm.PutSecretValueInput, optFns ...func(*sm.Options)) (
+	*sm.PutSecretValueOutput, error) {
+	return rc.client(ctx).PutSecretValue(ctx, input, optFns...)
+}
+
+func (rc *RoleClient) CreateSecret(ctx context.Context, input *sm.CreateSecretInput, optFns ...func(*sm.Options)) (
+	*sm.CreateSecretOutput, error) {
+	return rc.client(ctx).CreateSecret(ctx, input, optFns...)
+}
+
+func (rc *RoleClient) DescribeSecret(ctx context.Context, input *sm.DescribeSecretInput, optFns ...func(*sm.Options)) (
+	*sm.DescribeSecretOutput, error) {
+	return rc.client(ctx).DescribeSecret(ctx, input, optFns...)
+}
+
+func (rc *RoleClient) DeleteSecret(ctx context.Context, input *sm.DeleteSecretInput, optFns ...func(*sm.Options)) (
+	*sm.DeleteSecretOutput, error) {
+	return rc.client(ctx).DeleteSecret(ctx, input, optFns...)
+}
+
+func (rc *RoleClient) ListSecrets(ctx context.Context, input *sm.ListSecretsInput, optFns ...func(*sm.Options)) (
+	*sm.ListSecretsOutput, error) {
+	return rc.client(ctx).ListSecrets(ctx, input, optFns...)
+}
+
+func (rc *RoleClient) UpdateSecretVersionStage(ctx context.Context, input *sm.UpdateSecretVersionStageInput,
+	optFns ...func(*sm.Options)) (*sm.UpdateSecretVersionStageOutput, error) {
+	return rc.client(ctx).UpdateSecretVersionStage(ctx, input, optFns...)
+}
+
+func (rc *RoleClient) ListSecretVersionIds(ctx context.Context, input *sm.ListSecretVersionIdsInput,
+	optFns ...func(*sm.Options)) (*sm.ListSecretVersionIdsOutput, error) {
+	return rc.client(ctx).ListSecretVersionIds(ctx, input, optFns...)
+}
@@ -0,0 +1,108 @@
+package secret
+
+import (
+	"app/api"
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"testing"
+	"time"
+)
+
+// STSStub returns temporary credentials for every role, recording the roles assumed.
+type STSStub struct {
+	assumed []*sts.AssumeRoleInput
+}
+
+func (s *STSStub) AssumeRole(ctx context.Context, input *sts.AssumeRoleInput, opts ...func(*sts.Options)) (
+	*sts.AssumeRoleOutput, error) {
+	s.assumed = append(s.assumed, input)
+	return &sts.AssumeRoleOutput{Credentials: &ststypes.Credentials{
+		AccessKeyId:     aws.String("ASIA-" + aws.ToString(input.RoleArn)),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("session"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour))}}, nil
+}
+
+func TestRoleClients_Client(t *testing.T) {
+	stub := &STSStub{}
+	rc := &RoleClients{Config: aws.Config{Region: "eu-west-1"}, STS: stub, SessionName: DefaultRoleSessionName}
+	roleARN := "arn:aws:iam::111111111111:role/tenant-a"
+
+	cl, ok := rc.Client(roleARN).(*sm.Client)
+	if !ok {
+		t.Fatalf("Client() = %T, want *secretsmanager.Client", rc.Client(roleARN))
+	}
+	creds, err := cl.Options().Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyID != "ASIA-"+roleARN || creds.SessionToken != "session" {
+		t.Errorf("Retrieve() = %v/%v, want the credentials of %v", creds.AccessKeyID, creds.SessionToken, roleARN)
+	}
+	if len(stub.assumed) != 1 || aws.ToString(stub.assumed[0].RoleArn) != roleARN ||
+		aws.ToString(stub.assumed[0].RoleSessionName) != DefaultRoleSessionName {
+		t.Fatalf("AssumeRole() calls = %v, want one for %v", len(stub.assumed), roleARN)
+	}
+
+	if again := rc.Client(roleARN); again != Client(cl) {
+		t.Errorf("Client() created a second client for %v", roleARN)
+	}
+	if _, err = rc.Client(roleARN).(*sm.Client).Options().Credentials.Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if len(stub.assumed) != 1 {
+		t.Errorf("AssumeRole() calls = %v, want the cached credentials reused", len(stub.assumed))
+	}
+	if other := rc.Client("arn:aws:iam::222222222222:role/tenant-b"); other == Client(cl) {
+		t.Errorf("Client() shared the client of %v with another role", roleARN)
+	}
+}
+
+func TestRoleClient(t *testing.T) {
+	stubFor := func(value string) *AWSClientStub {
+		return &AWSClientStub{GetSecretValueFunc: func(ctx context.Context, input *sm.GetSecretValueInput,
+			opts ...func(*sm.Options)) (*sm.GetSecretValueOutput, error) {
+			return &sm.GetSecretValueOutput{SecretString: aws.String(value)}, nil
+		}}
+	}
+	var roles []string
+	rc := &RoleClient{Default: stubFor("default"), Roles: func(roleARN string) Client {
+		roles = append(roles, roleARN)
+		return stubFor(roleARN)
+	}}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{
+			name: "RoleClientDefault",
+			ctx:  context.Background(),
+			want: "default",
+		},
+		{
+			name: "RoleClientRole",
+			ctx:  WithRoleARN(context.Background(), "arn:aws:iam::111111111111:role/tenant-a"),
+			want: "arn:aws:iam::111111111111:role/tenant-a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := (&AWSGetter{Client: rc}).GetSecret(tt.ctx, &api.GetSecretRequest{SecretID: "root/token/1"})
+			if err != nil {
+				t.Fatalf("GetSecret() error = %v", err)
+			}
+			if res != tt.want {
+				t.Errorf("GetSecret() used client %v, want %v", res, tt.want)
+			}
+		})
+	}
+	if len(roles) != 1 {
+		t.Errorf("Roles() calls = %v, want 1", roles)
+	}
+}
@@ -2,6 +2,8 @@ package secret
 
 import (
 	"app/api"
+	"app/env"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -9,7 +11,13 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
 	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 )
 
 type (
@@ -17,26 +25,88 @@ type (
 	// It takes a GetRequest struct pointer as an argument and returns the secret value
 	// or an error.
 	Getter interface {
-		GetSecret(r *api.GetSecretRequest) (string, error)
+		GetSecret(ctx context.Context, r *api.GetSecretRequest) (string, error)
+	}
+
+	// MetaGetter interface defines the behaviour of getting a secret together with the
+	// metadata of the version read, saving a DescribeSecret call when it is needed. It takes
+	// a GetRequest struct pointer as an argument and returns the SecretValue or an error.
+	MetaGetter interface {
+		GetSecretWithMeta(ctx context.Context, r *api.GetSecretRequest) (*api.SecretValue, error)
 	}
 
 	// Putter interface defines the behaviour of putting a secret into the secret manager.
 	// It takes a PutRequest struct pointer as an argument and returns an error.
 	Putter interface {
-		PutSecret(r *api.PutSecretRequest) error
+		PutSecret(ctx context.Context, r *api.PutSecretRequest) error
+	}
+
+	// VersionPutter interface defines the behaviour of putting a secret and reporting the
+	// VersionId Secrets Manager gave the new version, so callers can tell later reads of
+	// that version apart. It takes a PutRequest struct pointer as an argument and returns
+	// the VersionId or an error.
+	VersionPutter interface {
+		PutSecretWithVersion(ctx context.Context, r *api.PutSecretRequest) (string, error)
+	}
+
+	// Versioner interface defines the behaviour of reading the current version of a secret.
+	// It takes a GetSecretRequest struct pointer as an argument and returns the VersionId
+	// of the secret version labelled AWSCURRENT or an error.
+	Versioner interface {
+		GetSecretVersion(ctx context.Context, r *api.GetSecretRequest) (string, error)
 	}
 
 	// Creator interface defines the behaviour of creating a secret in the secret manager.
 	// It takes a PutRequest struct pointer as an argument and returns an error.
 	Creator interface {
-		CreateSecret(r *api.CreateSecretRequest) error
+		CreateSecret(ctx context.Context, r *api.CreateSecretRequest) error
+	}
+
+	// VersionCreator interface defines the behaviour of creating a secret and reporting the
+	// VersionId of its first version. It takes a CreateSecretRequest struct pointer as an
+	// argument and returns the VersionId or an error.
+	VersionCreator interface {
+		CreateSecretWithVersion(ctx context.Context, r *api.CreateSecretRequest) (string, error)
+	}
+
+	// Deleter interface defines the behaviour of deleting a secret from the secret manager.
+	// It takes a DeleteSecretRequest struct pointer as an argument and returns an error.
+	Deleter interface {
+		DeleteSecret(ctx context.Context, r *api.DeleteSecretRequest) error
+	}
+
+	// Lister interface defines the behaviour of listing the secrets in the secret manager.
+	// It takes a ListSecretsRequest struct pointer as an argument and returns a summary of
+	// every matching secret or an error.
+	Lister interface {
+		ListSecrets(ctx context.Context, r *api.ListSecretsRequest) ([]api.SecretSummary, error)
 	}
 
 	// IDResolver interface defines the behaviour of resolving the secret ID from the user ID
 	// and the domain which together with the root domain will form the secret ID. It takes
 	// a ResolveIDRequest struct pointer as an argument and returns the secret ID or an error.
 	IDResolver interface {
-		ResolveSecretID(r *api.ResolveSecretRequest) (string, error)
+		ResolveSecretID(ctx context.Context, r *api.ResolveSecretRequest) (string, error)
+	}
+
+	// Rollbacker interface defines the behaviour of rolling a secret back to its previous
+	// version. It takes a RollbackSecretRequest struct pointer as an argument, makes the
+	// version labelled AWSPREVIOUS the current one and returns its value or an error.
+	Rollbacker interface {
+		RollbackSecret(ctx context.Context, r *api.RollbackSecretRequest) (string, error)
+	}
+
+	// Store is the facade over the single-purpose interfaces above for code that reads and
+	// writes whole secrets: resolving the ID of a secret, creating it, getting and putting
+	// its value and deleting it, all with the api request types. Both the AWSManager and the
+	// MemoryStore implement it; depend on the narrower interfaces where a type only needs
+	// some of the behaviour.
+	Store interface {
+		IDResolver
+		Getter
+		Putter
+		Creator
+		Deleter
 	}
 
 	// Client interface define an abstraction/wrapper around secretsmanager.Client.
@@ -51,6 +121,14 @@ type (
 			*sm.CreateSecretOutput, error)
 		DescribeSecret(context.Context, *sm.DescribeSecretInput, ...func(*sm.Options)) (
 			*sm.DescribeSecretOutput, error)
+		DeleteSecret(context.Context, *sm.DeleteSecretInput, ...func(*sm.Options)) (
+			*sm.DeleteSecretOutput, error)
+		ListSecrets(context.Context, *sm.ListSecretsInput, ...func(*sm.Options)) (
+			*sm.ListSecretsOutput, error)
+		UpdateSecretVersionStage(context.Context, *sm.UpdateSecretVersionStageInput, ...func(*sm.Options)) (
+			*sm.UpdateSecretVersionStageOutput, error)
+		ListSecretVersionIds(context.Context, *sm.ListSecretVersionIdsInput, ...func(*sm.Options)) (
+			*sm.ListSecretVersionIdsOutput, error)
 	}
 
 	AWSManager struct {
@@ -58,27 +136,153 @@ type (
 		AWSPutter
 		AWSCreator
 		AWSResolver
+		AWSDeleter
+		AWSLister
+		AWSRollbacker
 	}
 
 	AWSGetter struct {
 		Client Client
 	}
 
+	// AWSPutter puts new secret values. With MaxVersions set, the staging labels of all but
+	// the MaxVersions newest versions are removed after a put, which deprecates those
+	// versions so Secrets Manager can garbage-collect them. Values longer than MaxSize
+	// bytes, MaxSecretSize when zero, fail with ErrSecretTooLarge. A put with a VersionID
+	// fails with ErrVersionConflict when that is no longer the current version.
 	AWSPutter struct {
-		Client Client
+		Client      Client
+		MaxVersions int
+		MaxSize     int
 	}
 
+	// AWSCreator creates secrets encrypted with the KmsKeyID and labelled with the Tags of
+	// its domain. An empty KmsKeyID falls back to the aws/secretsmanager managed key. The
+	// KmsKeyID of an api.CreateSecretRequest takes precedence over the one of the creator
+	// and its Tags are added to those of the creator, replacing tags with the same key.
+	// Like with AWSPutter, values longer than MaxSize bytes fail with ErrSecretTooLarge.
 	AWSCreator struct {
-		Client Client
+		Client   Client
+		KmsKeyID string
+		Tags     map[string]string
+		MaxSize  int
 	}
 
+	// AWSResolver resolves secret IDs by describing the secret. With Providers set, a
+	// request naming any other provider fails with ErrProviderNotAllowed before Secrets
+	// Manager is called, so a typo cannot create an orphan secret. A secret scheduled for
+	// deletion resolves to an ErrSecretDeleted.
 	AWSResolver struct {
+		Client    Client
+		Providers []string
+	}
+
+	AWSLister struct {
+		Client Client
+	}
+
+	AWSRollbacker struct {
 		Client Client
 	}
+
+	// MultiRegionGetter is an implementation of the Getter interface for secrets that are
+	// replicated to a second region. Reads go to the Primary client, and only fall back to
+	// the Secondary client when the primary failed with an error worth retrying, see
+	// IsErrorRetryable. A missing secret is not retried, as it is missing in both regions.
+	MultiRegionGetter struct {
+		Primary   Client
+		Secondary Client
+	}
+
+	// AWSDeleter schedules secrets for deletion after RecoveryWindowDays, during which they
+	// can still be restored. Zero uses the Secrets Manager default of 30 days.
+	AWSDeleter struct {
+		Client             Client
+		RecoveryWindowDays int64
+	}
+
+	// ErrSecretDeleted is returned by AWSResolver for a secret that is scheduled for
+	// deletion. It can still be restored until DeletionDate, after which it is gone.
+	ErrSecretDeleted struct {
+		SecretID     string
+		DeletionDate time.Time
+	}
 )
 
-func NewClient() (*sm.Client, error) {
-	conf, err := config.LoadDefaultConfig(context.TODO())
+func (e *ErrSecretDeleted) Error() string {
+	return fmt.Sprintf("secret %v is scheduled for deletion on %v", e.SecretID, e.DeletionDate.Format(time.RFC3339))
+}
+
+var (
+	_ Store = (*AWSManager)(nil)
+	_ Store = (*MemoryStore)(nil)
+
+	_ VersionPutter  = (*AWSPutter)(nil)
+	_ VersionCreator = (*AWSCreator)(nil)
+	_ VersionPutter  = (*MemoryStore)(nil)
+	_ VersionCreator = (*MemoryStore)(nil)
+)
+
+// ErrVersionConflict is returned by AWSPutter when the current version of a secret no
+// longer matches the VersionID the caller expected, meaning it was modified concurrently.
+var ErrVersionConflict = errors.New("secret version changed since it was read")
+
+// PendingStage is the private staging label of a version put conditionally by AWSPutter,
+// until AWSCURRENT is moved to it.
+const PendingStage = "SMSPENDING"
+
+// ErrNoPreviousVersion is returned by AWSRollbacker when a secret has no version labelled
+// AWSPREVIOUS to roll back to, e.g. because it was never updated.
+var ErrNoPreviousVersion = errors.New("secret has no previous version")
+
+// ErrProviderNotAllowed is returned by AWSResolver for a provider that is not one of its
+// Providers.
+var ErrProviderNotAllowed = errors.New("provider not allowed")
+
+// ErrEmptySecret is returned when Secrets Manager returns a secret value without a
+// SecretString or SecretBinary.
+var ErrEmptySecret = errors.New("secret value has neither SecretString nor SecretBinary")
+
+// MaxSecretSize is the length in bytes of the longest secret value Secrets Manager accepts.
+const MaxSecretSize = 64 << 10
+
+// ErrSecretTooLarge is returned by the Putter and Creator implementations for a token
+// longer than their size limit, before Secrets Manager is called.
+var ErrSecretTooLarge = errors.New("secret value is too large")
+
+// checkSize returns an ErrSecretTooLarge when token is longer than maxSize bytes, or than
+// MaxSecretSize when maxSize is zero.
+func checkSize(secretID string, token string, maxSize int) error {
+	if maxSize == 0 {
+		maxSize = MaxSecretSize
+	}
+	if len(token) > maxSize {
+		return fmt.Errorf("%w: secret %v would hold %d bytes, at most %d are allowed",
+			ErrSecretTooLarge, secretID, len(token), maxSize)
+	}
+
+	return nil
+}
+
+// ErrInvalidCreateRequest is returned by the Creator implementations for an
+// api.CreateSecretRequest with metadata Secrets Manager would reject, or metadata that
+// contains the token.
+var ErrInvalidCreateRequest = errors.New("invalid create secret request")
+
+// throttlingCodes are the error codes AWS uses when a request is rejected for exceeding the
+// request rate. They are not modelled as typed exceptions by the SDK, so they can only be
+// recognised from the smithy.APIError code.
+var throttlingCodes = map[string]bool{
+	"ThrottlingException":      true,
+	"Throttling":               true,
+	"TooManyRequestsException": true,
+	"RequestLimitExceeded":     true,
+}
+
+// NewClient creates a Secrets Manager client from the default AWS config. The optFns are
+// applied on top of the defaults, e.g. config.WithRegion for a secondary region client.
+func NewClient(optFns ...func(*config.LoadOptions) error) (*sm.Client, error) {
+	conf, err := config.LoadDefaultConfig(context.TODO(), optFns...)
 	if err != nil {
 		slog.Error(fmt.Sprintf("Unable to load SDK config: %v", err))
 		return nil, err
@@ -87,58 +291,537 @@ func NewClient() (*sm.Client, error) {
 	return sm.NewFromConfig(conf), nil
 }
 
-func (gt *AWSGetter) GetSecret(r *api.GetSecretRequest) (string, error) {
-	result, err := gt.Client.GetSecretValue(context.TODO(), &sm.GetSecretValueInput{
-		SecretId: aw.String(r.SecretID)})
+// NewAWSManager wires an AWSManager for a single secret domain, so that secrets created and
+// deleted through it use the KMS key, tags and recovery window configured for that domain.
+func NewAWSManager(cl Client, d env.DomainVars) *AWSManager {
+	return &AWSManager{
+		AWSGetter:     AWSGetter{Client: cl},
+		AWSPutter:     AWSPutter{Client: cl},
+		AWSCreator:    AWSCreator{Client: cl, KmsKeyID: d.KmsKeyID, Tags: d.Tags},
+		AWSResolver:   AWSResolver{Client: cl},
+		AWSDeleter:    AWSDeleter{Client: cl, RecoveryWindowDays: d.RecoveryWindowDays},
+		AWSLister:     AWSLister{Client: cl},
+		AWSRollbacker: AWSRollbacker{Client: cl},
+	}
+}
+
+func (gt *AWSGetter) GetSecret(ctx context.Context, r *api.GetSecretRequest) (string, error) {
+	value, err := gt.GetSecretWithMeta(ctx, r)
+	if err != nil {
+		return "", err
+	}
+
+	return value.Value, nil
+}
+
+func (gt *AWSGetter) GetSecretWithMeta(ctx context.Context, r *api.GetSecretRequest) (*api.SecretValue, error) {
+	input := &sm.GetSecretValueInput{SecretId: aw.String(r.SecretID)}
+	if r.VersionID != "" {
+		input.VersionId = aw.String(r.VersionID)
+	}
+
+	result, err := gt.Client.GetSecretValue(ctx, input)
 	if err != nil {
 		slog.Error(fmt.Sprintf("Unable to gt secret: %v", err))
+		return nil, err
+	}
+
+	value, err := secretValue(r.SecretID, result)
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, err
+	}
+
+	return &api.SecretValue{
+		Value:       value,
+		VersionID:   aw.ToString(result.VersionId),
+		CreatedDate: aw.ToTime(result.CreatedDate)}, nil
+}
+
+func (gt *AWSGetter) GetSecretVersion(ctx context.Context, r *api.GetSecretRequest) (string, error) {
+	versionID, err := currentVersionID(ctx, gt.Client, r.SecretID)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Unable to get secret version: %v", err))
 		return "", err
 	}
 
-	return *result.SecretString, nil
+	return versionID, nil
+}
+
+func (mg *MultiRegionGetter) GetSecret(ctx context.Context, r *api.GetSecretRequest) (string, error) {
+	value, err := (&AWSGetter{Client: mg.Primary}).GetSecret(ctx, r)
+	if err == nil || mg.Secondary == nil || !IsErrorRetryable(err) {
+		return value, err
+	}
+
+	slog.Warn(fmt.Sprintf("Primary region failed, reading secret from secondary region: %v", err))
+	return (&AWSGetter{Client: mg.Secondary}).GetSecret(ctx, r)
+}
+
+func (pt *AWSPutter) PutSecret(ctx context.Context, r *api.PutSecretRequest) error {
+	_, err := pt.PutSecretWithVersion(ctx, r)
+	return err
 }
 
-func (pt *AWSPutter) PutSecret(r *api.PutSecretRequest) error {
-	_, err := pt.Client.PutSecretValue(context.TODO(), &sm.PutSecretValueInput{
-		SecretId:     aw.String(r.SecretID),
-		SecretString: aw.String(r.Token)})
+// PutSecretWithVersion puts the secret and returns the VersionId of the new version.
+func (pt *AWSPutter) PutSecretWithVersion(ctx context.Context, r *api.PutSecretRequest) (string, error) {
+	if err := checkSize(r.SecretID, r.Token, pt.MaxSize); err != nil {
+		slog.Error(fmt.Sprintf("Unable to put secret: %v", err))
+		return "", err
+	}
+
+	var versionID string
+	if r.VersionID != "" {
+		var err error
+		if versionID, err = pt.putConditional(ctx, r); err != nil {
+			return "", err
+		}
+	} else {
+		result, err := pt.Client.PutSecretValue(ctx, &sm.PutSecretValueInput{
+			SecretId:     aw.String(r.SecretID),
+			SecretString: aw.String(r.Token)})
+		if err != nil {
+			slog.Error(fmt.Sprintf("Unable to pt secret: %v", err))
+			return "", err
+		}
+		versionID = aw.ToString(result.VersionId)
+	}
+
+	if pt.MaxVersions > 0 {
+		// The put succeeded, a failed trim is only retried by the next put.
+		if err := trimVersions(ctx, pt.Client, r.SecretID, pt.MaxVersions); err != nil {
+			slog.Warn(fmt.Sprintf("Unable to trim versions of secret %v: %v", r.SecretID, err))
+		}
+	}
+
+	return versionID, nil
+}
+
+// putConditional puts the secret as a new version labelled PendingStage and then moves
+// AWSCURRENT to it from r.VersionID. Secrets Manager refuses to move a label from a version
+// that no longer holds it, so a write landing in between fails with ErrVersionConflict
+// instead of being overwritten, and the new version is left without labels.
+func (pt *AWSPutter) putConditional(ctx context.Context, r *api.PutSecretRequest) (string, error) {
+	current, err := currentVersionID(ctx, pt.Client, r.SecretID)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Unable to pt secret: %v", err))
-		return err
+		slog.Error(fmt.Sprintf("Unable to check secret version: %v", err))
+		return "", err
+	}
+	if current != r.VersionID {
+		slog.Warn(fmt.Sprintf("Secret version is %v, expected %v", current, r.VersionID))
+		return "", ErrVersionConflict
+	}
+
+	result, err := pt.Client.PutSecretValue(ctx, &sm.PutSecretValueInput{
+		SecretId:      aw.String(r.SecretID),
+		SecretString:  aw.String(r.Token),
+		VersionStages: []string{PendingStage}})
+	if err != nil {
+		slog.Error(fmt.Sprintf("Unable to put secret: %v", err))
+		return "", err
+	}
+	versionID := aw.ToString(result.VersionId)
+
+	_, err = pt.Client.UpdateSecretVersionStage(ctx, &sm.UpdateSecretVersionStageInput{
+		SecretId:            aw.String(r.SecretID),
+		VersionStage:        aw.String("AWSCURRENT"),
+		MoveToVersionId:     aw.String(versionID),
+		RemoveFromVersionId: aw.String(r.VersionID)})
+	var invalid *types.InvalidParameterException
+	if errors.As(err, &invalid) {
+		slog.Warn(fmt.Sprintf("Secret %v moved on from version %v during the put", r.SecretID, r.VersionID))
+		err = ErrVersionConflict
+	}
+
+	// The new version is now current or abandoned, either way it no longer needs the label.
+	if _, lerr := pt.Client.UpdateSecretVersionStage(ctx, &sm.UpdateSecretVersionStageInput{
+		SecretId:            aw.String(r.SecretID),
+		VersionStage:        aw.String(PendingStage),
+		RemoveFromVersionId: aw.String(versionID)}); lerr != nil {
+		slog.Warn(fmt.Sprintf("Unable to remove %v from secret %v: %v", PendingStage, r.SecretID, lerr))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return versionID, nil
+}
+
+// PutSecretWithVersion puts the secret through p, reporting the VersionId of the new version
+// when p is a VersionPutter and an empty one otherwise.
+func PutSecretWithVersion(ctx context.Context, p Putter, r *api.PutSecretRequest) (string, error) {
+	if vp, ok := p.(VersionPutter); ok {
+		return vp.PutSecretWithVersion(ctx, r)
+	}
+
+	return "", p.PutSecret(ctx, r)
+}
+
+// CreateSecretWithVersion creates the secret through c, reporting the VersionId of its first
+// version when c is a VersionCreator and an empty one otherwise.
+func CreateSecretWithVersion(ctx context.Context, c Creator, r *api.CreateSecretRequest) (string, error) {
+	if vc, ok := c.(VersionCreator); ok {
+		return vc.CreateSecretWithVersion(ctx, r)
+	}
+
+	return "", c.CreateSecret(ctx, r)
+}
+
+// trimVersions removes the staging labels from the versions of the secret that
+// trimmedVersions selects.
+func trimVersions(ctx context.Context, cl Client, secretID string, maxVersions int) error {
+	var versions []types.SecretVersionsListEntry
+	pages := sm.NewListSecretVersionIdsPaginator(cl, &sm.ListSecretVersionIdsInput{SecretId: aw.String(secretID)})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, page.Versions...)
+	}
+
+	for versionID, stages := range trimmedVersions(versions, maxVersions) {
+		for _, stage := range stages {
+			_, err := cl.UpdateSecretVersionStage(ctx, &sm.UpdateSecretVersionStageInput{
+				SecretId:            aw.String(secretID),
+				VersionStage:        aw.String(stage),
+				RemoveFromVersionId: aw.String(versionID)})
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
-func (ct *AWSCreator) CreateSecret(r *api.CreateSecretRequest) error {
-	_, err := ct.Client.CreateSecret(context.TODO(), &sm.CreateSecretInput{
-		Name:         aw.String(r.SecretID),
-		SecretString: aw.String(r.Token)})
+// trimmedVersions decides which versions lose their staging labels when a secret keeps at
+// most maxVersions labelled versions: all but the maxVersions newest by creation date,
+// except the AWSCURRENT version, the AWSPREVIOUS version rollbacks restore and a version
+// still PendingStage, which are never trimmed. It returns the staging labels to remove by version ID.
+func trimmedVersions(versions []types.SecretVersionsListEntry, maxVersions int) map[string][]string {
+	versions = slices.Clone(versions)
+	slices.SortFunc(versions, func(a, b types.SecretVersionsListEntry) int {
+		return aw.ToTime(b.CreatedDate).Compare(aw.ToTime(a.CreatedDate))
+	})
+
+	trimmed := map[string][]string{}
+	for i, v := range versions {
+		if i < maxVersions || len(v.VersionStages) == 0 || slices.Contains(v.VersionStages, "AWSCURRENT") ||
+			slices.Contains(v.VersionStages, "AWSPREVIOUS") || slices.Contains(v.VersionStages, PendingStage) {
+			continue
+		}
+		trimmed[aw.ToString(v.VersionId)] = v.VersionStages
+	}
+
+	return trimmed
+}
+
+func (ct *AWSCreator) CreateSecret(ctx context.Context, r *api.CreateSecretRequest) error {
+	_, err := ct.CreateSecretWithVersion(ctx, r)
+	return err
+}
+
+func (ct *AWSCreator) CreateSecretWithVersion(ctx context.Context, r *api.CreateSecretRequest) (string, error) {
+	if err := validateCreateRequest(r); err != nil {
+		slog.Error(fmt.Sprintf("Unable to create secret: %v", err))
+		return "", err
+	}
+	if err := checkSize(r.SecretID, r.Token, ct.MaxSize); err != nil {
+		slog.Error(fmt.Sprintf("Unable to create secret: %v", err))
+		return "", err
+	}
+
+	result, err := ct.Client.CreateSecret(ctx, ct.createInput(r))
 	if err != nil {
 		slog.Error(fmt.Sprintf("Unable to create secret: %v", err))
+		return "", err
+	}
+
+	return aw.ToString(result.VersionId), nil
+}
+
+// createInput assembles the sm.CreateSecretInput of r. The Token of r only goes into the
+// SecretString, unset fields are left nil.
+func (ct *AWSCreator) createInput(r *api.CreateSecretRequest) *sm.CreateSecretInput {
+	input := &sm.CreateSecretInput{
+		Name:         aw.String(r.SecretID),
+		SecretString: aw.String(r.Token)}
+	if r.Description != "" {
+		input.Description = aw.String(r.Description)
+	}
+	if kmsKeyID := cmp.Or(r.KmsKeyID, ct.KmsKeyID); kmsKeyID != "" {
+		input.KmsKeyId = aw.String(kmsKeyID)
+	}
+	if r.ClientRequestToken != "" {
+		input.ClientRequestToken = aw.String(r.ClientRequestToken)
+	}
+
+	tags := maps.Clone(ct.Tags)
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	maps.Copy(tags, r.Tags)
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		input.Tags = append(input.Tags, types.Tag{Key: aw.String(k), Value: aw.String(tags[k])})
+	}
+
+	return input
+}
+
+// Limits of Secrets Manager on the metadata of a secret.
+const (
+	maxDescriptionLength = 2048
+	maxTagKeyLength      = 128
+	maxTagValueLength    = 256
+	minRequestTokenLen   = 32
+	maxRequestTokenLen   = 64
+)
+
+// validateCreateRequest checks the metadata of r against the limits of Secrets Manager and
+// makes sure none of it contains the token, since metadata is readable without
+// secretsmanager:GetSecretValue. Errors wrap ErrInvalidCreateRequest.
+func validateCreateRequest(r *api.CreateSecretRequest) error {
+	leaks := func(s string) bool { return r.Token != "" && strings.Contains(s, r.Token) }
+
+	if len(r.Description) > maxDescriptionLength {
+		return fmt.Errorf("%w: description longer than %d characters", ErrInvalidCreateRequest, maxDescriptionLength)
+	}
+	if leaks(r.Description) {
+		return fmt.Errorf("%w: description contains the token", ErrInvalidCreateRequest)
+	}
+	for k, v := range r.Tags {
+		if k == "" || len(k) > maxTagKeyLength || strings.HasPrefix(strings.ToLower(k), "aws:") {
+			return fmt.Errorf("%w: invalid tag key %q", ErrInvalidCreateRequest, k)
+		}
+		if len(v) > maxTagValueLength {
+			return fmt.Errorf("%w: value of tag %q longer than %d characters", ErrInvalidCreateRequest, k, maxTagValueLength)
+		}
+		if leaks(k) || leaks(v) {
+			return fmt.Errorf("%w: tag %q contains the token", ErrInvalidCreateRequest, k)
+		}
+	}
+	if r.ClientRequestToken != "" &&
+		(len(r.ClientRequestToken) < minRequestTokenLen || len(r.ClientRequestToken) > maxRequestTokenLen) {
+		return fmt.Errorf("%w: client request token must be %d to %d characters",
+			ErrInvalidCreateRequest, minRequestTokenLen, maxRequestTokenLen)
+	}
+	if leaks(r.ClientRequestToken) || leaks(r.KmsKeyID) {
+		return fmt.Errorf("%w: client request token or KMS key ID contains the token", ErrInvalidCreateRequest)
+	}
+
+	return nil
+}
+
+func (dl *AWSDeleter) DeleteSecret(ctx context.Context, r *api.DeleteSecretRequest) error {
+	input := &sm.DeleteSecretInput{SecretId: aw.String(r.SecretID)}
+	if dl.RecoveryWindowDays > 0 {
+		input.RecoveryWindowInDays = aw.Int64(dl.RecoveryWindowDays)
+	}
+
+	_, err := dl.Client.DeleteSecret(ctx, input)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Unable to delete secret: %v", err))
 		return err
 	}
 
 	return nil
 }
 
-func (rs *AWSResolver) ResolveSecretID(r *api.ResolveSecretRequest) (string, error) {
-	secretID := fmt.Sprintf("%v/%v/%v", r.RootDomain, r.Domain, r.UserID)
-	_, err := rs.Client.DescribeSecret(context.TODO(), &sm.DescribeSecretInput{SecretId: aw.String(secretID)})
+func (ls *AWSLister) ListSecrets(ctx context.Context, r *api.ListSecretsRequest) ([]api.SecretSummary, error) {
+	input := &sm.ListSecretsInput{}
+	if r.Prefix != "" {
+		input.Filters = []types.Filter{{Key: types.FilterNameStringTypeName, Values: []string{r.Prefix}}}
+	}
+
+	var secrets []api.SecretSummary
+	pages := sm.NewListSecretsPaginator(ls.Client, input)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Unable to list secrets: %v", err))
+			return nil, err
+		}
+
+		for _, entry := range page.SecretList {
+			secrets = append(secrets, api.SecretSummary{
+				SecretID:        aw.ToString(entry.Name),
+				LastChangedDate: aw.ToTime(entry.LastChangedDate)})
+		}
+	}
+
+	return secrets, nil
+}
+
+// RollbackSecret reads the version labelled AWSPREVIOUS and moves the AWSCURRENT label to
+// it, which makes Secrets Manager label the replaced version AWSPREVIOUS in turn. The value
+// is read before the labels are moved, so a version that cannot be read is not promoted.
+func (rb *AWSRollbacker) RollbackSecret(ctx context.Context, r *api.RollbackSecretRequest) (string, error) {
+	described, err := rb.Client.DescribeSecret(ctx, &sm.DescribeSecretInput{SecretId: aw.String(r.SecretID)})
+	if err != nil {
+		slog.Error(fmt.Sprintf("Unable to describe secret: %v", err))
+		return "", err
+	}
+
+	var currentID, previousID string
+	for versionID, stages := range described.VersionIdsToStages {
+		if slices.Contains(stages, "AWSCURRENT") {
+			currentID = versionID
+		}
+		if slices.Contains(stages, "AWSPREVIOUS") {
+			previousID = versionID
+		}
+	}
+	if previousID == "" {
+		return "", ErrNoPreviousVersion
+	}
+
+	result, err := rb.Client.GetSecretValue(ctx, &sm.GetSecretValueInput{
+		SecretId:  aw.String(r.SecretID),
+		VersionId: aw.String(previousID)})
+	if err != nil {
+		slog.Error(fmt.Sprintf("Unable to get previous secret version: %v", err))
+		return "", err
+	}
+
+	value, err := secretValue(r.SecretID, result)
+	if err != nil {
+		slog.Error(err.Error())
+		return "", err
+	}
+
+	_, err = rb.Client.UpdateSecretVersionStage(ctx, &sm.UpdateSecretVersionStageInput{
+		SecretId:            aw.String(r.SecretID),
+		VersionStage:        aw.String("AWSCURRENT"),
+		MoveToVersionId:     aw.String(previousID),
+		RemoveFromVersionId: aw.String(currentID)})
+	if err != nil {
+		slog.Error(fmt.Sprintf("Unable to promote previous secret version: %v", err))
+		return "", err
+	}
+
+	return value, nil
+}
+
+// secretValue returns the SecretString of a secret value, or its SecretBinary for secrets
+// stored as binary. The SDK already decoded the base64 of SecretBinary. A value with
+// neither, e.g. from a read scoped by permissions, fails with ErrEmptySecret.
+func secretValue(secretID string, result *sm.GetSecretValueOutput) (string, error) {
+	switch {
+	case result.SecretString != nil:
+		return *result.SecretString, nil
+	case result.SecretBinary != nil:
+		return string(result.SecretBinary), nil
+	default:
+		return "", fmt.Errorf("secret %v: %w", secretID, ErrEmptySecret)
+	}
+}
+
+func (rs *AWSResolver) ResolveSecretID(ctx context.Context, r *api.ResolveSecretRequest) (string, error) {
+	if r.Provider != "" && len(rs.Providers) > 0 && !slices.Contains(rs.Providers, r.Provider) {
+		slog.Warn(fmt.Sprintf("Rejected provider %q, allowed are %v", r.Provider, rs.Providers))
+		return "", fmt.Errorf("provider %q: %w", r.Provider, ErrProviderNotAllowed)
+	}
+
+	secretID := r.SecretID().String()
+	result, err := rs.Client.DescribeSecret(ctx, &sm.DescribeSecretInput{SecretId: aw.String(secretID)})
 	if err != nil {
 		slog.Info(fmt.Sprintf("Unable to resolve secret: %v", err))
 		return secretID, err
 	}
+	if result.DeletedDate != nil {
+		return secretID, &ErrSecretDeleted{SecretID: secretID, DeletionDate: *result.DeletedDate}
+	}
 
 	return secretID, nil
 }
 
+// Ping checks that Secrets Manager can be reached with the credentials of cl, by listing
+// at most one secret.
+func Ping(ctx context.Context, cl Client) error {
+	_, err := cl.ListSecrets(ctx, &sm.ListSecretsInput{MaxResults: aw.Int32(1)})
+	return err
+}
+
+// versionIDPattern matches the VersionId of a Secrets Manager secret, 32 to 64 letters,
+// digits and hyphens, usually a UUID.
+var versionIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]{32,64}$`)
+
+// IsValidVersionID reports whether versionID has the format of a Secrets Manager VersionId.
+func IsValidVersionID(versionID string) bool {
+	return versionIDPattern.MatchString(versionID)
+}
+
+// currentVersionID describes the secret and returns the VersionId that currently holds the
+// AWSCURRENT staging label.
+func currentVersionID(ctx context.Context, cl Client, secretID string) (string, error) {
+	result, err := cl.DescribeSecret(ctx, &sm.DescribeSecretInput{SecretId: aw.String(secretID)})
+	if err != nil {
+		return "", err
+	}
+
+	for versionID, stages := range result.VersionIdsToStages {
+		if slices.Contains(stages, "AWSCURRENT") {
+			return versionID, nil
+		}
+	}
+
+	return "", fmt.Errorf("secret %v has no AWSCURRENT version", secretID)
+}
+
 // IsErrorResourceNotFound This function will unwrap a given error and check if
 // it contains types.ResourceNotFoundException. This is an error type that indicates
 // that our application tried to access a secret that does not exist. This is useful
 // to decide if we should create the secret or not if it's some other error type.
 func IsErrorResourceNotFound(err error) bool {
-	var resourceNotFound *types.ResourceNotFoundException
+	return Classify(err) == KindNotFound
+}
+
+// IsErrorLimitExceeded unwraps a given error and checks if it contains
+// types.LimitExceededException. When creating a secret, this means the account has reached
+// its Secrets Manager quota, which needs unused secrets deleted or a quota increase.
+func IsErrorLimitExceeded(err error) bool {
+	return Classify(err) == KindLimitExceeded
+}
+
+// IsErrorThrottling unwraps a given error and checks if it is an AWS API error with one of
+// the throttling error codes, meaning the request was rejected for exceeding the rate limit.
+func IsErrorThrottling(err error) bool {
+	return Classify(err) == KindThrottled
+}
+
+// IsErrorRetryable reports whether a failed request may succeed when retried, possibly
+// against another region. Errors caused by the request itself, such as a missing secret
+// or invalid parameters, are not retryable, with the exception of throttling. Server
+// faults and errors without an AWS response, like connection failures, are retryable.
+func IsErrorRetryable(err error) bool {
+	if IsErrorResourceNotFound(err) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorFault() != smithy.FaultClient || IsErrorThrottling(err)
+	}
+
+	return true
+}
+
+// IsErrorResourceExists unwraps a given error and checks if it contains
+// types.ResourceExistsException. This indicates that our application tried to create a
+// secret that already exists, e.g. because a concurrent save created it first.
+func IsErrorResourceExists(err error) bool {
+	return Classify(err) == KindExists
+}
+
+// RequestID unwraps a given error and returns the ID AWS assigned to the failed request, or
+// an empty string if the error did not come from an AWS response. AWS support needs this
+// ID to investigate a failure.
+func RequestID(err error) string {
+	var reqErr interface{ ServiceRequestID() string }
+	if errors.As(err, &reqErr) {
+		return reqErr.ServiceRequestID()
+	}
 
-	return errors.As(err, &resourceNotFound)
+	return ""
 }
@@ -2,11 +2,22 @@ package secret
 
 import (
 	"app/api"
+	"app/env"
 	"context"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 type AWSClientStub struct {
@@ -18,6 +29,14 @@ type AWSClientStub struct {
 		*sm.CreateSecretOutput, error)
 	DescribeSecretFunc func(context.Context, *sm.DescribeSecretInput, ...func(*sm.Options)) (
 		*sm.DescribeSecretOutput, error)
+	DeleteSecretFunc func(context.Context, *sm.DeleteSecretInput, ...func(*sm.Options)) (
+		*sm.DeleteSecretOutput, error)
+	ListSecretsFunc func(context.Context, *sm.ListSecretsInput, ...func(*sm.Options)) (
+		*sm.ListSecretsOutput, error)
+	UpdateSecretVersionStageFunc func(context.Context, *sm.UpdateSecretVersionStageInput, ...func(*sm.Options)) (
+		*sm.UpdateSecretVersionStageOutput, error)
+	ListSecretVersionIdsFunc func(context.Context, *sm.ListSecretVersionIdsInput, ...func(*sm.Options)) (
+		*sm.ListSecretVersionIdsOutput, error)
 }
 
 func (s *AWSClientStub) GetSecretValue(ctx context.Context, input *sm.GetSecretValueInput, opts ...func(*sm.Options)) (
@@ -40,6 +59,26 @@ func (s *AWSClientStub) DescribeSecret(ctx context.Context, input *sm.DescribeSe
 	return s.DescribeSecretFunc(ctx, input, opts...)
 }
 
+func (s *AWSClientStub) DeleteSecret(ctx context.Context, input *sm.DeleteSecretInput, opts ...func(*sm.Options)) (
+	*sm.DeleteSecretOutput, error) {
+	return s.DeleteSecretFunc(ctx, input, opts...)
+}
+
+func (s *AWSClientStub) ListSecrets(ctx context.Context, input *sm.ListSecretsInput, opts ...func(*sm.Options)) (
+	*sm.ListSecretsOutput, error) {
+	return s.ListSecretsFunc(ctx, input, opts...)
+}
+
+func (s *AWSClientStub) UpdateSecretVersionStage(ctx context.Context, input *sm.UpdateSecretVersionStageInput,
+	opts ...func(*sm.Options)) (*sm.UpdateSecretVersionStageOutput, error) {
+	return s.UpdateSecretVersionStageFunc(ctx, input, opts...)
+}
+
+func (s *AWSClientStub) ListSecretVersionIds(ctx context.Context, input *sm.ListSecretVersionIdsInput,
+	opts ...func(*sm.Options)) (*sm.ListSecretVersionIdsOutput, error) {
+	return s.ListSecretVersionIdsFunc(ctx, input, opts...)
+}
+
 func TestAWSManager_GetSecret(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -60,6 +99,42 @@ func TestAWSManager_GetSecret(t *testing.T) {
 			want:    "SecretValue",
 			wantErr: false,
 		},
+		{
+			name: "GetBinarySecret",
+			stub: &AWSClientStub{
+				GetSecretValueFunc: func(ctx context.Context, input *sm.GetSecretValueInput,
+					opts ...func(*sm.Options)) (*sm.GetSecretValueOutput, error) {
+					return &sm.GetSecretValueOutput{SecretBinary: []byte("BinaryValue")}, nil
+				},
+			},
+			request: api.GetSecretRequest{SecretID: "root-domain/domain/userID"},
+			want:    "BinaryValue",
+			wantErr: false,
+		},
+		{
+			name: "GetStringSecretIgnoresBinary",
+			stub: &AWSClientStub{
+				GetSecretValueFunc: func(ctx context.Context, input *sm.GetSecretValueInput,
+					opts ...func(*sm.Options)) (*sm.GetSecretValueOutput, error) {
+					return &sm.GetSecretValueOutput{SecretString: aws.String("SecretValue"), SecretBinary: []byte("BinaryValue")}, nil
+				},
+			},
+			request: api.GetSecretRequest{SecretID: "root-domain/domain/userID"},
+			want:    "SecretValue",
+			wantErr: false,
+		},
+		{
+			name: "GetEmptySecret",
+			stub: &AWSClientStub{
+				GetSecretValueFunc: func(ctx context.Context, input *sm.GetSecretValueInput,
+					opts ...func(*sm.Options)) (*sm.GetSecretValueOutput, error) {
+					return &sm.GetSecretValueOutput{}, nil
+				},
+			},
+			request: api.GetSecretRequest{SecretID: "root-domain/domain/userID"},
+			want:    "",
+			wantErr: true,
+		},
 		{
 			name: "GetNonExistingSecret",
 			stub: &AWSClientStub{
@@ -80,13 +155,227 @@ func TestAWSManager_GetSecret(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			gtr := AWSGetter{Client: tt.stub}
 
-			res, err := gtr.GetSecret(&tt.request)
+			res, err := gtr.GetSecret(context.Background(), &tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if res != tt.want {
+				t.Errorf("GetSecret() = %v, want %v", res, tt.want)
+			}
+		})
+	}
+}
+
+func TestAWSGetter_GetSecretEmpty(t *testing.T) {
+	stub := &AWSClientStub{
+		GetSecretValueFunc: func(ctx context.Context, input *sm.GetSecretValueInput,
+			opts ...func(*sm.Options)) (*sm.GetSecretValueOutput, error) {
+			return &sm.GetSecretValueOutput{}, nil
+		},
+	}
+	getters := map[string]Getter{
+		"AWSGetter":         &AWSGetter{Client: stub},
+		"MultiRegionGetter": &MultiRegionGetter{Primary: stub, Secondary: stub},
+	}
+
+	for name, gtr := range getters {
+		t.Run(name, func(t *testing.T) {
+			_, err := gtr.GetSecret(context.Background(), &api.GetSecretRequest{SecretID: "root-domain/domain/userID"})
+			if !errors.Is(err, ErrEmptySecret) {
+				t.Errorf("GetSecret() error = %v, want ErrEmptySecret", err)
+			}
+		})
+	}
+}
+
+func TestAWSGetter_GetSecretWithMeta(t *testing.T) {
+	created := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		stub    *AWSClientStub
+		want    *api.SecretValue
+		wantErr bool
+	}{
+		{
+			name: "GetSecretWithMeta",
+			stub: &AWSClientStub{
+				GetSecretValueFunc: func(ctx context.Context, input *sm.GetSecretValueInput,
+					opts ...func(*sm.Options)) (*sm.GetSecretValueOutput, error) {
+					return &sm.GetSecretValueOutput{
+						SecretString: aws.String("SecretValue"),
+						VersionId:    aws.String("EXAMPLE1-90ab-cdef-fedc-ba987SECRET1"),
+						CreatedDate:  aws.Time(created)}, nil
+				},
+			},
+			want: &api.SecretValue{Value: "SecretValue", VersionID: "EXAMPLE1-90ab-cdef-fedc-ba987SECRET1", CreatedDate: created},
+		},
+		{
+			name: "GetSecretWithMetaNotFound",
+			stub: &AWSClientStub{
+				GetSecretValueFunc: func(ctx context.Context, input *sm.GetSecretValueInput,
+					opts ...func(*sm.Options)) (*sm.GetSecretValueOutput, error) {
+					return nil, &types.ResourceNotFoundException{}
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gtr := AWSGetter{Client: tt.stub}
+
+			res, err := gtr.GetSecretWithMeta(context.Background(), &api.GetSecretRequest{SecretID: "root-domain/domain/userID"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSecretWithMeta() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want != nil && *res != *tt.want {
+				t.Errorf("GetSecretWithMeta() = %+v, want %+v", res, tt.want)
+			}
+		})
+	}
+}
+
+func TestAWSGetter_GetSecretVersionID(t *testing.T) {
+	const (
+		oldVersion = "EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE"
+		newVersion = "EXAMPLE2-90ab-cdef-fedc-ba987EXAMPLE"
+	)
+	payloads := map[string]string{oldVersion: "OldValue", newVersion: "NewValue"}
+	stub := &AWSClientStub{
+		GetSecretValueFunc: func(ctx context.Context, input *sm.GetSecretValueInput,
+			opts ...func(*sm.Options)) (*sm.GetSecretValueOutput, error) {
+			if input.VersionId == nil {
+				return &sm.GetSecretValueOutput{SecretString: aws.String("NewValue")}, nil
+			}
+			payload, ok := payloads[*input.VersionId]
+			if !ok {
+				return nil, &types.ResourceNotFoundException{}
+			}
+			return &sm.GetSecretValueOutput{SecretString: aws.String(payload)}, nil
+		},
+	}
+
+	tests := []struct {
+		name      string
+		versionID string
+		want      string
+	}{
+		{name: "GetOldVersion", versionID: oldVersion, want: "OldValue"},
+		{name: "GetNewVersion", versionID: newVersion, want: "NewValue"},
+		{name: "GetCurrentVersion", versionID: "", want: "NewValue"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gtr := AWSGetter{Client: stub}
+
+			res, err := gtr.GetSecret(context.Background(), &api.GetSecretRequest{SecretID: "root-domain/domain/userID", VersionID: tt.versionID})
+			if err != nil {
+				t.Fatalf("GetSecret() error = %v", err)
+			}
+			if res != tt.want {
+				t.Errorf("GetSecret() = %v, want %v", res, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsValidVersionID(t *testing.T) {
+	tests := []struct {
+		versionID string
+		want      bool
+	}{
+		{versionID: "EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE", want: true},
+		{versionID: "0123456789abcdef0123456789abcdef", want: true},
+		{versionID: "short", want: false},
+		{versionID: "EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE/../x", want: false},
+		{versionID: "", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := IsValidVersionID(tt.versionID); got != tt.want {
+			t.Errorf("IsValidVersionID(%q) = %v, want %v", tt.versionID, got, tt.want)
+		}
+	}
+}
+
+func TestMultiRegionGetter_GetSecret(t *testing.T) {
+	getSecretStub := func(value string, err error, called *bool) *AWSClientStub {
+		return &AWSClientStub{
+			GetSecretValueFunc: func(ctx context.Context, input *sm.GetSecretValueInput,
+				opts ...func(*sm.Options)) (*sm.GetSecretValueOutput, error) {
+				*called = true
+				if err != nil {
+					return nil, err
+				}
+				return &sm.GetSecretValueOutput{SecretString: aws.String(value)}, nil
+			},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		primaryErr    error
+		want          string
+		wantSecondary bool
+		wantErr       bool
+	}{
+		{
+			name:          "PrimarySucceeds",
+			primaryErr:    nil,
+			want:          "primary",
+			wantSecondary: false,
+			wantErr:       false,
+		},
+		{
+			name:          "PrimaryConnectionErrorFailsOver",
+			primaryErr:    errors.New("dial tcp: connection refused"),
+			want:          "secondary",
+			wantSecondary: true,
+			wantErr:       false,
+		},
+		{
+			name:          "PrimaryServerFaultFailsOver",
+			primaryErr:    &types.InternalServiceError{},
+			want:          "secondary",
+			wantSecondary: true,
+			wantErr:       false,
+		},
+		{
+			name:          "PrimaryNotFoundDoesNotFailOver",
+			primaryErr:    &types.ResourceNotFoundException{},
+			want:          "",
+			wantSecondary: false,
+			wantErr:       true,
+		},
+		{
+			name:          "PrimaryInvalidRequestDoesNotFailOver",
+			primaryErr:    &types.InvalidRequestException{},
+			want:          "",
+			wantSecondary: false,
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var primaryCalled, secondaryCalled bool
+			gtr := MultiRegionGetter{
+				Primary:   getSecretStub("primary", tt.primaryErr, &primaryCalled),
+				Secondary: getSecretStub("secondary", nil, &secondaryCalled),
+			}
+
+			res, err := gtr.GetSecret(context.Background(), &api.GetSecretRequest{SecretID: "root-domain/domain/userID"})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetSecret() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if res != tt.want {
 				t.Errorf("GetSecret() = %v, want %v", res, tt.want)
 			}
+			if !primaryCalled || secondaryCalled != tt.wantSecondary {
+				t.Errorf("GetSecret() secondary called = %v, want %v", secondaryCalled, tt.wantSecondary)
+			}
 		})
 	}
 }
@@ -130,7 +419,7 @@ func TestAWSManager_PutSecret(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			ptr := AWSPutter{Client: tt.stub}
 
-			err := ptr.PutSecret(&tt.request)
+			err := ptr.PutSecret(context.Background(), &tt.request)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("PutSecret() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -138,137 +427,1121 @@ func TestAWSManager_PutSecret(t *testing.T) {
 	}
 }
 
-func TestAWSManager_CreateSecret(t *testing.T) {
+func TestAWSManager_SecretTooLarge(t *testing.T) {
+	var calls int
+	stub := &AWSClientStub{
+		PutSecretValueFunc: func(
+			ctx context.Context,
+			input *sm.PutSecretValueInput,
+			opts ...func(*sm.Options)) (*sm.PutSecretValueOutput, error) {
+			calls++
+			return &sm.PutSecretValueOutput{}, nil
+		},
+		CreateSecretFunc: func(
+			ctx context.Context,
+			input *sm.CreateSecretInput,
+			opts ...func(*sm.Options)) (*sm.CreateSecretOutput, error) {
+			calls++
+			return &sm.CreateSecretOutput{}, nil
+		},
+	}
+
 	tests := []struct {
 		name    string
-		stub    *AWSClientStub
-		request api.CreateSecretRequest
+		maxSize int
+		size    int
 		wantErr bool
 	}{
 		{
-			name: "CreateSecretSuccess",
-			stub: &AWSClientStub{
-				CreateSecretFunc: func(
-					ctx context.Context,
-					input *sm.CreateSecretInput,
-					opts ...func(*sm.Options)) (*sm.CreateSecretOutput, error) {
-					return &sm.CreateSecretOutput{}, nil
-				},
-			},
-			request: api.CreateSecretRequest{SecretID: "root-domain/domain/userID", Token: "token"},
-			wantErr: false,
+			name: "SecretSizeAtDefaultLimit",
+			size: MaxSecretSize,
 		},
 		{
-			name: "CreateSecretFailure",
-			stub: &AWSClientStub{
-				CreateSecretFunc: func(
-					ctx context.Context,
-					input *sm.CreateSecretInput,
-					opts ...func(*sm.Options)) (*sm.CreateSecretOutput, error) {
-					return nil, &types.LimitExceededException{}
-				},
-			},
-			request: api.CreateSecretRequest{SecretID: "root-domain/domain/userID", Token: "token"},
+			name:    "SecretSizeOverDefaultLimit",
+			size:    MaxSecretSize + 1,
 			wantErr: true,
 		},
+		{
+			name:    "SecretSizeOverConfiguredLimit",
+			maxSize: 1024,
+			size:    1025,
+			wantErr: true,
+		},
+		{
+			name:    "SecretSizeUnderRaisedLimit",
+			maxSize: 2 * MaxSecretSize,
+			size:    MaxSecretSize + 1,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ctr := AWSCreator{Client: tt.stub}
+			calls = 0
+			mgr := NewAWSManager(stub, env.DomainVars{})
+			mgr.AWSPutter.MaxSize, mgr.AWSCreator.MaxSize = tt.maxSize, tt.maxSize
+			token := strings.Repeat("a", tt.size)
 
-			err := ctr.CreateSecret(&tt.request)
-			if (err != nil) != tt.wantErr {
+			err := mgr.PutSecret(context.Background(), &api.PutSecretRequest{SecretID: "id", Token: token})
+			if errors.Is(err, ErrSecretTooLarge) != tt.wantErr {
+				t.Errorf("PutSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			err = mgr.CreateSecret(context.Background(), &api.CreateSecretRequest{SecretID: "id", Token: token})
+			if errors.Is(err, ErrSecretTooLarge) != tt.wantErr {
 				t.Errorf("CreateSecret() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			wantCalls := 2
+			if tt.wantErr {
+				wantCalls = 0
+			}
+			if calls != wantCalls {
+				t.Errorf("Secrets Manager called %d times, want %d", calls, wantCalls)
+			}
 		})
 	}
 }
 
-func TestAWSManager_ResolveID(t *testing.T) {
+func TestTrimmedVersions(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	version := func(id string, age int, stages ...string) types.SecretVersionsListEntry {
+		return types.SecretVersionsListEntry{
+			VersionId:     aws.String(id),
+			CreatedDate:   aws.Time(created.Add(-time.Duration(age) * time.Hour)),
+			VersionStages: stages}
+	}
+	versions := []types.SecretVersionsListEntry{
+		version("v2", 2, "AWSPREVIOUS"),
+		version("v4", 0, "AWSCURRENT"),
+		version("v1", 3, "audit"),
+		version("v3", 1, "pending"),
+		version("v0", 4),
+	}
+
 	tests := []struct {
-		name    string
-		stub    *AWSClientStub
-		request api.ResolveSecretRequest
-		want    string
-		wantErr bool
+		name        string
+		versions    []types.SecretVersionsListEntry
+		maxVersions int
+		want        map[string][]string
 	}{
 		{
-			name: "ResolveExistingSecretID",
-			stub: &AWSClientStub{
-				DescribeSecretFunc: func(
-					ctx context.Context,
-					input *sm.DescribeSecretInput,
-					opts ...func(*sm.Options)) (*sm.DescribeSecretOutput, error) {
-					return &sm.DescribeSecretOutput{}, nil
-				},
-			},
-			request: api.ResolveSecretRequest{
-				RootDomain: "root-domain",
-				Domain:     "domain",
-				UserID:     "userID",
-			},
-			want:    "root-domain/domain/userID",
-			wantErr: false,
+			name:        "TrimBeyondRetention",
+			versions:    versions,
+			maxVersions: 2,
+			want:        map[string][]string{"v1": {"audit"}},
 		},
 		{
-			name: "ResolveNonExistingSecretID",
-			stub: &AWSClientStub{
-				DescribeSecretFunc: func(
-					ctx context.Context,
-					input *sm.DescribeSecretInput,
-					opts ...func(*sm.Options)) (*sm.DescribeSecretOutput, error) {
-					return nil, &types.ResourceNotFoundException{}
-				},
-			},
-			request: api.ResolveSecretRequest{
-				RootDomain: "root-domain",
-				Domain:     "domain",
-				UserID:     "userID",
-			},
-			want:    "root-domain/domain/userID",
-			wantErr: true,
+			name:        "TrimKeepsPrevious",
+			versions:    []types.SecretVersionsListEntry{version("v2", 1, "AWSCURRENT"), version("v1", 2, "AWSPREVIOUS")},
+			maxVersions: 1,
+			want:        map[string][]string{},
+		},
+		{
+			name:        "TrimKeepsCurrent",
+			versions:    []types.SecretVersionsListEntry{version("v2", 1, "AWSPENDING"), version("v1", 2, "AWSCURRENT")},
+			maxVersions: 1,
+			want:        map[string][]string{},
+		},
+		{
+			name:        "TrimNothingWithinRetention",
+			versions:    versions,
+			maxVersions: 5,
+			want:        map[string][]string{},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			rsr := AWSResolver{Client: tt.stub}
-
-			res, err := rsr.ResolveSecretID(&tt.request)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("ResolveSecretID() error = %v, wantErr %v", err, tt.wantErr)
-			}
-			if res != tt.want {
-				t.Errorf("ResolveSecretID() = %v, want = %v", res, tt.want)
+			got := trimmedVersions(tt.versions, tt.maxVersions)
+			if !maps.EqualFunc(got, tt.want, slices.Equal[[]string]) {
+				t.Errorf("trimmedVersions() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestIsErrorResourceNotFound(t *testing.T) {
+func TestAWSManager_PutSecretTrimsVersions(t *testing.T) {
+	var removed []string
+	pt := AWSPutter{MaxVersions: 1, Client: &AWSClientStub{
+		PutSecretValueFunc: func(ctx context.Context, input *sm.PutSecretValueInput,
+			opts ...func(*sm.Options)) (*sm.PutSecretValueOutput, error) {
+			return &sm.PutSecretValueOutput{}, nil
+		},
+		ListSecretVersionIdsFunc: func(ctx context.Context, input *sm.ListSecretVersionIdsInput,
+			opts ...func(*sm.Options)) (*sm.ListSecretVersionIdsOutput, error) {
+			return &sm.ListSecretVersionIdsOutput{Versions: []types.SecretVersionsListEntry{
+				{VersionId: aws.String("v3"), CreatedDate: aws.Time(time.Unix(3, 0)), VersionStages: []string{"AWSCURRENT"}},
+				{VersionId: aws.String("v2"), CreatedDate: aws.Time(time.Unix(2, 0)), VersionStages: []string{"AWSPREVIOUS"}},
+				{VersionId: aws.String("v1"), CreatedDate: aws.Time(time.Unix(1, 0)), VersionStages: []string{"audit"}},
+			}}, nil
+		},
+		UpdateSecretVersionStageFunc: func(ctx context.Context, input *sm.UpdateSecretVersionStageInput,
+			opts ...func(*sm.Options)) (*sm.UpdateSecretVersionStageOutput, error) {
+			if input.MoveToVersionId != nil {
+				return nil, errors.New("trim moved a staging label")
+			}
+			removed = append(removed, aws.ToString(input.VersionStage)+"@"+aws.ToString(input.RemoveFromVersionId))
+			return &sm.UpdateSecretVersionStageOutput{}, nil
+		},
+	}}
+
+	if err := pt.PutSecret(context.Background(), &api.PutSecretRequest{SecretID: "root-domain/domain/userID", Token: "v3"}); err != nil {
+		t.Fatalf("PutSecret() error = %v", err)
+	}
+	if !slices.Equal(removed, []string{"audit@v1"}) {
+		t.Errorf("PutSecret() removed %v, want audit@v1", removed)
+	}
+}
+
+func TestAWSManager_PutSecretVersioned(t *testing.T) {
 	tests := []struct {
-		name string
-		err  error
-		want bool
+		name      string
+		current   string
+		movedTo   string
+		request   api.PutSecretRequest
+		wantPut   bool
+		wantMoved bool
+		wantErr   error
 	}{
 		{
-			name: "ErrorIsResourceNotFound",
-			err:  &types.ResourceNotFoundException{},
-			want: true,
+			name:      "PutSecretVersionMatches",
+			current:   "v1",
+			request:   api.PutSecretRequest{SecretID: "root-domain/domain/userID", Token: "Token", VersionID: "v1"},
+			wantPut:   true,
+			wantMoved: true,
+			wantErr:   nil,
 		},
 		{
-			name: "ErrorIsNotResourceNotFound",
-			err:  &types.InvalidRequestException{},
-			want: false,
+			name:    "PutSecretVersionConflict",
+			current: "v2",
+			request: api.PutSecretRequest{SecretID: "root-domain/domain/userID", Token: "Token", VersionID: "v1"},
+			wantPut: false,
+			wantErr: ErrVersionConflict,
+		},
+		{
+			// Another writer moved AWSCURRENT between the version check and the put.
+			name:    "PutSecretVersionMovedDuringPut",
+			current: "v1",
+			movedTo: "v2",
+			request: api.PutSecretRequest{SecretID: "root-domain/domain/userID", Token: "Token", VersionID: "v1"},
+			wantPut: true,
+			wantErr: ErrVersionConflict,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			res := IsErrorResourceNotFound(tt.err)
-			if res != tt.want {
-				t.Errorf("IsErrorResourceNotFound() = %v, want %v", res, tt.want)
+			put, moved, pendingRemoved := false, false, false
+			current := tt.current
+			ptr := AWSPutter{Client: &AWSClientStub{
+				DescribeSecretFunc: func(
+					ctx context.Context,
+					input *sm.DescribeSecretInput,
+					opts ...func(*sm.Options)) (*sm.DescribeSecretOutput, error) {
+					return &sm.DescribeSecretOutput{VersionIdsToStages: map[string][]string{
+						current: {"AWSCURRENT"},
+						"v0":    {"AWSPREVIOUS"},
+					}}, nil
+				},
+				PutSecretValueFunc: func(
+					ctx context.Context,
+					input *sm.PutSecretValueInput,
+					opts ...func(*sm.Options)) (*sm.PutSecretValueOutput, error) {
+					put = true
+					if !slices.Equal(input.VersionStages, []string{PendingStage}) {
+						t.Errorf("PutSecretValue() stages = %v, want [%v]", input.VersionStages, PendingStage)
+					}
+					if tt.movedTo != "" {
+						current = tt.movedTo
+					}
+					return &sm.PutSecretValueOutput{VersionId: aws.String("v-new")}, nil
+				},
+				UpdateSecretVersionStageFunc: func(
+					ctx context.Context,
+					input *sm.UpdateSecretVersionStageInput,
+					opts ...func(*sm.Options)) (*sm.UpdateSecretVersionStageOutput, error) {
+					switch aws.ToString(input.VersionStage) {
+					case "AWSCURRENT":
+						if aws.ToString(input.RemoveFromVersionId) != current {
+							return nil, &types.InvalidParameterException{}
+						}
+						if aws.ToString(input.MoveToVersionId) != "v-new" {
+							t.Errorf("UpdateSecretVersionStage() moved to %v, want v-new", aws.ToString(input.MoveToVersionId))
+						}
+						moved = true
+					case PendingStage:
+						pendingRemoved = aws.ToString(input.RemoveFromVersionId) == "v-new"
+					}
+					return &sm.UpdateSecretVersionStageOutput{}, nil
+				},
+			}}
+
+			versionID, err := ptr.PutSecretWithVersion(context.Background(), &tt.request)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("PutSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if put != tt.wantPut {
+				t.Errorf("PutSecret() put = %v, wantPut %v", put, tt.wantPut)
+			}
+			if moved != tt.wantMoved {
+				t.Errorf("PutSecret() moved AWSCURRENT = %v, want %v", moved, tt.wantMoved)
+			}
+			if put && !pendingRemoved {
+				t.Errorf("PutSecret() left %v on the new version", PendingStage)
+			}
+			if tt.wantMoved && versionID != "v-new" {
+				t.Errorf("PutSecretWithVersion() = %v, want v-new", versionID)
+			}
+		})
+	}
+}
+
+func TestAWSManager_GetSecretVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		stages  map[string][]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "GetCurrentVersion",
+			stages:  map[string][]string{"v1": {"AWSPREVIOUS"}, "v2": {"AWSCURRENT"}},
+			want:    "v2",
+			wantErr: false,
+		},
+		{
+			name:    "GetMissingCurrentVersion",
+			stages:  map[string][]string{"v1": {"AWSPREVIOUS"}},
+			want:    "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gtr := AWSGetter{Client: &AWSClientStub{
+				DescribeSecretFunc: func(
+					ctx context.Context,
+					input *sm.DescribeSecretInput,
+					opts ...func(*sm.Options)) (*sm.DescribeSecretOutput, error) {
+					return &sm.DescribeSecretOutput{VersionIdsToStages: tt.stages}, nil
+				},
+			}}
+
+			res, err := gtr.GetSecretVersion(context.Background(), &api.GetSecretRequest{SecretID: "root-domain/domain/userID"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetSecretVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if res != tt.want {
+				t.Errorf("GetSecretVersion() = %v, want %v", res, tt.want)
+			}
+		})
+	}
+}
+
+func TestAWSManager_RollbackSecret(t *testing.T) {
+	tests := []struct {
+		name       string
+		stages     map[string][]string
+		getErr     error
+		want       string
+		wantErr    error
+		wantUpdate bool
+	}{
+		{
+			name:       "RollbackToPreviousVersion",
+			stages:     map[string][]string{"v1": {"AWSPREVIOUS"}, "v2": {"AWSCURRENT"}},
+			want:       "PreviousValue",
+			wantUpdate: true,
+		},
+		{
+			name:    "RollbackWithoutPreviousVersion",
+			stages:  map[string][]string{"v1": {"AWSCURRENT"}},
+			wantErr: ErrNoPreviousVersion,
+		},
+		{
+			name:    "RollbackUnreadablePreviousVersion",
+			stages:  map[string][]string{"v1": {"AWSPREVIOUS"}, "v2": {"AWSCURRENT"}},
+			getErr:  &types.ResourceNotFoundException{},
+			wantErr: &types.ResourceNotFoundException{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var update *sm.UpdateSecretVersionStageInput
+			rb := AWSRollbacker{Client: &AWSClientStub{
+				DescribeSecretFunc: func(ctx context.Context, input *sm.DescribeSecretInput,
+					opts ...func(*sm.Options)) (*sm.DescribeSecretOutput, error) {
+					return &sm.DescribeSecretOutput{VersionIdsToStages: tt.stages}, nil
+				},
+				GetSecretValueFunc: func(ctx context.Context, input *sm.GetSecretValueInput,
+					opts ...func(*sm.Options)) (*sm.GetSecretValueOutput, error) {
+					if tt.getErr != nil {
+						return nil, tt.getErr
+					}
+					if aws.ToString(input.VersionId) != "v1" {
+						return nil, fmt.Errorf("read version %v, want v1", aws.ToString(input.VersionId))
+					}
+					return &sm.GetSecretValueOutput{SecretString: aws.String("PreviousValue")}, nil
+				},
+				UpdateSecretVersionStageFunc: func(ctx context.Context, input *sm.UpdateSecretVersionStageInput,
+					opts ...func(*sm.Options)) (*sm.UpdateSecretVersionStageOutput, error) {
+					update = input
+					return &sm.UpdateSecretVersionStageOutput{}, nil
+				},
+			}}
+
+			res, err := rb.RollbackSecret(context.Background(), &api.RollbackSecretRequest{SecretID: "root-domain/domain/userID"})
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("RollbackSecret() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("RollbackSecret() error = %v", err)
+			}
+			if res != tt.want {
+				t.Errorf("RollbackSecret() = %v, want %v", res, tt.want)
+			}
+
+			if !tt.wantUpdate {
+				if update != nil {
+					t.Errorf("RollbackSecret() updated version stages %+v, want no update", update)
+				}
+				return
+			}
+			if update == nil {
+				t.Fatal("RollbackSecret() did not update the version stages")
+			}
+			if aws.ToString(update.SecretId) != "root-domain/domain/userID" ||
+				aws.ToString(update.VersionStage) != "AWSCURRENT" ||
+				aws.ToString(update.MoveToVersionId) != "v1" ||
+				aws.ToString(update.RemoveFromVersionId) != "v2" {
+				t.Errorf("RollbackSecret() moved %v from %v to %v of %v, want AWSCURRENT from v2 to v1",
+					aws.ToString(update.VersionStage), aws.ToString(update.RemoveFromVersionId),
+					aws.ToString(update.MoveToVersionId), aws.ToString(update.SecretId))
+			}
+		})
+	}
+}
+
+func TestAWSManager_CreateSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		stub    *AWSClientStub
+		request api.CreateSecretRequest
+		wantErr bool
+	}{
+		{
+			name: "CreateSecretSuccess",
+			stub: &AWSClientStub{
+				CreateSecretFunc: func(
+					ctx context.Context,
+					input *sm.CreateSecretInput,
+					opts ...func(*sm.Options)) (*sm.CreateSecretOutput, error) {
+					return &sm.CreateSecretOutput{}, nil
+				},
+			},
+			request: api.CreateSecretRequest{SecretID: "root-domain/domain/userID", Token: "token"},
+			wantErr: false,
+		},
+		{
+			name: "CreateSecretFailure",
+			stub: &AWSClientStub{
+				CreateSecretFunc: func(
+					ctx context.Context,
+					input *sm.CreateSecretInput,
+					opts ...func(*sm.Options)) (*sm.CreateSecretOutput, error) {
+					return nil, &types.LimitExceededException{}
+				},
+			},
+			request: api.CreateSecretRequest{SecretID: "root-domain/domain/userID", Token: "token"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctr := AWSCreator{Client: tt.stub}
+
+			err := ctr.CreateSecret(context.Background(), &tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreateSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAWSManager_CreateSecretDomainConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		domain   env.DomainVars
+		wantKey  *string
+		wantTags []types.Tag
+	}{
+		{
+			name:     "CreateSecretDefaultConfig",
+			domain:   env.DomainVars{Name: "token"},
+			wantKey:  nil,
+			wantTags: nil,
+		},
+		{
+			name: "CreateSecretDomainConfig",
+			domain: env.DomainVars{
+				Name:     "apikey",
+				KmsKeyID: "alias/apikey",
+				Tags:     map[string]string{"team": "auth", "env": "prod"},
+			},
+			wantKey: aws.String("alias/apikey"),
+			wantTags: []types.Tag{
+				{Key: aws.String("env"), Value: aws.String("prod")},
+				{Key: aws.String("team"), Value: aws.String("auth")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *sm.CreateSecretInput
+			mgr := NewAWSManager(&AWSClientStub{
+				CreateSecretFunc: func(
+					ctx context.Context,
+					input *sm.CreateSecretInput,
+					opts ...func(*sm.Options)) (*sm.CreateSecretOutput, error) {
+					got = input
+					return &sm.CreateSecretOutput{}, nil
+				},
+			}, tt.domain)
+
+			if err := mgr.CreateSecret(context.Background(), &api.CreateSecretRequest{SecretID: "id", Token: "token"}); err != nil {
+				t.Fatalf("CreateSecret() error = %v", err)
+			}
+			if aws.ToString(got.KmsKeyId) != aws.ToString(tt.wantKey) {
+				t.Errorf("CreateSecret() KmsKeyId = %v, want %v", aws.ToString(got.KmsKeyId), aws.ToString(tt.wantKey))
+			}
+			if len(got.Tags) != len(tt.wantTags) {
+				t.Fatalf("CreateSecret() Tags = %v, want %v", got.Tags, tt.wantTags)
+			}
+			for i, tag := range got.Tags {
+				if *tag.Key != *tt.wantTags[i].Key || *tag.Value != *tt.wantTags[i].Value {
+					t.Errorf("CreateSecret() Tag = %v=%v, want %v=%v",
+						*tag.Key, *tag.Value, *tt.wantTags[i].Key, *tt.wantTags[i].Value)
+				}
+			}
+		})
+	}
+}
+
+func TestAWSCreator_CreateSecretRequestFields(t *testing.T) {
+	const requestToken = "0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name     string
+		request  api.CreateSecretRequest
+		want     *sm.CreateSecretInput
+		wantErr  error
+		wantCall bool
+	}{
+		{
+			name:    "CreateSecretUnsetFields",
+			request: api.CreateSecretRequest{SecretID: "id", Token: "token"},
+			want: &sm.CreateSecretInput{
+				Name:         aws.String("id"),
+				SecretString: aws.String("token"),
+				KmsKeyId:     aws.String("alias/domain"),
+				Tags:         []types.Tag{{Key: aws.String("team"), Value: aws.String("auth")}}},
+			wantCall: true,
+		},
+		{
+			name: "CreateSecretAllFields",
+			request: api.CreateSecretRequest{
+				SecretID:           "id",
+				Token:              `{"access_token":"at"}`,
+				Description:        "OAuth token of user 1",
+				Tags:               map[string]string{"team": "billing", "user": "1"},
+				KmsKeyID:           "alias/user",
+				ClientRequestToken: requestToken},
+			want: &sm.CreateSecretInput{
+				Name:               aws.String("id"),
+				SecretString:       aws.String(`{"access_token":"at"}`),
+				Description:        aws.String("OAuth token of user 1"),
+				KmsKeyId:           aws.String("alias/user"),
+				ClientRequestToken: aws.String(requestToken),
+				Tags: []types.Tag{
+					{Key: aws.String("team"), Value: aws.String("billing")},
+					{Key: aws.String("user"), Value: aws.String("1")},
+				}},
+			wantCall: true,
+		},
+		{
+			name:    "CreateSecretTokenInDescription",
+			request: api.CreateSecretRequest{SecretID: "id", Token: "secret-token", Description: "copy of secret-token"},
+			wantErr: ErrInvalidCreateRequest,
+		},
+		{
+			name:    "CreateSecretTokenInTag",
+			request: api.CreateSecretRequest{SecretID: "id", Token: "secret-token", Tags: map[string]string{"t": "secret-token"}},
+			wantErr: ErrInvalidCreateRequest,
+		},
+		{
+			name:    "CreateSecretReservedTagKey",
+			request: api.CreateSecretRequest{SecretID: "id", Token: "token", Tags: map[string]string{"aws:owner": "me"}},
+			wantErr: ErrInvalidCreateRequest,
+		},
+		{
+			name:    "CreateSecretShortRequestToken",
+			request: api.CreateSecretRequest{SecretID: "id", Token: "token", ClientRequestToken: "retry-1"},
+			wantErr: ErrInvalidCreateRequest,
+		},
+		{
+			name:    "CreateSecretLongDescription",
+			request: api.CreateSecretRequest{SecretID: "id", Token: "token", Description: strings.Repeat("d", 2049)},
+			wantErr: ErrInvalidCreateRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *sm.CreateSecretInput
+			ct := &AWSCreator{
+				Client: &AWSClientStub{
+					CreateSecretFunc: func(
+						ctx context.Context,
+						input *sm.CreateSecretInput,
+						opts ...func(*sm.Options)) (*sm.CreateSecretOutput, error) {
+						got = input
+						return &sm.CreateSecretOutput{}, nil
+					},
+				},
+				KmsKeyID: "alias/domain",
+				Tags:     map[string]string{"team": "auth"},
+			}
+
+			err := ct.CreateSecret(context.Background(), &tt.request)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got != nil) != tt.wantCall {
+				t.Fatalf("CreateSecret() called Secrets Manager = %v, want %v", got != nil, tt.wantCall)
+			}
+			if got == nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CreateSecret() input = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAWSManager_WithVersion(t *testing.T) {
+	mgr := NewAWSManager(&AWSClientStub{
+		PutSecretValueFunc: func(
+			ctx context.Context,
+			input *sm.PutSecretValueInput,
+			opts ...func(*sm.Options)) (*sm.PutSecretValueOutput, error) {
+			return &sm.PutSecretValueOutput{VersionId: aws.String("put-version")}, nil
+		},
+		CreateSecretFunc: func(
+			ctx context.Context,
+			input *sm.CreateSecretInput,
+			opts ...func(*sm.Options)) (*sm.CreateSecretOutput, error) {
+			return &sm.CreateSecretOutput{VersionId: aws.String("create-version")}, nil
+		},
+	}, env.DomainVars{})
+
+	versionID, err := mgr.PutSecretWithVersion(context.Background(), &api.PutSecretRequest{SecretID: "id", Token: "token"})
+	if err != nil || versionID != "put-version" {
+		t.Errorf("PutSecretWithVersion() = %v, %v, want put-version", versionID, err)
+	}
+
+	versionID, err = mgr.CreateSecretWithVersion(context.Background(), &api.CreateSecretRequest{SecretID: "id", Token: "token"})
+	if err != nil || versionID != "create-version" {
+		t.Errorf("CreateSecretWithVersion() = %v, %v, want create-version", versionID, err)
+	}
+}
+
+func TestAWSManager_DeleteSecret(t *testing.T) {
+	tests := []struct {
+		name       string
+		window     int64
+		stubErr    error
+		wantWindow *int64
+		wantErr    bool
+	}{
+		{
+			name:       "DeleteSecretDefaultWindow",
+			window:     0,
+			wantWindow: nil,
+			wantErr:    false,
+		},
+		{
+			name:       "DeleteSecretDomainWindow",
+			window:     7,
+			wantWindow: aws.Int64(7),
+			wantErr:    false,
+		},
+		{
+			name:    "DeleteSecretFailure",
+			stubErr: &types.ResourceNotFoundException{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *sm.DeleteSecretInput
+			dlr := AWSDeleter{RecoveryWindowDays: tt.window, Client: &AWSClientStub{
+				DeleteSecretFunc: func(
+					ctx context.Context,
+					input *sm.DeleteSecretInput,
+					opts ...func(*sm.Options)) (*sm.DeleteSecretOutput, error) {
+					got = input
+					if tt.stubErr != nil {
+						return nil, tt.stubErr
+					}
+					return &sm.DeleteSecretOutput{}, nil
+				},
+			}}
+
+			err := dlr.DeleteSecret(context.Background(), &api.DeleteSecretRequest{SecretID: "root-domain/domain/userID"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DeleteSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && aws.ToInt64(got.RecoveryWindowInDays) != aws.ToInt64(tt.wantWindow) {
+				t.Errorf("DeleteSecret() RecoveryWindowInDays = %v, want %v",
+					aws.ToInt64(got.RecoveryWindowInDays), aws.ToInt64(tt.wantWindow))
+			}
+		})
+	}
+}
+
+func TestAWSManager_ListSecrets(t *testing.T) {
+	changed := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	pages := map[string]*sm.ListSecretsOutput{
+		"": {
+			SecretList: []types.SecretListEntry{{Name: aws.String("root-domain/token/1"), LastChangedDate: &changed}},
+			NextToken:  aws.String("page2"),
+		},
+		"page2": {
+			SecretList: []types.SecretListEntry{{Name: aws.String("root-domain/token/2")}},
+		},
+	}
+
+	var filters []types.Filter
+	lst := AWSLister{Client: &AWSClientStub{
+		ListSecretsFunc: func(
+			ctx context.Context,
+			input *sm.ListSecretsInput,
+			opts ...func(*sm.Options)) (*sm.ListSecretsOutput, error) {
+			filters = input.Filters
+			return pages[aws.ToString(input.NextToken)], nil
+		},
+	}}
+
+	res, err := lst.ListSecrets(context.Background(), &api.ListSecretsRequest{Prefix: "root-domain/token/"})
+	if err != nil {
+		t.Fatalf("ListSecrets() error = %v", err)
+	}
+	want := []api.SecretSummary{
+		{SecretID: "root-domain/token/1", LastChangedDate: changed},
+		{SecretID: "root-domain/token/2"},
+	}
+	if len(res) != len(want) || res[0] != want[0] || res[1] != want[1] {
+		t.Errorf("ListSecrets() = %v, want %v", res, want)
+	}
+	if len(filters) != 1 || filters[0].Values[0] != "root-domain/token/" {
+		t.Errorf("ListSecrets() filters = %v, want name prefix root-domain/token/", filters)
+	}
+}
+
+func TestAWSManager_ResolveID(t *testing.T) {
+	tests := []struct {
+		name    string
+		stub    *AWSClientStub
+		request api.ResolveSecretRequest
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "ResolveExistingSecretID",
+			stub: &AWSClientStub{
+				DescribeSecretFunc: func(
+					ctx context.Context,
+					input *sm.DescribeSecretInput,
+					opts ...func(*sm.Options)) (*sm.DescribeSecretOutput, error) {
+					return &sm.DescribeSecretOutput{}, nil
+				},
+			},
+			request: api.ResolveSecretRequest{
+				RootDomain: "root-domain",
+				Domain:     "domain",
+				UserID:     "userID",
+			},
+			want:    "root-domain/domain/userID",
+			wantErr: false,
+		},
+		{
+			name: "ResolveNonExistingSecretID",
+			stub: &AWSClientStub{
+				DescribeSecretFunc: func(
+					ctx context.Context,
+					input *sm.DescribeSecretInput,
+					opts ...func(*sm.Options)) (*sm.DescribeSecretOutput, error) {
+					return nil, &types.ResourceNotFoundException{}
+				},
+			},
+			request: api.ResolveSecretRequest{
+				RootDomain: "root-domain",
+				Domain:     "domain",
+				UserID:     "userID",
+			},
+			want:    "root-domain/domain/userID",
+			wantErr: true,
+		},
+		{
+			name: "ResolveSecretIDWithEnvironment",
+			stub: &AWSClientStub{
+				DescribeSecretFunc: func(
+					ctx context.Context,
+					input *sm.DescribeSecretInput,
+					opts ...func(*sm.Options)) (*sm.DescribeSecretOutput, error) {
+					return &sm.DescribeSecretOutput{}, nil
+				},
+			},
+			request: api.ResolveSecretRequest{
+				RootDomain:  "root-domain",
+				Environment: "staging",
+				Domain:      "domain",
+				UserID:      "userID",
+			},
+			want:    "root-domain/staging/domain/userID",
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsr := AWSResolver{Client: tt.stub}
+
+			res, err := rsr.ResolveSecretID(context.Background(), &tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ResolveSecretID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if res != tt.want {
+				t.Errorf("ResolveSecretID() = %v, want = %v", res, tt.want)
+			}
+		})
+	}
+}
+
+func TestAWSResolver_AllowedProviders(t *testing.T) {
+	tests := []struct {
+		name      string
+		provider  string
+		want      string
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			name:      "AllowedProviderResolved",
+			provider:  "google",
+			want:      "root-domain/domain/userID/google",
+			wantCalls: 1,
+		},
+		{
+			name:      "NoProviderResolved",
+			want:      "root-domain/domain/userID",
+			wantCalls: 1,
+		},
+		{
+			name:      "DisallowedProviderRejected",
+			provider:  "gogle",
+			wantErr:   ErrProviderNotAllowed,
+			wantCalls: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			stub := &AWSClientStub{
+				DescribeSecretFunc: func(ctx context.Context, input *sm.DescribeSecretInput,
+					opts ...func(*sm.Options)) (*sm.DescribeSecretOutput, error) {
+					calls++
+					return &sm.DescribeSecretOutput{}, nil
+				},
+			}
+			rsr := AWSResolver{Client: stub, Providers: []string{"google", "github"}}
+
+			res, err := rsr.ResolveSecretID(context.Background(), &api.ResolveSecretRequest{
+				RootDomain: "root-domain",
+				Domain:     "domain",
+				UserID:     "userID",
+				Provider:   tt.provider})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ResolveSecretID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if res != tt.want || calls != tt.wantCalls {
+				t.Errorf("ResolveSecretID() = %v with %d calls, want %v with %d calls", res, calls, tt.want, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestAWSResolver_Deleted(t *testing.T) {
+	deletion := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		stub    func() *AWSClientStub
+		wantErr func(error) bool
+	}{
+		{
+			name: "DeletedRecoverable",
+			stub: func() *AWSClientStub {
+				return &AWSClientStub{
+					DescribeSecretFunc: func(ctx context.Context, input *sm.DescribeSecretInput,
+						opts ...func(*sm.Options)) (*sm.DescribeSecretOutput, error) {
+						return &sm.DescribeSecretOutput{Name: input.SecretId, DeletedDate: &deletion}, nil
+					},
+				}
+			},
+			wantErr: func(err error) bool {
+				var deleted *ErrSecretDeleted
+				return errors.As(err, &deleted) && deleted.DeletionDate.Equal(deletion) &&
+					deleted.SecretID == "root-domain/domain/userID"
+			},
+		},
+		{
+			name: "NeverExisted",
+			stub: func() *AWSClientStub {
+				return &AWSClientStub{
+					DescribeSecretFunc: func(ctx context.Context, input *sm.DescribeSecretInput,
+						opts ...func(*sm.Options)) (*sm.DescribeSecretOutput, error) {
+						return nil, &types.ResourceNotFoundException{}
+					},
+				}
+			},
+			wantErr: IsErrorResourceNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsr := AWSResolver{Client: tt.stub()}
+
+			_, err := rsr.ResolveSecretID(context.Background(), &api.ResolveSecretRequest{
+				RootDomain: "root-domain",
+				Domain:     "domain",
+				UserID:     "userID"})
+			if !tt.wantErr(err) {
+				t.Errorf("ResolveSecretID() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{name: "PingReachable", err: nil, wantErr: false},
+		{name: "PingUnreachable", err: &types.InternalServiceError{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &AWSClientStub{
+				ListSecretsFunc: func(ctx context.Context, input *sm.ListSecretsInput,
+					opts ...func(*sm.Options)) (*sm.ListSecretsOutput, error) {
+					if aws.ToInt32(input.MaxResults) != 1 {
+						t.Errorf("Ping() MaxResults = %v, want 1", aws.ToInt32(input.MaxResults))
+					}
+					return &sm.ListSecretsOutput{}, tt.err
+				},
+			}
+
+			if err := Ping(context.Background(), stub); (err != nil) != tt.wantErr {
+				t.Errorf("Ping() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsErrorRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "ResourceNotFound",
+			err:  &types.ResourceNotFoundException{},
+			want: false,
+		},
+		{
+			name: "InvalidParameter",
+			err:  &types.InvalidParameterException{},
+			want: false,
+		},
+		{
+			name: "Throttling",
+			err:  &smithy.GenericAPIError{Code: "ThrottlingException", Fault: smithy.FaultClient},
+			want: true,
+		},
+		{
+			name: "InternalServiceError",
+			err:  &types.InternalServiceError{},
+			want: true,
+		},
+		{
+			name: "ConnectionError",
+			err:  errors.New("dial tcp: connection refused"),
+			want: true,
+		},
+		{
+			name: "Canceled",
+			err:  context.Canceled,
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := IsErrorRetryable(tt.err); res != tt.want {
+				t.Errorf("IsErrorRetryable() = %v, want %v", res, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsErrorResourceNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "ErrorIsResourceNotFound",
+			err:  &types.ResourceNotFoundException{},
+			want: true,
+		},
+		{
+			name: "ErrorIsNotResourceNotFound",
+			err:  &types.InvalidRequestException{},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := IsErrorResourceNotFound(tt.err)
+			if res != tt.want {
+				t.Errorf("IsErrorResourceNotFound() = %v, want %v", res, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsErrorLimitExceeded(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "ErrorIsLimitExceeded",
+			err:  &types.LimitExceededException{},
+			want: true,
+		},
+		{
+			name: "WrappedErrorIsLimitExceeded",
+			err:  fmt.Errorf("create: %w", &types.LimitExceededException{}),
+			want: true,
+		},
+		{
+			name: "ErrorIsNotLimitExceeded",
+			err:  &types.ResourceExistsException{},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsErrorLimitExceeded(tt.err); got != tt.want {
+				t.Errorf("IsErrorLimitExceeded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsErrorResourceExists(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "ErrorIsResourceExists",
+			err:  &types.ResourceExistsException{},
+			want: true,
+		},
+		{
+			name: "WrappedErrorIsResourceExists",
+			err:  fmt.Errorf("create: %w", &types.ResourceExistsException{}),
+			want: true,
+		},
+		{
+			name: "ErrorIsNotResourceExists",
+			err:  &types.ResourceNotFoundException{},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := IsErrorResourceExists(tt.err)
+			if res != tt.want {
+				t.Errorf("IsErrorResourceExists() = %v, want %v", res, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "RequestIDFromResponseError",
+			err: &smithy.OperationError{
+				ServiceID:     "Secrets Manager",
+				OperationName: "GetSecretValue",
+				Err: &awshttp.ResponseError{
+					RequestID:     "req-1",
+					ResponseError: &smithyhttp.ResponseError{Err: &types.InternalServiceError{}},
+				},
+			},
+			want: "req-1",
+		},
+		{
+			name: "RequestIDWrapped",
+			err:  fmt.Errorf("get: %w", &awshttp.ResponseError{RequestID: "req-2", ResponseError: &smithyhttp.ResponseError{}}),
+			want: "req-2",
+		},
+		{
+			name: "RequestIDMissing",
+			err:  errors.New("connection refused"),
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RequestID(tt.err); got != tt.want {
+				t.Errorf("RequestID() = %v, want %v", got, tt.want)
 			}
 		})
 	}
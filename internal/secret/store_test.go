@@ -0,0 +1,122 @@
+package secret
+
+import (
+	"app/api"
+	"app/env"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"testing"
+	"time"
+)
+
+// mapClientStub is an AWSClientStub keeping secret values in a map, which marks deleted
+// secrets with a DeletedDate like Secrets Manager does during the recovery window.
+func mapClientStub() *AWSClientStub {
+	values := map[string]string{}
+	deleted := map[string]time.Time{}
+	notFound := func(id *string) error {
+		return &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("secret %v not found", aws.ToString(id)))}
+	}
+
+	return &AWSClientStub{
+		DescribeSecretFunc: func(ctx context.Context, input *sm.DescribeSecretInput, opts ...func(*sm.Options)) (
+			*sm.DescribeSecretOutput, error) {
+			if _, ok := values[aws.ToString(input.SecretId)]; !ok {
+				return nil, notFound(input.SecretId)
+			}
+			out := &sm.DescribeSecretOutput{Name: input.SecretId}
+			if date, ok := deleted[aws.ToString(input.SecretId)]; ok {
+				out.DeletedDate = &date
+			}
+			return out, nil
+		},
+		CreateSecretFunc: func(ctx context.Context, input *sm.CreateSecretInput, opts ...func(*sm.Options)) (
+			*sm.CreateSecretOutput, error) {
+			if _, ok := values[aws.ToString(input.Name)]; ok {
+				return nil, &types.ResourceExistsException{}
+			}
+			values[aws.ToString(input.Name)] = aws.ToString(input.SecretString)
+			return &sm.CreateSecretOutput{Name: input.Name}, nil
+		},
+		GetSecretValueFunc: func(ctx context.Context, input *sm.GetSecretValueInput, opts ...func(*sm.Options)) (
+			*sm.GetSecretValueOutput, error) {
+			value, ok := values[aws.ToString(input.SecretId)]
+			if !ok {
+				return nil, notFound(input.SecretId)
+			}
+			return &sm.GetSecretValueOutput{SecretString: aws.String(value)}, nil
+		},
+		PutSecretValueFunc: func(ctx context.Context, input *sm.PutSecretValueInput, opts ...func(*sm.Options)) (
+			*sm.PutSecretValueOutput, error) {
+			if _, ok := values[aws.ToString(input.SecretId)]; !ok {
+				return nil, notFound(input.SecretId)
+			}
+			values[aws.ToString(input.SecretId)] = aws.ToString(input.SecretString)
+			return &sm.PutSecretValueOutput{}, nil
+		},
+		DeleteSecretFunc: func(ctx context.Context, input *sm.DeleteSecretInput, opts ...func(*sm.Options)) (
+			*sm.DeleteSecretOutput, error) {
+			if _, ok := values[aws.ToString(input.SecretId)]; !ok {
+				return nil, notFound(input.SecretId)
+			}
+			deleted[aws.ToString(input.SecretId)] = time.Now().AddDate(0, 0, 30)
+			return &sm.DeleteSecretOutput{}, nil
+		},
+	}
+}
+
+func TestStore(t *testing.T) {
+	tests := []struct {
+		name  string
+		store func() Store
+	}{
+		{
+			name: "StoreAWSManager",
+			store: func() Store {
+				return NewAWSManager(mapClientStub(), env.DomainVars{Name: "token"})
+			},
+		},
+		{
+			name: "StoreMemory",
+			store: func() Store {
+				return NewMemoryStore()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := tt.store()
+			req := &api.ResolveSecretRequest{RootDomain: "root", Domain: "token", UserID: "1"}
+
+			secretID, err := st.ResolveSecretID(ctx, req)
+			if !IsErrorResourceNotFound(err) {
+				t.Fatalf("ResolveSecretID() of a missing secret error = %v, want not found", err)
+			}
+			if err := st.CreateSecret(ctx, &api.CreateSecretRequest{SecretID: secretID, Token: "first"}); err != nil {
+				t.Fatalf("CreateSecret() error = %v", err)
+			}
+			if got, err := st.ResolveSecretID(ctx, req); err != nil || got != "root/token/1" {
+				t.Fatalf("ResolveSecretID() = %v, %v, want root/token/1", got, err)
+			}
+
+			if err := st.PutSecret(ctx, &api.PutSecretRequest{SecretID: secretID, Token: "second"}); err != nil {
+				t.Fatalf("PutSecret() error = %v", err)
+			}
+			if got, err := st.GetSecret(ctx, &api.GetSecretRequest{SecretID: secretID}); err != nil || got != "second" {
+				t.Fatalf("GetSecret() = %v, %v, want second", got, err)
+			}
+
+			if err := st.DeleteSecret(ctx, &api.DeleteSecretRequest{SecretID: secretID}); err != nil {
+				t.Fatalf("DeleteSecret() error = %v", err)
+			}
+			if _, err := st.ResolveSecretID(ctx, req); err == nil {
+				t.Errorf("ResolveSecretID() of a deleted secret succeeded")
+			}
+		})
+	}
+}
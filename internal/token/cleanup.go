@@ -0,0 +1,91 @@
+package token
+
+import (
+	"app/api"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// Cleaner deletes stale token secrets on demand, as opposed to the periodic Sweep of the
+// Janitor.
+type Cleaner interface {
+	Cleanup(ctx context.Context, r *api.CleanupRequest) (*api.CleanupResponse, error)
+}
+
+// ErrNoCleanupCutoff is returned by Cleanup when neither cutoff is set, which would
+// otherwise select no secrets at all.
+var ErrNoCleanupCutoff = errors.New("cleanup needs expired_before or changed_before")
+
+// Cleanup lists the token secrets of the janitor's domain and deletes the stale ones: those
+// whose token Expiry lies before ExpiredBefore, or that were last changed before
+// ChangedBefore. Unlike Sweep, expired tokens are deleted even if they have a refresh
+// token. With DryRun set, the stale secrets are only reported. Secrets that cannot be read
+// or deleted are logged and skipped.
+func (j *Janitor) Cleanup(ctx context.Context, r *api.CleanupRequest) (*api.CleanupResponse, error) {
+	if r.ExpiredBefore.IsZero() && r.ChangedBefore.IsZero() {
+		return nil, ErrNoCleanupCutoff
+	}
+
+	secrets, err := j.Lst.ListSecrets(ctx, &api.ListSecretsRequest{
+		Prefix: domainSecretID(j.Env, j.Domain).DomainPrefix()})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &api.CleanupResponse{Candidates: []string{}, DryRun: r.DryRun}
+	for _, s := range secrets {
+		if err = ctx.Err(); err != nil {
+			return res, err
+		}
+
+		stale, versionID, err := j.isStale(ctx, s, r)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Cleanup could not read secret %v: %v", s.SecretID, err))
+			continue
+		}
+		if !stale {
+			continue
+		}
+		res.Candidates = append(res.Candidates, s.SecretID)
+		if r.DryRun {
+			continue
+		}
+
+		ok, err := j.deleteUnchanged(ctx, s.SecretID, versionID)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Cleanup could not delete secret %v: %v", s.SecretID, err))
+			continue
+		}
+		if ok {
+			res.Deleted++
+		}
+	}
+
+	return res, nil
+}
+
+// isStale decides whether the secret matches one of the cutoffs of the request, and
+// returns the version it was decided on. The token is only read when the last changed
+// date alone does not make the secret stale.
+func (j *Janitor) isStale(ctx context.Context, s api.SecretSummary, r *api.CleanupRequest) (bool, string, error) {
+	versionID, err := j.version(ctx, s.SecretID)
+	if err != nil {
+		return false, "", err
+	}
+
+	if !r.ChangedBefore.IsZero() && s.LastChangedDate.Before(r.ChangedBefore) {
+		return true, versionID, nil
+	}
+	if r.ExpiredBefore.IsZero() {
+		return false, versionID, nil
+	}
+
+	tk, err := j.readToken(ctx, s.SecretID)
+	if err != nil {
+		return false, "", err
+	}
+
+	return !tk.Expiry.IsZero() && tk.Expiry.Before(r.ExpiredBefore), versionID, nil
+}
@@ -0,0 +1,106 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestJanitor_Cleanup(t *testing.T) {
+	cutoff := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	recent := cutoff.Add(24 * time.Hour)
+	secrets := map[string]struct {
+		value   string
+		changed time.Time
+	}{
+		"root/token/expired":   {`{"access_token":"a","refresh_token":"r","expiry":"2025-01-01T00:00:00Z"}`, recent},
+		"root/token/valid":     {`{"access_token":"a","expiry":"2025-02-01T00:00:00Z"}`, recent},
+		"root/token/noexpiry":  {`{"access_token":"a"}`, recent},
+		"root/token/orphaned":  {`{"access_token":"a","expiry":"2025-02-01T00:00:00Z"}`, cutoff.Add(-time.Hour)},
+		"root/token/corrupt":   {`invalid JSON`, recent},
+		"root/token/unchanged": {`invalid JSON`, cutoff.Add(-time.Hour)},
+	}
+
+	tests := []struct {
+		name           string
+		request        api.CleanupRequest
+		wantCandidates []string
+		wantDeleted    []string
+		wantErr        error
+	}{
+		{
+			name:           "CleanupExpired",
+			request:        api.CleanupRequest{ExpiredBefore: cutoff},
+			wantCandidates: []string{"root/token/expired"},
+			wantDeleted:    []string{"root/token/expired"},
+		},
+		{
+			name:           "CleanupChanged",
+			request:        api.CleanupRequest{ChangedBefore: cutoff},
+			wantCandidates: []string{"root/token/orphaned", "root/token/unchanged"},
+			wantDeleted:    []string{"root/token/orphaned", "root/token/unchanged"},
+		},
+		{
+			name:           "CleanupBoth",
+			request:        api.CleanupRequest{ExpiredBefore: cutoff, ChangedBefore: cutoff},
+			wantCandidates: []string{"root/token/expired", "root/token/orphaned", "root/token/unchanged"},
+			wantDeleted:    []string{"root/token/expired", "root/token/orphaned", "root/token/unchanged"},
+		},
+		{
+			name:           "CleanupDryRun",
+			request:        api.CleanupRequest{ExpiredBefore: cutoff, ChangedBefore: cutoff, DryRun: true},
+			wantCandidates: []string{"root/token/expired", "root/token/orphaned", "root/token/unchanged"},
+			wantDeleted:    nil,
+		},
+		{
+			name:    "CleanupNoCutoff",
+			request: api.CleanupRequest{DryRun: true},
+			wantErr: ErrNoCleanupCutoff,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deleted []string
+			stub := &SecretFuncStub{
+				ListSecretsFunc: func(request *api.ListSecretsRequest) ([]api.SecretSummary, error) {
+					var list []api.SecretSummary
+					for _, id := range slices.Sorted(maps.Keys(secrets)) {
+						list = append(list, api.SecretSummary{SecretID: id, LastChangedDate: secrets[id].changed})
+					}
+					return list, nil
+				},
+				GetSecretFunc: func(request *api.GetSecretRequest) (string, error) {
+					return secrets[request.SecretID].value, nil
+				},
+				GetSecretVersionFunc: func(request *api.GetSecretRequest) (string, error) {
+					return "v1", nil
+				},
+				DeleteSecretFunc: func(request *api.DeleteSecretRequest) error {
+					deleted = append(deleted, request.SecretID)
+					return nil
+				},
+			}
+			jtr := Janitor{Env: env.AwsVars{SmsRootDomain: "root"}, Lst: stub, Get: stub, Del: stub, Ver: stub}
+
+			res, err := jtr.Cleanup(context.Background(), &tt.request)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Cleanup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !slices.Equal(res.Candidates, tt.wantCandidates) {
+				t.Errorf("Cleanup() candidates = %v, want %v", res.Candidates, tt.wantCandidates)
+			}
+			if !slices.Equal(deleted, tt.wantDeleted) || res.Deleted != len(tt.wantDeleted) {
+				t.Errorf("Cleanup() deleted = %v (%d), want %v", deleted, res.Deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package token
+
+import (
+	"app/api"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type (
+	// DeviceAuthorizer starts the OAuth 2.0 device authorization grant (RFC 8628) for a user
+	// of a headless device, which cannot open a browser itself.
+	DeviceAuthorizer interface {
+		StartDeviceAuth(ctx context.Context, r *api.DeviceAuthRequest) (*oauth2.DeviceAuthResponse, error)
+	}
+
+	// DeviceFlow is the implementation for the DeviceAuthorizer interface. It requests a
+	// user code at the device authorization endpoint of the oauth2.Config, then polls the
+	// token endpoint in the background until the user has authorized the device or the
+	// code expired, and saves the token under the user through the Saver. A poll gives up
+	// after MaxLifetime, DefaultDeviceLifetime when zero, even if the provider reported no
+	// expiry, and stops when the optional Ctx, e.g. that of the server, is cancelled. A
+	// user has at most MaxPollsPerUser, DefaultDevicePollsPerUser when zero, polls running,
+	// further starts fail with ErrTooManyDevicePolls. The optional OnComplete callback is
+	// invoked once per started flow, with its outcome.
+	DeviceFlow struct {
+		Config          *oauth2.Config
+		Svr             Saver
+		Ctx             context.Context
+		MaxLifetime     time.Duration
+		MaxPollsPerUser int
+		OnComplete      func(userID string, err error)
+
+		mu    sync.Mutex
+		polls map[string]int
+	}
+)
+
+// Defaults of the DeviceFlow, used when MaxLifetime or MaxPollsPerUser is zero.
+const (
+	DefaultDeviceLifetime     = 15 * time.Minute
+	DefaultDevicePollsPerUser = 3
+)
+
+// DeviceSaveTimeout bounds the save of an authorized token, which does not end with the
+// poll, so a token authorized just before the device code expired is still stored.
+const DeviceSaveTimeout = 30 * time.Second
+
+// ErrTooManyDevicePolls is returned by DeviceFlow.StartDeviceAuth when the user already
+// waits for MaxPollsPerUser devices to be authorized.
+var ErrTooManyDevicePolls = errors.New("too many device authorizations in progress")
+
+func (df *DeviceFlow) StartDeviceAuth(ctx context.Context, r *api.DeviceAuthRequest) (*oauth2.DeviceAuthResponse, error) {
+	if !df.acquire(r.UserID) {
+		slog.Warn(fmt.Sprintf("User %v already waits for %d device authorizations", r.UserID, df.maxPolls()))
+		return nil, ErrTooManyDevicePolls
+	}
+
+	res, err := df.Config.DeviceAuth(ctx)
+	if err != nil {
+		df.release(r.UserID)
+		slog.Error(fmt.Sprintf("Could not start device authorization: %v", err))
+		return nil, err
+	}
+
+	// The poll outlives the request, but keeps its values, e.g. the role of a tenant.
+	pollCtx, cancel := df.pollContext(context.WithoutCancel(ctx), res)
+	go func() {
+		err := df.poll(pollCtx, r, res)
+		cancel()
+		df.release(r.UserID)
+		if df.OnComplete != nil {
+			df.OnComplete(r.UserID, err)
+		}
+	}()
+
+	return res, nil
+}
+
+// pollContext returns the context of a poll: ctx cancelled with Ctx, and with a deadline
+// at the expiry of the device code but no later than MaxLifetime from now.
+func (df *DeviceFlow) pollContext(ctx context.Context, res *oauth2.DeviceAuthResponse) (context.Context, context.CancelFunc) {
+	deadline := time.Now().Add(cmp.Or(df.MaxLifetime, DefaultDeviceLifetime))
+	if !res.Expiry.IsZero() && res.Expiry.Before(deadline) {
+		deadline = res.Expiry
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	if df.Ctx == nil {
+		return ctx, cancel
+	}
+
+	stop := context.AfterFunc(df.Ctx, cancel)
+	return ctx, func() { stop(); cancel() }
+}
+
+// acquire counts a poll for userID, unless the user already has MaxPollsPerUser running.
+func (df *DeviceFlow) acquire(userID string) bool {
+	df.mu.Lock()
+	defer df.mu.Unlock()
+
+	if df.polls[userID] >= df.maxPolls() {
+		return false
+	}
+	if df.polls == nil {
+		df.polls = map[string]int{}
+	}
+	df.polls[userID]++
+	return true
+}
+
+func (df *DeviceFlow) release(userID string) {
+	df.mu.Lock()
+	defer df.mu.Unlock()
+
+	if df.polls[userID]--; df.polls[userID] <= 0 {
+		delete(df.polls, userID)
+	}
+}
+
+func (df *DeviceFlow) maxPolls() int {
+	return cmp.Or(df.MaxPollsPerUser, DefaultDevicePollsPerUser)
+}
+
+// poll waits for the user to authorize the device and saves the token. It gives up waiting
+// when ctx is done, see pollContext, the save has DeviceSaveTimeout of its own.
+func (df *DeviceFlow) poll(ctx context.Context, r *api.DeviceAuthRequest, res *oauth2.DeviceAuthResponse) error {
+	tk, err := df.Config.DeviceAccessToken(ctx, res)
+	if err == nil {
+		saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DeviceSaveTimeout)
+		defer cancel()
+		_, err = df.Svr.SaveToken(saveCtx, &api.SaveTokenRequest{
+			UserID:       r.UserID,
+			Provider:     r.Provider,
+			TokenType:    tk.TokenType,
+			Scope:        Scope(tk),
+			AccessToken:  tk.AccessToken,
+			RefreshToken: tk.RefreshToken,
+			Expiry:       tk.Expiry})
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Device authorization of user %v failed: %v", r.UserID, err))
+	} else {
+		slog.Info(fmt.Sprintf("Device authorization of user %v completed", r.UserID))
+	}
+
+	return err
+}
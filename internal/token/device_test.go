@@ -0,0 +1,234 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/secret"
+	"context"
+	"encoding/json"
+	"errors"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// deviceProvider fakes the device authorization and token endpoints of a provider. The
+// token endpoint answers authorization_pending pending times, then with tokenError, or
+// with a token when tokenError is empty.
+func deviceProvider(pending int, tokenError string) (*httptest.Server, *atomic.Int32) {
+	var polls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"device_code":      "device-code",
+			"user_code":        "ABCD-EFGH",
+			"verification_uri": "https://provider.example/device",
+			"expires_in":       60,
+			"interval":         1})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("device_code") != "device-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		if int(polls.Add(1)) <= pending {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "authorization_pending"})
+			return
+		}
+		if tokenError != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": tokenError})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "access_token",
+			"refresh_token": "refresh_token",
+			"token_type":    "Bearer",
+			"expires_in":    3600})
+	})
+
+	return httptest.NewServer(mux), &polls
+}
+
+func TestDeviceFlow_StartDeviceAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		pending    int
+		tokenError string
+		wantPolls  int32
+		wantErr    bool
+	}{
+		{
+			name:      "DeviceAuthorizedAfterPending",
+			pending:   1,
+			wantPolls: 2,
+		},
+		{
+			name:       "DeviceAccessDenied",
+			tokenError: "access_denied",
+			wantPolls:  1,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, polls := deviceProvider(tt.pending, tt.tokenError)
+			defer srv.Close()
+
+			vars := env.AwsVars{SmsRootDomain: "root"}
+			store := secret.NewMemoryStore()
+			done := make(chan error, 1)
+			df := &DeviceFlow{
+				Config: &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{
+					DeviceAuthURL: srv.URL + "/device",
+					TokenURL:      srv.URL + "/token",
+					AuthStyle:     oauth2.AuthStyleInParams}},
+				Svr:        &ApiSaver{Env: vars, Res: store, Put: store, Ctr: store},
+				OnComplete: func(userID string, err error) { done <- err },
+			}
+
+			res, err := df.StartDeviceAuth(context.Background(), &api.DeviceAuthRequest{UserID: "1", Provider: "google"})
+			if err != nil {
+				t.Fatalf("StartDeviceAuth() error = %v", err)
+			}
+			if res.UserCode != "ABCD-EFGH" || res.VerificationURI != "https://provider.example/device" {
+				t.Errorf("StartDeviceAuth() = %+v, want the user code and verification URI", res)
+			}
+
+			select {
+			case err = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("device flow did not complete")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("device flow error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := polls.Load(); got != tt.wantPolls {
+				t.Errorf("token endpoint polled %v times, want %v", got, tt.wantPolls)
+			}
+
+			tk, err := (&ApiRetriever{Env: vars, Res: store, Get: store}).RetrieveToken(context.Background(),
+				&api.RetrieveTokenRequest{UserID: "1", Provider: "google"})
+			if tt.wantErr {
+				if !secret.IsErrorResourceNotFound(err) {
+					t.Errorf("RetrieveToken() error = %v, want no token saved", err)
+				}
+				return
+			}
+			if err != nil || tk.AccessToken != "access_token" || tk.RefreshToken != "refresh_token" {
+				t.Errorf("RetrieveToken() = %v, %v, want the authorized token", tk, err)
+			}
+		})
+	}
+}
+
+func TestDeviceFlow_PollLimits(t *testing.T) {
+	srv, _ := deviceProvider(1000, "")
+	defer srv.Close()
+
+	vars := env.AwsVars{SmsRootDomain: "root"}
+	store := secret.NewMemoryStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 4)
+	df := &DeviceFlow{
+		Config: &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{
+			DeviceAuthURL: srv.URL + "/device",
+			TokenURL:      srv.URL + "/token",
+			AuthStyle:     oauth2.AuthStyleInParams}},
+		Svr:             &ApiSaver{Env: vars, Res: store, Put: store, Ctr: store},
+		Ctx:             ctx,
+		MaxPollsPerUser: 1,
+		OnComplete:      func(userID string, err error) { done <- err },
+	}
+
+	if _, err := df.StartDeviceAuth(context.Background(), &api.DeviceAuthRequest{UserID: "1"}); err != nil {
+		t.Fatalf("StartDeviceAuth() error = %v", err)
+	}
+	if _, err := df.StartDeviceAuth(context.Background(), &api.DeviceAuthRequest{UserID: "1"}); !errors.Is(err, ErrTooManyDevicePolls) {
+		t.Errorf("StartDeviceAuth() second poll error = %v, want %v", err, ErrTooManyDevicePolls)
+	}
+	if _, err := df.StartDeviceAuth(context.Background(), &api.DeviceAuthRequest{UserID: "2"}); err != nil {
+		t.Errorf("StartDeviceAuth() other user error = %v", err)
+	}
+
+	cancel()
+	for range 2 {
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("device flow error = %v, want %v", err, context.Canceled)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("device flow did not stop when Ctx was cancelled")
+		}
+	}
+
+	// The cancelled polls no longer count against the user.
+	df.Ctx = context.Background()
+	df.MaxLifetime = 10 * time.Millisecond
+	if _, err := df.StartDeviceAuth(context.Background(), &api.DeviceAuthRequest{UserID: "1"}); err != nil {
+		t.Fatalf("StartDeviceAuth() after the poll stopped error = %v", err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("device flow error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("device flow outlived MaxLifetime")
+	}
+}
+
+// saverFunc is a Saver calling the function.
+type saverFunc func(ctx context.Context, r *api.SaveTokenRequest) (SaveResult, error)
+
+func (f saverFunc) SaveToken(ctx context.Context, r *api.SaveTokenRequest) (SaveResult, error) {
+	return f(ctx, r)
+}
+
+func TestDeviceFlow_SaveOutlivesPoll(t *testing.T) {
+	srv, _ := deviceProvider(0, "")
+	defer srv.Close()
+
+	lifetime := 1500 * time.Millisecond
+	done := make(chan error, 1)
+	df := &DeviceFlow{
+		Config: &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{
+			DeviceAuthURL: srv.URL + "/device",
+			TokenURL:      srv.URL + "/token",
+			AuthStyle:     oauth2.AuthStyleInParams}},
+		Svr: saverFunc(func(ctx context.Context, r *api.SaveTokenRequest) (SaveResult, error) {
+			// The token was authorized right before the poll ended.
+			time.Sleep(lifetime)
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+			if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > DeviceSaveTimeout {
+				t.Errorf("SaveToken() deadline = %v, %v, want at most %v away", deadline, ok, DeviceSaveTimeout)
+			}
+			return "", nil
+		}),
+		MaxLifetime: lifetime,
+		OnComplete:  func(userID string, err error) { done <- err },
+	}
+
+	if _, err := df.StartDeviceAuth(context.Background(), &api.DeviceAuthRequest{UserID: "1"}); err != nil {
+		t.Fatalf("StartDeviceAuth() error = %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("device flow error = %v, want the token saved after the poll ended", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("device flow did not complete")
+	}
+}
@@ -0,0 +1,189 @@
+package token
+
+import (
+	"app/api"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"log/slog"
+)
+
+type (
+	// Exporter exports the token of a user as an api.SealedToken, which only the holder of
+	// the private key it was sealed for can read, e.g. to move it to another service.
+	Exporter interface {
+		ExportToken(ctx context.Context, r *api.ExportTokenRequest) (*api.SealedToken, error)
+	}
+
+	// SealedImporter stores an api.SealedToken exported by another service for this one.
+	SealedImporter interface {
+		ImportToken(ctx context.Context, r *api.ImportTokenRequest) (SaveResult, error)
+	}
+
+	// KeyDecrypter decrypts the content key of an api.SealedToken, encrypted with RSA-OAEP
+	// and SHA-256 for the public key of the service, e.g. a key.AwsRSADecrypter.
+	KeyDecrypter interface {
+		Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+	}
+
+	// ApiSealer is the implementation for the Exporter and SealedImporter interfaces. It
+	// exports the tokens read through the Retriever, so expired tokens are refreshed when
+	// the Retriever does, and imports tokens through the Saver once their content key is
+	// decrypted by the KeyDecrypter. Without a KeyDecrypter it can only export.
+	ApiSealer struct {
+		Ret Retriever
+		Svr Saver
+		Dec KeyDecrypter
+	}
+)
+
+// SealAlgorithm is the Algorithm of the api.SealedToken created by ApiSealer.
+const SealAlgorithm = "RSA-OAEP-256+A256GCM"
+
+// MinSealKeyBits is the size of the smallest RSA public key ApiSealer seals tokens for.
+const MinSealKeyBits = 2048
+
+// ErrInvalidSealKey is returned by ApiSealer.ExportToken for a public key that is not a
+// base64url-encoded DER RSA public key of at least MinSealKeyBits.
+var ErrInvalidSealKey = errors.New("invalid public key to seal token for")
+
+// ErrInvalidSealedToken is returned by ApiSealer.ImportToken for an api.SealedToken that
+// uses another algorithm or was corrupted.
+var ErrInvalidSealedToken = errors.New("invalid sealed token")
+
+func (sl *ApiSealer) ExportToken(ctx context.Context, r *api.ExportTokenRequest) (*api.SealedToken, error) {
+	pub, err := parseSealKey(r.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tk, err := sl.Ret.RetrieveToken(ctx, &api.RetrieveTokenRequest{UserID: r.UserID, Provider: r.Provider})
+	if err != nil {
+		return nil, err
+	}
+
+	return sealToken(tk, pub)
+}
+
+func (sl *ApiSealer) ImportToken(ctx context.Context, r *api.ImportTokenRequest) (SaveResult, error) {
+	tk, err := sl.openToken(ctx, &r.Token)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not import token of user %v: %v", r.UserID, err))
+		return "", err
+	}
+
+	return sl.Svr.SaveToken(ctx, &api.SaveTokenRequest{
+		UserID:       r.UserID,
+		Provider:     r.Provider,
+		TokenType:    tk.TokenType,
+		Scope:        Scope(tk),
+		AccessToken:  tk.AccessToken,
+		RefreshToken: tk.RefreshToken,
+		Expiry:       tk.Expiry})
+}
+
+// parseSealKey parses the base64url-encoded DER public key of an api.ExportTokenRequest.
+func parseSealKey(encoded string) (*rsa.PublicKey, error) {
+	der, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSealKey, err)
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSealKey, err)
+	}
+	pub, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not an RSA public key", ErrInvalidSealKey, parsed)
+	}
+	if pub.N.BitLen() < MinSealKeyBits {
+		return nil, fmt.Errorf("%w: RSA public key of %d bits is too small, want at least %d",
+			ErrInvalidSealKey, pub.N.BitLen(), MinSealKeyBits)
+	}
+
+	return pub, nil
+}
+
+// sealToken encrypts tk for pub as described by api.SealedToken. RSA-OAEP can only encrypt
+// a few hundred bytes, so the token itself is encrypted with a random content key, which
+// is bound to the SealAlgorithm as additional data.
+func sealToken(tk *oauth2.Token, pub *rsa.PublicKey) (*api.SealedToken, error) {
+	plaintext, err := JSONSerializer{}.Marshal(tk)
+	if err != nil {
+		return nil, err
+	}
+
+	contentKey := make([]byte, 32)
+	if _, err = rand.Read(contentKey); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, contentKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.SealedToken{
+		Algorithm:    SealAlgorithm,
+		EncryptedKey: encryptedKey,
+		Nonce:        nonce,
+		Ciphertext:   gcm.Seal(nil, nonce, []byte(plaintext), []byte(SealAlgorithm))}, nil
+}
+
+// openToken decrypts st with the content key decrypted by the KeyDecrypter.
+func (sl *ApiSealer) openToken(ctx context.Context, st *api.SealedToken) (*oauth2.Token, error) {
+	if sl.Dec == nil {
+		return nil, errors.New("no key to decrypt sealed tokens with")
+	}
+	if st.Algorithm != SealAlgorithm {
+		return nil, fmt.Errorf("%w: algorithm %q, want %q", ErrInvalidSealedToken, st.Algorithm, SealAlgorithm)
+	}
+
+	contentKey, err := sl.Dec.Decrypt(ctx, st.EncryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(contentKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSealedToken, err)
+	}
+	if len(st.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: nonce of %d bytes, want %d", ErrInvalidSealedToken, len(st.Nonce), gcm.NonceSize())
+	}
+
+	plaintext, err := gcm.Open(nil, st.Nonce, st.Ciphertext, []byte(SealAlgorithm))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSealedToken, err)
+	}
+
+	return JSONSerializer{}.Unmarshal(string(plaintext))
+}
+
+func newGCM(contentKey []byte) (cipher.AEAD, error) {
+	if len(contentKey) != 32 {
+		return nil, fmt.Errorf("content key of %d bytes, want 32", len(contentKey))
+	}
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
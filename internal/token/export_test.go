@@ -0,0 +1,219 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/key"
+	"app/internal/secret"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sealKeyPair generates an RSA key pair of bits, with its public key encoded the way
+// api.ExportTokenRequest expects it.
+func sealKeyPair(t *testing.T, bits int) (*rsa.PrivateKey, string) {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return priv, encodeSealKey(t, &priv.PublicKey)
+}
+
+func encodeSealKey(t *testing.T, pub any) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.URLEncoding.EncodeToString(der)
+}
+
+// newSealer returns an ApiSealer on its own MemoryStore, which imports with priv.
+func newSealer(priv *rsa.PrivateKey) *ApiSealer {
+	vars := env.AwsVars{SmsRootDomain: "root"}
+	store := secret.NewMemoryStore()
+	sl := &ApiSealer{
+		Ret: &ApiRetriever{Env: vars, Res: store, Get: store},
+		Svr: &ApiSaver{Env: vars, Res: store, Put: store, Ctr: store}}
+	if priv != nil {
+		sl.Dec = &key.RSADecrypter{Key: priv}
+	}
+
+	return sl
+}
+
+func TestApiSealer_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	priv, pub := sealKeyPair(t, 2048)
+
+	from := newSealer(nil)
+	if _, err := from.Svr.SaveToken(ctx, &api.SaveTokenRequest{
+		UserID:       "1",
+		Provider:     "google",
+		TokenType:    "Bearer",
+		Scope:        "email profile",
+		AccessToken:  "access_token",
+		RefreshToken: "refresh_token",
+		Expiry:       expiry}); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := from.ExportToken(ctx, &api.ExportTokenRequest{UserID: "1", Provider: "google", PublicKey: pub})
+	if err != nil {
+		t.Fatalf("ExportToken() error = %v", err)
+	}
+	if sealed.Algorithm != SealAlgorithm {
+		t.Errorf("ExportToken() algorithm = %q, want %q", sealed.Algorithm, SealAlgorithm)
+	}
+	if strings.Contains(string(sealed.Ciphertext), "access_token") {
+		t.Errorf("ExportToken() ciphertext contains the access token in the clear")
+	}
+
+	to := newSealer(priv)
+	if _, err = to.ImportToken(ctx, &api.ImportTokenRequest{UserID: "2", Provider: "google", Token: *sealed}); err != nil {
+		t.Fatalf("ImportToken() error = %v", err)
+	}
+
+	tk, err := to.Ret.RetrieveToken(ctx, &api.RetrieveTokenRequest{UserID: "2", Provider: "google"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.AccessToken != "access_token" || tk.RefreshToken != "refresh_token" || tk.TokenType != "Bearer" ||
+		!tk.Expiry.Equal(expiry) {
+		t.Errorf("ImportToken() stored %+v", tk)
+	}
+	if Scope(tk) != "email profile" {
+		t.Errorf("ImportToken() stored scope %q, want %q", Scope(tk), "email profile")
+	}
+}
+
+func TestApiSealer_ExportToken(t *testing.T) {
+	_, small := sealKeyPair(t, 1024)
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		publicKey string
+	}{
+		{
+			name:      "ExportTokenMalformedKey",
+			publicKey: "not base64!",
+		},
+		{
+			name:      "ExportTokenNotDER",
+			publicKey: base64.URLEncoding.EncodeToString([]byte("not a key")),
+		},
+		{
+			name:      "ExportTokenKeyTooSmall",
+			publicKey: small,
+		},
+		{
+			name:      "ExportTokenNotRSA",
+			publicKey: encodeSealKey(t, &ec.PublicKey),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newSealer(nil).ExportToken(context.Background(),
+				&api.ExportTokenRequest{UserID: "1", PublicKey: tt.publicKey})
+			if !errors.Is(err, ErrInvalidSealKey) {
+				t.Errorf("ExportToken() error = %v, want %v", err, ErrInvalidSealKey)
+			}
+		})
+	}
+}
+
+func TestApiSealer_ImportToken(t *testing.T) {
+	priv, pub := sealKeyPair(t, 2048)
+	_, other := sealKeyPair(t, 2048)
+
+	from := newSealer(nil)
+	if _, err := from.Svr.SaveToken(context.Background(), &api.SaveTokenRequest{
+		UserID: "1", TokenType: "Bearer", AccessToken: "access_token"}); err != nil {
+		t.Fatal(err)
+	}
+	seal := func(publicKey string) api.SealedToken {
+		sealed, err := from.ExportToken(context.Background(), &api.ExportTokenRequest{UserID: "1", PublicKey: publicKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *sealed
+	}
+
+	tampered := seal(pub)
+	tampered.Ciphertext[0] ^= 0xff
+	otherAlg := seal(pub)
+	otherAlg.Algorithm = "RSA1_5+A128CBC"
+	shortNonce := seal(pub)
+	shortNonce.Nonce = shortNonce.Nonce[:4]
+
+	tests := []struct {
+		name    string
+		token   api.SealedToken
+		noKey   bool
+		wantErr error
+	}{
+		{
+			name:    "ImportTokenTampered",
+			token:   tampered,
+			wantErr: ErrInvalidSealedToken,
+		},
+		{
+			name:    "ImportTokenOtherAlgorithm",
+			token:   otherAlg,
+			wantErr: ErrInvalidSealedToken,
+		},
+		{
+			name:    "ImportTokenShortNonce",
+			token:   shortNonce,
+			wantErr: ErrInvalidSealedToken,
+		},
+		{
+			name:    "ImportTokenSealedForOtherKey",
+			token:   seal(other),
+			wantErr: key.ErrUndecryptable,
+		},
+		{
+			name:  "ImportTokenWithoutKey",
+			token: seal(pub),
+			noKey: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sl := newSealer(priv)
+			if tt.noKey {
+				sl.Dec = nil
+			}
+
+			_, err := sl.ImportToken(context.Background(), &api.ImportTokenRequest{UserID: "2", Token: tt.token})
+			if err == nil {
+				t.Fatal("ImportToken() error = nil, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("ImportToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if _, err = sl.Ret.RetrieveToken(context.Background(), &api.RetrieveTokenRequest{UserID: "2"}); err == nil {
+				t.Errorf("ImportToken() stored a token despite the error")
+			}
+		})
+	}
+}
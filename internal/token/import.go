@@ -0,0 +1,154 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/secret"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"io"
+	"sync"
+)
+
+type (
+	// ImportRecord is a single token to import, as found in an import file.
+	ImportRecord struct {
+		UserID   string       `json:"user_id"`
+		Provider string       `json:"provider"`
+		Token    oauth2.Token `json:"token"`
+	}
+
+	// ImportResult is the outcome of importing a single ImportRecord. Outcome is one of
+	// "created", "updated" or "failed", and Error explains a failure.
+	ImportResult struct {
+		UserID   string `json:"user_id"`
+		Provider string `json:"provider,omitempty"`
+		Outcome  string `json:"outcome"`
+		Error    string `json:"error,omitempty"`
+	}
+
+	// ImportReport contains the ImportResult of every record, in the order of the records,
+	// and the number of created, updated and failed records.
+	ImportReport struct {
+		Results []ImportResult `json:"results"`
+		Created int            `json:"created"`
+		Updated int            `json:"updated"`
+		Failed  int            `json:"failed"`
+	}
+
+	// BulkImporter saves many tokens at once and reports the outcome of each.
+	BulkImporter interface {
+		Import(ctx context.Context, records []ImportRecord) ImportReport
+	}
+
+	// Importer saves many tokens at once through a Saver, using up to Concurrency saves in
+	// parallel. With DryRun set, records are only resolved through the secret.IDResolver to
+	// tell whether they would create a new secret or update an existing one, not saved.
+	Importer struct {
+		Env         env.AwsVars
+		Res         secret.IDResolver
+		Svr         Saver
+		Domain      string
+		Concurrency int
+		DryRun      bool
+	}
+)
+
+// DefaultImportConcurrency is the number of tokens an import saves in parallel by default.
+const DefaultImportConcurrency = 4
+
+// ReadImportRecords decodes a JSON array of ImportRecord from r.
+func ReadImportRecords(r io.Reader) ([]ImportRecord, error) {
+	var records []ImportRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, fmt.Errorf("unable to decode import records: %w", err)
+	}
+
+	return records, nil
+}
+
+// Import saves every record and reports the outcome of each. A failing record does not
+// stop the import. Records that have not been started when ctx is cancelled fail with
+// the context error.
+func (im *Importer) Import(ctx context.Context, records []ImportRecord) ImportReport {
+	concurrency := max(im.Concurrency, 1)
+	results := make([]ImportResult, len(records))
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, rec := range records {
+		if err := ctx.Err(); err != nil {
+			results[i] = failedResult(rec, err)
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i] = failedResult(rec, ctx.Err())
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			results[i] = im.importRecord(ctx, rec)
+		}()
+	}
+	wg.Wait()
+
+	report := ImportReport{Results: results}
+	for _, res := range results {
+		switch res.Outcome {
+		case "created":
+			report.Created++
+		case "updated":
+			report.Updated++
+		default:
+			report.Failed++
+		}
+	}
+
+	return report
+}
+
+func (im *Importer) importRecord(ctx context.Context, rec ImportRecord) ImportResult {
+	if rec.UserID == "" || rec.Token.AccessToken == "" {
+		return failedResult(rec, errors.New("user_id and token.access_token are required"))
+	}
+
+	if im.DryRun {
+		_, err := im.Res.ResolveSecretID(ctx, &api.ResolveSecretRequest{
+			RootDomain:  im.Env.SmsRootDomain,
+			Environment: im.Env.Environment,
+			Domain:      domainOrDefault(im.Domain),
+			UserID:      rec.UserID,
+			Provider:    rec.Provider})
+		outcome := SaveUpdated
+		if err != nil {
+			if !secret.IsErrorResourceNotFound(err) {
+				return failedResult(rec, err)
+			}
+			outcome = SaveCreated
+		}
+		return ImportResult{UserID: rec.UserID, Provider: rec.Provider, Outcome: string(outcome)}
+	}
+
+	outcome, err := im.Svr.SaveToken(ctx, &api.SaveTokenRequest{
+		UserID:       rec.UserID,
+		Provider:     rec.Provider,
+		AccessToken:  rec.Token.AccessToken,
+		RefreshToken: rec.Token.RefreshToken,
+		Expiry:       rec.Token.Expiry})
+	if err != nil {
+		return failedResult(rec, err)
+	}
+
+	return ImportResult{UserID: rec.UserID, Provider: rec.Provider, Outcome: string(outcome)}
+}
+
+func failedResult(rec ImportRecord, err error) ImportResult {
+	return ImportResult{UserID: rec.UserID, Provider: rec.Provider, Outcome: "failed", Error: err.Error()}
+}
@@ -0,0 +1,99 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/secret"
+	"context"
+	"os"
+	"testing"
+)
+
+func TestImporter_Import(t *testing.T) {
+	tests := []struct {
+		name        string
+		dryRun      bool
+		wantCreated int
+		wantUpdated int
+		wantFailed  int
+		wantStored  int
+	}{
+		{
+			name:        "ImportSavesRecords",
+			dryRun:      false,
+			wantCreated: 1,
+			wantUpdated: 1,
+			wantFailed:  1,
+			wantStored:  2,
+		},
+		{
+			name:        "ImportDryRun",
+			dryRun:      true,
+			wantCreated: 1,
+			wantUpdated: 1,
+			wantFailed:  1,
+			wantStored:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open("testdata/import.json")
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer f.Close()
+
+			records, err := ReadImportRecords(f)
+			if err != nil {
+				t.Fatalf("ReadImportRecords() error = %v", err)
+			}
+
+			vars := env.AwsVars{SmsRootDomain: "root"}
+			store := secret.NewMemoryStore()
+			_ = store.CreateSecret(context.Background(), &api.CreateSecretRequest{SecretID: "root/token/2/google", Token: "{}"})
+
+			im := Importer{
+				Env:         vars,
+				Res:         store,
+				Svr:         &ApiSaver{Env: vars, Res: store, Put: store, Ctr: store},
+				Concurrency: 2,
+				DryRun:      tt.dryRun,
+			}
+
+			report := im.Import(context.Background(), records)
+			if report.Created != tt.wantCreated || report.Updated != tt.wantUpdated || report.Failed != tt.wantFailed {
+				t.Errorf("Import() = %+v, want created %d, updated %d, failed %d",
+					report, tt.wantCreated, tt.wantUpdated, tt.wantFailed)
+			}
+			if report.Results[2].Outcome != "failed" || report.Results[2].UserID != "3" {
+				t.Errorf("Import() result = %+v, want user 3 failed", report.Results[2])
+			}
+
+			stored, _ := store.ListSecrets(context.Background(), &api.ListSecretsRequest{Prefix: "root/token/"})
+			if len(stored) != tt.wantStored {
+				t.Errorf("Import() stored = %v, want %d secrets", stored, tt.wantStored)
+			}
+			if !tt.dryRun {
+				tk, err := (&ApiRetriever{Env: vars, Res: store, Get: store}).RetrieveToken(context.Background(),
+					&api.RetrieveTokenRequest{UserID: "1", Provider: "google"})
+				if err != nil || tk.AccessToken != "a1" || tk.RefreshToken != "r1" {
+					t.Errorf("RetrieveToken() = %v, %v, want imported token", tk, err)
+				}
+			}
+		})
+	}
+}
+
+func TestImporter_ImportCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	store := secret.NewMemoryStore()
+	im := Importer{Res: store, Svr: &ApiSaver{Res: store, Put: store, Ctr: store}, Concurrency: 1}
+
+	report := im.Import(ctx, []ImportRecord{{UserID: "1"}, {UserID: "2"}})
+	if report.Failed != 2 {
+		t.Errorf("Import() = %+v, want every record failed", report)
+	}
+}
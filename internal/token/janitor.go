@@ -0,0 +1,155 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/secret"
+	"context"
+	"fmt"
+	"golang.org/x/oauth2"
+	"log/slog"
+	"time"
+)
+
+// Janitor deletes token secrets that can no longer be used: the access token is past its
+// Expiry and there is no refresh token to obtain a new one, or the token is past the
+// DeleteAfter time of its fixed lifetime. It contains the secret.Lister,
+// secret.Getter and secret.Deleter interfaces as dependencies. When the optional
+// secret.Versioner is set, a secret is only deleted if it was not saved again since it
+// was read, so a sweep can safely run alongside live traffic. Ser must match the
+// Serializer the tokens were saved with and defaults to JSONSerializer when nil.
+type Janitor struct {
+	Env    env.AwsVars
+	Lst    secret.Lister
+	Get    secret.Getter
+	Del    secret.Deleter
+	Ver    secret.Versioner
+	Ser    Serializer
+	Domain string
+	Now    func() time.Time
+}
+
+// ShouldDelete decides whether a stored token is dead. A token is deleted when it has
+// an Expiry that lies before now and it carries no refresh token, or when its DeleteAfter
+// time lies before now, even if it could still be refreshed. Tokens without an Expiry or
+// DeleteAfter time never expire, so they are always kept.
+func ShouldDelete(tk *oauth2.Token, now time.Time) bool {
+	if deleteAfter := DeleteAfter(tk); !deleteAfter.IsZero() && deleteAfter.Before(now) {
+		return true
+	}
+
+	return !tk.Expiry.IsZero() && tk.Expiry.Before(now) && tk.RefreshToken == ""
+}
+
+// Run sweeps once every interval until ctx is cancelled. A sweep in progress stops at
+// the next secret once ctx is cancelled.
+func (j *Janitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Janitor stopped")
+			return
+		case <-ticker.C:
+			deleted, err := j.Sweep(ctx)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Janitor sweep failed: %v", err))
+				continue
+			}
+			slog.Info(fmt.Sprintf("Janitor sweep deleted %d tokens", deleted))
+		}
+	}
+}
+
+// Sweep lists the token secrets of the janitor's domain and deletes every one for which
+// ShouldDelete holds. Secrets that cannot be read or deleted are logged and skipped, so a
+// single bad secret does not stop the sweep. It returns the number of deleted secrets.
+func (j *Janitor) Sweep(ctx context.Context) (int, error) {
+	now := time.Now
+	if j.Now != nil {
+		now = j.Now
+	}
+
+	secrets, err := j.Lst.ListSecrets(ctx, &api.ListSecretsRequest{
+		Prefix: domainSecretID(j.Env, j.Domain).DomainPrefix()})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, s := range secrets {
+		if err = ctx.Err(); err != nil {
+			return deleted, err
+		}
+
+		ok, err := j.sweepSecret(ctx, s.SecretID, now())
+		if err != nil {
+			slog.Error(fmt.Sprintf("Janitor could not sweep secret %v: %v", s.SecretID, err))
+			continue
+		}
+		if ok {
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (j *Janitor) sweepSecret(ctx context.Context, secretID string, now time.Time) (bool, error) {
+	versionID, err := j.version(ctx, secretID)
+	if err != nil {
+		return false, err
+	}
+
+	tk, err := j.readToken(ctx, secretID)
+	if err != nil {
+		return false, err
+	}
+	if !ShouldDelete(tk, now) {
+		return false, nil
+	}
+
+	return j.deleteUnchanged(ctx, secretID, versionID)
+}
+
+// version returns the current version of the secret, or an empty string without a
+// secret.Versioner.
+func (j *Janitor) version(ctx context.Context, secretID string) (string, error) {
+	if j.Ver == nil {
+		return "", nil
+	}
+
+	return j.Ver.GetSecretVersion(ctx, &api.GetSecretRequest{SecretID: secretID})
+}
+
+func (j *Janitor) readToken(ctx context.Context, secretID string) (*oauth2.Token, error) {
+	secretStr, err := j.Get.GetSecret(ctx, &api.GetSecretRequest{SecretID: secretID})
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalToken(ctx, j.Ser, secretID, secretStr)
+}
+
+// deleteUnchanged deletes the secret unless its version moved on from versionID since it
+// was read. It reports whether the secret was deleted.
+func (j *Janitor) deleteUnchanged(ctx context.Context, secretID string, versionID string) (bool, error) {
+	if j.Ver != nil {
+		current, err := j.Ver.GetSecretVersion(ctx, &api.GetSecretRequest{SecretID: secretID})
+		if err != nil {
+			return false, err
+		}
+		if current != versionID {
+			slog.Info(fmt.Sprintf("Janitor skipped secret %v, it was saved during the sweep", secretID))
+			return false, nil
+		}
+	}
+
+	if err := j.Del.DeleteSecret(ctx, &api.DeleteSecretRequest{SecretID: secretID}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
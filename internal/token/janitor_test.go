@@ -0,0 +1,151 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"context"
+	"fmt"
+	"golang.org/x/oauth2"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestShouldDelete(t *testing.T) {
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		token oauth2.Token
+		want  bool
+	}{
+		{
+			name:  "ExpiredWithoutRefreshToken",
+			token: oauth2.Token{AccessToken: "access_token", Expiry: now.Add(-time.Hour)},
+			want:  true,
+		},
+		{
+			name:  "ExpiredWithRefreshToken",
+			token: oauth2.Token{AccessToken: "access_token", RefreshToken: "refresh_token", Expiry: now.Add(-time.Hour)},
+			want:  false,
+		},
+		{
+			name:  "ValidWithoutRefreshToken",
+			token: oauth2.Token{AccessToken: "access_token", Expiry: now.Add(time.Hour)},
+			want:  false,
+		},
+		{
+			name:  "NoExpiry",
+			token: oauth2.Token{AccessToken: "access_token"},
+			want:  false,
+		},
+		{
+			name: "PastDeleteAfterWithRefreshToken",
+			token: *WithDeleteAfter(&oauth2.Token{AccessToken: "access_token", RefreshToken: "refresh_token",
+				Expiry: now.Add(time.Hour)}, now.Add(-time.Minute)),
+			want: true,
+		},
+		{
+			name: "FreshDeleteAfterWithoutExpiry",
+			token: *WithDeleteAfter(&oauth2.Token{AccessToken: "access_token"},
+				now.Add(time.Hour)),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ShouldDelete(&tt.token, now); got != tt.want {
+				t.Errorf("ShouldDelete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJanitor_Sweep(t *testing.T) {
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	secrets := map[string]string{
+		"root/token/dead":      `{"access_token":"a","expiry":"2025-01-01T00:00:00Z"}`,
+		"root/token/renewable": `{"access_token":"a","refresh_token":"r","expiry":"2025-01-01T00:00:00Z"}`,
+		"root/token/valid":     `{"access_token":"a","expiry":"2025-02-01T00:00:00Z"}`,
+		"root/token/saved":     `{"access_token":"a","expiry":"2025-01-01T00:00:00Z"}`,
+		"root/token/corrupt":   `invalid JSON`,
+		"root/token/lapsed":    `{"access_token":"a","refresh_token":"r","expiry":"2025-02-01T00:00:00Z","delete_after":"2025-01-01T00:00:00Z"}`,
+		"root/token/leased":    `{"access_token":"a","refresh_token":"r","expiry":"2025-02-01T00:00:00Z","delete_after":"2025-02-01T00:00:00Z"}`,
+	}
+
+	var prefix string
+	var deleted []string
+	versions := map[string]int{}
+	stub := &SecretFuncStub{
+		ListSecretsFunc: func(request *api.ListSecretsRequest) ([]api.SecretSummary, error) {
+			prefix = request.Prefix
+			var list []api.SecretSummary
+			for _, id := range slices.Sorted(maps.Keys(secrets)) {
+				list = append(list, api.SecretSummary{SecretID: id})
+			}
+			return list, nil
+		},
+		GetSecretFunc: func(request *api.GetSecretRequest) (string, error) {
+			return secrets[request.SecretID], nil
+		},
+		GetSecretVersionFunc: func(request *api.GetSecretRequest) (string, error) {
+			// "saved" gets a new version between the janitor's two version reads.
+			versions[request.SecretID]++
+			if request.SecretID == "root/token/saved" {
+				return fmt.Sprintf("v%d", versions[request.SecretID]), nil
+			}
+			return "v1", nil
+		},
+		DeleteSecretFunc: func(request *api.DeleteSecretRequest) error {
+			deleted = append(deleted, request.SecretID)
+			return nil
+		},
+	}
+	jtr := Janitor{
+		Env: env.AwsVars{SmsRootDomain: "root"},
+		Lst: stub,
+		Get: stub,
+		Del: stub,
+		Ver: stub,
+		Now: func() time.Time { return now },
+	}
+
+	n, err := jtr.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if prefix != "root/token/" {
+		t.Errorf("Sweep() prefix = %v, want root/token/", prefix)
+	}
+	if n != 2 || !slices.Equal(deleted, []string{"root/token/dead", "root/token/lapsed"}) {
+		t.Errorf("Sweep() deleted = %v (%d), want [root/token/dead root/token/lapsed]", deleted, n)
+	}
+
+	jtr.Env.Environment = "dev"
+	if _, err := jtr.Sweep(context.Background()); err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if prefix != "root/dev/token/" {
+		t.Errorf("Sweep() prefix = %v, want root/dev/token/", prefix)
+	}
+}
+
+func TestJanitor_RunStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	jtr := Janitor{}
+	go func() {
+		jtr.Run(ctx, time.Hour)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Run() did not stop after cancel")
+	}
+}
@@ -0,0 +1,27 @@
+package token
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// userLockStripes is the number of mutexes a userLocks spreads its users over.
+const userLockStripes = 64
+
+// userLocks serializes operations on the tokens of a user within this process, while the
+// tokens of different users are mostly handled in parallel. Users are striped over a fixed
+// number of mutexes by the hash of their ID, so memory does not grow with the number of
+// users, at the cost of some unrelated users sharing a mutex. The zero value is ready to use.
+type userLocks struct {
+	stripes [userLockStripes]sync.Mutex
+}
+
+// lock locks the mutex of userID and returns the function that unlocks it.
+func (ul *userLocks) lock(userID string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	mu := &ul.stripes[h.Sum32()%userLockStripes]
+
+	mu.Lock()
+	return mu.Unlock
+}
@@ -0,0 +1,97 @@
+package token
+
+import (
+	"app/api"
+	"app/internal/secret"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// racyStore widens the window between resolving and creating a secret, so unserialized
+// saves of the same user would both try to create it.
+type racyStore struct {
+	*secret.MemoryStore
+	creates atomic.Int32
+}
+
+func (rs *racyStore) ResolveSecretID(ctx context.Context, r *api.ResolveSecretRequest) (string, error) {
+	secretID, err := rs.MemoryStore.ResolveSecretID(ctx, r)
+	time.Sleep(time.Millisecond)
+	return secretID, err
+}
+
+func (rs *racyStore) CreateSecret(ctx context.Context, r *api.CreateSecretRequest) error {
+	_, err := rs.CreateSecretWithVersion(ctx, r)
+	return err
+}
+
+func (rs *racyStore) CreateSecretWithVersion(ctx context.Context, r *api.CreateSecretRequest) (string, error) {
+	rs.creates.Add(1)
+	return rs.MemoryStore.CreateSecretWithVersion(ctx, r)
+}
+
+func TestApiSaver_ConcurrentSaves(t *testing.T) {
+	const saves = 10
+	store := &racyStore{MemoryStore: secret.NewMemoryStore()}
+	svr := ApiSaver{Res: store, Put: store, Ctr: store, Ver: store, Retries: DefaultSaveRetries}
+
+	var wg sync.WaitGroup
+	results := make([]SaveResult, saves)
+	errs := make([]error, saves)
+	for i := range saves {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = svr.SaveToken(context.Background(), &api.SaveTokenRequest{
+				UserID:      "userID",
+				AccessToken: fmt.Sprintf("access_token_%d", i)})
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for i := range saves {
+		if errs[i] != nil {
+			t.Errorf("SaveToken() error = %v", errs[i])
+		}
+		if results[i] == SaveCreated {
+			created++
+		}
+	}
+	if created != 1 || store.creates.Load() != 1 {
+		t.Errorf("SaveToken() created %d secrets in %d attempts, want 1", created, store.creates.Load())
+	}
+
+	version, err := store.GetSecretVersion(context.Background(), &api.GetSecretRequest{SecretID: "/token/userID"})
+	if err != nil || version != fmt.Sprintf("v%d", saves) {
+		t.Errorf("GetSecretVersion() = %v, %v, want v%d", version, err, saves)
+	}
+}
+
+func TestUserLocks(t *testing.T) {
+	var ul userLocks
+
+	unlock := ul.lock("userID")
+	locked := make(chan struct{})
+	go func() {
+		defer ul.lock("userID")()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("lock() of a locked user did not block")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("lock() did not proceed after unlock")
+	}
+}
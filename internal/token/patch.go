@@ -0,0 +1,107 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/secret"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+type (
+	// Patcher replaces the access token and expiry of a stored token, for callers that
+	// refreshed the access token themselves, and keeps its refresh token.
+	Patcher interface {
+		PatchToken(ctx context.Context, r *api.PatchTokenRequest) error
+	}
+
+	// ApiPatcher is the implementation for the Patcher interface. It resolves the secret of
+	// the token through the secret.IDResolver, reads it through the secret.Getter and stores
+	// the patched token through the secret.Putter. A token that does not exist is not
+	// created, the patch fails with the not found error of the secret.IDResolver. When the
+	// optional secret.Versioner is set, the read-then-put is guarded by an optimistic
+	// version check and retried up to Retries times if the secret was modified
+	// concurrently. Domain selects the secret namespace and defaults to DefaultDomain when
+	// empty. Dec decodes the stored token and defaults to Ser, Ser encodes the patched token
+	// and defaults to JSONSerializer when nil.
+	ApiPatcher struct {
+		Env     env.AwsVars
+		Res     secret.IDResolver
+		Get     secret.Getter
+		Put     secret.Putter
+		Ver     secret.Versioner
+		Retries int
+		Domain  string
+		Dec     Serializer
+		Ser     Serializer
+	}
+)
+
+func (pt *ApiPatcher) PatchToken(ctx context.Context, r *api.PatchTokenRequest) error {
+	accessToken, err := validAccessToken(r.AccessToken)
+	if err != nil {
+		return err
+	}
+
+	secretID, err := pt.Res.ResolveSecretID(ctx, &api.ResolveSecretRequest{
+		RootDomain:  pt.Env.SmsRootDomain,
+		Environment: pt.Env.Environment,
+		Domain:      domainOrDefault(pt.Domain),
+		UserID:      r.UserID,
+		Provider:    r.Provider})
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not patch token. Resolving SecretID failed: %v", err))
+		return err
+	}
+
+	for attempt := 0; attempt <= pt.Retries; attempt++ {
+		err = pt.patchSecret(ctx, secretID, accessToken, r)
+		if !errors.Is(err, secret.ErrVersionConflict) {
+			return err
+		}
+		slog.Warn(fmt.Sprintf("Concurrent modification of secret %v, attempt %d", secretID, attempt+1))
+	}
+
+	return err
+}
+
+// patchSecret reads the token of the secret, at its current version when there is a
+// secret.Versioner, replaces its access token and expiry and puts it conditionally on
+// that version.
+func (pt *ApiPatcher) patchSecret(ctx context.Context, secretID string, accessToken string, r *api.PatchTokenRequest) error {
+	var versionID string
+	if pt.Ver != nil {
+		var err error
+		versionID, err = pt.Ver.GetSecretVersion(ctx, &api.GetSecretRequest{SecretID: secretID})
+		if err != nil {
+			return err
+		}
+	}
+
+	secretStr, err := pt.Get.GetSecret(ctx, &api.GetSecretRequest{SecretID: secretID, VersionID: versionID})
+	if err != nil {
+		return err
+	}
+
+	ser := serializerOrDefault(pt.Ser)
+	dec := pt.Dec
+	if dec == nil {
+		dec = ser
+	}
+	tk, err := unmarshalToken(ctx, dec, secretID, secretStr)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Unable to unmarshal secret to oauth2.Token: %v", err))
+		return err
+	}
+
+	tk.AccessToken = accessToken
+	tk.Expiry = r.Expiry
+	tokenStr, err := marshalToken(ctx, ser, secretID, tk)
+	if err != nil {
+		return err
+	}
+
+	return pt.Put.PutSecret(ctx, &api.PutSecretRequest{SecretID: secretID, Token: tokenStr, VersionID: versionID})
+}
@@ -0,0 +1,91 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/secret"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"testing"
+	"time"
+)
+
+func TestApiPatcher_PatchToken(t *testing.T) {
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		stored       string
+		accessToken  string
+		wantErr      bool
+		wantNotFound bool
+	}{
+		{
+			name:        "PatchTokenKeepsRefreshToken",
+			stored:      `{"access_token":"old_token","token_type":"Bearer","refresh_token":"refresh_token"}`,
+			accessToken: "new_token",
+		},
+		{
+			name:         "PatchTokenMissing",
+			accessToken:  "new_token",
+			wantErr:      true,
+			wantNotFound: true,
+		},
+		{
+			name:        "PatchTokenInvalidAccessToken",
+			stored:      `{"access_token":"old_token","refresh_token":"refresh_token"}`,
+			accessToken: " ",
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := secret.NewMemoryStore()
+			if tt.stored != "" {
+				if err := store.CreateSecret(ctx, &api.CreateSecretRequest{SecretID: "root/token/1/google", Token: tt.stored}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pt := &ApiPatcher{Env: env.AwsVars{SmsRootDomain: "root"}, Res: store, Get: store, Put: store, Ver: store, Retries: 1}
+
+			err := pt.PatchToken(ctx, &api.PatchTokenRequest{UserID: "1", Provider: "google", AccessToken: tt.accessToken, Expiry: expiry})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PatchToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var notFound *types.ResourceNotFoundException
+			if errors.As(err, &notFound) != tt.wantNotFound {
+				t.Errorf("PatchToken() error = %v, want not found %v", err, tt.wantNotFound)
+			}
+			if err != nil {
+				return
+			}
+
+			secretStr, _ := store.GetSecret(ctx, &api.GetSecretRequest{SecretID: "root/token/1/google"})
+			tk, err := JSONSerializer{}.Unmarshal(secretStr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tk.AccessToken != tt.accessToken || !tk.Expiry.Equal(expiry) {
+				t.Errorf("PatchToken() stored %v expiring %v, want %v expiring %v", tk.AccessToken, tk.Expiry, tt.accessToken, expiry)
+			}
+			if tk.RefreshToken != "refresh_token" || tk.TokenType != "Bearer" {
+				t.Errorf("PatchToken() stored refresh token %q and type %q, want them preserved", tk.RefreshToken, tk.TokenType)
+			}
+		})
+	}
+}
+
+func TestApiPatcher_PatchTokenMissingNotCreated(t *testing.T) {
+	ctx := context.Background()
+	store := secret.NewMemoryStore()
+	pt := &ApiPatcher{Env: env.AwsVars{SmsRootDomain: "root"}, Res: store, Get: store, Put: store}
+
+	_ = pt.PatchToken(ctx, &api.PatchTokenRequest{UserID: "1", AccessToken: "new_token", Expiry: time.Now()})
+
+	if secrets, _ := store.ListSecrets(ctx, &api.ListSecretsRequest{Prefix: "root/"}); len(secrets) != 0 {
+		t.Errorf("PatchToken() created %v, want no secret", secrets)
+	}
+}
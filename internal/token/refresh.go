@@ -0,0 +1,63 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/oauth2"
+)
+
+type (
+	// Refresher exchanges the refresh token of an expired oauth2.Token for a new token.
+	Refresher interface {
+		RefreshToken(ctx context.Context, tk *oauth2.Token) (*oauth2.Token, error)
+	}
+
+	// OAuthRefresher is the implementation for the Refresher interface. It refreshes tokens
+	// at the token endpoint of the oauth2.Config of the provider.
+	OAuthRefresher struct {
+		Config *oauth2.Config
+	}
+
+	// ProviderRefresher is a Refresher for deployments storing the tokens of several
+	// providers. It refreshes each token at the token endpoint of the oauth2.Config of the
+	// Provider stored with it, see WithProvider. Tokens stored without a provider, such as
+	// those saved before providers were stored, are refreshed with the Default config.
+	ProviderRefresher struct {
+		Configs map[string]*oauth2.Config
+		Default *oauth2.Config
+	}
+
+	// ErrUnknownProvider is returned by ProviderRefresher when there is no oauth2.Config for
+	// the provider of a token.
+	ErrUnknownProvider struct {
+		Provider string
+	}
+)
+
+func (or *OAuthRefresher) RefreshToken(ctx context.Context, tk *oauth2.Token) (*oauth2.Token, error) {
+	// Without an access token the token source always goes to the token endpoint.
+	expired := *tk
+	expired.AccessToken = ""
+
+	return or.Config.TokenSource(ctx, &expired).Token()
+}
+
+func (pr *ProviderRefresher) RefreshToken(ctx context.Context, tk *oauth2.Token) (*oauth2.Token, error) {
+	config := pr.Default
+	if provider := Provider(tk); provider != "" {
+		config = pr.Configs[provider]
+	}
+	if config == nil {
+		return nil, &ErrUnknownProvider{Provider: Provider(tk)}
+	}
+
+	return (&OAuthRefresher{Config: config}).RefreshToken(ctx, tk)
+}
+
+func (e *ErrUnknownProvider) Error() string {
+	if e.Provider == "" {
+		return "no OAuth client configured to refresh tokens without a provider"
+	}
+
+	return fmt.Sprintf("no OAuth client configured to refresh tokens of provider %q", e.Provider)
+}
@@ -0,0 +1,139 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOAuthRefresher_RefreshToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "RefreshSuccess",
+			status: http.StatusOK,
+			body:   `{"access_token":"new","token_type":"Bearer","expires_in":3600}`,
+			want:   "new",
+		},
+		{
+			name:    "RefreshRejected",
+			status:  http.StatusBadRequest,
+			body:    `{"error":"invalid_grant"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.FormValue("refresh_token") != "refresh_token" {
+					t.Errorf("refresh_token = %v, want refresh_token", r.FormValue("refresh_token"))
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			ref := OAuthRefresher{Config: &oauth2.Config{ClientID: "id", Endpoint: oauth2.Endpoint{TokenURL: srv.URL}}}
+			res, err := ref.RefreshToken(context.Background(), &oauth2.Token{
+				AccessToken:  "old",
+				RefreshToken: "refresh_token",
+				Expiry:       time.Now().Add(-time.Hour)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RefreshToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if res != nil && res.AccessToken != tt.want {
+				t.Errorf("RefreshToken() = %v, want %v", res.AccessToken, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderRefresher_RefreshToken(t *testing.T) {
+	endpoint := func(accessToken string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"` + accessToken + `","token_type":"Bearer","expires_in":3600}`))
+		}))
+	}
+	google, github, fallback := endpoint("google_token"), endpoint("github_token"), endpoint("default_token")
+	defer google.Close()
+	defer github.Close()
+	defer fallback.Close()
+
+	config := func(srv *httptest.Server) *oauth2.Config {
+		return &oauth2.Config{ClientID: "id", Endpoint: oauth2.Endpoint{TokenURL: srv.URL}}
+	}
+	ref := ProviderRefresher{
+		Configs: map[string]*oauth2.Config{"google": config(google), "github": config(github)},
+		Default: config(fallback)}
+
+	tests := []struct {
+		name     string
+		provider string
+		noDef    bool
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "RefreshGoogle",
+			provider: "google",
+			want:     "google_token",
+		},
+		{
+			name:     "RefreshGithub",
+			provider: "github",
+			want:     "github_token",
+		},
+		{
+			name: "RefreshWithoutProvider",
+			want: "default_token",
+		},
+		{
+			name:    "RefreshWithoutProviderOrDefault",
+			noDef:   true,
+			wantErr: true,
+		},
+		{
+			name:     "RefreshUnknownProvider",
+			provider: "gitlab",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref := ref
+			if tt.noDef {
+				ref.Default = nil
+			}
+
+			tk := &oauth2.Token{AccessToken: "old", RefreshToken: "refresh_token", Expiry: time.Now().Add(-time.Hour)}
+			if tt.provider != "" {
+				tk = WithProvider(tk, tt.provider)
+			}
+
+			res, err := ref.RefreshToken(context.Background(), tk)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RefreshToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var unknown *ErrUnknownProvider
+			if tt.wantErr && (!errors.As(err, &unknown) || unknown.Provider != tt.provider) {
+				t.Errorf("RefreshToken() error = %v, want ErrUnknownProvider for %q", err, tt.provider)
+			}
+			if res != nil && res.AccessToken != tt.want {
+				t.Errorf("RefreshToken() = %v, want %v", res.AccessToken, tt.want)
+			}
+		})
+	}
+}
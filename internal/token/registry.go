@@ -0,0 +1,34 @@
+package token
+
+import (
+	"app/env"
+	"app/internal/secret"
+)
+
+type (
+	// Domain is a secret namespace served by this deployment, e.g. "token" or "apikey",
+	// together with the Saver and Retriever bound to its own secret.AWSManager.
+	Domain struct {
+		Config    env.DomainVars
+		Saver     Saver
+		Retriever Retriever
+	}
+
+	// Registry maps a domain name to the Domain serving it. Handlers select the Domain
+	// from the request path, so a single deployment can serve several namespaces that
+	// differ in KMS key, recovery window and tags.
+	Registry map[string]Domain
+)
+
+// NewRegistry creates a Registry with a Domain for every entry of domains. Each Domain
+// gets its own Service for its env.DomainVars, configured with o and sharing cl.
+func NewRegistry(vars env.AwsVars, cl secret.Client, domains []env.DomainVars, o Options) Registry {
+	reg := Registry{}
+	for _, d := range domains {
+		svc := NewService(vars, cl, d)
+		svc.Configure(o)
+		reg[d.Name] = Domain{Config: d, Saver: svc.Saver, Retriever: svc.Retriever}
+	}
+
+	return reg
+}
@@ -0,0 +1,112 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/secret"
+	"context"
+	"testing"
+)
+
+func TestNewRegistry(t *testing.T) {
+	reg := NewRegistry(env.AwsVars{SmsRootDomain: "root-domain"}, nil, []env.DomainVars{
+		{Name: "token"},
+		{Name: "apikey", KmsKeyID: "alias/apikey", RecoveryWindowDays: 7, Tags: map[string]string{"team": "auth"}},
+	}, Options{Ser: SchemaSerializer{}, Dec: Base64Serializer{Serializer: SchemaSerializer{}}, MigrateOnRead: true})
+
+	tests := []struct {
+		name       string
+		domain     string
+		wantKey    string
+		wantWindow int64
+	}{
+		{
+			name:       "RegistryTokenDomain",
+			domain:     "token",
+			wantKey:    "",
+			wantWindow: 0,
+		},
+		{
+			name:       "RegistryApiKeyDomain",
+			domain:     "apikey",
+			wantKey:    "alias/apikey",
+			wantWindow: 7,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, ok := reg[tt.domain]
+			if !ok {
+				t.Fatalf("NewRegistry() missing domain %v", tt.domain)
+			}
+
+			svr := d.Saver.(*ApiSaver)
+			if svr.Domain != tt.domain {
+				t.Errorf("NewRegistry() saver domain = %v, want %v", svr.Domain, tt.domain)
+			}
+			if key := svr.Ctr.(*secret.AWSCreator).KmsKeyID; key != tt.wantKey {
+				t.Errorf("NewRegistry() KmsKeyID = %v, want %v", key, tt.wantKey)
+			}
+
+			rtr := d.Retriever.(*ApiRetriever)
+			if rtr.Domain != tt.domain {
+				t.Errorf("NewRegistry() retriever domain = %v, want %v", rtr.Domain, tt.domain)
+			}
+			if window := rtr.Get.(*secret.AWSManager).RecoveryWindowDays; window != tt.wantWindow {
+				t.Errorf("NewRegistry() RecoveryWindowDays = %v, want %v", window, tt.wantWindow)
+			}
+
+			// Every domain stores and reads tokens like the default one.
+			if _, ok := svr.Ser.(SchemaSerializer); !ok {
+				t.Errorf("NewRegistry() saver Ser = %T, want SchemaSerializer", svr.Ser)
+			}
+			if _, ok := rtr.Dec.(Base64Serializer); !ok || rtr.Ser != svr.Ser || !rtr.MigrateOnRead {
+				t.Errorf("NewRegistry() retriever Ser, Dec, MigrateOnRead = %T, %T, %v, want the options",
+					rtr.Ser, rtr.Dec, rtr.MigrateOnRead)
+			}
+		})
+	}
+}
+
+func TestApiSaver_Domain(t *testing.T) {
+	tests := []struct {
+		name       string
+		domain     string
+		wantDomain string
+	}{
+		{
+			name:       "SaveTokenDefaultDomain",
+			domain:     "",
+			wantDomain: DefaultDomain,
+		},
+		{
+			name:       "SaveTokenConfiguredDomain",
+			domain:     "apikey",
+			wantDomain: "apikey",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			stub := &SecretFuncStub{
+				ResolveSecretIDFunc: func(request *api.ResolveSecretRequest) (string, error) {
+					got = request.Domain
+					return "secretID", nil
+				},
+				PutSecretFunc: func(request *api.PutSecretRequest) error {
+					return nil
+				},
+			}
+			svr := ApiSaver{Res: stub, Put: stub, Ctr: stub, Domain: tt.domain}
+
+			if _, err := svr.SaveToken(context.Background(), &api.SaveTokenRequest{UserID: "userID", AccessToken: "access_token"}); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			if got != tt.wantDomain {
+				t.Errorf("Save() domain = %v, want %v", got, tt.wantDomain)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/secret"
+	"context"
+	"fmt"
+	"golang.org/x/oauth2"
+	"log/slog"
+)
+
+type (
+	// Rollbacker rolls the token of a user back to its previous version, e.g. after a bad
+	// token was saved, and returns the token that is current again.
+	Rollbacker interface {
+		RollbackToken(ctx context.Context, r *api.RollbackTokenRequest) (*oauth2.Token, error)
+	}
+
+	// ApiRollbacker is the implementation for the Rollbacker interface. It resolves the
+	// secret of the token through the secret.IDResolver and rolls it back through the
+	// secret.Rollbacker. Domain selects the secret namespace and defaults to DefaultDomain
+	// when empty, Ser decodes the stored tokens and defaults to JSONSerializer when nil.
+	ApiRollbacker struct {
+		Env    env.AwsVars
+		Res    secret.IDResolver
+		Rbk    secret.Rollbacker
+		Domain string
+		Ser    Serializer
+	}
+)
+
+func (rb *ApiRollbacker) RollbackToken(ctx context.Context, r *api.RollbackTokenRequest) (*oauth2.Token, error) {
+	secretID, err := rb.Res.ResolveSecretID(ctx, &api.ResolveSecretRequest{
+		RootDomain:  rb.Env.SmsRootDomain,
+		Environment: rb.Env.Environment,
+		Domain:      domainOrDefault(rb.Domain),
+		UserID:      r.UserID,
+		Provider:    r.Provider})
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not roll back token. Resolving SecretID failed: %v", err))
+		return nil, err
+	}
+
+	secretStr, err := rb.Rbk.RollbackSecret(ctx, &api.RollbackSecretRequest{SecretID: secretID})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info(fmt.Sprintf("Rolled back secret %v to its previous version", secretID))
+
+	tk, err := unmarshalToken(ctx, rb.Ser, secretID, secretStr)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Unable to unmarshal secret to oauth2.Token: %v", err))
+		return nil, err
+	}
+
+	return tk, nil
+}
@@ -0,0 +1,73 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/secret"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"testing"
+)
+
+var errNotFound = &types.ResourceNotFoundException{}
+
+func TestApiRollbacker_RollbackToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		stub    *SecretFuncStub
+		want    string
+		wantErr error
+	}{
+		{
+			name: "RollbackTokenSuccess",
+			stub: &SecretFuncStub{
+				ResolveSecretIDFunc: func(request *api.ResolveSecretRequest) (string, error) {
+					return request.SecretID().String(), nil
+				},
+				RollbackSecretFunc: func(request *api.RollbackSecretRequest) (string, error) {
+					if request.SecretID != "root/token/1/google" {
+						return "", errors.New("unexpected secret " + request.SecretID)
+					}
+					return `{"access_token":"previous_token"}`, nil
+				},
+			},
+			want: "previous_token",
+		},
+		{
+			name: "RollbackTokenNoPreviousVersion",
+			stub: &SecretFuncStub{
+				ResolveSecretIDFunc: func(request *api.ResolveSecretRequest) (string, error) {
+					return request.SecretID().String(), nil
+				},
+				RollbackSecretFunc: func(request *api.RollbackSecretRequest) (string, error) {
+					return "", secret.ErrNoPreviousVersion
+				},
+			},
+			wantErr: secret.ErrNoPreviousVersion,
+		},
+		{
+			name: "RollbackTokenResolveError",
+			stub: &SecretFuncStub{
+				ResolveSecretIDFunc: func(request *api.ResolveSecretRequest) (string, error) {
+					return "", errNotFound
+				},
+			},
+			wantErr: errNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb := &ApiRollbacker{Env: env.AwsVars{SmsRootDomain: "root"}, Res: tt.stub, Rbk: tt.stub}
+
+			tk, err := rb.RollbackToken(context.Background(), &api.RollbackTokenRequest{UserID: "1", Provider: "google"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RollbackToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && tk.AccessToken != tt.want {
+				t.Errorf("RollbackToken() = %v, want %v", tk.AccessToken, tt.want)
+			}
+		})
+	}
+}
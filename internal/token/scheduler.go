@@ -0,0 +1,279 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"app/internal/secret"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RefreshScheduler refreshes stored tokens before they expire, for providers with
+// short-lived access tokens. Ser and Dec encode and decode tokens like those of the
+// ApiRetriever.
+type RefreshScheduler struct {
+	Env         env.AwsVars
+	Lst         secret.Lister
+	Get         secret.Getter
+	Put         secret.Putter
+	Ver         secret.Versioner
+	Ref         Refresher
+	Ser         Serializer
+	Dec         Serializer
+	Domain      string
+	Window      time.Duration
+	Concurrency int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Now         func() time.Time
+
+	mu      sync.Mutex
+	backoff map[string]refreshBackoff
+	locks   *userLocks
+}
+
+// refreshBackoff is the number of consecutive failed refreshes of a token and the time
+// until which it is not refreshed again.
+type refreshBackoff struct {
+	failures int
+	until    time.Time
+}
+
+// Defaults of the RefreshScheduler backoff, used when Backoff or MaxBackoff is zero.
+const (
+	DefaultRefreshBackoff    = time.Minute
+	DefaultRefreshMaxBackoff = time.Hour
+)
+
+// errRefreshSkipped reports a token that did not need a refresh.
+var errRefreshSkipped = errors.New("token does not need a refresh")
+
+// Run refreshes the expiring tokens once every interval until ctx is cancelled. A pass in
+// progress stops starting refreshes once ctx is cancelled and waits for the running ones.
+func (rs *RefreshScheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Refresh scheduler stopped")
+			return
+		case <-ticker.C:
+			refreshed, err := rs.RefreshExpiring(ctx)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Refresh scheduler pass failed: %v", err))
+				continue
+			}
+			slog.Info(fmt.Sprintf("Refresh scheduler refreshed %d tokens", refreshed))
+		}
+	}
+}
+
+// RefreshExpiring lists the token secrets of the domain and refreshes every token that
+// expires within Window. Tokens that cannot be read, refreshed or stored are logged and
+// skipped, so a single bad token does not stop the pass. It returns the number of
+// refreshed tokens.
+func (rs *RefreshScheduler) RefreshExpiring(ctx context.Context) (int, error) {
+	secrets, err := rs.Lst.ListSecrets(ctx, &api.ListSecretsRequest{
+		Prefix: domainSecretID(rs.Env, rs.Domain).DomainPrefix()})
+	if err != nil {
+		return 0, err
+	}
+
+	var refreshed atomic.Int32
+	sem := make(chan struct{}, max(rs.Concurrency, 1))
+	var wg sync.WaitGroup
+	for _, s := range secrets {
+		if rs.backingOff(s.SecretID) {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return int(refreshed.Load()), ctx.Err()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			err := rs.refreshSecret(ctx, s.SecretID)
+			switch {
+			case err == nil:
+				refreshed.Add(1)
+			case errors.Is(err, errRefreshSkipped):
+			default:
+				slog.Error(fmt.Sprintf("Refresh scheduler could not refresh secret %v: %v", s.SecretID, err))
+			}
+		}()
+	}
+	wg.Wait()
+
+	return int(refreshed.Load()), nil
+}
+
+func (rs *RefreshScheduler) refreshSecret(ctx context.Context, secretID string) error {
+	versionID, tk, err := rs.readToken(ctx, secretID)
+	if err != nil {
+		return err
+	}
+	if !rs.expiring(tk) {
+		return errRefreshSkipped
+	}
+
+	refreshed, err := rs.Ref.RefreshToken(ctx, tk)
+	if err != nil {
+		rs.failed(secretID)
+		return err
+	}
+	rs.succeeded(secretID)
+
+	unlock := rs.lock(secretID)
+	defer unlock()
+
+	return rs.storeRefreshed(ctx, secretID, versionID, tk, withStoredFields(refreshed, tk))
+}
+
+// readToken reads the token of the secret, at its current version when there is a
+// secret.Versioner, and returns that version.
+func (rs *RefreshScheduler) readToken(ctx context.Context, secretID string) (string, *oauth2.Token, error) {
+	var versionID string
+	if rs.Ver != nil {
+		var err error
+		if versionID, err = rs.Ver.GetSecretVersion(ctx, &api.GetSecretRequest{SecretID: secretID}); err != nil {
+			return "", nil, err
+		}
+	}
+
+	secretStr, err := rs.Get.GetSecret(ctx, &api.GetSecretRequest{SecretID: secretID, VersionID: versionID})
+	if err != nil {
+		return "", nil, err
+	}
+	dec := rs.Dec
+	if dec == nil {
+		dec = serializerOrDefault(rs.Ser)
+	}
+	tk, err := unmarshalToken(ctx, dec, secretID, secretStr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return versionID, tk, nil
+}
+
+// storeRefreshed puts refreshed, the refresh of tk, conditionally on versionID, the version
+// tk was read from. A token saved during the refresh wins over the refreshed one, but when
+// the refresh rotated the refresh token, the provider revoked the one of tk: as long as the
+// saved token still holds that revoked refresh token, the rotated one replaces it and the
+// saved token is put again, conditionally on the version read, up to DefaultSaveRetries
+// times.
+func (rs *RefreshScheduler) storeRefreshed(ctx context.Context, secretID string, versionID string,
+	tk *oauth2.Token, refreshed *oauth2.Token) error {
+	rotated := refreshed.RefreshToken != "" && refreshed.RefreshToken != tk.RefreshToken
+	store := refreshed
+
+	var err error
+	for attempt := 0; attempt <= DefaultSaveRetries; attempt++ {
+		var tokenStr string
+		if tokenStr, err = marshalToken(ctx, rs.Ser, secretID, store); err != nil {
+			return err
+		}
+
+		err = rs.Put.PutSecret(ctx, &api.PutSecretRequest{SecretID: secretID, Token: tokenStr, VersionID: versionID})
+		if !errors.Is(err, secret.ErrVersionConflict) {
+			return err
+		}
+		if !rotated {
+			slog.Info(fmt.Sprintf("Refresh scheduler skipped secret %v, it was saved during the refresh", secretID))
+			return errRefreshSkipped
+		}
+
+		var saved *oauth2.Token
+		if versionID, saved, err = rs.readToken(ctx, secretID); err != nil {
+			return err
+		}
+		if saved.RefreshToken != tk.RefreshToken {
+			slog.Info(fmt.Sprintf("Refresh scheduler skipped secret %v, it was saved with a new refresh token", secretID))
+			return errRefreshSkipped
+		}
+		slog.Warn(fmt.Sprintf("Secret %v was saved during the refresh, keeping its rotated refresh token", secretID))
+		saved.RefreshToken = refreshed.RefreshToken
+		store = saved
+	}
+
+	return err
+}
+
+// lock locks the user of the secret in the userLocks shared with the ApiSaver, and returns
+// the function that unlocks it. Without shared locks, or for a secret ID that does not
+// name a user, it does not lock.
+func (rs *RefreshScheduler) lock(secretID string) func() {
+	id, err := api.ParseSecretID(secretID, rs.Env.SmsRootDomain, rs.Env.Environment)
+	if rs.locks == nil || err != nil {
+		return func() {}
+	}
+
+	return rs.locks.lock(id.UserID)
+}
+
+// expiring reports whether tk can be refreshed and expires within the Window.
+func (rs *RefreshScheduler) expiring(tk *oauth2.Token) bool {
+	if tk.RefreshToken == "" || tk.Expiry.IsZero() {
+		return false
+	}
+
+	return tk.Expiry.Before(rs.now().Add(rs.Window))
+}
+
+// backingOff reports whether the last refresh of the secret failed less than its backoff
+// ago. The backoff starts at Backoff and doubles with every further failure up to
+// MaxBackoff, so a provider that rejects a refresh token is not asked on every pass.
+func (rs *RefreshScheduler) backingOff(secretID string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	b, ok := rs.backoff[secretID]
+	return ok && rs.now().Before(b.until)
+}
+
+func (rs *RefreshScheduler) failed(secretID string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.backoff == nil {
+		rs.backoff = map[string]refreshBackoff{}
+	}
+	b := rs.backoff[secretID]
+	b.failures++
+
+	maxDelay := cmp.Or(rs.MaxBackoff, DefaultRefreshMaxBackoff)
+	delay := cmp.Or(rs.Backoff, DefaultRefreshBackoff)
+	for i := 1; i < b.failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	b.until = rs.now().Add(min(delay, maxDelay))
+	rs.backoff[secretID] = b
+}
+
+func (rs *RefreshScheduler) succeeded(secretID string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	delete(rs.backoff, secretID)
+}
+
+func (rs *RefreshScheduler) now() time.Time {
+	if rs.Now != nil {
+		return rs.Now()
+	}
+
+	return time.Now()
+}
@@ -0,0 +1,293 @@
+package token
+
+import (
+	"app/api"
+	"app/internal/secret"
+	"context"
+	"errors"
+	"golang.org/x/oauth2"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newSchedulerStore stores a token for every user with the given expiry, relative to now.
+// A zero expiry stores a token without one, a user ending in "-norefresh" gets no refresh
+// token.
+func newSchedulerStore(t *testing.T, now time.Time, expiries map[string]time.Duration) *secret.MemoryStore {
+	t.Helper()
+	store := secret.NewMemoryStore()
+	svr := ApiSaver{Res: store, Put: store, Ctr: store}
+	for userID, in := range expiries {
+		r := &api.SaveTokenRequest{UserID: userID, AccessToken: "old", RefreshToken: "refresh_token"}
+		if in != 0 {
+			r.Expiry = now.Add(in)
+		}
+		if strings.HasSuffix(userID, "-norefresh") {
+			r.RefreshToken = ""
+		}
+		if _, err := svr.SaveToken(context.Background(), r); err != nil {
+			t.Fatalf("SaveToken() error = %v", err)
+		}
+	}
+
+	return store
+}
+
+func TestRefreshScheduler_RefreshExpiring(t *testing.T) {
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	store := newSchedulerStore(t, now, map[string]time.Duration{
+		"expiring":           5 * time.Minute,
+		"expired":            -time.Minute,
+		"valid":              time.Hour,
+		"expiring-norefresh": 5 * time.Minute,
+		"noexpiry":           0,
+	})
+
+	ref := &RefresherStub{RefreshTokenFunc: func(tk *oauth2.Token) (*oauth2.Token, error) {
+		return &oauth2.Token{AccessToken: "new", RefreshToken: tk.RefreshToken, Expiry: now.Add(time.Hour)}, nil
+	}}
+	rs := &RefreshScheduler{
+		Lst:    store,
+		Get:    store,
+		Put:    store,
+		Ver:    store,
+		Ref:    ref,
+		Window: 10 * time.Minute,
+		Now:    func() time.Time { return now },
+	}
+
+	refreshed, err := rs.RefreshExpiring(context.Background())
+	if err != nil {
+		t.Fatalf("RefreshExpiring() error = %v", err)
+	}
+	if refreshed != 2 {
+		t.Errorf("RefreshExpiring() = %v, want 2", refreshed)
+	}
+
+	var refreshedUsers []string
+	secrets, _ := store.ListSecrets(context.Background(), &api.ListSecretsRequest{})
+	for _, s := range secrets {
+		value, _ := store.GetSecret(context.Background(), &api.GetSecretRequest{SecretID: s.SecretID})
+		tk, _ := JSONSerializer{}.Unmarshal(value)
+		if tk.AccessToken == "new" {
+			refreshedUsers = append(refreshedUsers, s.SecretID)
+		}
+	}
+	if want := []string{"/token/expired", "/token/expiring"}; !slices.Equal(refreshedUsers, want) {
+		t.Errorf("RefreshExpiring() refreshed %v, want %v", refreshedUsers, want)
+	}
+}
+
+func TestRefreshScheduler_Backoff(t *testing.T) {
+	start := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	now := start
+	store := newSchedulerStore(t, start, map[string]time.Duration{"expiring": 5 * time.Minute})
+
+	calls := 0
+	fail := true
+	ref := &RefresherStub{RefreshTokenFunc: func(tk *oauth2.Token) (*oauth2.Token, error) {
+		calls++
+		if fail {
+			return nil, errors.New("provider unavailable")
+		}
+		return &oauth2.Token{AccessToken: "new", RefreshToken: tk.RefreshToken, Expiry: now.Add(time.Hour)}, nil
+	}}
+	rs := &RefreshScheduler{
+		Lst:     store,
+		Get:     store,
+		Put:     store,
+		Ref:     ref,
+		Window:  10 * time.Minute,
+		Backoff: time.Minute,
+		Now:     func() time.Time { return now },
+	}
+
+	passes := []struct {
+		at        time.Duration
+		fail      bool
+		wantCalls int
+		want      int
+	}{
+		{at: 0, fail: true, wantCalls: 1},
+		{at: 30 * time.Second, fail: true, wantCalls: 1},
+		{at: time.Minute, fail: true, wantCalls: 2},
+		{at: 2 * time.Minute, fail: false, wantCalls: 2},
+		{at: 3 * time.Minute, fail: false, wantCalls: 3, want: 1},
+	}
+	for _, p := range passes {
+		now = start.Add(p.at)
+		fail = p.fail
+
+		refreshed, err := rs.RefreshExpiring(context.Background())
+		if err != nil {
+			t.Fatalf("RefreshExpiring() at %v error = %v", p.at, err)
+		}
+		if calls != p.wantCalls || refreshed != p.want {
+			t.Errorf("RefreshExpiring() at %v = %v with %v refresh calls, want %v with %v", p.at, refreshed, calls, p.want, p.wantCalls)
+		}
+	}
+}
+
+func TestRefreshScheduler_Concurrency(t *testing.T) {
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	expiries := map[string]time.Duration{}
+	for _, userID := range []string{"a", "b", "c", "d", "e", "f"} {
+		expiries[userID] = time.Minute
+	}
+	store := newSchedulerStore(t, now, expiries)
+
+	var running, maxRunning atomic.Int32
+	ref := &RefresherStub{RefreshTokenFunc: func(tk *oauth2.Token) (*oauth2.Token, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return &oauth2.Token{AccessToken: "new", RefreshToken: tk.RefreshToken}, nil
+	}}
+	rs := &RefreshScheduler{
+		Lst:         store,
+		Get:         store,
+		Put:         store,
+		Ref:         ref,
+		Window:      10 * time.Minute,
+		Concurrency: 2,
+		Now:         func() time.Time { return now },
+	}
+
+	refreshed, err := rs.RefreshExpiring(context.Background())
+	if err != nil || refreshed != len(expiries) {
+		t.Fatalf("RefreshExpiring() = %v, %v, want %v", refreshed, err, len(expiries))
+	}
+	if maxRunning.Load() > 2 {
+		t.Errorf("RefreshExpiring() ran %v refreshes at once, want at most 2", maxRunning.Load())
+	}
+}
+
+func TestRefreshScheduler_RunStops(t *testing.T) {
+	store := secret.NewMemoryStore()
+	rs := &RefreshScheduler{Lst: store, Get: store, Put: store, Ref: &RefresherStub{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		rs.Run(ctx, time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run() did not stop after ctx was cancelled")
+	}
+}
+
+func TestRefreshScheduler_SavedDuringRefresh(t *testing.T) {
+	tests := []struct {
+		name             string
+		rotated          string
+		savedRefresh     string
+		wantRefreshed    int
+		wantAccessToken  string
+		wantRefreshToken string
+	}{
+		{
+			name:             "RotatedRefreshTokenMergedIntoSave",
+			rotated:          "rotated_refresh_token",
+			savedRefresh:     "refresh_token",
+			wantRefreshed:    1,
+			wantAccessToken:  "saved",
+			wantRefreshToken: "rotated_refresh_token",
+		},
+		{
+			name:             "SaveWithNewRefreshTokenWins",
+			rotated:          "rotated_refresh_token",
+			savedRefresh:     "saved_refresh_token",
+			wantAccessToken:  "saved",
+			wantRefreshToken: "saved_refresh_token",
+		},
+		{
+			name:             "UnrotatedRefreshSkipped",
+			rotated:          "refresh_token",
+			savedRefresh:     "refresh_token",
+			wantAccessToken:  "saved",
+			wantRefreshToken: "refresh_token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+			store := newSchedulerStore(t, now, map[string]time.Duration{"expiring": time.Minute})
+			svr := &ApiSaver{Res: store, Put: store, Ctr: store}
+
+			ref := &RefresherStub{RefreshTokenFunc: func(tk *oauth2.Token) (*oauth2.Token, error) {
+				if _, err := svr.SaveToken(ctx, &api.SaveTokenRequest{
+					UserID: "expiring", AccessToken: "saved", RefreshToken: tt.savedRefresh}); err != nil {
+					t.Fatalf("SaveToken() error = %v", err)
+				}
+				return &oauth2.Token{AccessToken: "new", RefreshToken: tt.rotated, Expiry: now.Add(time.Hour)}, nil
+			}}
+			rs := &RefreshScheduler{
+				Lst:    store,
+				Get:    store,
+				Put:    store,
+				Ver:    store,
+				Ref:    ref,
+				Window: 10 * time.Minute,
+				Now:    func() time.Time { return now },
+				locks:  &svr.locks,
+			}
+
+			refreshed, err := rs.RefreshExpiring(ctx)
+			if err != nil || refreshed != tt.wantRefreshed {
+				t.Fatalf("RefreshExpiring() = %v, %v, want %v", refreshed, err, tt.wantRefreshed)
+			}
+
+			value, _ := store.GetSecret(ctx, &api.GetSecretRequest{SecretID: "/token/expiring"})
+			tk, _ := JSONSerializer{}.Unmarshal(value)
+			if tk.AccessToken != tt.wantAccessToken || tk.RefreshToken != tt.wantRefreshToken {
+				t.Errorf("RefreshExpiring() stored %v, %v, want %v, %v",
+					tk.AccessToken, tk.RefreshToken, tt.wantAccessToken, tt.wantRefreshToken)
+			}
+		})
+	}
+}
+
+func TestRefreshScheduler_Dec(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	store := secret.NewMemoryStore()
+	// The token was written base64-encoded in a schema envelope, while the scheduler
+	// writes plain JSON, e.g. after SMS_TOKEN_BASE64 and SMS_TOKEN_SCHEMA were disabled.
+	svr := ApiSaver{Res: store, Put: store, Ctr: store, Ser: Base64Serializer{Serializer: SchemaSerializer{}}}
+	if _, err := svr.SaveToken(ctx, &api.SaveTokenRequest{UserID: "expiring", AccessToken: "old",
+		RefreshToken: "refresh_token", Expiry: now.Add(time.Minute)}); err != nil {
+		t.Fatalf("SaveToken() error = %v", err)
+	}
+
+	ref := &RefresherStub{RefreshTokenFunc: func(tk *oauth2.Token) (*oauth2.Token, error) {
+		return &oauth2.Token{AccessToken: "new", RefreshToken: tk.RefreshToken, Expiry: now.Add(time.Hour)}, nil
+	}}
+	rs := &RefreshScheduler{Lst: store, Get: store, Put: store, Ver: store, Ref: ref, Ser: JSONSerializer{},
+		Dec: Base64Serializer{Serializer: SchemaSerializer{}}, Window: 10 * time.Minute,
+		Now: func() time.Time { return now }}
+
+	if refreshed, err := rs.RefreshExpiring(ctx); err != nil || refreshed != 1 {
+		t.Fatalf("RefreshExpiring() = %v, %v, want 1", refreshed, err)
+	}
+	value, _ := store.GetSecret(ctx, &api.GetSecretRequest{SecretID: "/token/expiring"})
+	if tk, err := (JSONSerializer{}).Unmarshal(value); err != nil || tk.AccessToken != "new" {
+		t.Errorf("RefreshExpiring() stored %v, want the refreshed token as JSON", value)
+	}
+}
@@ -0,0 +1,466 @@
+package token
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/oauth2"
+	"strings"
+	"time"
+)
+
+type (
+	// Serializer converts an oauth2.Token to the string stored in a secret and back, so the
+	// storage format can change without touching the ApiSaver and ApiRetriever.
+	Serializer interface {
+		Marshal(tk *oauth2.Token) (string, error)
+		Unmarshal(s string) (*oauth2.Token, error)
+	}
+
+	// JSONSerializer stores a token as its plain JSON encoding. It is the default Serializer.
+	JSONSerializer struct{}
+
+	// EnvelopeSerializer stores a token as JSON wrapped in an envelope carrying the format
+	// Version, e.g. {"v":1,"token":{...}}. Reading a secret written with another version
+	// fails with an ErrSerializerVersion, rather than silently decoding a wrong token.
+	EnvelopeSerializer struct {
+		Version int
+	}
+
+	// SchemaSerializer stores a token as JSON wrapped in an envelope carrying the
+	// CurrentSchemaVersion of the stored format, e.g. {"schema_version":1,"token":{...}}.
+	// Unlike EnvelopeSerializer, secrets stored with an older schema version are upgraded
+	// on read by applying the schema migrations in order. A payload without an envelope is
+	// schema version 0, the plain JSON encoding written by JSONSerializer.
+	SchemaSerializer struct{}
+
+	// Migrator is implemented by a Serializer that can tell whether a stored payload uses
+	// an older format than the one it writes, so the payload can be written back upgraded.
+	Migrator interface {
+		Outdated(s string) bool
+	}
+
+	// ContextSerializer is implemented by a Serializer that needs the request context and
+	// the ID of the secret a token is stored in, e.g. to bind an encrypted field to its
+	// secret. Marshal and Unmarshal behave as MarshalContext and UnmarshalContext for no
+	// particular secret.
+	ContextSerializer interface {
+		MarshalContext(ctx context.Context, secretID string, tk *oauth2.Token) (string, error)
+		UnmarshalContext(ctx context.Context, secretID string, s string) (*oauth2.Token, error)
+	}
+
+	// Base64Serializer stores the output of the wrapped Serializer base64url-encoded and
+	// prefixed with Base64Prefix, which avoids escaping issues with unusual tokens. Payloads
+	// without the prefix are passed to the wrapped Serializer as they are, so secrets stored
+	// before encoding was enabled can still be read.
+	Base64Serializer struct {
+		Serializer Serializer
+	}
+
+	// Encrypter encrypts and decrypts a single field of the token stored in the secret
+	// secretID, e.g. a key.AwsCipher. A ciphertext encrypted for a secret may not decrypt
+	// for another one.
+	Encrypter interface {
+		Encrypt(ctx context.Context, plaintext []byte, secretID string) ([]byte, error)
+		Decrypt(ctx context.Context, ciphertext []byte, secretID string) ([]byte, error)
+	}
+
+	// RefreshTokenSerializer encrypts only the RefreshToken of a token with Enc, stored as
+	// EncryptedPrefix followed by the base64url-encoded ciphertext, and passes the token on
+	// to the wrapped Serializer, so the rest of the stored token stays readable. Refresh
+	// tokens without the prefix are read as they are, so secrets stored before encryption
+	// was enabled can still be read. It is a ContextSerializer, which passes the secret ID
+	// on to Enc.
+	RefreshTokenSerializer struct {
+		Serializer Serializer
+		Enc        Encrypter
+	}
+
+	// ErrSerializerVersion is returned by EnvelopeSerializer when a secret was stored with
+	// another version than the one expected.
+	ErrSerializerVersion struct {
+		Got  int
+		Want int
+	}
+
+	schemaEnvelope struct {
+		SchemaVersion *int            `json:"schema_version"`
+		Token         json.RawMessage `json:"token"`
+	}
+
+	envelope struct {
+		V     int          `json:"v"`
+		Token *storedToken `json:"token"`
+	}
+
+	// storedToken is the stored form of an oauth2.Token. The JSON encoding of a token drops
+	// the fields of the provider response, so the granted Scope is stored next to it, as are
+	// the DeleteAfter time of tokens with a fixed lifetime and the Provider that issued it.
+	storedToken struct {
+		*oauth2.Token
+		Scope       string     `json:"scope,omitempty"`
+		DeleteAfter *time.Time `json:"delete_after,omitempty"`
+		Provider    string     `json:"provider,omitempty"`
+	}
+)
+
+func (e *ErrSerializerVersion) Error() string {
+	return fmt.Sprintf("token stored with serializer version %d, want %d", e.Got, e.Want)
+}
+
+func (JSONSerializer) Marshal(tk *oauth2.Token) (string, error) {
+	b, err := json.Marshal(newStoredToken(tk))
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func (JSONSerializer) Unmarshal(s string) (*oauth2.Token, error) {
+	st := storedToken{Token: &oauth2.Token{}}
+	if err := json.Unmarshal([]byte(s), &st); err != nil {
+		return nil, err
+	}
+
+	return st.token(), nil
+}
+
+func (es EnvelopeSerializer) Marshal(tk *oauth2.Token) (string, error) {
+	b, err := json.Marshal(envelope{V: es.Version, Token: newStoredToken(tk)})
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func (es EnvelopeSerializer) Unmarshal(s string) (*oauth2.Token, error) {
+	var env envelope
+	if err := json.Unmarshal([]byte(s), &env); err != nil {
+		return nil, err
+	}
+	if env.V != es.Version {
+		return nil, &ErrSerializerVersion{Got: env.V, Want: es.Version}
+	}
+	if env.Token == nil || env.Token.Token == nil {
+		return nil, fmt.Errorf("token envelope has no token")
+	}
+
+	return env.Token.token(), nil
+}
+
+// CurrentSchemaVersion is the schema version written by SchemaSerializer.
+const CurrentSchemaVersion = 1
+
+// schemaMigrations upgrade the envelope of a stored token by one schema version, the
+// migration at index i from version i to i+1. A new schema version adds a migration here.
+var schemaMigrations = []func(env schemaEnvelope) (schemaEnvelope, error){
+	// Version 0 is the bare JSON token, which version 1 wraps as it is.
+	func(env schemaEnvelope) (schemaEnvelope, error) {
+		return env, nil
+	},
+}
+
+func (SchemaSerializer) Marshal(tk *oauth2.Token) (string, error) {
+	b, err := json.Marshal(newStoredToken(tk))
+	if err != nil {
+		return "", err
+	}
+
+	version := CurrentSchemaVersion
+	b, err = json.Marshal(schemaEnvelope{SchemaVersion: &version, Token: b})
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func (SchemaSerializer) Unmarshal(s string) (*oauth2.Token, error) {
+	env, err := decodeSchemaEnvelope(s)
+	if err != nil {
+		return nil, err
+	}
+
+	for version := *env.SchemaVersion; version < CurrentSchemaVersion; version++ {
+		if env, err = schemaMigrations[version](env); err != nil {
+			return nil, fmt.Errorf("unable to migrate token from schema version %d: %w", version, err)
+		}
+	}
+
+	st := storedToken{Token: &oauth2.Token{}}
+	if err = json.Unmarshal(env.Token, &st); err != nil {
+		return nil, err
+	}
+
+	return st.token(), nil
+}
+
+// Outdated reports whether s was stored with a schema version older than
+// CurrentSchemaVersion.
+func (SchemaSerializer) Outdated(s string) bool {
+	env, err := decodeSchemaEnvelope(s)
+	return err == nil && *env.SchemaVersion < CurrentSchemaVersion
+}
+
+// decodeSchemaEnvelope decodes the envelope of s, treating a payload without one as schema
+// version 0. Versions newer than CurrentSchemaVersion fail with an ErrSerializerVersion.
+func decodeSchemaEnvelope(s string) (schemaEnvelope, error) {
+	var env schemaEnvelope
+	if err := json.Unmarshal([]byte(s), &env); err != nil {
+		return schemaEnvelope{}, err
+	}
+	if env.SchemaVersion == nil {
+		version := 0
+		return schemaEnvelope{SchemaVersion: &version, Token: json.RawMessage(s)}, nil
+	}
+	if *env.SchemaVersion < 0 || *env.SchemaVersion > CurrentSchemaVersion {
+		return schemaEnvelope{}, &ErrSerializerVersion{Got: *env.SchemaVersion, Want: CurrentSchemaVersion}
+	}
+	if len(env.Token) == 0 || string(env.Token) == "null" {
+		return schemaEnvelope{}, fmt.Errorf("token envelope has no token")
+	}
+
+	return env, nil
+}
+
+// Base64Prefix marks a payload written by Base64Serializer.
+const Base64Prefix = "b64:"
+
+func (bs Base64Serializer) Marshal(tk *oauth2.Token) (string, error) {
+	return bs.MarshalContext(context.Background(), "", tk)
+}
+
+// MarshalContext passes ctx and secretID on to the wrapped Serializer.
+func (bs Base64Serializer) MarshalContext(ctx context.Context, secretID string, tk *oauth2.Token) (string, error) {
+	s, err := marshalToken(ctx, bs.Serializer, secretID, tk)
+	if err != nil {
+		return "", err
+	}
+
+	return Base64Prefix + base64.URLEncoding.EncodeToString([]byte(s)), nil
+}
+
+func (bs Base64Serializer) Unmarshal(s string) (*oauth2.Token, error) {
+	return bs.UnmarshalContext(context.Background(), "", s)
+}
+
+// UnmarshalContext passes ctx and secretID on to the wrapped Serializer.
+func (bs Base64Serializer) UnmarshalContext(ctx context.Context, secretID string, s string) (*oauth2.Token, error) {
+	encoded, ok := strings.CutPrefix(s, Base64Prefix)
+	if !ok {
+		return unmarshalToken(ctx, bs.Serializer, secretID, s)
+	}
+
+	decoded, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode base64 token payload: %w", err)
+	}
+
+	return unmarshalToken(ctx, bs.Serializer, secretID, string(decoded))
+}
+
+// Outdated reports whether the wrapped Serializer considers the decoded payload outdated.
+func (bs Base64Serializer) Outdated(s string) bool {
+	if encoded, ok := strings.CutPrefix(s, Base64Prefix); ok {
+		decoded, err := base64.URLEncoding.DecodeString(encoded)
+		if err != nil {
+			return false
+		}
+		s = string(decoded)
+	}
+
+	return outdated(bs.Serializer, s)
+}
+
+// EncryptedPrefix marks a refresh token encrypted by RefreshTokenSerializer.
+const EncryptedPrefix = "enc:"
+
+func (rs RefreshTokenSerializer) Marshal(tk *oauth2.Token) (string, error) {
+	return rs.MarshalContext(context.Background(), "", tk)
+}
+
+// MarshalContext encrypts the refresh token for the secret secretID.
+func (rs RefreshTokenSerializer) MarshalContext(ctx context.Context, secretID string, tk *oauth2.Token) (
+	string, error) {
+	if tk.RefreshToken == "" {
+		return marshalToken(ctx, rs.Serializer, secretID, tk)
+	}
+
+	ciphertext, err := rs.Enc.Encrypt(ctx, []byte(tk.RefreshToken), secretID)
+	if err != nil {
+		return "", fmt.Errorf("unable to encrypt refresh token: %w", err)
+	}
+
+	// Copy the token so the caller keeps its plaintext refresh token.
+	encrypted := *tk
+	encrypted.RefreshToken = EncryptedPrefix + base64.URLEncoding.EncodeToString(ciphertext)
+
+	return marshalToken(ctx, rs.Serializer, secretID, &encrypted)
+}
+
+func (rs RefreshTokenSerializer) Unmarshal(s string) (*oauth2.Token, error) {
+	return rs.UnmarshalContext(context.Background(), "", s)
+}
+
+// UnmarshalContext decrypts the refresh token for the secret secretID.
+func (rs RefreshTokenSerializer) UnmarshalContext(ctx context.Context, secretID string, s string) (
+	*oauth2.Token, error) {
+	tk, err := unmarshalToken(ctx, rs.Serializer, secretID, s)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, ok := strings.CutPrefix(tk.RefreshToken, EncryptedPrefix)
+	if !ok {
+		return tk, nil
+	}
+
+	ciphertext, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode encrypted refresh token: %w", err)
+	}
+	plaintext, err := rs.Enc.Decrypt(ctx, ciphertext, secretID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt refresh token: %w", err)
+	}
+	tk.RefreshToken = string(plaintext)
+
+	return tk, nil
+}
+
+// Outdated reports whether the wrapped Serializer considers s outdated.
+func (rs RefreshTokenSerializer) Outdated(s string) bool {
+	return outdated(rs.Serializer, s)
+}
+
+// outdated reports whether ser is a Migrator that considers s outdated.
+func outdated(ser Serializer, s string) bool {
+	m, ok := ser.(Migrator)
+	return ok && m.Outdated(s)
+}
+
+// Scope returns the space-delimited scopes granted to tk, as returned by the provider or
+// stored with the token, or "" when they are unknown.
+func Scope(tk *oauth2.Token) string {
+	scope, _ := tk.Extra("scope").(string)
+	return scope
+}
+
+// WithScope returns a copy of tk carrying the granted scope, which is stored with it. Other
+// fields of the provider response, except the stored DeleteAfter time and Provider, are
+// dropped from the copy.
+func WithScope(tk *oauth2.Token, scope string) *oauth2.Token {
+	e := extrasOf(tk)
+	e.scope = scope
+	return e.apply(tk)
+}
+
+// DeleteAfter returns the time after which the Janitor deletes tk, as stored with it, or
+// the zero time when tk has no fixed lifetime.
+func DeleteAfter(tk *oauth2.Token) time.Time {
+	deleteAfter, _ := tk.Extra("delete_after").(time.Time)
+	return deleteAfter
+}
+
+// WithDeleteAfter returns a copy of tk carrying the time after which the Janitor deletes
+// it, which is stored with it. Like with WithScope, other fields of the provider response
+// are dropped from the copy.
+func WithDeleteAfter(tk *oauth2.Token, deleteAfter time.Time) *oauth2.Token {
+	e := extrasOf(tk)
+	e.deleteAfter = deleteAfter
+	return e.apply(tk)
+}
+
+// Provider returns the name of the provider that issued tk, as stored with it, or "" for
+// tokens saved without a provider.
+func Provider(tk *oauth2.Token) string {
+	provider, _ := tk.Extra("provider").(string)
+	return provider
+}
+
+// WithProvider returns a copy of tk carrying the name of the provider that issued it, which
+// is stored with it. Like with WithScope, other fields of the provider response are dropped
+// from the copy.
+func WithProvider(tk *oauth2.Token, provider string) *oauth2.Token {
+	e := extrasOf(tk)
+	e.provider = provider
+	return e.apply(tk)
+}
+
+// tokenExtras are the fields stored next to a token, carried by an oauth2.Token as extras.
+type tokenExtras struct {
+	scope       string
+	deleteAfter time.Time
+	provider    string
+}
+
+func extrasOf(tk *oauth2.Token) tokenExtras {
+	return tokenExtras{scope: Scope(tk), deleteAfter: DeleteAfter(tk), provider: Provider(tk)}
+}
+
+func (e tokenExtras) apply(tk *oauth2.Token) *oauth2.Token {
+	extras := map[string]interface{}{}
+	if e.scope != "" {
+		extras["scope"] = e.scope
+	}
+	if !e.deleteAfter.IsZero() {
+		extras["delete_after"] = e.deleteAfter
+	}
+	if e.provider != "" {
+		extras["provider"] = e.provider
+	}
+
+	return tk.WithExtra(extras)
+}
+
+func newStoredToken(tk *oauth2.Token) *storedToken {
+	st := &storedToken{Token: tk, Scope: Scope(tk), Provider: Provider(tk)}
+	if deleteAfter := DeleteAfter(tk); !deleteAfter.IsZero() {
+		st.DeleteAfter = &deleteAfter
+	}
+
+	return st
+}
+
+func (st *storedToken) token() *oauth2.Token {
+	if st.Scope == "" && st.DeleteAfter == nil && st.Provider == "" {
+		return st.Token
+	}
+
+	e := tokenExtras{scope: st.Scope, provider: st.Provider}
+	if st.DeleteAfter != nil {
+		e.deleteAfter = *st.DeleteAfter
+	}
+	return e.apply(st.Token)
+}
+
+func serializerOrDefault(s Serializer) Serializer {
+	if s == nil {
+		return JSONSerializer{}
+	}
+
+	return s
+}
+
+// marshalToken marshals tk, stored in the secret secretID, with ser or the default
+// Serializer, passing ctx and secretID on when it is a ContextSerializer.
+func marshalToken(ctx context.Context, ser Serializer, secretID string, tk *oauth2.Token) (string, error) {
+	if cs, ok := serializerOrDefault(ser).(ContextSerializer); ok {
+		return cs.MarshalContext(ctx, secretID, tk)
+	}
+
+	return serializerOrDefault(ser).Marshal(tk)
+}
+
+// unmarshalToken unmarshals s, stored in the secret secretID, with ser or the default
+// Serializer, passing ctx and secretID on when it is a ContextSerializer.
+func unmarshalToken(ctx context.Context, ser Serializer, secretID string, s string) (*oauth2.Token, error) {
+	if cs, ok := serializerOrDefault(ser).(ContextSerializer); ok {
+		return cs.UnmarshalContext(ctx, secretID, s)
+	}
+
+	return serializerOrDefault(ser).Unmarshal(s)
+}
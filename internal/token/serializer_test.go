@@ -0,0 +1,401 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/oauth2"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSerializer(t *testing.T) {
+	tk := &oauth2.Token{
+		AccessToken:  "access_token",
+		TokenType:    "Bearer",
+		RefreshToken: "refresh_token",
+		Expiry:       time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)}
+
+	tests := []struct {
+		name   string
+		writer Serializer
+		reader Serializer
+		want   string
+		err    any
+	}{
+		{
+			name:   "SerializerJSON",
+			writer: JSONSerializer{},
+			reader: JSONSerializer{},
+			want:   `{"access_token":"access_token","token_type":"Bearer","refresh_token":"refresh_token","expiry":"2025-01-02T15:04:05Z"}`,
+		},
+		{
+			name:   "SerializerEnvelope",
+			writer: EnvelopeSerializer{Version: 1},
+			reader: EnvelopeSerializer{Version: 1},
+			want:   `{"v":1,"token":{"access_token":"access_token","token_type":"Bearer","refresh_token":"refresh_token","expiry":"2025-01-02T15:04:05Z"}}`,
+		},
+		{
+			name:   "SerializerEnvelopeVersionMismatch",
+			writer: EnvelopeSerializer{Version: 1},
+			reader: EnvelopeSerializer{Version: 2},
+			want:   `{"v":1,"token":{"access_token":"access_token","token_type":"Bearer","refresh_token":"refresh_token","expiry":"2025-01-02T15:04:05Z"}}`,
+			err:    new(*ErrSerializerVersion),
+		},
+		{
+			name:   "SerializerSchema",
+			writer: SchemaSerializer{},
+			reader: SchemaSerializer{},
+			want:   `{"schema_version":1,"token":{"access_token":"access_token","token_type":"Bearer","refresh_token":"refresh_token","expiry":"2025-01-02T15:04:05Z"}}`,
+		},
+		{
+			name:   "SerializerSchemaReadsPlainJSON",
+			writer: JSONSerializer{},
+			reader: SchemaSerializer{},
+			want:   `{"access_token":"access_token","token_type":"Bearer","refresh_token":"refresh_token","expiry":"2025-01-02T15:04:05Z"}`,
+		},
+		{
+			name:   "SerializerEnvelopeReadsPlainJSON",
+			writer: JSONSerializer{},
+			reader: EnvelopeSerializer{Version: 1},
+			want:   `{"access_token":"access_token","token_type":"Bearer","refresh_token":"refresh_token","expiry":"2025-01-02T15:04:05Z"}`,
+			err:    new(*ErrSerializerVersion),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := tt.writer.Marshal(tk)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if s != tt.want {
+				t.Errorf("Marshal() = %v, want %v", s, tt.want)
+			}
+
+			res, err := tt.reader.Unmarshal(s)
+			if tt.err != nil {
+				if !errors.As(err, tt.err) {
+					t.Errorf("Unmarshal() error = %v, want %T", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if *res != *tk {
+				t.Errorf("Unmarshal() = %v, want %v", res, tk)
+			}
+		})
+	}
+}
+
+func TestSerializerStoredFields(t *testing.T) {
+	tk := WithProvider(WithScope(&oauth2.Token{AccessToken: "access_token", TokenType: "Bearer"}, "read write"), "google")
+
+	tests := []struct {
+		name       string
+		serializer Serializer
+		want       string
+	}{
+		{
+			name:       "SerializerJSONScope",
+			serializer: JSONSerializer{},
+			want:       `{"access_token":"access_token","token_type":"Bearer","expiry":"0001-01-01T00:00:00Z","scope":"read write","provider":"google"}`,
+		},
+		{
+			name:       "SerializerEnvelopeScope",
+			serializer: EnvelopeSerializer{Version: 1},
+			want:       `{"v":1,"token":{"access_token":"access_token","token_type":"Bearer","expiry":"0001-01-01T00:00:00Z","scope":"read write","provider":"google"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := tt.serializer.Marshal(tk)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if s != tt.want {
+				t.Errorf("Marshal() = %v, want %v", s, tt.want)
+			}
+
+			res, err := tt.serializer.Unmarshal(s)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if Scope(res) != "read write" || Provider(res) != "google" || res.AccessToken != tk.AccessToken {
+				t.Errorf("Unmarshal() = %v with scope %q and provider %q, want %v with scope %q and provider %q",
+					res, Scope(res), Provider(res), tk, "read write", "google")
+			}
+		})
+	}
+}
+
+func TestSchemaSerializer(t *testing.T) {
+	want := WithScope(&oauth2.Token{
+		AccessToken:  "access_token",
+		TokenType:    "Bearer",
+		RefreshToken: "refresh_token",
+		Expiry:       time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)}, "read")
+
+	tests := []struct {
+		name         string
+		stored       string
+		wantOutdated bool
+		wantErr      any
+	}{
+		{
+			name:         "SchemaV0",
+			stored:       `{"access_token":"access_token","token_type":"Bearer","refresh_token":"refresh_token","expiry":"2025-01-02T15:04:05Z","scope":"read"}`,
+			wantOutdated: true,
+		},
+		{
+			name:   "SchemaV1",
+			stored: `{"schema_version":1,"token":{"access_token":"access_token","token_type":"Bearer","refresh_token":"refresh_token","expiry":"2025-01-02T15:04:05Z","scope":"read"}}`,
+		},
+		{
+			name:    "SchemaNewerVersion",
+			stored:  `{"schema_version":2,"token":{"access_token":"access_token"}}`,
+			wantErr: new(*ErrSerializerVersion),
+		},
+		{
+			name:    "SchemaEnvelopeWithoutToken",
+			stored:  `{"schema_version":1}`,
+			wantErr: new(error),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ser := SchemaSerializer{}
+			if got := ser.Outdated(tt.stored); got != tt.wantOutdated {
+				t.Errorf("Outdated() = %v, want %v", got, tt.wantOutdated)
+			}
+
+			res, err := ser.Unmarshal(tt.stored)
+			if tt.wantErr != nil {
+				if err == nil || !errors.As(err, tt.wantErr) {
+					t.Errorf("Unmarshal() error = %v, want %T", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if res.AccessToken != want.AccessToken || res.RefreshToken != want.RefreshToken ||
+				!res.Expiry.Equal(want.Expiry) || Scope(res) != Scope(want) {
+				t.Errorf("Unmarshal() = %v with scope %q, want %v with scope %q", res, Scope(res), want, Scope(want))
+			}
+
+			// The upgraded token is written as the current schema version.
+			upgraded, err := ser.Marshal(res)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if !strings.HasPrefix(upgraded, `{"schema_version":1,`) || ser.Outdated(upgraded) {
+				t.Errorf("Marshal() = %v, want schema version %d", upgraded, CurrentSchemaVersion)
+			}
+		})
+	}
+}
+
+func TestMigrator_Wrapped(t *testing.T) {
+	v0, _ := JSONSerializer{}.Marshal(&oauth2.Token{AccessToken: "access_token"})
+	ser := RefreshTokenSerializer{Serializer: Base64Serializer{Serializer: SchemaSerializer{}}}
+
+	if !ser.Outdated(v0) {
+		t.Errorf("Outdated() of a plain v0 payload = false, want true")
+	}
+	v1, _ := ser.Marshal(&oauth2.Token{AccessToken: "access_token"})
+	if ser.Outdated(v1) {
+		t.Errorf("Outdated() of %v = true, want false", v1)
+	}
+	b64, _ := Base64Serializer{}.Marshal(&oauth2.Token{AccessToken: "access_token"})
+	if !ser.Outdated(b64) {
+		t.Errorf("Outdated() of a base64 v0 payload = false, want true")
+	}
+	if (Base64Serializer{}).Outdated(v0) {
+		t.Errorf("Outdated() without a Migrator = true, want false")
+	}
+}
+
+func TestBase64Serializer(t *testing.T) {
+	tests := []struct {
+		name  string
+		token oauth2.Token
+	}{
+		{
+			name:  "Base64Quotes",
+			token: oauth2.Token{AccessToken: `a"b\"c`, TokenType: "Bearer"},
+		},
+		{
+			name:  "Base64Newlines",
+			token: oauth2.Token{AccessToken: "line1\nline2\r\n", RefreshToken: "\ttab"},
+		},
+		{
+			name:  "Base64Unicode",
+			token: oauth2.Token{AccessToken: "\u2028\u0000<script>&", RefreshToken: "ü"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ser := Base64Serializer{}
+
+			s, err := ser.Marshal(&tt.token)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if !strings.HasPrefix(s, Base64Prefix) || strings.ContainsAny(s, "\"\\\n") {
+				t.Errorf("Marshal() = %v, want a base64url payload", s)
+			}
+
+			res, err := ser.Unmarshal(s)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if *res != tt.token {
+				t.Errorf("Unmarshal() = %v, want %v", res, tt.token)
+			}
+
+			plain, _ := JSONSerializer{}.Marshal(&tt.token)
+			res, err = ser.Unmarshal(plain)
+			if err != nil || *res != tt.token {
+				t.Errorf("Unmarshal() of a plain payload = %v, %v, want %v", res, err, tt.token)
+			}
+		})
+	}
+
+	if _, err := (Base64Serializer{}).Unmarshal(Base64Prefix + "not base64!"); err == nil {
+		t.Errorf("Unmarshal() of an invalid payload succeeded")
+	}
+}
+
+// EncrypterStub "encrypts" by prefixing the plaintext with a marker and the secret ID it
+// is bound to, or fails with Err.
+type EncrypterStub struct {
+	Err error
+}
+
+func (es EncrypterStub) Encrypt(ctx context.Context, plaintext []byte, secretID string) ([]byte, error) {
+	if es.Err != nil {
+		return nil, es.Err
+	}
+	return append([]byte("sealed:"+secretID+":"), plaintext...), nil
+}
+
+func (es EncrypterStub) Decrypt(ctx context.Context, ciphertext []byte, secretID string) ([]byte, error) {
+	if es.Err != nil {
+		return nil, es.Err
+	}
+	plaintext, ok := strings.CutPrefix(string(ciphertext), "sealed:"+secretID+":")
+	if !ok {
+		return nil, errors.New("invalid ciphertext")
+	}
+	return []byte(plaintext), nil
+}
+
+func TestRefreshTokenSerializer(t *testing.T) {
+	tk := &oauth2.Token{
+		AccessToken:  "access_token",
+		TokenType:    "Bearer",
+		RefreshToken: "refresh_token",
+		Expiry:       time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)}
+
+	tests := []struct {
+		name  string
+		inner Serializer
+	}{
+		{
+			name:  "RefreshTokenJSON",
+			inner: JSONSerializer{},
+		},
+		{
+			name:  "RefreshTokenEnvelope",
+			inner: EnvelopeSerializer{Version: 1},
+		},
+		{
+			name:  "RefreshTokenBase64",
+			inner: Base64Serializer{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ser := RefreshTokenSerializer{Serializer: tt.inner, Enc: EncrypterStub{}}
+
+			s, err := ser.Marshal(tk)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if tk.RefreshToken != "refresh_token" {
+				t.Errorf("Marshal() modified the token")
+			}
+
+			stored, err := tt.inner.Unmarshal(s)
+			if err != nil {
+				t.Fatalf("Unmarshal() of the stored token error = %v", err)
+			}
+			if stored.AccessToken != tk.AccessToken || !strings.HasPrefix(stored.RefreshToken, EncryptedPrefix) {
+				t.Errorf("stored token = %v, want a readable access token and an encrypted refresh token", stored)
+			}
+
+			res, err := ser.Unmarshal(s)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if *res != *tk {
+				t.Errorf("Unmarshal() = %v, want %v", res, tk)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenSerializer_Plain(t *testing.T) {
+	ser := RefreshTokenSerializer{Enc: EncrypterStub{Err: errors.New("no key")}}
+
+	plain, _ := JSONSerializer{}.Marshal(&oauth2.Token{AccessToken: "access_token", RefreshToken: "refresh_token"})
+	res, err := ser.Unmarshal(plain)
+	if err != nil || res.RefreshToken != "refresh_token" {
+		t.Errorf("Unmarshal() of a plain refresh token = %v, %v, want refresh_token", res, err)
+	}
+
+	s, err := ser.Marshal(&oauth2.Token{AccessToken: "access_token"})
+	if err != nil || strings.Contains(s, EncryptedPrefix) {
+		t.Errorf("Marshal() without a refresh token = %v, %v, want it stored as it is", s, err)
+	}
+
+	if _, err := ser.Marshal(&oauth2.Token{AccessToken: "access_token", RefreshToken: "refresh_token"}); err == nil {
+		t.Errorf("Marshal() error = nil, want the encryption error")
+	}
+
+	encrypted, _ := JSONSerializer{}.Marshal(&oauth2.Token{AccessToken: "access_token", RefreshToken: EncryptedPrefix + "c2VhbGVkOng="})
+	if _, err := ser.Unmarshal(encrypted); err == nil {
+		t.Errorf("Unmarshal() error = nil, want the decryption error")
+	}
+	if _, err := ser.Unmarshal(strings.Replace(encrypted, "c2VhbGVkOng=", "not base64!", 1)); err == nil {
+		t.Errorf("Unmarshal() of an invalid ciphertext succeeded")
+	}
+}
+
+func TestRefreshTokenSerializer_BoundToSecret(t *testing.T) {
+	ctx := context.Background()
+	ser := Base64Serializer{Serializer: RefreshTokenSerializer{Serializer: SchemaSerializer{}, Enc: EncrypterStub{}}}
+	tk := &oauth2.Token{AccessToken: "access_token", RefreshToken: "refresh_token"}
+
+	s, err := ser.MarshalContext(ctx, "root/1", tk)
+	if err != nil {
+		t.Fatalf("MarshalContext() error = %v", err)
+	}
+	res, err := ser.UnmarshalContext(ctx, "root/1", s)
+	if err != nil || res.RefreshToken != "refresh_token" {
+		t.Errorf("UnmarshalContext() = %v, %v, want refresh_token", res, err)
+	}
+	if _, err = ser.UnmarshalContext(ctx, "root/2", s); err == nil {
+		t.Errorf("UnmarshalContext() for another secret succeeded")
+	}
+	if _, err = ser.Unmarshal(s); err == nil {
+		t.Errorf("Unmarshal() for no particular secret succeeded")
+	}
+}
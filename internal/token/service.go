@@ -0,0 +1,127 @@
+package token
+
+import (
+	"app/env"
+	"app/internal/secret"
+	"time"
+)
+
+// Service bundles the secret.AWSManager of a domain with the ApiSaver, ApiRetriever,
+// ApiPatcher, ApiRollbacker, Janitor and RefreshScheduler built on top of it, all sharing
+// the same env.AwsVars.
+type Service struct {
+	Manager    *secret.AWSManager
+	Saver      *ApiSaver
+	Retriever  *ApiRetriever
+	Patcher    *ApiPatcher
+	Rollbacker *ApiRollbacker
+	Janitor    *Janitor
+	Scheduler  *RefreshScheduler
+}
+
+// Options are the settings Configure applies to a Service, shared by all domains of a
+// deployment so they store and read tokens alike. Ser encodes and Dec decodes the stored
+// tokens, see ApiRetriever. Ref refreshes tokens for the Scheduler, and with
+// RefreshOnRetrieve for the Retriever as well.
+type Options struct {
+	TokenType           string
+	RequireExisting     bool
+	MaxProviders        int
+	ProviderTTLs        map[string]time.Duration
+	Ser                 Serializer
+	Dec                 Serializer
+	MigrateOnRead       bool
+	Ref                 Refresher
+	RefreshOnRetrieve   bool
+	WriteBack           bool
+	ScheduleWindow      time.Duration
+	ScheduleConcurrency int
+}
+
+// NewService wires a Service for the domain d on cl. The returned components are ready to
+// use; optional behaviour, such as refreshing or a custom Serializer, can be set on them
+// afterwards. The Scheduler needs a Refresher before it is run.
+func NewService(vars env.AwsVars, cl secret.Client, d env.DomainVars) *Service {
+	mgr := secret.NewAWSManager(cl, d)
+	mgr.AWSPutter.MaxVersions = vars.MaxSecretVersions
+	mgr.AWSPutter.MaxSize = vars.MaxSecretSize
+	mgr.AWSCreator.MaxSize = vars.MaxSecretSize
+	mgr.AWSResolver.Providers = vars.AllowedProviders
+
+	svc := &Service{
+		Manager: mgr,
+		Saver: &ApiSaver{
+			Env:     vars,
+			Res:     &mgr.AWSResolver,
+			Put:     &mgr.AWSPutter,
+			Ctr:     &mgr.AWSCreator,
+			Ver:     &mgr.AWSGetter,
+			Lst:     &mgr.AWSLister,
+			Get:     mgr,
+			Retries: DefaultSaveRetries,
+			Domain:  d.Name,
+		},
+		Retriever: &ApiRetriever{
+			Env:    vars,
+			Res:    &mgr.AWSResolver,
+			Get:    mgr,
+			Put:    &mgr.AWSPutter,
+			Domain: d.Name,
+		},
+		Patcher: &ApiPatcher{
+			Env:     vars,
+			Res:     &mgr.AWSResolver,
+			Get:     mgr,
+			Put:     &mgr.AWSPutter,
+			Ver:     &mgr.AWSGetter,
+			Retries: DefaultSaveRetries,
+			Domain:  d.Name,
+		},
+		Rollbacker: &ApiRollbacker{
+			Env:    vars,
+			Res:    &mgr.AWSResolver,
+			Rbk:    &mgr.AWSRollbacker,
+			Domain: d.Name,
+		},
+		Janitor: &Janitor{
+			Env:    vars,
+			Lst:    &mgr.AWSLister,
+			Get:    &mgr.AWSGetter,
+			Del:    &mgr.AWSDeleter,
+			Ver:    &mgr.AWSGetter,
+			Domain: d.Name,
+		},
+		Scheduler: &RefreshScheduler{
+			Env:    vars,
+			Lst:    &mgr.AWSLister,
+			Get:    &mgr.AWSGetter,
+			Put:    &mgr.AWSPutter,
+			Ver:    &mgr.AWSGetter,
+			Domain: d.Name,
+		},
+	}
+	svc.Scheduler.locks = &svc.Saver.locks
+
+	return svc
+}
+
+// Configure applies o to the components of s.
+func (s *Service) Configure(o Options) {
+	s.Saver.TokenType = o.TokenType
+	s.Saver.RequireExisting = o.RequireExisting
+	s.Saver.MaxProviders = o.MaxProviders
+	s.Saver.ProviderTTLs = o.ProviderTTLs
+	s.Saver.Ser, s.Saver.Dec = o.Ser, o.Dec
+	s.Retriever.Ser, s.Retriever.Dec = o.Ser, o.Dec
+	s.Retriever.MigrateOnRead = o.MigrateOnRead
+	if o.RefreshOnRetrieve {
+		s.Retriever.Ref = o.Ref
+		s.Retriever.WriteBack = o.WriteBack
+	}
+	s.Patcher.Ser, s.Patcher.Dec = o.Ser, o.Dec
+	s.Rollbacker.Ser = o.Dec
+	s.Scheduler.Ser, s.Scheduler.Dec = o.Ser, o.Dec
+	s.Scheduler.Ref = o.Ref
+	s.Scheduler.Window = o.ScheduleWindow
+	s.Scheduler.Concurrency = o.ScheduleConcurrency
+}
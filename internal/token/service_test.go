@@ -0,0 +1,127 @@
+package token
+
+import (
+	"app/api"
+	"app/env"
+	"context"
+	"fmt"
+	aw "github.com/aws/aws-sdk-go-v2/aws"
+	sm "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"testing"
+)
+
+// secretClientStub is a secret.Client storing secrets in a map, recording the secret IDs
+// it was asked for.
+type secretClientStub struct {
+	secrets map[string]string
+	ids     []string
+}
+
+func (s *secretClientStub) GetSecretValue(_ context.Context, in *sm.GetSecretValueInput, _ ...func(*sm.Options)) (
+	*sm.GetSecretValueOutput, error) {
+	s.ids = append(s.ids, *in.SecretId)
+	v, ok := s.secrets[*in.SecretId]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+	return &sm.GetSecretValueOutput{SecretString: aw.String(v)}, nil
+}
+
+func (s *secretClientStub) PutSecretValue(_ context.Context, in *sm.PutSecretValueInput, _ ...func(*sm.Options)) (
+	*sm.PutSecretValueOutput, error) {
+	s.ids = append(s.ids, *in.SecretId)
+	s.secrets[*in.SecretId] = *in.SecretString
+	return &sm.PutSecretValueOutput{}, nil
+}
+
+func (s *secretClientStub) CreateSecret(_ context.Context, in *sm.CreateSecretInput, _ ...func(*sm.Options)) (
+	*sm.CreateSecretOutput, error) {
+	s.ids = append(s.ids, *in.Name)
+	s.secrets[*in.Name] = *in.SecretString
+	return &sm.CreateSecretOutput{}, nil
+}
+
+func (s *secretClientStub) DescribeSecret(_ context.Context, in *sm.DescribeSecretInput, _ ...func(*sm.Options)) (
+	*sm.DescribeSecretOutput, error) {
+	s.ids = append(s.ids, *in.SecretId)
+	if _, ok := s.secrets[*in.SecretId]; !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+	return &sm.DescribeSecretOutput{
+		Name:               in.SecretId,
+		VersionIdsToStages: map[string][]string{"v1": {"AWSCURRENT"}}}, nil
+}
+
+func (s *secretClientStub) DeleteSecret(context.Context, *sm.DeleteSecretInput, ...func(*sm.Options)) (
+	*sm.DeleteSecretOutput, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (s *secretClientStub) ListSecrets(context.Context, *sm.ListSecretsInput, ...func(*sm.Options)) (
+	*sm.ListSecretsOutput, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (s *secretClientStub) UpdateSecretVersionStage(context.Context, *sm.UpdateSecretVersionStageInput,
+	...func(*sm.Options)) (*sm.UpdateSecretVersionStageOutput, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (s *secretClientStub) ListSecretVersionIds(context.Context, *sm.ListSecretVersionIdsInput,
+	...func(*sm.Options)) (*sm.ListSecretVersionIdsOutput, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func TestNewService(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain env.DomainVars
+		wantID string
+	}{
+		{
+			name:   "ServiceDefaultDomain",
+			domain: env.DomainVars{Name: DefaultDomain},
+			wantID: "root-domain/token/userID",
+		},
+		{
+			name:   "ServiceCustomDomain",
+			domain: env.DomainVars{Name: "apikey", KmsKeyID: "alias/apikey"},
+			wantID: "root-domain/apikey/userID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := &secretClientStub{secrets: map[string]string{}}
+			svc := NewService(env.AwsVars{SmsRootDomain: "root-domain"}, cl, tt.domain)
+
+			if _, err := svc.Saver.SaveToken(context.Background(), &api.SaveTokenRequest{UserID: "userID", AccessToken: "access_token"}); err != nil {
+				t.Fatalf("SaveToken() error = %v", err)
+			}
+			if _, ok := cl.secrets[tt.wantID]; !ok {
+				t.Errorf("SaveToken() saved %v, want %v", cl.ids, tt.wantID)
+			}
+
+			cl.ids = nil
+			tk, err := svc.Retriever.RetrieveToken(context.Background(), &api.RetrieveTokenRequest{UserID: "userID"})
+			if err != nil {
+				t.Fatalf("RetrieveToken() error = %v", err)
+			}
+			if tk.AccessToken != "access_token" {
+				t.Errorf("RetrieveToken() = %v, want access_token", tk.AccessToken)
+			}
+			for _, id := range cl.ids {
+				if id != tt.wantID {
+					t.Errorf("RetrieveToken() used secret %v, want %v", id, tt.wantID)
+				}
+			}
+			if svc.Janitor.Env.SmsRootDomain != "root-domain" || svc.Janitor.Domain != tt.domain.Name {
+				t.Errorf("Janitor env = %v/%v, want root-domain/%v", svc.Janitor.Env.SmsRootDomain, svc.Janitor.Domain, tt.domain.Name)
+			}
+			if key := svc.Manager.AWSCreator.KmsKeyID; key != tt.domain.KmsKeyID {
+				t.Errorf("Manager KmsKeyID = %v, want %v", key, tt.domain.KmsKeyID)
+			}
+		})
+	}
+}
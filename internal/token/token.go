@@ -4,84 +4,533 @@ import (
 	"app/api"
 	"app/env"
 	"app/internal/secret"
-	"encoding/json"
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"golang.org/x/oauth2"
 	"log/slog"
+	"strings"
+	"time"
+	"unicode"
 )
 
 type (
 	Retriever interface {
-		RetrieveToken(r *api.RetrieveTokenRequest) (*oauth2.Token, error)
+		RetrieveToken(ctx context.Context, r *api.RetrieveTokenRequest) (*oauth2.Token, error)
 	}
 
+	// Describer describes a stored token, such as its expiry and granted scopes, without
+	// refreshing it.
+	Describer interface {
+		DescribeToken(ctx context.Context, r *api.RetrieveTokenRequest) (*api.TokenDescription, error)
+	}
+
+	// Saver saves a token, reporting whether it created a new secret or updated an
+	// existing one.
 	Saver interface {
-		SaveToken(r *api.SaveTokenRequest) error
+		SaveToken(ctx context.Context, r *api.SaveTokenRequest) (SaveResult, error)
+	}
+
+	// VersionRetriever is a Retriever that also reports the VersionId of the secret version
+	// the token was served from, empty when the token is not stored as served, so clients
+	// can check it against the version reported by a VersionSaver.
+	VersionRetriever interface {
+		RetrieveTokenWithVersion(ctx context.Context, r *api.RetrieveTokenRequest) (*oauth2.Token, string, error)
+	}
+
+	// VersionSaver is a Saver that also reports the VersionId of the secret version it
+	// wrote, empty when the secret store does not report versions.
+	VersionSaver interface {
+		SaveTokenWithVersion(ctx context.Context, r *api.SaveTokenRequest) (SaveResult, string, error)
 	}
 
+	// SaveResult tells whether a save created a new secret, SaveCreated, or updated an
+	// existing one, SaveUpdated.
+	SaveResult string
+
 	// ApiRetriever is the implementation for the Retriever interface.
 	// It contains secret.IDResolver and secret.Getter interfaces as dependencies
-	// to retrieve secrets for the tokens.
+	// to retrieve the tokens of Domain, DefaultDomain when empty.
 	ApiRetriever struct {
-		Env env.AwsVars
-		Res secret.IDResolver
-		Get secret.Getter
+		Env           env.AwsVars
+		Res           secret.IDResolver
+		Get           secret.Getter
+		Domain        string
+		Ref           Refresher
+		Put           secret.Putter
+		WriteBack     bool
+		Ser           Serializer
+		Dec           Serializer
+		MigrateOnRead bool
 	}
 
 	// ApiSaver is the implementation for the Saver interface.
 	// It contains secret.IDResolver, secret.Putter and secret.Creator interfaces as dependencies
-	// to create and store secrets for the tokens.
+	// to create and store secrets for the tokens of Domain, DefaultDomain when empty.
 	ApiSaver struct {
-		Res secret.IDResolver
-		Put secret.Putter
-		Ctr secret.Creator
+		Env             env.AwsVars
+		Res             secret.IDResolver
+		Put             secret.Putter
+		Ctr             secret.Creator
+		Ver             secret.Versioner
+		Lst             secret.Lister
+		MaxProviders    int
+		Retries         int
+		Domain          string
+		TokenType       string
+		Ser             Serializer
+		RequireExisting bool
+		ProviderTTLs    map[string]time.Duration
+		Get             secret.Getter
+		Dec             Serializer
+		locks           userLocks
 	}
 )
 
-func (rt *ApiRetriever) RetrieveToken(r *api.RetrieveTokenRequest) (*oauth2.Token, error) {
-	secretID, err := rt.Res.ResolveSecretID(&api.ResolveSecretRequest{
-		RootDomain: rt.Env.SmsRootDomain,
-		Domain:     "token",
-		UserID:     r.UserID})
+const (
+	// DefaultDomain is the secret domain tokens are stored under when none is configured.
+	DefaultDomain = "token"
+
+	// DefaultSaveRetries is the number of times a save is retried after a concurrent
+	// modification of the same secret.
+	DefaultSaveRetries = 3
+
+	// DefaultTokenType is the token type stored for tokens saved without one, when the
+	// ApiSaver has no TokenType configured.
+	DefaultTokenType = env.DefaultTokenType
+)
+
+const (
+	SaveCreated SaveResult = "created"
+	SaveUpdated SaveResult = "updated"
+)
+
+// ErrTooManyProviders is returned by ApiSaver.SaveToken when saving a token for a new
+// provider would exceed the MaxProviders of the user.
+var ErrTooManyProviders = errors.New("maximum number of providers reached")
+
+// ErrInvalidAccessToken is returned by ApiSaver.SaveToken for an access token that is blank
+// or contains control characters.
+var ErrInvalidAccessToken = errors.New("access token is blank or malformed")
+
+// RetrieveToken returns the stored token, decoded with Dec, which defaults to Ser. With a
+// Refresher, an expired token is refreshed, and stored again with WriteBack or when its
+// refresh token was rotated. With MigrateOnRead, a token in a format Dec reports as
+// outdated, see Migrator, is stored again. Tokens are written with Ser, JSONSerializer
+// when nil.
+func (rt *ApiRetriever) RetrieveToken(ctx context.Context, r *api.RetrieveTokenRequest) (*oauth2.Token, error) {
+	token, _, err := rt.RetrieveTokenWithVersion(ctx, r)
+	return token, err
+}
+
+// RetrieveTokenWithVersion is RetrieveToken reporting the VersionId of the secret version
+// the token was read from, which is only known when the secret.Getter is a
+// secret.MetaGetter. A refreshed token is reported with the version it was written back to,
+// or without a version when it was not written back.
+func (rt *ApiRetriever) RetrieveTokenWithVersion(ctx context.Context, r *api.RetrieveTokenRequest) (
+	*oauth2.Token, string, error) {
+	secretID, versionID, token, err := rt.readToken(ctx, r)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Could not retrieve token. Resolving SecretID failed: %v", err))
-		return nil, err
+		return nil, "", err
 	}
 
-	secretStr, err := rt.Get.GetSecret(&api.GetSecretRequest{SecretID: secretID})
+	// A historical version is returned as it was stored, refreshing it would overwrite the
+	// current version.
+	if rt.Ref == nil || r.VersionID != "" || token.Valid() || token.RefreshToken == "" {
+		return token, versionID, nil
+	}
+
+	return rt.refreshToken(ctx, secretID, token)
+}
+
+// DescribeToken describes the stored token as it is, an expired token is not refreshed.
+func (rt *ApiRetriever) DescribeToken(ctx context.Context, r *api.RetrieveTokenRequest) (*api.TokenDescription, error) {
+	_, _, token, err := rt.readToken(ctx, r)
 	if err != nil {
 		return nil, err
 	}
 
-	var token oauth2.Token
-	if err = json.Unmarshal([]byte(secretStr), &token); err != nil {
-		slog.Error(fmt.Sprintf("Unable to unmarshal secret JSON to oauth2.Token: %v", err))
-		return nil, err
+	return &api.TokenDescription{
+		UserID:          r.UserID,
+		Provider:        r.Provider,
+		TokenType:       token.Type(),
+		Expiry:          token.Expiry,
+		HasRefreshToken: token.RefreshToken != "",
+		Scopes:          strings.Fields(Scope(token))}, nil
+}
+
+// readToken resolves the secret of the token and decodes the stored token, returning the
+// secret ID and the VersionId read, when the secret.Getter reports it.
+func (rt *ApiRetriever) readToken(ctx context.Context, r *api.RetrieveTokenRequest) (string, string, *oauth2.Token, error) {
+	secretID, err := rt.Res.ResolveSecretID(ctx, &api.ResolveSecretRequest{
+		RootDomain:  rt.Env.SmsRootDomain,
+		Environment: rt.Env.Environment,
+		Domain:      domainOrDefault(rt.Domain),
+		UserID:      r.UserID,
+		Provider:    r.Provider})
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not retrieve token. Resolving SecretID failed: %v", err))
+		return "", "", nil, err
 	}
-	return &token, nil
+
+	secretStr, versionID, err := rt.getSecret(ctx, &api.GetSecretRequest{SecretID: secretID, VersionID: r.VersionID})
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	dec := rt.Dec
+	if dec == nil {
+		dec = serializerOrDefault(rt.Ser)
+	}
+	token, err := unmarshalToken(ctx, dec, secretID, secretStr)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Unable to unmarshal secret to oauth2.Token: %v", err))
+		return "", "", nil, err
+	}
+
+	if rt.MigrateOnRead && r.VersionID == "" && outdated(dec, secretStr) {
+		versionID = rt.migrateToken(ctx, secretID, token, versionID)
+	}
+
+	return secretID, versionID, token, nil
 }
 
-func (sv *ApiSaver) SaveToken(r *api.SaveTokenRequest) error {
-	tokenJSON, err := json.Marshal(oauth2.Token{
-		AccessToken:  r.AccessToken,
-		RefreshToken: r.RefreshToken,
-		Expiry:       r.Expiry})
+// getSecret reads the secret through the secret.Getter, with the VersionId read when it is
+// a secret.MetaGetter.
+func (rt *ApiRetriever) getSecret(ctx context.Context, r *api.GetSecretRequest) (string, string, error) {
+	if mg, ok := rt.Get.(secret.MetaGetter); ok {
+		value, err := mg.GetSecretWithMeta(ctx, r)
+		if err != nil {
+			return "", "", err
+		}
+		return value.Value, value.VersionID, nil
+	}
+
+	value, err := rt.Get.GetSecret(ctx, r)
+	return value, r.VersionID, err
+}
+
+// migrateToken stores a token read in an outdated format again in the current format and
+// returns the VersionId now holding the token. The write only succeeds while versionID, the
+// version read, is still current, so a token saved concurrently is never overwritten with
+// the one read; the migration is skipped when the secret.Getter does not report versions or
+// the secret changed. A failed migration is logged but does not fail the retrieval, the next
+// read tries again, and versionID is returned.
+func (rt *ApiRetriever) migrateToken(ctx context.Context, secretID string, tk *oauth2.Token, versionID string) string {
+	if versionID == "" {
+		return versionID
+	}
+
+	tokenStr, err := marshalToken(ctx, rt.Ser, secretID, tk)
 	if err != nil {
 		slog.Error(fmt.Sprintf("Unable to marshal oauth2.Token: %v", err))
-		return err
+		return versionID
+	}
+
+	migrated, err := secret.PutSecretWithVersion(ctx, rt.Put,
+		&api.PutSecretRequest{SecretID: secretID, Token: tokenStr, VersionID: versionID})
+	if errors.Is(err, secret.ErrVersionConflict) {
+		slog.Info(fmt.Sprintf("Skipped migrating token of secret %v, it was modified concurrently", secretID))
+		return versionID
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not migrate token of secret %v: %v", secretID, err))
+		return versionID
+	}
+	slog.Info(fmt.Sprintf("Migrated token of secret %v to the current format", secretID))
+
+	return migrated
+}
+
+// refreshToken refreshes an expired token and, with WriteBack, stores the new token. A
+// failed write-back is logged but does not fail the retrieval, since the caller can still
+// use the refreshed token. A rotated refresh token is always stored, the provider revoked
+// the stored one, and failing to store it fails the retrieval rather than serving a token
+// whose refresh token is lost. Providers often leave out the scope when refreshing, see
+// withStoredFields.
+func (rt *ApiRetriever) refreshToken(ctx context.Context, secretID string, tk *oauth2.Token) (
+	*oauth2.Token, string, error) {
+	refreshed, err := rt.Ref.RefreshToken(ctx, tk)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not refresh token of secret %v: %v", secretID, err))
+		return nil, "", err
+	}
+	refreshed = withStoredFields(refreshed, tk)
+
+	rotated := refreshed.RefreshToken != "" && refreshed.RefreshToken != tk.RefreshToken
+	if !rt.WriteBack && !rotated {
+		return refreshed, "", nil
+	}
+
+	tokenStr, err := marshalToken(ctx, rt.Ser, secretID, refreshed)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Unable to marshal oauth2.Token: %v", err))
+		if rotated {
+			return nil, "", err
+		}
+		return refreshed, "", nil
+	}
+
+	versionID, err := secret.PutSecretWithVersion(ctx, rt.Put, &api.PutSecretRequest{SecretID: secretID, Token: tokenStr})
+	if err != nil {
+		if rotated {
+			slog.Error(fmt.Sprintf("Could not store rotated refresh token of secret %v: %v", secretID, err))
+			return nil, "", err
+		}
+		slog.Error(fmt.Sprintf("Could not write back refreshed token of secret %v: %v", secretID, err))
+	}
+
+	return refreshed, versionID, nil
+}
+
+// SaveToken stores the token with Ser, JSONSerializer when nil, creating the secret of a
+// user without one unless RequireExisting is set. Saves of a user are serialized, and with
+// a secret.Versioner each update is conditional on the version read before it and retried
+// up to Retries times. A token of a new provider beyond MaxProviders fails with
+// ErrTooManyProviders, a blank or malformed access token with ErrInvalidAccessToken.
+// ProviderTTLs gives the tokens of a provider a DeleteAfter time, which later saves keep.
+func (sv *ApiSaver) SaveToken(ctx context.Context, r *api.SaveTokenRequest) (SaveResult, error) {
+	result, _, err := sv.SaveTokenWithVersion(ctx, r)
+	return result, err
+}
+
+// SaveTokenWithVersion is SaveToken reporting the VersionId of the secret version written,
+// which is empty when the secret.Putter or secret.Creator does not report versions.
+func (sv *ApiSaver) SaveTokenWithVersion(ctx context.Context, r *api.SaveTokenRequest) (SaveResult, string, error) {
+	accessToken, err := validAccessToken(r.AccessToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	unlock := sv.locks.lock(r.UserID)
+	defer unlock()
+
+	tokenType := r.TokenType
+	if tokenType == "" {
+		tokenType = cmp.Or(sv.TokenType, DefaultTokenType)
+	}
+
+	tk := &oauth2.Token{
+		AccessToken:  accessToken,
+		TokenType:    tokenType,
+		RefreshToken: r.RefreshToken,
+		Expiry:       r.Expiry}
+	if r.Scope != "" {
+		tk = WithScope(tk, r.Scope)
+	}
+	if r.Provider != "" {
+		tk = WithProvider(tk, r.Provider)
+	}
+	ttl := sv.ProviderTTLs[r.Provider]
+	if ttl > 0 {
+		tk = WithDeleteAfter(tk, time.Now().Add(ttl))
+	}
+
+	secretID, err := sv.Res.ResolveSecretID(ctx, &api.ResolveSecretRequest{
+		RootDomain:  sv.Env.SmsRootDomain,
+		Environment: sv.Env.Environment,
+		Domain:      domainOrDefault(sv.Domain),
+		UserID:      r.UserID,
+		Provider:    r.Provider})
+	missing := secret.IsErrorResourceNotFound(err)
+	if err != nil && !missing {
+		return "", "", err
+	}
+	if missing && sv.RequireExisting {
+		slog.Warn(fmt.Sprintf("Secret %v does not exist and creating secrets is disabled", secretID))
+		return "", "", err
 	}
 
-	secretID, err := sv.Res.ResolveSecretID(&api.ResolveSecretRequest{
-		Domain: "token",
-		UserID: r.UserID})
+	// The token is marshalled for its secret, e.g. a RefreshTokenSerializer binds the
+	// encrypted refresh token to it.
+	tokenStr, err := marshalToken(ctx, sv.Ser, secretID, tk)
 	if err != nil {
-		if secret.IsErrorResourceNotFound(err) {
-			return sv.Ctr.CreateSecret(&api.CreateSecretRequest{
-				SecretID: secretID,
-				Token:    string(tokenJSON)})
+		slog.Error(fmt.Sprintf("Unable to marshal oauth2.Token: %v", err))
+		return "", "", err
+	}
+
+	if missing {
+		if err := sv.checkProviderLimit(ctx, r); err != nil {
+			return "", "", err
+		}
+
+		versionID, err := secret.CreateSecretWithVersion(ctx, sv.Ctr, &api.CreateSecretRequest{
+			SecretID: secretID,
+			Token:    tokenStr})
+		if err == nil {
+			return SaveCreated, versionID, nil
 		}
+		if !secret.IsErrorResourceExists(err) {
+			return "", "", err
+		}
+		// A concurrent save created the secret after we resolved it, so update it instead.
+		slog.Info(fmt.Sprintf("Secret %v was created concurrently, updating it instead", secretID))
+	}
+
+	versionID, err := sv.putSecret(ctx, secretID, tk, tokenStr, ttl > 0)
+	if err != nil {
+		return "", "", err
+	}
+
+	return SaveUpdated, versionID, nil
+}
+
+// keepDeleteAfter returns tokenStr, the encoding of tk, again with the DeleteAfter time of
+// the token stored in the secret, so updating a token does not extend its lifetime. A
+// stored token without a DeleteAfter time, saved before the lifetime of its provider was
+// configured, or one that cannot be read, e.g. without a secret.Getter, starts its
+// lifetime with this save.
+func (sv *ApiSaver) keepDeleteAfter(ctx context.Context, secretID string, tk *oauth2.Token, tokenStr string) (string, error) {
+	if sv.Get == nil {
+		return tokenStr, nil
+	}
+
+	secretStr, err := sv.Get.GetSecret(ctx, &api.GetSecretRequest{SecretID: secretID})
+	if err != nil {
+		return "", err
+	}
+
+	dec := sv.Dec
+	if dec == nil {
+		dec = serializerOrDefault(sv.Ser)
+	}
+	stored, err := unmarshalToken(ctx, dec, secretID, secretStr)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Unable to read the lifetime of the token of secret %v: %v", secretID, err))
+		return tokenStr, nil
+	}
+	if DeleteAfter(stored).IsZero() {
+		return tokenStr, nil
+	}
+
+	return marshalToken(ctx, sv.Ser, secretID, WithDeleteAfter(tk, DeleteAfter(stored)))
+}
+
+// checkProviderLimit lists the provider secrets of the user and fails with
+// ErrTooManyProviders when there are MaxProviders of them already. Tokens saved without a
+// provider are not limited.
+func (sv *ApiSaver) checkProviderLimit(ctx context.Context, r *api.SaveTokenRequest) error {
+	if sv.MaxProviders <= 0 || sv.Lst == nil || r.Provider == "" {
+		return nil
+	}
+
+	id := domainSecretID(sv.Env, sv.Domain)
+	id.UserID = r.UserID
+	listed, err := sv.Lst.ListSecrets(ctx, &api.ListSecretsRequest{Prefix: id.UserPrefix()})
+	if err != nil {
 		return err
 	}
 
-	return sv.Put.PutSecret(&api.PutSecretRequest{SecretID: secretID, Token: string(tokenJSON)})
+	// The prefix also matches the secrets of users whose ID extends this one with a slash.
+	var secrets []api.SecretSummary
+	for _, s := range listed {
+		parsed, err := api.ParseSecretID(s.SecretID, id.RootDomain, id.Environment)
+		if err == nil && parsed.UserID == id.UserID && parsed.Provider != "" {
+			secrets = append(secrets, s)
+		}
+	}
+
+	if len(secrets) >= sv.MaxProviders {
+		slog.Warn(fmt.Sprintf("User %v already stores %d provider tokens", r.UserID, len(secrets)))
+		return ErrTooManyProviders
+	}
+
+	return nil
+}
+
+// withStoredFields returns refreshed with the fields stored next to the token it replaces:
+// its scope, see withGrantedScope, its DeleteAfter time, since a refresh does not extend
+// the lifetime of a token, and its Provider.
+func withStoredFields(refreshed *oauth2.Token, tk *oauth2.Token) *oauth2.Token {
+	refreshed = withGrantedScope(refreshed, tk)
+	if DeleteAfter(tk).IsZero() && Provider(tk) == "" {
+		return refreshed
+	}
+
+	e := extrasOf(refreshed)
+	e.deleteAfter, e.provider = DeleteAfter(tk), Provider(tk)
+	return e.apply(refreshed)
+}
+
+// withGrantedScope returns refreshed with the scope of the token it replaces when the
+// provider left the scope out of the refresh response.
+func withGrantedScope(refreshed *oauth2.Token, tk *oauth2.Token) *oauth2.Token {
+	if Scope(refreshed) != "" || Scope(tk) == "" {
+		return refreshed
+	}
+
+	return WithScope(refreshed, Scope(tk))
+}
+
+// putSecret stores tk, encoded as tokenStr, in an existing secret and returns the VersionId
+// of the version written, see secret.PutSecretWithVersion. With keep, the DeleteAfter time
+// of the stored token is merged in, see keepDeleteAfter. Without a secret.Versioner it is a
+// plain put, otherwise it reads the current version, puts conditionally on that version and
+// retries the read-merge-put whenever another writer got in between.
+func (sv *ApiSaver) putSecret(ctx context.Context, secretID string, tk *oauth2.Token, tokenStr string, keep bool) (
+	string, error) {
+	merge := func() (string, error) {
+		if !keep {
+			return tokenStr, nil
+		}
+		return sv.keepDeleteAfter(ctx, secretID, tk, tokenStr)
+	}
+
+	if sv.Ver == nil {
+		merged, err := merge()
+		if err != nil {
+			return "", err
+		}
+		return secret.PutSecretWithVersion(ctx, sv.Put, &api.PutSecretRequest{SecretID: secretID, Token: merged})
+	}
+
+	var err error
+	for attempt := 0; attempt <= sv.Retries; attempt++ {
+		var versionID, merged, written string
+		versionID, err = sv.Ver.GetSecretVersion(ctx, &api.GetSecretRequest{SecretID: secretID})
+		if err != nil {
+			return "", err
+		}
+		// A write after the version was read fails the put below, so the merged token is
+		// read from that version or a later one.
+		if merged, err = merge(); err != nil {
+			return "", err
+		}
+
+		written, err = secret.PutSecretWithVersion(ctx, sv.Put,
+			&api.PutSecretRequest{SecretID: secretID, Token: merged, VersionID: versionID})
+		if !errors.Is(err, secret.ErrVersionConflict) {
+			return written, err
+		}
+		slog.Warn(fmt.Sprintf("Concurrent modification of secret %v, attempt %d", secretID, attempt+1))
+	}
+
+	return "", err
+}
+
+// domainSecretID returns the api.SecretID of the domain, without a user, under the root
+// domain and environment of vars.
+func domainSecretID(vars env.AwsVars, domain string) api.SecretID {
+	return api.SecretID{RootDomain: vars.SmsRootDomain, Environment: vars.Environment, Domain: domainOrDefault(domain)}
+}
+
+func domainOrDefault(domain string) string {
+	if domain == "" {
+		return DefaultDomain
+	}
+
+	return domain
+}
+
+// validAccessToken returns accessToken without surrounding whitespace, or an
+// ErrInvalidAccessToken when nothing is left or it contains control characters, which no
+// provider issues.
+func validAccessToken(accessToken string) (string, error) {
+	accessToken = strings.TrimSpace(accessToken)
+	if accessToken == "" || strings.ContainsFunc(accessToken, unicode.IsControl) {
+		return "", ErrInvalidAccessToken
+	}
+
+	return accessToken, nil
 }
@@ -3,35 +3,62 @@ package token
 import (
 	"app/api"
 	"app/env"
+	"app/internal/secret"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 	"golang.org/x/oauth2"
 	"log/slog"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 type SecretFuncStub struct {
-	ResolveSecretIDFunc func(request *api.ResolveSecretRequest) (string, error)
-	GetSecretFunc       func(request *api.GetSecretRequest) (string, error)
-	PutSecretFunc       func(request *api.PutSecretRequest) error
-	CreateSecretFunc    func(request *api.CreateSecretRequest) error
+	ResolveSecretIDFunc  func(request *api.ResolveSecretRequest) (string, error)
+	GetSecretFunc        func(request *api.GetSecretRequest) (string, error)
+	PutSecretFunc        func(request *api.PutSecretRequest) error
+	CreateSecretFunc     func(request *api.CreateSecretRequest) error
+	GetSecretVersionFunc func(request *api.GetSecretRequest) (string, error)
+	ListSecretsFunc      func(request *api.ListSecretsRequest) ([]api.SecretSummary, error)
+	DeleteSecretFunc     func(request *api.DeleteSecretRequest) error
+	RollbackSecretFunc   func(request *api.RollbackSecretRequest) (string, error)
 }
 
-func (s *SecretFuncStub) ResolveSecretID(request *api.ResolveSecretRequest) (string, error) {
+func (s *SecretFuncStub) ResolveSecretID(ctx context.Context, request *api.ResolveSecretRequest) (string, error) {
 	return s.ResolveSecretIDFunc(request)
 }
 
-func (s *SecretFuncStub) GetSecret(request *api.GetSecretRequest) (string, error) {
+func (s *SecretFuncStub) GetSecret(ctx context.Context, request *api.GetSecretRequest) (string, error) {
 	return s.GetSecretFunc(request)
 }
 
-func (s *SecretFuncStub) PutSecret(request *api.PutSecretRequest) error {
+func (s *SecretFuncStub) PutSecret(ctx context.Context, request *api.PutSecretRequest) error {
 	return s.PutSecretFunc(request)
 }
 
-func (s *SecretFuncStub) CreateSecret(request *api.CreateSecretRequest) error {
+func (s *SecretFuncStub) CreateSecret(ctx context.Context, request *api.CreateSecretRequest) error {
 	return s.CreateSecretFunc(request)
 }
 
+func (s *SecretFuncStub) GetSecretVersion(ctx context.Context, request *api.GetSecretRequest) (string, error) {
+	return s.GetSecretVersionFunc(request)
+}
+
+func (s *SecretFuncStub) ListSecrets(ctx context.Context, request *api.ListSecretsRequest) ([]api.SecretSummary, error) {
+	return s.ListSecretsFunc(request)
+}
+
+func (s *SecretFuncStub) DeleteSecret(ctx context.Context, request *api.DeleteSecretRequest) error {
+	return s.DeleteSecretFunc(request)
+}
+
+func (s *SecretFuncStub) RollbackSecret(ctx context.Context, request *api.RollbackSecretRequest) (string, error) {
+	return s.RollbackSecretFunc(request)
+}
+
 func TestOAuthManager_Retrieve(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -109,7 +136,7 @@ func TestOAuthManager_Retrieve(t *testing.T) {
 			}
 			retr := ApiRetriever{Env: vars, Res: tt.stub, Get: tt.stub}
 
-			res, err := retr.RetrieveToken(&tt.request)
+			res, err := retr.RetrieveToken(context.Background(), &tt.request)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Retrieve() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -126,6 +153,7 @@ func TestOAuthManager_Save(t *testing.T) {
 		name    string
 		stub    *SecretFuncStub
 		request api.SaveTokenRequest
+		want    SaveResult
 		wantErr bool
 	}{
 		{
@@ -143,6 +171,7 @@ func TestOAuthManager_Save(t *testing.T) {
 				AccessToken:  "access_token",
 				RefreshToken: "refresh_token",
 			},
+			want:    SaveUpdated,
 			wantErr: false,
 		},
 		{
@@ -160,6 +189,7 @@ func TestOAuthManager_Save(t *testing.T) {
 				AccessToken:  "access_token",
 				RefreshToken: "refresh_token",
 			},
+			want:    SaveCreated,
 			wantErr: false,
 		},
 		{
@@ -193,6 +223,47 @@ func TestOAuthManager_Save(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "SaveTokenCreateLimitExceeded",
+			stub: &SecretFuncStub{
+				ResolveSecretIDFunc: func(request *api.ResolveSecretRequest) (string, error) {
+					return "secretID", &types.ResourceNotFoundException{}
+				},
+				CreateSecretFunc: func(request *api.CreateSecretRequest) error {
+					return &types.LimitExceededException{}
+				},
+			},
+			request: api.SaveTokenRequest{
+				UserID:       "userID",
+				AccessToken:  "access_token",
+				RefreshToken: "refresh_token",
+			},
+			wantErr: true,
+		},
+		{
+			name: "SaveTokenCreateConflictFallsBackToPut",
+			stub: &SecretFuncStub{
+				ResolveSecretIDFunc: func(request *api.ResolveSecretRequest) (string, error) {
+					return "secretID", &types.ResourceNotFoundException{}
+				},
+				CreateSecretFunc: func(request *api.CreateSecretRequest) error {
+					return &types.ResourceExistsException{}
+				},
+				PutSecretFunc: func(request *api.PutSecretRequest) error {
+					if request.SecretID != "secretID" {
+						return &types.ResourceNotFoundException{}
+					}
+					return nil
+				},
+			},
+			request: api.SaveTokenRequest{
+				UserID:       "userID",
+				AccessToken:  "access_token",
+				RefreshToken: "refresh_token",
+			},
+			want:    SaveUpdated,
+			wantErr: false,
+		},
 		{
 			name: "SaveTokenPutSecretError",
 			stub: &SecretFuncStub{
@@ -214,12 +285,949 @@ func TestOAuthManager_Save(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			svr := ApiSaver{tt.stub, tt.stub, tt.stub}
+			svr := ApiSaver{Res: tt.stub, Put: tt.stub, Ctr: tt.stub}
 
-			err := svr.SaveToken(&tt.request)
+			res, err := svr.SaveToken(context.Background(), &tt.request)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Save() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if res != tt.want {
+				t.Errorf("Save() = %q, want %q", res, tt.want)
+			}
 		})
 	}
 }
+
+func TestApiSaver_RequireExisting(t *testing.T) {
+	tests := []struct {
+		name            string
+		requireExisting bool
+		want            SaveResult
+		wantNotFound    bool
+		wantStored      int
+	}{
+		{
+			name:       "MissingSecretCreated",
+			want:       SaveCreated,
+			wantStored: 1,
+		},
+		{
+			name:            "RequireExistingNotFound",
+			requireExisting: true,
+			wantNotFound:    true,
+			wantStored:      0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := secret.NewMemoryStore()
+			svr := ApiSaver{Res: store, Put: store, Ctr: store, RequireExisting: tt.requireExisting}
+
+			res, err := svr.SaveToken(context.Background(), &api.SaveTokenRequest{UserID: "userID", AccessToken: "access_token"})
+			if secret.IsErrorResourceNotFound(err) != tt.wantNotFound {
+				t.Fatalf("SaveToken() error = %v, wantNotFound %v", err, tt.wantNotFound)
+			}
+			if !tt.wantNotFound && err != nil {
+				t.Fatalf("SaveToken() error = %v", err)
+			}
+			if res != tt.want {
+				t.Errorf("SaveToken() = %q, want %q", res, tt.want)
+			}
+			secrets, _ := store.ListSecrets(context.Background(), &api.ListSecretsRequest{})
+			if len(secrets) != tt.wantStored {
+				t.Errorf("SaveToken() stored %v secrets, want %v", len(secrets), tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestApiSaver_InvalidAccessToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		accessToken string
+		want        string
+		wantErr     error
+	}{
+		{
+			name:        "AccessTokenWhitespaceOnly",
+			accessToken: " \t\n ",
+			wantErr:     ErrInvalidAccessToken,
+		},
+		{
+			name:        "AccessTokenControlCharacter",
+			accessToken: "access\x00token",
+			wantErr:     ErrInvalidAccessToken,
+		},
+		{
+			name:        "AccessTokenEmbeddedNewline",
+			accessToken: "access\ntoken",
+			wantErr:     ErrInvalidAccessToken,
+		},
+		{
+			name:        "AccessTokenTrimmed",
+			accessToken: "  access_token\n",
+			want:        "access_token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored string
+			calls := 0
+			stub := &SecretFuncStub{
+				ResolveSecretIDFunc: func(r *api.ResolveSecretRequest) (string, error) {
+					calls++
+					return "secretID", nil
+				},
+				PutSecretFunc: func(r *api.PutSecretRequest) error {
+					calls++
+					stored = r.Token
+					return nil
+				},
+			}
+			svr := ApiSaver{Res: stub, Put: stub}
+
+			_, err := svr.SaveToken(context.Background(), &api.SaveTokenRequest{UserID: "userID", AccessToken: tt.accessToken})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SaveToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if calls != 0 {
+					t.Errorf("SaveToken() made %d secret calls, want none", calls)
+				}
+				return
+			}
+			tk, err := JSONSerializer{}.Unmarshal(stored)
+			if err != nil || tk.AccessToken != tt.want {
+				t.Errorf("SaveToken() stored %v, want access token %q", stored, tt.want)
+			}
+		})
+	}
+}
+
+func TestApiSaver_MaxProviders(t *testing.T) {
+	tests := []struct {
+		name       string
+		owner      string
+		existing   []string
+		provider   string
+		want       SaveResult
+		wantErr    error
+		wantStored int
+	}{
+		{
+			name:       "MaxProvidersUnderLimitCreates",
+			owner:      "userID",
+			existing:   []string{"google"},
+			provider:   "github",
+			want:       SaveCreated,
+			wantStored: 2,
+		},
+		{
+			name:       "MaxProvidersAtLimitBlocked",
+			owner:      "userID",
+			existing:   []string{"google", "github"},
+			provider:   "gitlab",
+			wantErr:    ErrTooManyProviders,
+			wantStored: 2,
+		},
+		{
+			name:       "MaxProvidersAtLimitUpdatesExisting",
+			owner:      "userID",
+			existing:   []string{"google", "github"},
+			provider:   "google",
+			want:       SaveUpdated,
+			wantStored: 2,
+		},
+		{
+			name:       "MaxProvidersIgnoresOtherUsers",
+			owner:      "userID2",
+			existing:   []string{"google", "github"},
+			provider:   "gitlab",
+			want:       SaveCreated,
+			wantStored: 3,
+		},
+		{
+			name:       "MaxProvidersIgnoresNestedUsers",
+			owner:      "userID/nested",
+			existing:   []string{"google", "github"},
+			provider:   "gitlab",
+			want:       SaveCreated,
+			wantStored: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := secret.NewMemoryStore()
+			svr := ApiSaver{Res: store, Put: store, Ctr: store, Lst: store, MaxProviders: 2}
+
+			for _, p := range tt.existing {
+				_, err := svr.SaveToken(context.Background(), &api.SaveTokenRequest{UserID: tt.owner, Provider: p, AccessToken: "access_token"})
+				if err != nil {
+					t.Fatalf("SaveToken() setup error = %v", err)
+				}
+			}
+
+			res, err := svr.SaveToken(context.Background(), &api.SaveTokenRequest{UserID: "userID", Provider: tt.provider, AccessToken: "access_token"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SaveToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if res != tt.want {
+				t.Errorf("SaveToken() = %q, want %q", res, tt.want)
+			}
+			secrets, _ := store.ListSecrets(context.Background(), &api.ListSecretsRequest{})
+			if len(secrets) != tt.wantStored {
+				t.Errorf("SaveToken() stored %v secrets, want %v", len(secrets), tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestOAuthManager_SaveVersionConflict(t *testing.T) {
+	tests := []struct {
+		name         string
+		provider     string
+		conflicts    int
+		retries      int
+		wantVersions int
+		wantPuts     int
+		wantErr      bool
+	}{
+		{
+			name:         "SaveTokenRetriesAfterStaleVersion",
+			provider:     "google",
+			conflicts:    1,
+			retries:      3,
+			wantVersions: 2,
+			wantPuts:     2,
+			wantErr:      false,
+		},
+		{
+			name:         "SaveTokenRetriesExhausted",
+			provider:     "google",
+			conflicts:    5,
+			retries:      2,
+			wantVersions: 3,
+			wantPuts:     3,
+			wantErr:      true,
+		},
+		{
+			name:         "SaveTokenWithoutTTLRetriesAfterStaleVersion",
+			conflicts:    1,
+			retries:      3,
+			wantVersions: 2,
+			wantPuts:     2,
+			wantErr:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+			versions, puts := 0, 0
+			stub := &SecretFuncStub{
+				ResolveSecretIDFunc: func(request *api.ResolveSecretRequest) (string, error) {
+					return "secretID", nil
+				},
+				GetSecretVersionFunc: func(request *api.GetSecretRequest) (string, error) {
+					versions++
+					return fmt.Sprintf("v%d", versions), nil
+				},
+				GetSecretFunc: func(request *api.GetSecretRequest) (string, error) {
+					// Each version stores its own DeleteAfter time, the save must keep the one
+					// of the version it is conditional on.
+					stored := WithDeleteAfter(&oauth2.Token{AccessToken: "old"}, base.AddDate(0, 0, versions))
+					return JSONSerializer{}.Marshal(stored)
+				},
+				PutSecretFunc: func(request *api.PutSecretRequest) error {
+					puts++
+					if request.VersionID != fmt.Sprintf("v%d", puts) {
+						t.Errorf("PutSecret() VersionID = %v, want v%d", request.VersionID, puts)
+					}
+					tk, err := JSONSerializer{}.Unmarshal(request.Token)
+					if err != nil {
+						return err
+					}
+					want := base.AddDate(0, 0, puts)
+					if tt.provider == "" {
+						want = time.Time{}
+					}
+					if !DeleteAfter(tk).Equal(want) {
+						t.Errorf("PutSecret() DeleteAfter = %v, want %v", DeleteAfter(tk), want)
+					}
+					if puts <= tt.conflicts {
+						return secret.ErrVersionConflict
+					}
+					return nil
+				},
+			}
+			svr := ApiSaver{
+				Res:          stub,
+				Put:          stub,
+				Ctr:          stub,
+				Ver:          stub,
+				Get:          stub,
+				Retries:      tt.retries,
+				ProviderTTLs: map[string]time.Duration{"google": time.Hour}}
+
+			_, err := svr.SaveToken(context.Background(), &api.SaveTokenRequest{
+				UserID: "userID", Provider: tt.provider, AccessToken: "access_token"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Save() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if puts != tt.wantPuts || versions != tt.wantVersions {
+				t.Errorf("Save() puts = %v, versions = %v, want %v, %v", puts, versions, tt.wantPuts, tt.wantVersions)
+			}
+		})
+	}
+}
+
+type RefresherStub struct {
+	RefreshTokenFunc func(tk *oauth2.Token) (*oauth2.Token, error)
+}
+
+func (r *RefresherStub) RefreshToken(ctx context.Context, tk *oauth2.Token) (*oauth2.Token, error) {
+	return r.RefreshTokenFunc(tk)
+}
+
+func TestApiRetriever_Refresh(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		versionID string
+		writeBack bool
+		putErr    error
+		want      string
+		wantPuts  int
+		wantErr   bool
+	}{
+		{
+			name:      "RefreshWithWriteBack",
+			secret:    `{"access_token":"old","refresh_token":"refresh_token","expiry":"2000-01-01T00:00:00Z"}`,
+			writeBack: true,
+			want:      "new",
+			wantPuts:  1,
+		},
+		{
+			name:      "RefreshWithoutWriteBack",
+			secret:    `{"access_token":"old","refresh_token":"refresh_token","expiry":"2000-01-01T00:00:00Z"}`,
+			writeBack: false,
+			want:      "new",
+			wantPuts:  0,
+		},
+		{
+			name:      "RefreshWriteBackErrorIgnored",
+			secret:    `{"access_token":"old","refresh_token":"refresh_token","expiry":"2000-01-01T00:00:00Z"}`,
+			writeBack: true,
+			putErr:    &types.InvalidRequestException{},
+			want:      "new",
+			wantPuts:  1,
+		},
+		{
+			name:      "RefreshSkippedForValidToken",
+			secret:    `{"access_token":"old","refresh_token":"refresh_token","expiry":"2100-01-01T00:00:00Z"}`,
+			writeBack: true,
+			want:      "old",
+			wantPuts:  0,
+		},
+		{
+			name:      "RefreshSkippedWithoutRefreshToken",
+			secret:    `{"access_token":"old","expiry":"2000-01-01T00:00:00Z"}`,
+			writeBack: true,
+			want:      "old",
+			wantPuts:  0,
+		},
+		{
+			name:      "RefreshSkippedForHistoricalVersion",
+			secret:    `{"access_token":"old","refresh_token":"refresh_token","expiry":"2000-01-01T00:00:00Z"}`,
+			versionID: "EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE",
+			writeBack: true,
+			want:      "old",
+			wantPuts:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			puts := 0
+			stub := &SecretFuncStub{
+				ResolveSecretIDFunc: func(request *api.ResolveSecretRequest) (string, error) {
+					return "secretID", nil
+				},
+				GetSecretFunc: func(request *api.GetSecretRequest) (string, error) {
+					if request.VersionID != tt.versionID {
+						return "", errors.New("unexpected version")
+					}
+					return tt.secret, nil
+				},
+				PutSecretFunc: func(request *api.PutSecretRequest) error {
+					puts++
+					return tt.putErr
+				},
+			}
+			ref := &RefresherStub{RefreshTokenFunc: func(tk *oauth2.Token) (*oauth2.Token, error) {
+				return &oauth2.Token{AccessToken: "new", RefreshToken: tk.RefreshToken}, nil
+			}}
+			retr := ApiRetriever{Res: stub, Get: stub, Ref: ref, Put: stub, WriteBack: tt.writeBack}
+
+			res, err := retr.RetrieveToken(context.Background(), &api.RetrieveTokenRequest{UserID: "userID", VersionID: tt.versionID})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Retrieve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if res.AccessToken != tt.want {
+				t.Errorf("Retrieve() = %v, want %v", res.AccessToken, tt.want)
+			}
+			if puts != tt.wantPuts {
+				t.Errorf("Retrieve() puts = %v, wantPuts %v", puts, tt.wantPuts)
+			}
+		})
+	}
+}
+
+func TestApiSaver_TokenType(t *testing.T) {
+	tests := []struct {
+		name      string
+		saverType string
+		request   string
+		want      string
+	}{
+		{
+			name: "TokenTypeDefault",
+			want: DefaultTokenType,
+		},
+		{
+			name:      "TokenTypeConfiguredDefault",
+			saverType: "MAC",
+			want:      "MAC",
+		},
+		{
+			name:      "TokenTypeExplicit",
+			saverType: "MAC",
+			request:   "DPoP",
+			want:      "DPoP",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored string
+			stub := &SecretFuncStub{
+				ResolveSecretIDFunc: func(request *api.ResolveSecretRequest) (string, error) {
+					return "secretID", nil
+				},
+				PutSecretFunc: func(request *api.PutSecretRequest) error {
+					stored = request.Token
+					return nil
+				},
+				GetSecretFunc: func(request *api.GetSecretRequest) (string, error) {
+					return stored, nil
+				},
+			}
+			svr := ApiSaver{Res: stub, Put: stub, Ctr: stub, TokenType: tt.saverType}
+			retr := ApiRetriever{Res: stub, Get: stub}
+
+			_, err := svr.SaveToken(context.Background(), &api.SaveTokenRequest{UserID: "userID", AccessToken: "access_token", TokenType: tt.request})
+			if err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			res, err := retr.RetrieveToken(context.Background(), &api.RetrieveTokenRequest{UserID: "userID"})
+			if err != nil {
+				t.Fatalf("Retrieve() error = %v", err)
+			}
+			if res.TokenType != tt.want {
+				t.Errorf("Retrieve() TokenType = %v, want %v", res.TokenType, tt.want)
+			}
+		})
+	}
+}
+
+type SerializerStub struct {
+	MarshalFunc   func(tk *oauth2.Token) (string, error)
+	UnmarshalFunc func(s string) (*oauth2.Token, error)
+}
+
+func (s *SerializerStub) Marshal(tk *oauth2.Token) (string, error) {
+	return s.MarshalFunc(tk)
+}
+
+func (s *SerializerStub) Unmarshal(str string) (*oauth2.Token, error) {
+	return s.UnmarshalFunc(str)
+}
+
+func TestOAuthManager_Serializer(t *testing.T) {
+	var stored string
+	stub := &SecretFuncStub{
+		ResolveSecretIDFunc: func(request *api.ResolveSecretRequest) (string, error) {
+			return "secretID", nil
+		},
+		PutSecretFunc: func(request *api.PutSecretRequest) error {
+			stored = request.Token
+			return nil
+		},
+		GetSecretFunc: func(request *api.GetSecretRequest) (string, error) {
+			return stored, nil
+		},
+	}
+	ser := &SerializerStub{
+		MarshalFunc: func(tk *oauth2.Token) (string, error) {
+			return tk.AccessToken + "|" + tk.RefreshToken, nil
+		},
+		UnmarshalFunc: func(s string) (*oauth2.Token, error) {
+			access, refresh, _ := strings.Cut(s, "|")
+			return &oauth2.Token{AccessToken: access, RefreshToken: refresh}, nil
+		},
+	}
+	svr := ApiSaver{Res: stub, Put: stub, Ctr: stub, Ser: ser}
+	retr := ApiRetriever{Res: stub, Get: stub, Ser: ser}
+
+	_, err := svr.SaveToken(context.Background(), &api.SaveTokenRequest{UserID: "userID", AccessToken: "access_token", RefreshToken: "refresh_token"})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if stored != "access_token|refresh_token" {
+		t.Errorf("Save() stored = %v, want access_token|refresh_token", stored)
+	}
+
+	res, err := retr.RetrieveToken(context.Background(), &api.RetrieveTokenRequest{UserID: "userID"})
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if res.AccessToken != "access_token" || res.RefreshToken != "refresh_token" {
+		t.Errorf("Retrieve() = %v, want access_token and refresh_token", res)
+	}
+
+	retr.Ser = EnvelopeSerializer{Version: 1}
+	stored = `{"v":2,"token":{"access_token":"access_token"}}`
+	var versionErr *ErrSerializerVersion
+	if _, err = retr.RetrieveToken(context.Background(), &api.RetrieveTokenRequest{UserID: "userID"}); !errors.As(err, &versionErr) {
+		t.Errorf("Retrieve() error = %v, want ErrSerializerVersion", err)
+	}
+}
+
+func TestApiRetriever_RefreshTokenRotated(t *testing.T) {
+	tests := []struct {
+		name         string
+		refreshed    string
+		writeBack    bool
+		putErr       error
+		wantPuts     int
+		wantPutToken string
+		wantErr      bool
+	}{
+		{
+			name:         "RotatedPersisted",
+			refreshed:    "rotated_refresh_token",
+			writeBack:    true,
+			wantPuts:     1,
+			wantPutToken: "rotated_refresh_token",
+		},
+		{
+			name:         "UnchangedPersistedOnce",
+			refreshed:    "refresh_token",
+			writeBack:    true,
+			wantPuts:     1,
+			wantPutToken: "refresh_token",
+		},
+		{
+			name:         "RotatedPersistedWithoutWriteBack",
+			refreshed:    "rotated_refresh_token",
+			writeBack:    false,
+			wantPuts:     1,
+			wantPutToken: "rotated_refresh_token",
+		},
+		{
+			name:      "UnchangedNotPersistedWithoutWriteBack",
+			refreshed: "refresh_token",
+			writeBack: false,
+			wantPuts:  0,
+		},
+		{
+			name:         "RotatedPersistErrorFails",
+			refreshed:    "rotated_refresh_token",
+			writeBack:    false,
+			putErr:       errors.New("access denied"),
+			wantPuts:     1,
+			wantPutToken: "rotated_refresh_token",
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			puts := 0
+			var putToken string
+			stub := &SecretFuncStub{
+				ResolveSecretIDFunc: func(request *api.ResolveSecretRequest) (string, error) {
+					return "secretID", nil
+				},
+				GetSecretFunc: func(request *api.GetSecretRequest) (string, error) {
+					return `{"access_token":"old","refresh_token":"refresh_token","expiry":"2000-01-01T00:00:00Z"}`, nil
+				},
+				PutSecretFunc: func(request *api.PutSecretRequest) error {
+					puts++
+					tk, err := JSONSerializer{}.Unmarshal(request.Token)
+					if err != nil {
+						return err
+					}
+					putToken = tk.RefreshToken
+					return tt.putErr
+				},
+			}
+			ref := &RefresherStub{RefreshTokenFunc: func(tk *oauth2.Token) (*oauth2.Token, error) {
+				return &oauth2.Token{AccessToken: "new", RefreshToken: tt.refreshed}, nil
+			}}
+			retr := ApiRetriever{Res: stub, Get: stub, Ref: ref, Put: stub, WriteBack: tt.writeBack}
+
+			tk, err := retr.RetrieveToken(context.Background(), &api.RetrieveTokenRequest{UserID: "userID"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Retrieve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && tk != nil {
+				t.Errorf("Retrieve() = %v, want no token", tk)
+			}
+			if puts != tt.wantPuts || putToken != tt.wantPutToken {
+				t.Errorf("Retrieve() puts = %v of %v, want %v of %v", puts, putToken, tt.wantPuts, tt.wantPutToken)
+			}
+		})
+	}
+}
+
+func TestApiRetriever_RefreshKeepsScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		refreshed  *oauth2.Token
+		wantScopes []string
+	}{
+		{
+			name:       "RefreshWithoutScopeKeepsGrantedScope",
+			refreshed:  &oauth2.Token{AccessToken: "new", RefreshToken: "refresh_token", Expiry: time.Now().Add(time.Hour)},
+			wantScopes: []string{"read", "write"},
+		},
+		{
+			name:       "RefreshWithScopeReplacesGrantedScope",
+			refreshed:  WithScope(&oauth2.Token{AccessToken: "new", RefreshToken: "refresh_token", Expiry: time.Now().Add(time.Hour)}, "read"),
+			wantScopes: []string{"read"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := secret.NewMemoryStore()
+			svr := ApiSaver{Res: store, Put: store, Ctr: store}
+			_, err := svr.SaveToken(ctx, &api.SaveTokenRequest{
+				UserID:       "userID",
+				Scope:        "read write",
+				AccessToken:  "old",
+				RefreshToken: "refresh_token",
+				Expiry:       time.Now().Add(-time.Hour)})
+			if err != nil {
+				t.Fatalf("SaveToken() error = %v", err)
+			}
+
+			ref := &RefresherStub{RefreshTokenFunc: func(tk *oauth2.Token) (*oauth2.Token, error) {
+				return tt.refreshed, nil
+			}}
+			retr := ApiRetriever{Res: store, Get: store, Put: store, Ref: ref, WriteBack: true}
+
+			tk, err := retr.RetrieveToken(ctx, &api.RetrieveTokenRequest{UserID: "userID"})
+			if err != nil {
+				t.Fatalf("RetrieveToken() error = %v", err)
+			}
+			if tk.AccessToken != "new" {
+				t.Errorf("RetrieveToken() = %v, want refreshed token", tk.AccessToken)
+			}
+
+			desc, err := retr.DescribeToken(ctx, &api.RetrieveTokenRequest{UserID: "userID"})
+			if err != nil {
+				t.Fatalf("DescribeToken() error = %v", err)
+			}
+			if !slices.Equal(desc.Scopes, tt.wantScopes) {
+				t.Errorf("DescribeToken() scopes = %v, want %v", desc.Scopes, tt.wantScopes)
+			}
+			if !desc.HasRefreshToken || desc.TokenType != "Bearer" {
+				t.Errorf("DescribeToken() = %+v, want refresh token and Bearer type", desc)
+			}
+		})
+	}
+}
+
+func TestApiRetriever_RefreshWriteBackFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		ser  Serializer
+	}{
+		{
+			name: "WriteBackJSON",
+			ser:  JSONSerializer{},
+		},
+		{
+			name: "WriteBackSchema",
+			ser:  SchemaSerializer{},
+		},
+		{
+			name: "WriteBackBase64Schema",
+			ser:  Base64Serializer{Serializer: SchemaSerializer{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := secret.NewMemoryStore()
+			// The expired token was stored base64-encoded in a schema envelope, e.g. before
+			// SMS_TOKEN_BASE64 and SMS_TOKEN_SCHEMA were disabled again.
+			svr := ApiSaver{Res: store, Put: store, Ctr: store, Ser: Base64Serializer{Serializer: SchemaSerializer{}}}
+			if _, err := svr.SaveToken(ctx, &api.SaveTokenRequest{UserID: "userID", AccessToken: "old",
+				RefreshToken: "refresh_token", Expiry: time.Now().Add(-time.Hour)}); err != nil {
+				t.Fatalf("SaveToken() error = %v", err)
+			}
+
+			ref := &RefresherStub{RefreshTokenFunc: func(tk *oauth2.Token) (*oauth2.Token, error) {
+				return &oauth2.Token{AccessToken: "new", RefreshToken: "refresh_token", Expiry: time.Now().Add(time.Hour)}, nil
+			}}
+			retr := ApiRetriever{Res: store, Get: store, Put: store, Ref: ref, WriteBack: true, Ser: tt.ser,
+				Dec: Base64Serializer{Serializer: SchemaSerializer{}}}
+			if _, err := retr.RetrieveToken(ctx, &api.RetrieveTokenRequest{UserID: "userID"}); err != nil {
+				t.Fatalf("RetrieveToken() error = %v", err)
+			}
+
+			stored, _ := store.GetSecret(ctx, &api.GetSecretRequest{SecretID: "/token/userID"})
+			want, _ := tt.ser.Marshal(&oauth2.Token{AccessToken: "new"})
+			if strings.HasPrefix(stored, Base64Prefix) != strings.HasPrefix(want, Base64Prefix) ||
+				strings.Contains(stored, "schema_version") != strings.Contains(want, "schema_version") {
+				t.Errorf("RetrieveToken() wrote back %v, want the format of %v", stored, want)
+			}
+			if tk, err := tt.ser.Unmarshal(stored); err != nil || tk.AccessToken != "new" {
+				t.Errorf("RetrieveToken() wrote back %v, %v, want the refreshed token", tk, err)
+			}
+		})
+	}
+}
+
+func TestApiRetriever_MigrateOnRead(t *testing.T) {
+	tests := []struct {
+		name         string
+		migrate      bool
+		stored       string
+		wantMigrated bool
+	}{
+		{
+			name:         "MigrateOnReadUpgradesV0",
+			migrate:      true,
+			stored:       `{"access_token":"access_token","token_type":"Bearer","expiry":"0001-01-01T00:00:00Z","scope":"read"}`,
+			wantMigrated: true,
+		},
+		{
+			name:    "MigrateOnReadKeepsV1",
+			migrate: true,
+			stored:  `{"schema_version":1,"token":{"access_token":"access_token","token_type":"Bearer","expiry":"0001-01-01T00:00:00Z","scope":"read"}}`,
+		},
+		{
+			name:   "MigrateOnReadDisabled",
+			stored: `{"access_token":"access_token","token_type":"Bearer","expiry":"0001-01-01T00:00:00Z","scope":"read"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := secret.NewMemoryStore()
+			secretID := "/token/userID"
+			if err := store.CreateSecret(ctx, &api.CreateSecretRequest{SecretID: secretID, Token: tt.stored}); err != nil {
+				t.Fatalf("CreateSecret() error = %v", err)
+			}
+
+			retr := ApiRetriever{Res: store, Get: store, Put: store, Ser: SchemaSerializer{}, MigrateOnRead: tt.migrate}
+			tk, err := retr.RetrieveToken(ctx, &api.RetrieveTokenRequest{UserID: "userID"})
+			if err != nil {
+				t.Fatalf("RetrieveToken() error = %v", err)
+			}
+			if tk.AccessToken != "access_token" || Scope(tk) != "read" {
+				t.Errorf("RetrieveToken() = %v with scope %q, want access_token with scope read", tk, Scope(tk))
+			}
+
+			stored, _ := store.GetSecret(ctx, &api.GetSecretRequest{SecretID: secretID})
+			if migrated := stored != tt.stored; migrated != tt.wantMigrated {
+				t.Errorf("RetrieveToken() stored %v, want migrated %v", stored, tt.wantMigrated)
+			}
+			if tt.wantMigrated && (SchemaSerializer{}).Outdated(stored) {
+				t.Errorf("RetrieveToken() stored %v, want schema version %d", stored, CurrentSchemaVersion)
+			}
+		})
+	}
+}
+
+// racingGetter is a secret.MetaGetter that stores saved in the secret right after it was
+// read, like a save that races the migration of the token read.
+type racingGetter struct {
+	*secret.MemoryStore
+	saved string
+}
+
+func (g *racingGetter) GetSecretWithMeta(ctx context.Context, r *api.GetSecretRequest) (*api.SecretValue, error) {
+	value, err := g.MemoryStore.GetSecretWithMeta(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if err = g.PutSecret(ctx, &api.PutSecretRequest{SecretID: r.SecretID, Token: g.saved}); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func TestApiRetriever_MigrateOnReadConcurrentSave(t *testing.T) {
+	ctx := context.Background()
+	store := secret.NewMemoryStore()
+	secretID := "/token/userID"
+	stored := `{"access_token":"access_token","token_type":"Bearer","expiry":"0001-01-01T00:00:00Z"}`
+	if err := store.CreateSecret(ctx, &api.CreateSecretRequest{SecretID: secretID, Token: stored}); err != nil {
+		t.Fatalf("CreateSecret() error = %v", err)
+	}
+
+	saved := `{"schema_version":1,"token":{"access_token":"saved_access_token","token_type":"Bearer","expiry":"0001-01-01T00:00:00Z"}}`
+	get := &racingGetter{MemoryStore: store, saved: saved}
+	retr := ApiRetriever{Res: store, Get: get, Put: store, Ser: SchemaSerializer{}, MigrateOnRead: true}
+	tk, versionID, err := retr.RetrieveTokenWithVersion(ctx, &api.RetrieveTokenRequest{UserID: "userID"})
+	if err != nil {
+		t.Fatalf("RetrieveTokenWithVersion() error = %v", err)
+	}
+	if tk.AccessToken != "access_token" || versionID != "v1" {
+		t.Errorf("RetrieveTokenWithVersion() = %v, %v, want access_token, v1", tk.AccessToken, versionID)
+	}
+
+	if got, _ := store.GetSecret(ctx, &api.GetSecretRequest{SecretID: secretID}); got != saved {
+		t.Errorf("RetrieveTokenWithVersion() overwrote the concurrent save with %v", got)
+	}
+}
+
+func TestApiSaver_VersionEcho(t *testing.T) {
+	ctx := context.Background()
+	store := secret.NewMemoryStore()
+	svr := &ApiSaver{Res: store, Put: store, Ctr: store, Ver: store, Retries: DefaultSaveRetries}
+	rtr := &ApiRetriever{Res: store, Get: store}
+
+	for i, want := range []struct {
+		result    SaveResult
+		versionID string
+	}{
+		{SaveCreated, "v1"},
+		{SaveUpdated, "v2"},
+	} {
+		result, versionID, err := svr.SaveTokenWithVersion(ctx, &api.SaveTokenRequest{
+			UserID:      "1",
+			AccessToken: fmt.Sprintf("access_token_%d", i)})
+		if err != nil {
+			t.Fatalf("SaveTokenWithVersion() error = %v", err)
+		}
+		if result != want.result || versionID != want.versionID {
+			t.Errorf("SaveTokenWithVersion() = %v, %v, want %v, %v", result, versionID, want.result, want.versionID)
+		}
+
+		tk, served, err := rtr.RetrieveTokenWithVersion(ctx, &api.RetrieveTokenRequest{UserID: "1"})
+		if err != nil {
+			t.Fatalf("RetrieveTokenWithVersion() error = %v", err)
+		}
+		if served != versionID || tk.AccessToken != fmt.Sprintf("access_token_%d", i) {
+			t.Errorf("RetrieveTokenWithVersion() = %v, %v, want the saved token of version %v", tk.AccessToken, served, versionID)
+		}
+	}
+}
+
+func TestApiSaver_RefreshTokenBoundToSecret(t *testing.T) {
+	ctx := context.Background()
+	store := secret.NewMemoryStore()
+	ser := RefreshTokenSerializer{Enc: EncrypterStub{}}
+	svr := &ApiSaver{Res: store, Put: store, Ctr: store, Ser: ser}
+	rtr := &ApiRetriever{Res: store, Get: store, Ser: ser}
+
+	if _, err := svr.SaveToken(ctx, &api.SaveTokenRequest{UserID: "1", AccessToken: "access_token",
+		RefreshToken: "refresh_token"}); err != nil {
+		t.Fatalf("SaveToken() error = %v", err)
+	}
+	tk, err := rtr.RetrieveToken(ctx, &api.RetrieveTokenRequest{UserID: "1"})
+	if err != nil || tk.RefreshToken != "refresh_token" {
+		t.Fatalf("RetrieveToken() = %v, %v, want refresh_token", tk, err)
+	}
+
+	// A stored token copied to the secret of another user cannot be read there.
+	stored, err := store.GetSecret(ctx, &api.GetSecretRequest{SecretID: "/token/1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.CreateSecret(ctx, &api.CreateSecretRequest{SecretID: "/token/2", Token: stored}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rtr.RetrieveToken(ctx, &api.RetrieveTokenRequest{UserID: "2"}); err == nil {
+		t.Errorf("RetrieveToken() of a refresh token encrypted for another secret succeeded")
+	}
+}
+
+func TestApiSaver_RootDomain(t *testing.T) {
+	ctx := context.Background()
+	store := secret.NewMemoryStore()
+	vars := env.AwsVars{SmsRootDomain: "root", Environment: "prod"}
+	svr := &ApiSaver{Env: vars, Res: store, Put: store, Ctr: store}
+	rtr := &ApiRetriever{Env: vars, Res: store, Get: store}
+
+	for _, want := range []SaveResult{SaveCreated, SaveUpdated} {
+		result, err := svr.SaveToken(ctx, &api.SaveTokenRequest{UserID: "1", AccessToken: "access_token"})
+		if err != nil || result != want {
+			t.Fatalf("SaveToken() = %v, %v, want %v", result, err, want)
+		}
+	}
+
+	if _, err := store.GetSecret(ctx, &api.GetSecretRequest{SecretID: "root/prod/token/1"}); err != nil {
+		t.Errorf("SaveToken() did not save under the root domain: %v", err)
+	}
+	if tk, err := rtr.RetrieveToken(ctx, &api.RetrieveTokenRequest{UserID: "1"}); err != nil || tk.AccessToken != "access_token" {
+		t.Errorf("RetrieveToken() = %v, %v, want the saved token", tk, err)
+	}
+}
+
+func TestApiSaver_ProviderTTLs(t *testing.T) {
+	ctx := context.Background()
+	store := secret.NewMemoryStore()
+	svr := &ApiSaver{Res: store, Put: store, Ctr: store, Get: store,
+		ProviderTTLs: map[string]time.Duration{"google": 720 * time.Hour}}
+
+	stored := func(provider string) *oauth2.Token {
+		t.Helper()
+		id := api.SecretID{Domain: DefaultDomain, UserID: "1", Provider: provider}
+		secretStr, err := store.GetSecret(ctx, &api.GetSecretRequest{SecretID: id.String()})
+		if err != nil {
+			t.Fatalf("GetSecret() error = %v", err)
+		}
+		tk, err := JSONSerializer{}.Unmarshal(secretStr)
+		if err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		return tk
+	}
+
+	before := time.Now()
+	for _, provider := range []string{"google", "github"} {
+		if _, err := svr.SaveToken(ctx, &api.SaveTokenRequest{UserID: "1", Provider: provider, AccessToken: "first"}); err != nil {
+			t.Fatalf("SaveToken() error = %v", err)
+		}
+	}
+
+	deleteAfter := DeleteAfter(stored("google"))
+	if deleteAfter.Before(before.Add(720*time.Hour)) || deleteAfter.After(time.Now().Add(720*time.Hour)) {
+		t.Errorf("DeleteAfter() = %v, want 720h after the first save", deleteAfter)
+	}
+	if got := DeleteAfter(stored("github")); !got.IsZero() {
+		t.Errorf("DeleteAfter() = %v, want none for a provider without a TTL", got)
+	}
+
+	if _, err := svr.SaveToken(ctx, &api.SaveTokenRequest{UserID: "1", Provider: "google", AccessToken: "second"}); err != nil {
+		t.Fatalf("SaveToken() error = %v", err)
+	}
+	tk := stored("google")
+	if tk.AccessToken != "second" || !DeleteAfter(tk).Equal(deleteAfter) {
+		t.Errorf("SaveToken() stored %v with DeleteAfter %v, want second with %v", tk.AccessToken, DeleteAfter(tk), deleteAfter)
+	}
+}